@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/sdrshn-nmbr/tusk/pkg/client"
+)
+
+func search(ctx context.Context, c *client.Client, args []string) error {
+	flags := newFlags("search", "[-json] [-limit N] [-folder F] <query>")
+	var opts client.SearchOptions
+	var tags stringList
+	flags.IntVar(&opts.Limit, "limit", 5, "number of passages, 1 to 20")
+	flags.StringVar(&opts.Folder, "folder", "", "only search this folder")
+	flags.Var(&tags, "tag", "only search documents with this tag (repeatable)")
+	asJSON := flags.Bool("json", false, "print the passages as JSON")
+	words, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		return usageError(flags, "no query given")
+	}
+	opts.Tags = tags
+
+	passages, err := c.Search(ctx, strings.Join(words, " "), &opts)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(passages)
+	}
+	for i, p := range passages {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s (%.2f) %s\n", p.Filename, p.Score, p.DocumentID)
+		fmt.Println(indent(strings.TrimSpace(p.Content), "    "))
+	}
+	return nil
+}
+
+func indent(text, prefix string) string {
+	return prefix + strings.ReplaceAll(text, "\n", "\n"+prefix)
+}
+
+const chatHelp = `Type a question and press Enter. Ctrl-C stops an answer, Ctrl-D quits.
+  /new    start a new conversation
+  /exit   quit
+`
+
+// chat answers a question given as arguments, or else reads questions from
+// standard input until it ends. Answers are printed as they stream in.
+func chat(ctx context.Context, c *client.Client, args []string) error {
+	flags := newFlags("chat", "[-agent] [-c ID] [-doc ID] [question]")
+	var req client.ChatRequest
+	var docs stringList
+	flags.BoolVar(&req.Agent, "agent", false, "let the agent search and read documents with tools")
+	flags.StringVar(&req.Conversation, "c", "", "conversation to continue")
+	flags.Var(&docs, "doc", "only answer from this document (repeatable)")
+	words, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	req.DocumentIDs = docs
+	if req.Agent && len(docs) > 0 {
+		return fmt.Errorf("-doc cannot be used with -agent, which picks its own documents")
+	}
+
+	if len(words) > 0 {
+		req.Query = strings.Join(words, " ")
+		stopCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+		_, err := ask(stopCtx, c, req)
+		return err
+	}
+
+	info, _ := os.Stdin.Stat()
+	interactive := info != nil && info.Mode()&os.ModeCharDevice != 0
+	if interactive {
+		fmt.Print(chatHelp)
+	}
+
+	// Interrupts are caught for the whole session; between answers they
+	// have nothing to stop and are ignored
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		if interactive {
+			fmt.Print("> ")
+		}
+		if !scanner.Scan() {
+			if interactive {
+				fmt.Println()
+			}
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+			continue
+		case "/exit", "/quit":
+			return nil
+		case "/new":
+			req.Conversation = ""
+			fmt.Println("Started a new conversation")
+			continue
+		case "/help":
+			fmt.Print(chatHelp)
+			continue
+		}
+
+		// Drop an interrupt sent while the user was typing
+		select {
+		case <-interrupts:
+		default:
+		}
+		answerCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-interrupts:
+				cancel()
+			case <-answerCtx.Done():
+			}
+		}()
+		req.Query = line
+		conversation, err := ask(answerCtx, c, req)
+		stopped := answerCtx.Err() != nil
+		cancel()
+		switch {
+		case stopped:
+			fmt.Println("(stopped)")
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		default:
+			req.Conversation = conversation
+		}
+	}
+}
+
+// ask streams the answer to req to standard output and returns the
+// conversation it belongs to. Agent answers are not streamed and are printed
+// once they are complete.
+func ask(ctx context.Context, c *client.Client, req client.ChatRequest) (string, error) {
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	var streamed strings.Builder
+	answer, err := c.Chat(ctx, req, func(token string) {
+		streamed.WriteString(token)
+		out.WriteString(token)
+		out.Flush()
+	})
+	if err != nil {
+		if streamed.Len() > 0 {
+			out.WriteString("\n")
+		}
+		return "", err
+	}
+	if streamed.Len() == 0 {
+		out.WriteString(answer.Answer)
+	}
+	if !strings.HasSuffix(answer.Answer, "\n") {
+		out.WriteString("\n")
+	}
+	for _, step := range answer.Trace {
+		fmt.Fprintf(out, "  [%d] %s\n", step.Step, step.Tool)
+	}
+	return answer.Conversation, nil
+}
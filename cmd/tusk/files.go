@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sdrshn-nmbr/tusk/pkg/client"
+)
+
+// supportedExtensions are the file types the server extracts text from.
+// Directories are walked for these only; files named explicitly are sent
+// regardless and rejected by the server if need be.
+var supportedExtensions = map[string]bool{
+	".pdf": true, ".docx": true, ".txt": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".webp": true, ".heic": true, ".heif": true,
+}
+
+func supported(name string) bool {
+	return supportedExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// localFile is a file to upload and the folder it belongs in.
+type localFile struct {
+	Path   string
+	Folder string
+}
+
+// expandPaths resolves glob patterns and, when recursive, walks directories.
+// Files found in a subdirectory go into the matching subfolder of folder.
+func expandPaths(args []string, folder string, recursive bool) ([]localFile, error) {
+	var files []localFile
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("%s: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: no matching files", arg)
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				files = append(files, localFile{Path: match, Folder: folder})
+				continue
+			}
+			if !recursive {
+				return nil, fmt.Errorf("%s is a directory, use -r to upload its files", match)
+			}
+			found, err := walkDir(match, folder)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, found...)
+		}
+	}
+	return files, nil
+}
+
+// walkDir lists the supported files under root, skipping hidden files and
+// directories.
+func walkDir(root, folder string) ([]localFile, error) {
+	var files []localFile
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() || !supported(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		files = append(files, localFile{Path: p, Folder: joinFolder(folder, filepath.ToSlash(rel))})
+		return nil
+	})
+	return files, err
+}
+
+// joinFolder returns the subfolder sub of folder, where "." is folder itself.
+func joinFolder(folder, sub string) string {
+	return strings.TrimPrefix(path.Clean(path.Join("/", folder, sub)), "/")
+}
+
+func upload(ctx context.Context, c *client.Client, args []string) error {
+	flags := newFlags("upload", "[-folder F] [-r] <paths...>")
+	folder := flags.String("folder", "", "folder to upload into")
+	recursive := flags.Bool("r", false, "upload the supported files in directories, keeping subdirectories as subfolders")
+	paths, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return usageError(flags, "no files given")
+	}
+
+	files, err := expandPaths(paths, *folder, *recursive)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, file := range files {
+		doc, err := uploadFile(ctx, c, file)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file.Path, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s\t%s\n", doc.ID, displayName(doc))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(files))
+	}
+	return nil
+}
+
+// uploadFile streams the file at file.Path; an *os.File can be rewound, so
+// the upload is retried like any other request.
+func uploadFile(ctx context.Context, c *client.Client, file localFile) (*client.Document, error) {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.UploadFile(ctx, filepath.Base(file.Path), f, &client.UploadOptions{Folder: file.Folder})
+}
+
+// displayName is the document's path in its folder.
+func displayName(doc *client.Document) string {
+	return path.Join(doc.Folder, doc.Filename)
+}
+
+func list(ctx context.Context, c *client.Client, args []string) error {
+	flags := newFlags("ls", "[-folder F] [-tag T] [-sort S] [-desc] [-json]")
+	var opts client.ListOptions
+	var tags, attrs stringList
+	flags.StringVar(&opts.Folder, "folder", "", "only documents in this folder")
+	flags.Var(&tags, "tag", "only documents with this tag (repeatable)")
+	flags.Var(&attrs, "attr", "only documents matching key:op:value (repeatable)")
+	flags.StringVar(&opts.Sort, "sort", "name", `"name", "uploaded", "folder" or "attr:<key>"`)
+	flags.BoolVar(&opts.Desc, "desc", false, "sort in descending order")
+	asJSON := flags.Bool("json", false, "print the documents as JSON")
+	if _, err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	opts.Tags, opts.Attributes = tags, attrs
+
+	docs, err := listAll(ctx, c, opts)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(docs)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSIZE\tUPLOADED\tNAME")
+	for i := range docs {
+		doc := &docs[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", doc.ID, formatSize(doc.SizeBytes), doc.UploadedAt.Local().Format("2006-01-02 15:04"), displayName(doc))
+	}
+	return w.Flush()
+}
+
+// listAll pages through every document matching opts.
+func listAll(ctx context.Context, c *client.Client, opts client.ListOptions) ([]client.Document, error) {
+	opts.Limit = 200
+	var docs []client.Document
+	for {
+		page, err := c.ListFiles(ctx, &opts)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, page.Documents...)
+		if !page.HasMore {
+			return docs, nil
+		}
+		opts.Offset += int64(len(page.Documents))
+	}
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func remove(ctx context.Context, c *client.Client, args []string) error {
+	flags := newFlags("rm", "<ids...>")
+	ids, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return usageError(flags, "no documents given")
+	}
+
+	failed := 0
+	for _, id := range ids {
+		if err := c.DeleteFile(ctx, id); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(os.Stderr, "%s: %v\n", id, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d documents could not be deleted", failed, len(ids))
+	}
+	return nil
+}
+
+func get(ctx context.Context, c *client.Client, args []string) error {
+	flags := newFlags("get", "[-o FILE] <id>")
+	output := flags.String("o", "", `file to write to, "-" for standard output; the document's name by default`)
+	ids, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		return usageError(flags, "expected one document")
+	}
+	id := ids[0]
+
+	if *output == "-" {
+		_, err := c.Download(ctx, id, os.Stdout)
+		return err
+	}
+	name := *output
+	if name == "" {
+		doc, err := c.GetFile(ctx, id)
+		if err != nil {
+			return err
+		}
+		name = filepath.Base(doc.Filename)
+	}
+
+	// Download next to the target so a failed download leaves no partial
+	// file behind
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := c.Download(ctx, id, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	fmt.Println(name)
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
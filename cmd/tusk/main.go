@@ -0,0 +1,149 @@
+// Command tusk is a command-line client for a Tusk server. It talks to the
+// /api/v1 API with a personal API token:
+//
+//	export TUSK_URL=https://tusk.example.com TUSK_TOKEN=tusk_...
+//	tusk upload -r -folder contracts ./contracts
+//	tusk search "termination notice period"
+//	tusk chat
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/sdrshn-nmbr/tusk/pkg/client"
+)
+
+const usage = `Usage: tusk [-url URL] [-token TOKEN] <command> [arguments]
+
+Commands:
+  upload [-folder F] [-r] <paths...>   upload files, directories with -r
+  ls [-folder F] [-tag T] [-json]      list documents
+  rm <ids...>                          delete documents
+  get [-o FILE] <id>                   download a document
+  search [-json] [-limit N] <query>    find passages without an answer
+  chat [-agent] [-c ID] [question]     ask questions, interactively without one
+  sync [-folder F] [-delete] <dir>     mirror a directory into a folder
+
+The server and token default to $TUSK_URL and $TUSK_TOKEN. Run
+"tusk <command> -h" for the flags of a command.
+`
+
+// commands maps command names to their implementations. Each parses its own
+// flags from args.
+var commands = map[string]func(ctx context.Context, c *client.Client, args []string) error{
+	"upload": upload,
+	"ls":     list,
+	"rm":     remove,
+	"get":    get,
+	"search": search,
+	"chat":   chat,
+	"sync":   syncDir,
+}
+
+// errUsage reports wrong arguments; the command has already said why.
+var errUsage = errors.New("usage")
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	baseURL := flag.String("url", envOr("TUSK_URL", "http://localhost:8080"), "Tusk server")
+	token := flag.String("token", os.Getenv("TUSK_TOKEN"), "personal API token")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "tusk: unknown command %q\n\n", name)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *token == "" {
+		fmt.Fprintln(os.Stderr, "tusk: no API token; create one at /settings/tokens and set $TUSK_TOKEN")
+		os.Exit(2)
+	}
+
+	// chat handles interrupts itself so Ctrl-C only stops the current answer
+	ctx := context.Background()
+	if name != "chat" {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+	}
+
+	err := run(ctx, client.New(*baseURL, *token), flag.Args()[1:])
+	switch {
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "tusk %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// newFlags returns a flag set for a command whose usage line is
+// "tusk <name> <synopsis>".
+func newFlags(name, synopsis string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: tusk %s %s\n", name, synopsis)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses args and returns the positional arguments. Flags may
+// follow them, as in tusk search "query" -json, unless they come after "--".
+// -h and bad flags end the command.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(0)
+			}
+			return nil, errUsage
+		}
+		rest := flags.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// usageError prints the command's usage after msg.
+func usageError(flags *flag.FlagSet, msg string) error {
+	fmt.Fprintf(flags.Output(), "tusk %s: %s\n", flags.Name(), msg)
+	flags.Usage()
+	return errUsage
+}
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sdrshn-nmbr/tusk/pkg/client"
+)
+
+// syncFile is a local file with what sync compares it by.
+type syncFile struct {
+	localFile
+	Name string
+	Size int64
+	Hash string
+}
+
+func (f syncFile) key() string { return path.Join(f.Folder, f.Name) }
+
+// syncUpload uploads a file and then deletes the documents it replaces: the
+// older versions at the same path.
+type syncUpload struct {
+	File     syncFile
+	Replaces []client.Document
+}
+
+// syncMove moves a document whose content turned up at another path.
+type syncMove struct {
+	Doc client.Document
+	To  string
+}
+
+type syncPlan struct {
+	Uploads []syncUpload
+	Moves   []syncMove
+	// Duplicates share the path of an unchanged document and Extra
+	// documents have no local file; both are only deleted with -delete
+	Duplicates []client.Document
+	Extra      []client.Document
+	Unchanged  int
+}
+
+// planSync compares local files with the remote documents by path and
+// content hash. A document that matches a local file is kept; one with the
+// same name and content at another path is moved there rather than uploaded
+// again.
+func planSync(local []syncFile, remote []client.Document) syncPlan {
+	var plan syncPlan
+	claimed := make([]bool, len(remote))
+	byKey := map[string][]int{}
+	for i := range remote {
+		key := documentKey(&remote[i])
+		byKey[key] = append(byKey[key], i)
+	}
+
+	var pending []syncFile
+	unchanged := map[string]bool{}
+	for _, file := range local {
+		found := false
+		for _, i := range byKey[file.key()] {
+			if !claimed[i] && remote[i].SHA256 == file.Hash {
+				claimed[i], found = true, true
+				break
+			}
+		}
+		if found {
+			unchanged[file.key()] = true
+			plan.Unchanged++
+		} else {
+			pending = append(pending, file)
+		}
+	}
+
+	uploads := map[string]int{}
+	for _, file := range pending {
+		moved := false
+		for i := range remote {
+			doc := &remote[i]
+			if !claimed[i] && doc.Filename == file.Name && doc.SHA256 == file.Hash {
+				claimed[i], moved = true, true
+				plan.Moves = append(plan.Moves, syncMove{Doc: *doc, To: file.Folder})
+				break
+			}
+		}
+		if !moved {
+			uploads[file.key()] = len(plan.Uploads)
+			plan.Uploads = append(plan.Uploads, syncUpload{File: file})
+		}
+	}
+
+	for i := range remote {
+		if claimed[i] {
+			continue
+		}
+		doc := remote[i]
+		key := documentKey(&doc)
+		if n, ok := uploads[key]; ok {
+			plan.Uploads[n].Replaces = append(plan.Uploads[n].Replaces, doc)
+		} else if unchanged[key] {
+			plan.Duplicates = append(plan.Duplicates, doc)
+		} else {
+			plan.Extra = append(plan.Extra, doc)
+		}
+	}
+	return plan
+}
+
+func documentKey(doc *client.Document) string { return path.Join(doc.Folder, doc.Filename) }
+
+// inFolder reports whether name is folder or one of its subfolders.
+func inFolder(name, folder string) bool {
+	return name == folder || strings.HasPrefix(name, folder+"/")
+}
+
+func syncDir(ctx context.Context, c *client.Client, args []string) error {
+	flags := newFlags("sync", "[-folder F] [-delete] [-dry-run] <dir>")
+	folder := flags.String("folder", "", "folder to mirror into; the directory's name by default")
+	deleteExtra := flags.Bool("delete", false, "delete documents in the folder that have no local file or duplicate another")
+	dryRun := flags.Bool("dry-run", false, "only print what would change")
+	dirs, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(dirs) != 1 {
+		return usageError(flags, "expected one directory")
+	}
+	dir := dirs[0]
+
+	if *folder == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		*folder = filepath.Base(abs)
+	}
+	root := joinFolder(*folder, ".")
+	if root == "" {
+		return usageError(flags, "cannot sync into the top level, pass -folder")
+	}
+
+	local, err := scanDir(dir, root)
+	if err != nil {
+		return err
+	}
+	remote, err := listTree(ctx, c, root)
+	if err != nil {
+		return err
+	}
+	if err := hashMissing(ctx, c, remote, local); err != nil {
+		return err
+	}
+	plan := planSync(local, remote)
+
+	if *dryRun {
+		printPlan(plan, *deleteExtra)
+		return nil
+	}
+	return applyPlan(ctx, c, plan, *deleteExtra)
+}
+
+// scanDir hashes the supported files under dir.
+func scanDir(dir, folder string) ([]syncFile, error) {
+	files, err := walkDir(dir, folder)
+	if err != nil {
+		return nil, err
+	}
+	local := make([]syncFile, 0, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := hashFile(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		local = append(local, syncFile{localFile: file, Name: filepath.Base(file.Path), Size: info.Size(), Hash: hash})
+	}
+	return local, nil
+}
+
+// listTree lists the documents in folder and its subfolders.
+func listTree(ctx context.Context, c *client.Client, folder string) ([]client.Document, error) {
+	folders, err := c.Folders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var docs []client.Document
+	for _, f := range folders {
+		if !inFolder(f.Name, folder) {
+			continue
+		}
+		found, err := listAll(ctx, c, client.ListOptions{Filter: client.Filter{Folder: f.Name}})
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, found...)
+	}
+	return docs, nil
+}
+
+// hashMissing downloads and hashes documents uploaded before the server
+// recorded hashes, but only those that could match a local file by size.
+func hashMissing(ctx context.Context, c *client.Client, remote []client.Document, local []syncFile) error {
+	sizes := map[int64]bool{}
+	for _, file := range local {
+		sizes[file.Size] = true
+	}
+	for i := range remote {
+		doc := &remote[i]
+		if doc.SHA256 != "" || !sizes[doc.SizeBytes] {
+			continue
+		}
+		h := sha256.New()
+		if _, err := c.Download(ctx, doc.ID, h); err != nil {
+			return fmt.Errorf("hashing %s: %w", documentKey(doc), err)
+		}
+		doc.SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	return nil
+}
+
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func printPlan(plan syncPlan, deleteExtra bool) {
+	for _, u := range plan.Uploads {
+		action := "upload"
+		if len(u.Replaces) > 0 {
+			action = "update"
+		}
+		fmt.Printf("%s\t%s\n", action, u.File.key())
+	}
+	for _, m := range plan.Moves {
+		fmt.Printf("move\t%s -> %s\n", documentKey(&m.Doc), path.Join(m.To, m.Doc.Filename))
+	}
+	if deleteExtra {
+		for _, doc := range plan.Duplicates {
+			fmt.Printf("delete\t%s (duplicate)\n", documentKey(&doc))
+		}
+		for _, doc := range plan.Extra {
+			fmt.Printf("delete\t%s\n", documentKey(&doc))
+		}
+	}
+	fmt.Println(summary(plan, deleteExtra))
+}
+
+func summary(plan syncPlan, deleteExtra bool) string {
+	parts := []string{fmt.Sprintf("%d unchanged", plan.Unchanged)}
+	if n := len(plan.Uploads); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d to upload", n))
+	}
+	if n := len(plan.Moves); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d to move", n))
+	}
+	if n := len(plan.Duplicates) + len(plan.Extra); n > 0 && deleteExtra {
+		parts = append(parts, fmt.Sprintf("%d to delete", n))
+	}
+	if n := len(plan.Duplicates); n > 0 && !deleteExtra {
+		parts = append(parts, fmt.Sprintf("%d duplicates (see -delete)", n))
+	}
+	if n := len(plan.Extra); n > 0 && !deleteExtra {
+		parts = append(parts, fmt.Sprintf("%d only on the server (see -delete)", n))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// applyPlan carries out plan, going on after failures. An older version is
+// only deleted once its replacement is uploaded.
+func applyPlan(ctx context.Context, c *client.Client, plan syncPlan, deleteExtra bool) error {
+	var uploaded, moved, deleted, failed int
+	// report prints a failure, or stops the sync once it was interrupted
+	report := func(what string, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v\n", what, err)
+		failed++
+		return nil
+	}
+	deleteDocs := func(docs []client.Document) error {
+		for _, doc := range docs {
+			err := c.DeleteFile(ctx, doc.ID)
+			if err != nil && !client.IsNotFound(err) {
+				if err := report(documentKey(&doc), err); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Printf("deleted\t%s\n", documentKey(&doc))
+			deleted++
+		}
+		return nil
+	}
+
+	for _, u := range plan.Uploads {
+		if _, err := uploadFile(ctx, c, u.File.localFile); err != nil {
+			if err := report(u.File.Path, err); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("uploaded\t%s\n", u.File.key())
+		uploaded++
+		if err := deleteDocs(u.Replaces); err != nil {
+			return err
+		}
+	}
+	for _, m := range plan.Moves {
+		if _, err := c.MoveFile(ctx, m.Doc.ID, m.To); err != nil {
+			if err := report(documentKey(&m.Doc), err); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("moved\t%s -> %s\n", documentKey(&m.Doc), path.Join(m.To, m.Doc.Filename))
+		moved++
+	}
+	if deleteExtra {
+		if err := deleteDocs(plan.Duplicates); err != nil {
+			return err
+		}
+		if err := deleteDocs(plan.Extra); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%d unchanged, %d uploaded, %d moved, %d deleted\n", plan.Unchanged, uploaded, moved, deleted)
+	if n := len(plan.Duplicates); n > 0 && !deleteExtra {
+		fmt.Printf("%d documents duplicate another, -delete removes them\n", n)
+	}
+	if n := len(plan.Extra); n > 0 && !deleteExtra {
+		fmt.Printf("%d documents are only on the server, -delete removes them\n", n)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d changes failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/ai/aitest"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/handlers"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"github.com/sdrshn-nmbr/tusk/internal/storage/memstore"
+	"github.com/sdrshn-nmbr/tusk/pkg/client"
+)
+
+func TestPlanSync(t *testing.T) {
+	file := func(folder, name, hash string) syncFile {
+		return syncFile{localFile: localFile{Folder: folder}, Name: name, Hash: hash}
+	}
+	doc := func(id, folder, name, hash string) client.Document {
+		return client.Document{ID: id, Folder: folder, Filename: name, SHA256: hash}
+	}
+
+	local := []syncFile{
+		file("docs", "same.txt", "a"),
+		file("docs", "changed.txt", "b2"),
+		file("docs/new", "moved.txt", "c"),
+		file("docs", "new.txt", "d"),
+	}
+	remote := []client.Document{
+		doc("1", "docs", "same.txt", "a"),
+		doc("2", "docs", "same.txt", "a"),
+		doc("3", "docs", "changed.txt", "b1"),
+		doc("4", "docs/old", "moved.txt", "c"),
+		doc("5", "docs", "gone.txt", "e"),
+	}
+	plan := planSync(local, remote)
+
+	if plan.Unchanged != 1 {
+		t.Errorf("Expected 1 unchanged file, got %d", plan.Unchanged)
+	}
+	if len(plan.Uploads) != 2 || plan.Uploads[0].File.Name != "changed.txt" || plan.Uploads[1].File.Name != "new.txt" {
+		t.Fatalf("Expected changed.txt and new.txt to be uploaded, got %+v", plan.Uploads)
+	}
+	if replaces := plan.Uploads[0].Replaces; len(replaces) != 1 || replaces[0].ID != "3" {
+		t.Errorf("Expected changed.txt to replace document 3, got %+v", replaces)
+	}
+	if len(plan.Uploads[1].Replaces) != 0 {
+		t.Errorf("Expected new.txt to replace nothing, got %+v", plan.Uploads[1].Replaces)
+	}
+	if len(plan.Moves) != 1 || plan.Moves[0].Doc.ID != "4" || plan.Moves[0].To != "docs/new" {
+		t.Errorf("Expected document 4 to move to docs/new, got %+v", plan.Moves)
+	}
+	if len(plan.Duplicates) != 1 || plan.Duplicates[0].ID != "2" {
+		t.Errorf("Expected document 2 to be a duplicate, got %+v", plan.Duplicates)
+	}
+	if len(plan.Extra) != 1 || plan.Extra[0].ID != "5" {
+		t.Errorf("Expected document 5 to be extra, got %+v", plan.Extra)
+	}
+
+	// Nothing is deleted without -delete, not even duplicates
+	if got := summary(plan, false); strings.Contains(got, "to delete") || !strings.Contains(got, "1 duplicates (see -delete)") {
+		t.Errorf("Unexpected summary without -delete: %s", got)
+	}
+	if got := summary(plan, true); !strings.Contains(got, "2 to delete") {
+		t.Errorf("Unexpected summary with -delete: %s", got)
+	}
+}
+
+// TestSyncDir mirrors a directory into a server backed by the in-memory
+// store, changes it and mirrors it again.
+func TestSyncDir(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := memstore.New()
+	h := handlers.NewHandler(config.Default(), store, ai.NewHashEmbedder(64), &aitest.Model{}, template.New(""))
+	r := gin.New()
+	h.RegisterAPIRoutes(r, nil)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	_, secret, err := store.CreateAPIToken("user-1", "test", storage.Scopes, nil)
+	if err != nil {
+		t.Fatalf("Failed to create token: %+v", err)
+	}
+	c := client.New(server.URL, secret)
+	c.Backoff = time.Millisecond
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "first")
+	write("sub/b.txt", "second")
+	write("ignored.bin", "not a document")
+	write(".hidden/c.txt", "hidden")
+
+	listing := func() []string {
+		docs, err := listTree(ctx, c, "notes")
+		if err != nil {
+			t.Fatalf("listTree failed: %+v", err)
+		}
+		var keys []string
+		for i := range docs {
+			keys = append(keys, documentKey(&docs[i]))
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	if err := syncDir(ctx, c, []string{dir, "-folder", "notes"}); err != nil {
+		t.Fatalf("First sync failed: %+v", err)
+	}
+	if got := listing(); len(got) != 2 || got[0] != "notes/a.txt" || got[1] != "notes/sub/b.txt" {
+		t.Fatalf("Expected notes/a.txt and notes/sub/b.txt, got %v", got)
+	}
+
+	write("a.txt", "first, edited")
+	if err := os.Rename(filepath.Join(dir, "sub"), filepath.Join(dir, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncDir(ctx, c, []string{"-folder", "notes", dir}); err != nil {
+		t.Fatalf("Second sync failed: %+v", err)
+	}
+	if got := listing(); len(got) != 2 || got[0] != "notes/a.txt" || got[1] != "notes/moved/b.txt" {
+		t.Fatalf("Expected notes/a.txt and notes/moved/b.txt, got %v", got)
+	}
+
+	docs, err := listTree(ctx, c, "notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range docs {
+		if doc.Filename == "a.txt" && doc.SHA256 != storage.ContentHash([]byte("first, edited")) {
+			t.Errorf("Expected the edited a.txt on the server, got hash %s", doc.SHA256)
+		}
+	}
+}
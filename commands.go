@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/prompts"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// openStorage connects to MongoDB as configured. Images in uploads are read
+// with ocr; commands that never extract text pass nil.
+func openStorage(cfg *config.Config, ocr storage.ImageReader) (*storage.MongoStorage, error) {
+	client, err := storage.Connect(cfg.Mongo)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewMongoStorage(client, cfg, storage.NewTextExtractor(cfg.Ingest, ocr)), nil
+}
+
+// createIndexes creates every index the server relies on and, unless -wait=false,
+// waits until Atlas reports the search indexes as queryable.
+func createIndexes(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("create-indexes", flag.ExitOnError)
+	wait := flags.Bool("wait", true, "wait until the search indexes are queryable")
+	timeout := flags.Duration("timeout", 10*time.Minute, "how long to wait for the search indexes")
+	flags.Parse(args)
+
+	ms, err := openStorage(cfg, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := ms.EnsureIndexes(); err != nil {
+		return err
+	}
+	if err := ms.EnsureSearchIndexes(ms.ActiveEmbedder(ai.NewEmbedder(cfg)).Dimensions()); err != nil {
+		return err
+	}
+	if !*wait {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := ms.WaitForSearchIndexes(ctx); err != nil {
+		return err
+	}
+	fmt.Println("All indexes are ready")
+	return nil
+}
+
+// migrate runs "migrate status", "migrate up" or "migrate down". Up applies
+// every pending migration and down reverts the latest one, unless -to names
+// the version to stop at.
+func migrate(cfg *config.Config, args []string) error {
+	action := "status"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+
+	flags := flag.NewFlagSet("migrate "+action, flag.ExitOnError)
+	to := flags.Int("to", -1, "version to migrate up or down to")
+	dryRun := flags.Bool("dry-run", false, "only report what would change")
+	flags.Parse(args)
+
+	ms, err := openStorage(cfg, nil)
+	if err != nil {
+		return err
+	}
+	statuses, err := ms.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	var ran []storage.Migration
+	switch action {
+	case "status":
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied() {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-30s  %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	case "up":
+		target := *to
+		if target < 0 {
+			target = 0
+		}
+		ran, err = ms.MigrateUp(context.Background(), target, *dryRun)
+	case "down":
+		target := *to
+		if target < 0 {
+			target = previousVersion(statuses)
+		}
+		ran, err = ms.MigrateDown(context.Background(), target, *dryRun)
+	default:
+		return fmt.Errorf("unknown migrate action %q, expected status, up or down", action)
+	}
+
+	for _, m := range ran {
+		fmt.Printf("%04d  %s\n", m.Version, m.Name)
+	}
+	if err == nil && len(ran) == 0 {
+		fmt.Println("Nothing to migrate")
+	}
+	return err
+}
+
+// previousVersion is the version before the latest applied migration, which
+// is what a plain "migrate down" goes back to.
+func previousVersion(statuses []storage.MigrationStatus) int {
+	var applied []int
+	for _, s := range statuses {
+		if s.Applied() {
+			applied = append(applied, s.Version)
+		}
+	}
+	if len(applied) < 2 {
+		return 0
+	}
+	return applied[len(applied)-2]
+}
+
+// reindex re-embeds documents, either in place or into a new chunks
+// collection when -model names a different embedding model. Interrupting it
+// is safe; -resume continues from the last checkpoint.
+func reindex(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	model := flags.String("model", "", "embedding model to re-embed with (default: the active model)")
+	user := flags.String("user", "", "only re-embed this user's documents")
+	folder := flags.String("folder", "", "only re-embed documents in this folder")
+	tags := flags.String("tags", "", "only re-embed documents with all of these comma-separated tags")
+	rate := flags.Float64("rate", 2, "documents per second, 0 for no limit")
+	resume := flags.Bool("resume", false, "resume the last unfinished job")
+	dropOld := flags.Bool("drop-old", false, "drop the previous chunks collection after switching")
+	status := flags.Bool("status", false, "list recent jobs and exit")
+	flags.Parse(args)
+
+	ocr := storage.NewGeminiOCR(cfg)
+	ms, err := openStorage(cfg, ocr)
+	if err != nil {
+		return err
+	}
+	ocr.Prompter = prompts.New(ms)
+
+	if *status {
+		jobs, err := ms.ReindexJobs(10)
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			fmt.Printf("%s  %-8s  %s -> %s  %s  %d done, %d failed  %s\n",
+				job.ID.Hex(), job.Status, job.Source, job.Target, job.Model,
+				job.Processed, job.Failed, job.UpdatedAt.Format(time.RFC3339))
+		}
+		return nil
+	}
+
+	// Re-embedding extracts the text of PDFs and Word files again
+	if err := storage.SetLicense(cfg.Unidoc.APIKey); err != nil {
+		return fmt.Errorf("setting the Unidoc license: %w", err)
+	}
+
+	var tagList []string
+	if *tags != "" {
+		tagList = strings.Split(*tags, ",")
+	}
+
+	// Stop at the next checkpoint on Ctrl-C so the job can be resumed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	job, err := ms.Reindex(ctx, ai.NewEmbedder(cfg), storage.ReindexOptions{
+		Model:   *model,
+		UserID:  *user,
+		Folder:  *folder,
+		Tags:    tagList,
+		Rate:    *rate,
+		Resume:  *resume,
+		DropOld: *dropOld,
+	})
+	if job != nil {
+		fmt.Printf("Job %s is %s: %d documents, %d failed\n", job.ID.Hex(), job.Status, job.Processed, job.Failed)
+	}
+	return err
+}
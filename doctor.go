@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"github.com/unidoc/unipdf/v3/common/license"
+)
+
+// Exit codes of doctor. 2 is left to flag errors.
+const (
+	doctorFailed   = 1
+	doctorWarnings = 3
+)
+
+// checkup collects the outcome of doctor's checks.
+type checkup struct {
+	failures, warnings int
+}
+
+func (c *checkup) ok(format string, args ...any) {
+	fmt.Printf("ok    "+format+"\n", args...)
+}
+
+func (c *checkup) warn(format string, args ...any) {
+	c.warnings++
+	fmt.Printf("warn  "+format+"\n", args...)
+}
+
+func (c *checkup) fail(format string, args ...any) {
+	c.failures++
+	fmt.Printf("FAIL  "+format+"\n", args...)
+}
+
+// doctor checks that the server can run: the configuration, MongoDB, its
+// indexes, the provider keys and the Unidoc license. It exits with 0 when
+// everything is fine, 1 when something is broken and 3 when there are only
+// warnings.
+func doctor(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	offline := flags.Bool("offline", false, "skip the checks that call the model providers")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of each network check")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s doctor [flags]\n\nExits with 0 when healthy, 1 on failures and 3 on warnings only.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	c := &checkup{}
+	checkConfig(c, cfg)
+	checkMongo(c, cfg, *timeout)
+	if *offline {
+		fmt.Println("skip  model providers (-offline)")
+	} else {
+		checkProviders(c, cfg, *timeout)
+	}
+	checkLicense(c, cfg)
+
+	switch {
+	case c.failures > 0:
+		fmt.Printf("\n%d failed, %d warnings\n", c.failures, c.warnings)
+		return exitStatus(doctorFailed)
+	case c.warnings > 0:
+		fmt.Printf("\n%d warnings\n", c.warnings)
+		return exitStatus(doctorWarnings)
+	}
+	fmt.Println("\nAll checks passed")
+	return nil
+}
+
+// checkConfig reports every problem that would keep serve from starting.
+func checkConfig(c *checkup, cfg *config.Config) {
+	var problems config.Problems
+	if err := cfg.ValidateServer(); !errors.As(err, &problems) {
+		c.ok("configuration")
+		return
+	}
+	for _, problem := range problems {
+		c.fail("%s", problem)
+	}
+}
+
+func checkMongo(c *checkup, cfg *config.Config, timeout time.Duration) {
+	if cfg.Mongo.URI == "" {
+		return
+	}
+	ms, err := openStorage(cfg, nil)
+	if err != nil {
+		c.fail("MongoDB: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := ms.Ping(ctx); err != nil {
+		c.fail("MongoDB is unreachable: %v", err)
+		return
+	}
+	c.ok("MongoDB is reachable")
+
+	states, err := ms.IndexStates(ctx)
+	if err != nil {
+		c.fail("listing indexes: %v", err)
+		return
+	}
+	missing := 0
+	for _, state := range states {
+		switch {
+		case state.Ready:
+		case state.Status == "missing" || state.Status == "FAILED":
+			missing++
+			c.fail("index %s on %s is %s", state.Name, state.Collection, strings.ToLower(state.Status))
+		default:
+			c.warn("index %s on %s is %s", state.Name, state.Collection, strings.ToLower(state.Status))
+		}
+	}
+	if missing > 0 {
+		fmt.Printf("      run %s create-indexes to create the missing indexes\n", os.Args[0])
+	} else {
+		c.ok("%d indexes present", len(states))
+	}
+}
+
+func checkProviders(c *checkup, cfg *config.Config, timeout time.Duration) {
+	if cfg.Gemini.APIKey != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		model, err := ai.NewModel(cfg, "")
+		if err == nil {
+			_, err = model.CountTokens(ctx, "ping")
+			model.Close()
+		}
+		cancel()
+		if err != nil {
+			c.fail("Gemini key: %v", err)
+		} else {
+			c.ok("Gemini key")
+		}
+	}
+
+	if cfg.OpenAI.APIKey != "" {
+		embedder := ai.NewEmbedder(cfg)
+		if _, err := embedder.GenerateEmbeddings([]string{"ping"}); err != nil {
+			c.fail("OpenAI key: %v", err)
+		} else {
+			c.ok("OpenAI key (%s)", embedder.Model())
+		}
+	}
+
+	if url := cfg.Agent.OllamaURL; cfg.Agent.Provider == "ollama" && url != "" {
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Get(strings.TrimSuffix(url, "/") + "/api/tags")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("status %s", resp.Status)
+			}
+		}
+		if err != nil {
+			c.fail("Ollama at %s: %v", url, err)
+		} else {
+			c.ok("Ollama at %s", url)
+		}
+	}
+}
+
+// checkLicense sets the Unidoc license as serve does and reports its state.
+func checkLicense(c *checkup, cfg *config.Config) {
+	if cfg.Unidoc.APIKey == "" {
+		return
+	}
+	if err := storage.SetLicense(cfg.Unidoc.APIKey); err != nil {
+		c.fail("Unidoc license: %v", err)
+		return
+	}
+	if !license.GetLicenseKey().IsLicensed() {
+		c.fail("Unidoc license is not valid")
+		return
+	}
+	state, err := license.GetMeteredState()
+	switch {
+	case err != nil:
+		c.warn("Unidoc license usage: %v", err)
+	case !state.OK:
+		c.fail("Unidoc metered license is not accepted")
+	case state.Credits > 0 && state.Used >= state.Credits:
+		c.fail("Unidoc license has used all %d credits", state.Credits)
+	case state.Credits > 0 && state.Used*10 >= state.Credits*9:
+		c.warn("Unidoc license has used %d of %d credits", state.Used, state.Credits)
+	default:
+		c.ok("Unidoc license")
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/eval"
+	"github.com/sdrshn-nmbr/tusk/internal/prompts"
+	"github.com/sdrshn-nmbr/tusk/internal/retrieval"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"github.com/sdrshn-nmbr/tusk/internal/storage/memstore"
+)
+
+// evalUser owns the documents ingested for "eval run -docs".
+const evalUser = "eval"
+
+// evaluate runs "eval run", which measures retrieval on a golden dataset,
+// or "eval compare", which sets two saved runs side by side.
+func evaluate(cfg *config.Config, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New("expected run or compare")
+	}
+	switch action, args := args[0], args[1:]; action {
+	case "run":
+		return evalRun(cfg, args)
+	case "compare":
+		return evalCompare(args)
+	default:
+		return fmt.Errorf("unknown eval action %q, expected run or compare", action)
+	}
+}
+
+func evalRun(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("eval run", flag.ExitOnError)
+	datasetPath := flags.String("dataset", "", "JSON Lines file of question, expected_document and reference_answer")
+	userID := flags.String("user", "", "search this user's documents in MongoDB")
+	docs := flags.String("docs", "", "ingest this directory in memory with the configured chunking and search it instead")
+	k := flags.Int("k", 5, "cutoff of recall@k and nDCG@k")
+	judge := flags.Bool("judge", false, "answer every question and have the model grade faithfulness and relevance")
+	name := flags.String("name", "", "name of the run (default: the dataset and the time)")
+	output := flags.String("o", "", "write the run as JSON to this file")
+	concurrency := flags.Int("concurrency", 4, "questions evaluated at once")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s eval run -dataset FILE (-user ID | -docs DIR) [flags]\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *datasetPath == "" || (*userID == "") == (*docs == "") {
+		flags.Usage()
+		return exitStatus(2)
+	}
+	dataset, err := eval.LoadDataset(*datasetPath)
+	if err != nil {
+		return err
+	}
+
+	model, err := ai.NewModel(cfg, prompts.SystemPrompt)
+	if err != nil {
+		return fmt.Errorf("creating model: %w", err)
+	}
+	defer model.Close()
+	reranker, err := retrieval.NewReranker(cfg.Retrieval, model)
+	if err != nil {
+		return fmt.Errorf("creating reranker: %w", err)
+	}
+
+	// Retrieval is set up as serve sets it up, except that every reranked
+	// candidate is kept so the metrics see up to k documents rather than the
+	// few chunks the chat answers with
+	embedder := ai.NewEmbedder(cfg)
+	candidates := max(cfg.Retrieval.Candidates, *k)
+	e := &eval.Evaluator{
+		Retriever: &retrieval.Retriever{
+			Reranker: reranker,
+			Transformer: &retrieval.QueryTransformer{
+				Model:        model,
+				HistoryTurns: cfg.Retrieval.QueryHistoryTurns,
+				Paraphrases:  cfg.Retrieval.QueryParaphrases,
+				HyDE:         cfg.Retrieval.QueryHyDE,
+			},
+			NumCandidates: cfg.Retrieval.NumCandidates,
+			Candidates:    candidates,
+			Limit:         candidates,
+		},
+		Embedder:      embedder,
+		UserID:        *userID,
+		K:             *k,
+		ContextChunks: cfg.Retrieval.ContextChunks,
+		Concurrency:   *concurrency,
+	}
+	settings := eval.Settings{
+		Ingest:    cfg.Ingest,
+		Retrieval: cfg.Retrieval,
+		Chat:      cfg.Chat,
+		Model:     cfg.Gemini.Model,
+	}
+
+	if *docs != "" {
+		store, err := ingestDirectory(cfg, *docs, embedder)
+		if err != nil {
+			return err
+		}
+		e.Retriever.Searcher, e.UserID = store, evalUser
+		settings.Source = *docs
+	} else {
+		ms, err := openStorage(cfg, nil)
+		if err != nil {
+			return err
+		}
+		active := ms.ActiveEmbedder(embedder)
+		e.Retriever.Searcher, e.Embedder = ms, active
+		embedder = active
+		settings.Source = "user " + *userID
+	}
+	settings.EmbeddingModel = embedder.Model()
+
+	if *judge {
+		e.Model = model
+		e.Budgeter = &ai.Budgeter{Counter: model, MaxPromptTokens: cfg.Chat.MaxPromptTokens, HistoryShare: cfg.Chat.HistoryShare}
+		e.Judge = &eval.Judge{Model: model}
+		settings.SystemPrompt = prompts.SystemPrompt
+	}
+
+	run, err := e.Run(context.Background(), dataset)
+	if err != nil {
+		return err
+	}
+	run.Name = *name
+	if run.Name == "" {
+		run.Name = dataset.Name + "-" + run.StartedAt.Local().Format("20060102-150405")
+	}
+	run.Settings = settings
+
+	printRun(run)
+	if *output != "" {
+		if err := run.WriteFile(*output); err != nil {
+			return err
+		}
+		fmt.Printf("\nWrote %s\n", *output)
+	}
+	return nil
+}
+
+// ingestDirectory chunks and embeds every supported file under dir into an
+// in-memory store, named by its path relative to dir.
+func ingestDirectory(cfg *config.Config, dir string, embedder *ai.Embedder) (*memstore.Store, error) {
+	// PDF and Word files need the license; plain text and images do not
+	if cfg.Unidoc.APIKey != "" {
+		if err := storage.SetLicense(cfg.Unidoc.APIKey); err != nil {
+			return nil, fmt.Errorf("setting the Unidoc license: %w", err)
+		}
+	}
+
+	store := memstore.NewWithExtractor(storage.NewTextExtractor(cfg.Ingest, storage.NewGeminiOCR(cfg)))
+	start, count := time.Now(), 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := store.SaveFile(filepath.ToSlash(name), file, embedder, evalUser); err != nil {
+			return fmt.Errorf("ingesting %s: %w", name, err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Ingested %d documents in %s\n", count, time.Since(start).Round(time.Millisecond))
+	return store, nil
+}
+
+func printRun(run *eval.Run) {
+	s := run.Summary
+	fmt.Printf("%s: %d cases of %s", run.Name, s.Cases, run.Dataset)
+	if s.Failed > 0 {
+		fmt.Printf(", %d failed", s.Failed)
+	}
+	if s.AnswerFailed > 0 {
+		fmt.Printf(", %d not answered", s.AnswerFailed)
+	}
+	if s.GradeFailed > 0 {
+		fmt.Printf(", %d not graded", s.GradeFailed)
+	}
+	fmt.Printf(" in %s\n\n", run.Duration)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "recall@%d\t%.3f\n", run.K, s.Recall)
+	fmt.Fprintf(tw, "MRR\t%.3f\n", s.MRR)
+	fmt.Fprintf(tw, "nDCG@%d\t%.3f\n", run.K, s.NDCG)
+	if s.Faithfulness != nil {
+		fmt.Fprintf(tw, "faithfulness\t%.3f\n", *s.Faithfulness)
+		fmt.Fprintf(tw, "answer relevance\t%.3f\n", *s.Relevance)
+	}
+	tw.Flush()
+
+	var missed []eval.Result
+	for _, result := range run.Results {
+		if result.Error == "" && result.Recall == 0 {
+			missed = append(missed, result)
+		}
+	}
+	if len(missed) > 0 {
+		fmt.Printf("\nExpected document not in the top %d (%d):\n", run.K, len(missed))
+		for _, result := range missed {
+			fmt.Printf("  %s\n    expected %s, got %s\n", result.Question, result.ExpectedDocument, strings.Join(result.Retrieved, ", "))
+		}
+	}
+	for _, result := range run.Results {
+		switch {
+		case result.Error != "":
+			fmt.Printf("\nFailed: %s\n  %s\n", result.Question, result.Error)
+		case result.AnswerError != "":
+			fmt.Printf("\nNot answered: %s\n  %s\n", result.Question, result.AnswerError)
+		case result.GradeError != "":
+			fmt.Printf("\nNot graded: %s\n  %s\n", result.Question, result.GradeError)
+		}
+	}
+}
+
+func evalCompare(args []string) error {
+	flags := flag.NewFlagSet("eval compare", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s eval compare BASE.json RUN.json\n", os.Args[0])
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return exitStatus(2)
+	}
+
+	base, err := eval.ReadRun(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	run, err := eval.ReadRun(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	eval.Compare(base, run).Write(os.Stdout)
+	return nil
+}
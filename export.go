@@ -0,0 +1,121 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+)
+
+// export writes users' data to a JSON lines file, gzipped when its name
+// ends in .gz, to back it up or move it to another deployment.
+func export(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	userID := flags.String("user", "", "only export this user (default: every user)")
+	output := flags.String("o", "", "file to write, standard output when empty or -")
+	flags.Parse(args)
+
+	ms, err := openStorage(cfg, nil)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if *output != "" && *output != "-" {
+		if file, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	var gz *gzip.Writer
+	if strings.HasSuffix(*output, ".gz") {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	counts, err := ms.Export(ctx, w, ai.NewEmbedder(cfg), *userID)
+	if err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	printCounts(os.Stderr, "exported", counts)
+	return nil
+}
+
+// importData loads a file written by export. Records that already exist are
+// skipped, so an interrupted import can simply be run again.
+func importData(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected the file to import, - for standard input")
+	}
+	name := flags.Arg(0)
+
+	ms, err := openStorage(cfg, nil)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result, err := ms.Import(ctx, r, ai.NewEmbedder(cfg))
+	if result != nil {
+		printCounts(os.Stdout, "imported", result.Imported)
+		printCounts(os.Stdout, "already existed", result.Existing)
+		if result.SkippedChunks > 0 {
+			fmt.Printf("Skipped %d chunks embedded with another model; run \"reindex\" to make the imported documents searchable\n", result.SkippedChunks)
+		}
+	}
+	return err
+}
+
+func printCounts(w io.Writer, verb string, counts map[string]int64) {
+	collections := make([]string, 0, len(counts))
+	for collection := range counts {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	for _, collection := range collections {
+		fmt.Fprintf(w, "%-22s %d %s\n", collection, counts[collection], verb)
+	}
+}
@@ -0,0 +1,207 @@
+// Package agent answers chat messages with a tool-calling loop: the model
+// decides which of the user's documents to search, list, read or summarize
+// before it answers.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+)
+
+// Tool is a function exposed to the model. Run always receives the user of
+// the request from the server, never from the model's arguments, so a tool
+// can only reach that user's data.
+type Tool struct {
+	Spec ai.ToolSpec
+	Run  func(ctx context.Context, userID string, args Args) (map[string]any, error)
+}
+
+// Permissions decides which tools a user's agent may call.
+type Permissions interface {
+	DisabledTools(userID string) ([]string, error)
+}
+
+type Agent struct {
+	Model       ai.ToolCaller
+	Tools       []Tool
+	Permissions Permissions
+
+	// MaxSteps bounds the model requests that may call tools; one more is
+	// made without tools if the model is still calling them.
+	MaxSteps    int
+	ToolTimeout time.Duration
+}
+
+// Step is one tool call in the trace returned to the UI.
+type Step struct {
+	Step       int            `json:"step"`
+	Tool       string         `json:"tool"`
+	Args       map[string]any `json:"args"`
+	Result     string         `json:"result,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	DurationMS int64          `json:"duration_ms"`
+}
+
+type Result struct {
+	Answer string
+	Trace  []Step
+	Usage  ai.Usage
+}
+
+const systemPrompt = `You are an assistant that answers questions about the user's files. Use the tools to find and read the relevant files before answering, and do not guess what a file says. Answer naturally; name the files you used when it helps the user.`
+
+// maxTracePreview bounds the tool result shown in the trace; the model gets
+// the full result.
+const maxTracePreview = 500
+
+// Run answers query, continuing the conversation in history.
+func (a *Agent) Run(ctx context.Context, userID string, history []ai.ChatMessage, query string) (*Result, error) {
+	tools, err := a.allowedTools(userID)
+	if err != nil {
+		return nil, err
+	}
+	specs := make([]ai.ToolSpec, len(tools))
+	byName := make(map[string]Tool, len(tools))
+	for i, tool := range tools {
+		specs[i] = tool.Spec
+		byName[tool.Spec.Name] = tool
+	}
+
+	var messages []ai.AgentMessage
+	for _, msg := range history {
+		role := "user"
+		if msg.Sender != "user" {
+			role = "model"
+		}
+		messages = append(messages, ai.AgentMessage{Role: role, Content: msg.Content})
+	}
+	messages = append(messages, ai.AgentMessage{Role: "user", Content: query})
+
+	result := &Result{Trace: []Step{}}
+	for step := 1; ; step++ {
+		noCalls := step > a.MaxSteps || len(specs) == 0
+		reply, usage, err := a.Model.CallTools(ctx, ai.ToolRequest{
+			System:   systemPrompt,
+			Messages: messages,
+			Tools:    specs,
+			NoCalls:  noCalls,
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.Usage.PromptTokens += usage.PromptTokens
+		result.Usage.CompletionTokens += usage.CompletionTokens
+		result.Usage.TotalTokens += usage.TotalTokens
+
+		if len(reply.Calls) == 0 || noCalls {
+			result.Answer = reply.Content
+			return result, nil
+		}
+
+		messages = append(messages, reply)
+		results := ai.AgentMessage{Role: "tool"}
+		for _, call := range reply.Calls {
+			content, traced := a.call(ctx, userID, byName, call)
+			traced.Step = step
+			result.Trace = append(result.Trace, traced)
+			results.Results = append(results.Results, ai.ToolResult{CallID: call.ID, Name: call.Name, Content: content})
+		}
+		messages = append(messages, results)
+	}
+}
+
+// call runs one tool call. Failures are reported to the model as the
+// result so it can correct its arguments or try something else.
+func (a *Agent) call(ctx context.Context, userID string, tools map[string]Tool, call ai.ToolCall) (map[string]any, Step) {
+	traced := Step{Tool: call.Name, Args: call.Args}
+	start := time.Now()
+
+	var content map[string]any
+	var err error
+	if tool, ok := tools[call.Name]; !ok {
+		err = fmt.Errorf("tool %q is not available", call.Name)
+	} else {
+		timeout := a.ToolTimeout
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		toolCtx, cancel := context.WithTimeout(ctx, timeout)
+		content, err = tool.Run(toolCtx, userID, Args(call.Args))
+		cancel()
+	}
+	traced.DurationMS = time.Since(start).Milliseconds()
+
+	if err != nil {
+		log.Printf("Agent tool %s failed: %+v", call.Name, err)
+		traced.Error = err.Error()
+		return map[string]any{"error": err.Error()}, traced
+	}
+
+	preview, _ := json.Marshal(content)
+	traced.Result = string(preview)
+	if runes := []rune(traced.Result); len(runes) > maxTracePreview {
+		traced.Result = string(runes[:maxTracePreview]) + "..."
+	}
+	return content, traced
+}
+
+func (a *Agent) allowedTools(userID string) ([]Tool, error) {
+	if a.Permissions == nil {
+		return a.Tools, nil
+	}
+	disabled, err := a.Permissions.DisabledTools(userID)
+	if err != nil {
+		return nil, err
+	}
+	off := make(map[string]bool, len(disabled))
+	for _, name := range disabled {
+		off[name] = true
+	}
+	var tools []Tool
+	for _, tool := range a.Tools {
+		if !off[tool.Spec.Name] {
+			tools = append(tools, tool)
+		}
+	}
+	return tools, nil
+}
+
+// Args reads the model's arguments, which arrive as decoded JSON.
+type Args map[string]any
+
+func (a Args) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+// Int returns the argument clamped to [min, max], or def when it is missing.
+func (a Args) Int(name string, def, min, max int) int {
+	n := def
+	if f, ok := a[name].(float64); ok {
+		n = int(f)
+	}
+	if n < min {
+		n = min
+	}
+	if n > max {
+		n = max
+	}
+	return n
+}
+
+func (a Args) Strings(name string) []string {
+	var out []string
+	if values, ok := a[name].([]any); ok {
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+)
+
+// scriptedModel calls the given tool on every request that allows calls.
+type scriptedModel struct {
+	tool     string
+	requests []ai.ToolRequest
+}
+
+func (m *scriptedModel) CallTools(ctx context.Context, req ai.ToolRequest) (ai.AgentMessage, ai.Usage, error) {
+	m.requests = append(m.requests, req)
+	usage := ai.Usage{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12}
+	if req.NoCalls {
+		return ai.AgentMessage{Role: "model", Content: "done"}, usage, nil
+	}
+	call := ai.ToolCall{Name: m.tool, Args: map[string]any{"query": "q"}}
+	return ai.AgentMessage{Role: "model", Calls: []ai.ToolCall{call}}, usage, nil
+}
+
+type disabled []string
+
+func (d disabled) DisabledTools(userID string) ([]string, error) {
+	return d, nil
+}
+
+func echoTool(name string, users *[]string) Tool {
+	return Tool{
+		Spec: ai.ToolSpec{Name: name},
+		Run: func(ctx context.Context, userID string, args Args) (map[string]any, error) {
+			*users = append(*users, userID)
+			return map[string]any{"query": args.String("query")}, nil
+		},
+	}
+}
+
+func TestRunStopsAfterMaxSteps(t *testing.T) {
+	var users []string
+	model := &scriptedModel{tool: "search"}
+	a := &Agent{Model: model, Tools: []Tool{echoTool("search", &users)}, MaxSteps: 3}
+
+	result, err := a.Run(context.Background(), "user-1", nil, "question")
+	if err != nil {
+		t.Fatalf("Run failed: %+v", err)
+	}
+	if result.Answer != "done" {
+		t.Errorf("Expected the final answer, got %q", result.Answer)
+	}
+	if len(model.requests) != 4 || !model.requests[3].NoCalls {
+		t.Errorf("Expected 3 tool steps and a final request without tools, got %d requests", len(model.requests))
+	}
+	if len(result.Trace) != 3 || result.Trace[2].Step != 3 || result.Trace[0].Result != `{"query":"q"}` {
+		t.Errorf("Unexpected trace: %+v", result.Trace)
+	}
+	if result.Usage.TotalTokens != 48 {
+		t.Errorf("Expected usage summed over requests, got %+v", result.Usage)
+	}
+	if !slices.Equal(users, []string{"user-1", "user-1", "user-1"}) {
+		t.Errorf("Tools ran for the wrong user: %q", users)
+	}
+
+	// The tool results are sent back to the model
+	last := model.requests[3].Messages
+	if len(last) != 7 || last[6].Role != "tool" || last[6].Results[0].Content["query"] != "q" {
+		t.Errorf("Unexpected messages in the final request: %+v", last)
+	}
+}
+
+func TestRunSkipsDisabledTools(t *testing.T) {
+	var users []string
+	model := &scriptedModel{tool: "summarize"}
+	a := &Agent{
+		Model:       model,
+		Tools:       []Tool{echoTool("search", &users), echoTool("summarize", &users)},
+		Permissions: disabled{"summarize"},
+		MaxSteps:    1,
+	}
+
+	result, err := a.Run(context.Background(), "user-1", nil, "question")
+	if err != nil {
+		t.Fatalf("Run failed: %+v", err)
+	}
+	if specs := model.requests[0].Tools; len(specs) != 1 || specs[0].Name != "search" {
+		t.Errorf("Expected only search to be offered, got %+v", specs)
+	}
+	if len(users) != 0 {
+		t.Errorf("Disabled tool ran %d times", len(users))
+	}
+	if len(result.Trace) != 1 || result.Trace[0].Error == "" {
+		t.Errorf("Expected the disabled call to fail in the trace, got %+v", result.Trace)
+	}
+}
@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Backend is what the tools read. Every method is scoped to userID.
+type Backend interface {
+	Search(ctx context.Context, userID, query string, filter storage.DocumentFilter, limit int) ([]storage.Chunk, error)
+	ListFiles(userID string, filter storage.DocumentFilter) ([]storage.Document, error)
+	GetDocument(id string, userID string) (*storage.Document, error)
+	DocumentChunks(ids []primitive.ObjectID, userID string) ([]storage.Chunk, error)
+	Summarize(ctx context.Context, doc *storage.Document, userID string) (*storage.DocumentSummary, error)
+}
+
+// Tool names.
+const (
+	SearchDocuments     = "search_documents"
+	ListFiles           = "list_files"
+	ReadDocumentSection = "read_document_section"
+	GetDocumentMetadata = "get_document_metadata"
+	SummarizeDocument   = "summarize_document"
+)
+
+// ToolNames lists every tool, for settings that disable some of them.
+var ToolNames = []string{SearchDocuments, ListFiles, ReadDocumentSection, GetDocumentMetadata, SummarizeDocument}
+
+const maxListedFiles = 50
+
+// NewTools returns the document tools backed by b.
+func NewTools(b Backend) []Tool {
+	return []Tool{
+		{
+			Spec: ai.ToolSpec{
+				Name:        SearchDocuments,
+				Description: "Semantic search over the user's files. Returns the most relevant passages with their document ids.",
+				Parameters: map[string]ai.ToolParam{
+					"query":  {Type: "string", Description: "What to look for, as a standalone question or phrase"},
+					"folder": {Type: "string", Description: "Only search files in this folder"},
+					"tags":   {Type: "array", Description: "Only search files with all of these tags"},
+					"limit":  {Type: "integer", Description: "How many passages to return, 1 to 10"},
+				},
+				Required: []string{"query"},
+			},
+			Run: func(ctx context.Context, userID string, args Args) (map[string]any, error) {
+				query := args.String("query")
+				if query == "" {
+					return nil, fmt.Errorf("query is required")
+				}
+				filter := storage.DocumentFilter{Folder: args.String("folder"), Tags: args.Strings("tags")}
+				chunks, err := b.Search(ctx, userID, query, filter, args.Int("limit", 5, 1, 10))
+				if err != nil {
+					return nil, err
+				}
+				passages := make([]map[string]any, len(chunks))
+				for i, chunk := range chunks {
+					passages[i] = map[string]any{
+						"document_id": chunk.DocumentID.Hex(),
+						"filename":    chunk.Filename,
+						"content":     chunk.Content,
+					}
+				}
+				return map[string]any{"passages": passages}, nil
+			},
+		},
+		{
+			Spec: ai.ToolSpec{
+				Name:        ListFiles,
+				Description: "Lists the user's files with their ids, folders, tags and titles.",
+				Parameters: map[string]ai.ToolParam{
+					"folder": {Type: "string", Description: "Only list files in this folder"},
+					"tag":    {Type: "string", Description: "Only list files with this tag"},
+				},
+			},
+			Run: func(ctx context.Context, userID string, args Args) (map[string]any, error) {
+				filter := storage.DocumentFilter{Folder: args.String("folder"), SortBy: "uploaded", SortDesc: true}
+				if tag := args.String("tag"); tag != "" {
+					filter.Tags = []string{tag}
+				}
+				docs, err := b.ListFiles(userID, filter)
+				if err != nil {
+					return nil, err
+				}
+				files := make([]map[string]any, 0, len(docs))
+				for i, doc := range docs {
+					if i == maxListedFiles {
+						break
+					}
+					files = append(files, map[string]any{
+						"document_id": doc.ID.Hex(),
+						"filename":    doc.Filename,
+						"folder":      doc.Folder,
+						"tags":        doc.Tags,
+						"title":       doc.Metadata["title"],
+					})
+				}
+				return map[string]any{"files": files, "total": len(docs)}, nil
+			},
+		},
+		{
+			Spec: ai.ToolSpec{
+				Name:        ReadDocumentSection,
+				Description: "Reads consecutive sections of a file in order. Sections are numbered from 0; the result says how many there are.",
+				Parameters: map[string]ai.ToolParam{
+					"document_id": {Type: "string", Description: "The file's document id"},
+					"section":     {Type: "integer", Description: "The first section to read"},
+					"count":       {Type: "integer", Description: "How many sections to read, 1 to 5"},
+				},
+				Required: []string{"document_id"},
+			},
+			Run: func(ctx context.Context, userID string, args Args) (map[string]any, error) {
+				doc, err := b.GetDocument(args.String("document_id"), userID)
+				if err != nil {
+					return nil, err
+				}
+				chunks, err := b.DocumentChunks([]primitive.ObjectID{doc.ID}, userID)
+				if err != nil {
+					return nil, err
+				}
+				if len(chunks) == 0 {
+					return map[string]any{"filename": doc.Filename, "sections": 0, "text": ""}, nil
+				}
+
+				from := args.Int("section", 0, 0, len(chunks)-1)
+				to := from + args.Int("count", 2, 1, 5)
+				if to > len(chunks) {
+					to = len(chunks)
+				}
+				texts := make([]string, 0, to-from)
+				for _, chunk := range chunks[from:to] {
+					texts = append(texts, chunk.Content)
+				}
+				return map[string]any{
+					"filename": doc.Filename,
+					"sections": len(chunks),
+					"from":     from,
+					"to":       to - 1,
+					"text":     strings.Join(texts, "\n"),
+				}, nil
+			},
+		},
+		{
+			Spec: ai.ToolSpec{
+				Name:        GetDocumentMetadata,
+				Description: "Returns a file's name, folder, tags, typed attributes, size, title, abstract and upload date.",
+				Parameters: map[string]ai.ToolParam{
+					"document_id": {Type: "string", Description: "The file's document id"},
+				},
+				Required: []string{"document_id"},
+			},
+			Run: func(ctx context.Context, userID string, args Args) (map[string]any, error) {
+				doc, err := b.GetDocument(args.String("document_id"), userID)
+				if err != nil {
+					return nil, err
+				}
+				attributes := make(map[string]any, len(doc.Attributes))
+				for key, value := range doc.Attributes {
+					attributes[key] = storage.FormatAttribute(value)
+				}
+				return map[string]any{
+					"document_id": doc.ID.Hex(),
+					"filename":    doc.Filename,
+					"folder":      doc.Folder,
+					"tags":        doc.Tags,
+					"attributes":  attributes,
+					"size_bytes":  doc.Size(),
+					"title":       doc.Metadata["title"],
+					"abstract":    doc.Metadata["abstract"],
+					"uploaded_at": doc.UploadedAt.Format("2006-01-02"),
+				}, nil
+			},
+		},
+		{
+			Spec: ai.ToolSpec{
+				Name:        SummarizeDocument,
+				Description: "Summarizes a whole file. Slow the first time for long files; the summary is cached afterwards.",
+				Parameters: map[string]ai.ToolParam{
+					"document_id": {Type: "string", Description: "The file's document id"},
+				},
+				Required: []string{"document_id"},
+			},
+			Run: func(ctx context.Context, userID string, args Args) (map[string]any, error) {
+				doc, err := b.GetDocument(args.String("document_id"), userID)
+				if err != nil {
+					return nil, err
+				}
+				summary, err := b.Summarize(ctx, doc, userID)
+				if err != nil {
+					return nil, err
+				}
+				return map[string]any{"filename": doc.Filename, "summary": summary.Text}, nil
+			},
+		},
+	}
+}
@@ -0,0 +1,167 @@
+// Package aitest provides a scripted language model for tests that must not
+// call a provider.
+package aitest
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+)
+
+// DefaultReply is what a Model answers when Reply is empty.
+const DefaultReply = "This is a scripted answer."
+
+// Model answers every prompt with Reply, streamed word by word, and records
+// the prompts it was given. It never calls tools.
+type Model struct {
+	Reply string
+	// Script, when set, holds the replies of the first calls in order;
+	// once it runs out the model answers with Reply
+	Script []string
+	// JSON is what GenerateJSON returns, "{}" when empty
+	JSON string
+	// Err, when set, fails every call
+	Err error
+	// StreamErr, when set, fails a streamed answer after its tokens were
+	// sent, like a provider dropping the connection
+	StreamErr error
+
+	mu      sync.Mutex
+	prompts []string
+	systems []string
+	replies int
+}
+
+// reply returns the answer to the next call.
+func (m *Model) reply() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.replies < len(m.Script) {
+		m.replies++
+		return m.Script[m.replies-1]
+	}
+	if m.Reply == "" {
+		return DefaultReply
+	}
+	return m.Reply
+}
+
+func (m *Model) record(prompt string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prompts = append(m.prompts, prompt)
+}
+
+// Prompts returns the prompts the model was given so far.
+func (m *Model) Prompts() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.prompts...)
+}
+
+// SystemPrompts returns the system prompts Answer was given so far, with
+// SystemPrompt standing in for empty ones.
+func (m *Model) SystemPrompts() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.systems...)
+}
+
+// Tokens splits text into the chunks Answer streams: every word with the
+// space after it.
+func Tokens(text string) []string {
+	return strings.SplitAfter(text, " ")
+}
+
+func (m *Model) Generate(ctx context.Context, prompt string) (string, error) {
+	m.record(prompt)
+	if m.Err != nil {
+		return "", m.Err
+	}
+	return m.reply(), nil
+}
+
+func (m *Model) GenerateJSON(ctx context.Context, prompt string, schema *genai.Schema) (string, error) {
+	m.record(prompt)
+	if m.Err != nil {
+		return "", m.Err
+	}
+	if m.JSON == "" {
+		return "{}", nil
+	}
+	return m.JSON, nil
+}
+
+func (m *Model) Answer(ctx context.Context, system string, history []ai.ChatMessage, prompt string, usage *ai.Usage) (<-chan string, <-chan error) {
+	if system == "" {
+		system = m.SystemPrompt()
+	}
+	m.mu.Lock()
+	m.systems = append(m.systems, system)
+	m.mu.Unlock()
+	m.record(prompt)
+	reply := m.reply()
+	if usage != nil {
+		*usage = ai.Usage{
+			PromptTokens:     ai.EstimateTokens(prompt),
+			CompletionTokens: ai.EstimateTokens(reply),
+		}
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	return m.stream(ctx, reply)
+}
+
+func (m *Model) GenerateResponse(ctx context.Context, query string, imgData []byte, chunks ...string) (<-chan string, <-chan error) {
+	m.record(query)
+	return m.stream(ctx, m.reply())
+}
+
+func (m *Model) stream(ctx context.Context, reply string) (<-chan string, <-chan error) {
+	responseChan := make(chan string)
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer close(responseChan)
+		defer close(errChan)
+
+		if m.Err != nil {
+			errChan <- m.Err
+			return
+		}
+		for _, token := range Tokens(reply) {
+			select {
+			case responseChan <- token:
+			case <-ctx.Done():
+				errChan <- ctx.Err()
+				return
+			}
+		}
+		if m.StreamErr != nil {
+			errChan <- m.StreamErr
+		}
+	}()
+
+	return responseChan, errChan
+}
+
+// CallTools answers with Reply right away.
+func (m *Model) CallTools(ctx context.Context, req ai.ToolRequest) (ai.AgentMessage, ai.Usage, error) {
+	if len(req.Messages) > 0 {
+		m.record(req.Messages[len(req.Messages)-1].Content)
+	}
+	if m.Err != nil {
+		return ai.AgentMessage{}, ai.Usage{}, m.Err
+	}
+	return ai.AgentMessage{Role: "model", Content: m.reply()}, ai.Usage{}, nil
+}
+
+func (m *Model) GetHistory() []ai.ChatMessage {
+	return nil
+}
+
+func (m *Model) SystemPrompt() string {
+	return "You are a test assistant."
+}
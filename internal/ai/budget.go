@@ -0,0 +1,169 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Budgeter decides how much retrieved context and conversation history fit
+// into a prompt of at most MaxPromptTokens.
+//
+// Parts are planned with EstimateTokens and the result is checked with
+// Counter. When the exact count is over budget the estimate is scaled by the
+// observed ratio and the prompt planned again.
+type Budgeter struct {
+	Counter         TokenCounter
+	MaxPromptTokens int
+
+	// HistoryShare is the part of the budget left after the system prompt
+	// and query that history may claim before chunks are added. Whatever
+	// the chunks leave over goes to older history.
+	HistoryShare float64
+}
+
+// PromptParts are the inputs to a prompt, chunks in order of relevance and
+// history oldest first. Summary condenses turns older than History.
+type PromptParts struct {
+	System  string
+	Summary string
+	Query   string
+	Chunks  []string
+	History []ChatMessage
+
+	// Layout, when set, writes the prompt from the chunks a plan kept,
+	// given by their index in Chunks. Without it the chunks and the query
+	// are joined as PromptPlan.Prompt does.
+	Layout func(kept []int) string
+}
+
+// Prompt is the message that asks the query with the planned chunks.
+func (p PromptParts) Prompt(plan PromptPlan) string {
+	if p.Layout != nil {
+		return p.Layout(plan.ChunkIndexes)
+	}
+	return plan.Prompt(p.Query)
+}
+
+// PromptPlan is what fits. Dropped history is always the oldest turns.
+type PromptPlan struct {
+	Summary string
+	Chunks  []string
+	// ChunkIndexes are the positions of Chunks in PromptParts.Chunks
+	ChunkIndexes  []int
+	History       []ChatMessage
+	DroppedChunks int
+	DroppedTurns  int
+	PromptTokens  int
+}
+
+// Prompt joins the chunks and the query the way GenerateResponse does.
+func (p PromptPlan) Prompt(query string) string {
+	return strings.Join(append(append([]string{}, p.Chunks...), "Query: "+query), "\n")
+}
+
+// Messages returns the history to send, led by the summary when there is one.
+func (p PromptPlan) Messages() []ChatMessage {
+	if p.Summary == "" {
+		return p.History
+	}
+	summary := ChatMessage{Sender: "user", Content: "Summary of our conversation so far:\n" + p.Summary}
+	return append([]ChatMessage{summary}, p.History...)
+}
+
+const maxBudgetAttempts = 3
+
+// Fit plans the prompt. The system prompt, summary and query are always
+// kept, even if they alone exceed the budget.
+func (b *Budgeter) Fit(ctx context.Context, parts PromptParts) (PromptPlan, error) {
+	scale := 1.0
+	var plan PromptPlan
+	for attempt := 0; attempt < maxBudgetAttempts; attempt++ {
+		var estimated int
+		plan, estimated = b.plan(parts, scale)
+		if b.Counter == nil {
+			plan.PromptTokens = estimated
+			return plan, nil
+		}
+
+		exact, err := b.Counter.CountTokens(ctx, renderPlan(parts, plan))
+		if err != nil {
+			return plan, err
+		}
+		plan.PromptTokens = exact
+		if exact <= b.MaxPromptTokens || estimated == 0 {
+			return plan, nil
+		}
+
+		scale *= float64(exact) / float64(estimated) * 1.05
+		log.Printf("Prompt has %d tokens, over the budget of %d; planning again", exact, b.MaxPromptTokens)
+	}
+	return plan, fmt.Errorf("prompt has %d tokens, over the budget of %d", plan.PromptTokens, b.MaxPromptTokens)
+}
+
+// plan fills the budget using scaled estimates and returns the estimate of
+// the whole prompt.
+func (b *Budgeter) plan(parts PromptParts, scale float64) (PromptPlan, int) {
+	estimate := func(text string) int {
+		return int(float64(EstimateTokens(text))*scale) + 1
+	}
+
+	used := estimate(parts.System) + estimate(parts.Summary) + estimate("Query: "+parts.Query)
+	remaining := b.MaxPromptTokens - used
+
+	// Newest history first, up to its share
+	historyCap := int(float64(remaining) * b.HistoryShare)
+	kept := 0
+	historyTokens := 0
+	for i := len(parts.History) - 1; i >= 0; i-- {
+		n := estimate(parts.History[i].Content)
+		if historyTokens+n > historyCap {
+			break
+		}
+		historyTokens += n
+		kept++
+	}
+	remaining -= historyTokens
+
+	// Chunks in order of relevance, skipping any that do not fit
+	plan := PromptPlan{Summary: parts.Summary}
+	for i, chunk := range parts.Chunks {
+		n := estimate(chunk)
+		if n > remaining {
+			plan.DroppedChunks++
+			continue
+		}
+		plan.Chunks = append(plan.Chunks, chunk)
+		plan.ChunkIndexes = append(plan.ChunkIndexes, i)
+		remaining -= n
+	}
+
+	// Older history takes what the chunks left
+	for i := len(parts.History) - kept - 1; i >= 0; i-- {
+		n := estimate(parts.History[i].Content)
+		if n > remaining {
+			break
+		}
+		historyTokens += n
+		remaining -= n
+		kept++
+	}
+
+	plan.History = parts.History[len(parts.History)-kept:]
+	plan.DroppedTurns = len(parts.History) - kept
+	return plan, b.MaxPromptTokens - remaining
+}
+
+// renderPlan is the text the model receives, for exact counting.
+func renderPlan(parts PromptParts, plan PromptPlan) string {
+	var b strings.Builder
+	b.WriteString(parts.System)
+	b.WriteString("\n")
+	for _, msg := range plan.Messages() {
+		b.WriteString(msg.Content)
+		b.WriteString("\n")
+	}
+	b.WriteString(parts.Prompt(plan))
+	return b.String()
+}
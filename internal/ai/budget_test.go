@@ -0,0 +1,81 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBudgeterFit(t *testing.T) {
+	chunk := strings.Repeat("word ", 80) // ~100 tokens
+	var history []ChatMessage
+	for i := 0; i < 10; i++ {
+		history = append(history, ChatMessage{Sender: "user", Content: strings.Repeat("turn ", 40)}) // ~50 tokens
+	}
+
+	b := &Budgeter{MaxPromptTokens: 500, HistoryShare: 0.3}
+	plan, err := b.Fit(context.Background(), PromptParts{
+		System:  "Be brief.",
+		Query:   "What changed?",
+		Chunks:  []string{chunk, chunk, chunk, chunk, chunk},
+		History: history,
+	})
+	if err != nil {
+		t.Fatalf("Fit failed: %+v", err)
+	}
+
+	if len(plan.Chunks) == 0 || plan.DroppedChunks == 0 {
+		t.Errorf("Expected some chunks to be kept and some dropped, got %d kept, %d dropped", len(plan.Chunks), plan.DroppedChunks)
+	}
+	if len(plan.History)+plan.DroppedTurns != len(history) || plan.DroppedTurns == 0 {
+		t.Errorf("Expected the oldest turns to be dropped, got %d kept, %d dropped", len(plan.History), plan.DroppedTurns)
+	}
+	if plan.PromptTokens > b.MaxPromptTokens {
+		t.Errorf("Expected at most %d tokens, got %d", b.MaxPromptTokens, plan.PromptTokens)
+	}
+}
+
+// doubleCounter counts twice as many tokens as EstimateTokens predicts.
+type doubleCounter struct{}
+
+func (doubleCounter) CountTokens(ctx context.Context, text string) (int, error) {
+	return 2 * EstimateTokens(text), nil
+}
+
+func TestBudgeterRescalesOnExactCount(t *testing.T) {
+	chunk := strings.Repeat("word ", 80)
+	b := &Budgeter{Counter: doubleCounter{}, MaxPromptTokens: 500, HistoryShare: 0.3}
+	plan, err := b.Fit(context.Background(), PromptParts{
+		Query:  "q",
+		Chunks: []string{chunk, chunk, chunk, chunk},
+	})
+	if err != nil {
+		t.Fatalf("Fit failed: %+v", err)
+	}
+	if plan.PromptTokens > 500 || len(plan.Chunks) != 2 {
+		t.Errorf("Expected 2 chunks within 500 tokens, got %d chunks and %d tokens", len(plan.Chunks), plan.PromptTokens)
+	}
+}
+
+func TestBudgeterSkipsChunksThatDoNotFit(t *testing.T) {
+	short, long := "short chunk", strings.Repeat("word ", 400)
+	parts := PromptParts{
+		Query:  "q",
+		Chunks: []string{long, short, long, short},
+		Layout: func(kept []int) string {
+			return fmt.Sprintf("sources %v", kept)
+		},
+	}
+	plan, err := (&Budgeter{MaxPromptTokens: 200}).Fit(context.Background(), parts)
+	if err != nil {
+		t.Fatalf("Fit failed: %+v", err)
+	}
+	if !slices.Equal(plan.ChunkIndexes, []int{1, 3}) || plan.DroppedChunks != 2 {
+		t.Errorf("Expected the short chunks to be kept, got %v", plan.ChunkIndexes)
+	}
+	if got := parts.Prompt(plan); got != "sources [1 3]" {
+		t.Errorf("Expected the prompt to be laid out with the kept chunks, got %q", got)
+	}
+}
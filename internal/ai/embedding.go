@@ -3,7 +3,11 @@ package ai
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"math"
+	"strings"
+	"unicode"
 
 	"github.com/sashabaranov/go-openai"
 	"github.com/sdrshn-nmbr/tusk/internal/config"
@@ -11,14 +15,67 @@ import (
 
 type Embedder struct {
 	client *openai.Client
+	model  openai.EmbeddingModel
+
+	// dimensions is the vector size of a hash embedder, which has no client
+	dimensions int
+}
+
+// hashModel names the model of embedders made by NewHashEmbedder.
+const hashModel = "hash"
+
+// embeddingDimensions lists the vector size of each supported model, which
+// the vector index definition has to match.
+var embeddingDimensions = map[openai.EmbeddingModel]int{
+	openai.AdaEmbeddingV2:  1536,
+	openai.SmallEmbedding3: 1536,
+	openai.LargeEmbedding3: 3072,
 }
 
+// NewEmbedder uses the model named by openai.embedding_model, falling back to ada
+// when it is unset or unknown.
 func NewEmbedder(cfg *config.Config) *Embedder {
+	model := openai.EmbeddingModel(cfg.OpenAI.EmbeddingModel)
+	if _, ok := embeddingDimensions[model]; !ok {
+		if cfg.OpenAI.EmbeddingModel != "" {
+			log.Printf("Unknown embedding model %q, using %s", cfg.OpenAI.EmbeddingModel, openai.AdaEmbeddingV2)
+		}
+		model = openai.AdaEmbeddingV2
+	}
 	return &Embedder{
-		client: openai.NewClient(cfg.OpenAIAPIKey),
+		client: openai.NewClient(cfg.OpenAI.APIKey),
+		model:  model,
 	}
 }
 
+// NewHashEmbedder returns an embedder that works offline: it hashes the words
+// of a text into a vector of the given size, so texts sharing words end up
+// close. It is meant for tests and local development without an API key.
+func NewHashEmbedder(dimensions int) *Embedder {
+	return &Embedder{model: hashModel, dimensions: dimensions}
+}
+
+// Model returns the name of the embedding model.
+func (e *Embedder) Model() string {
+	return string(e.model)
+}
+
+// WithModel returns an embedder sharing the client but using another model.
+func (e *Embedder) WithModel(model string) (*Embedder, error) {
+	if _, ok := embeddingDimensions[openai.EmbeddingModel(model)]; !ok {
+		return nil, fmt.Errorf("unknown embedding model %q", model)
+	}
+	return &Embedder{client: e.client, model: openai.EmbeddingModel(model)}, nil
+}
+
+// Dimensions returns the length of the vectors produced by the embedder.
+func (e *Embedder) Dimensions() int {
+	if e.client == nil {
+		return e.dimensions
+	}
+	return embeddingDimensions[e.model]
+}
+
 // GenerateEmbedding generates an embedding for a single text.
 func (e *Embedder) GenerateEmbedding(text string) ([]float32, error) {
 	embeddings, err := e.GenerateEmbeddings([]string{text})
@@ -33,9 +90,13 @@ func (e *Embedder) GenerateEmbedding(text string) ([]float32, error) {
 
 // GenerateEmbeddings generates embeddings for a batch of texts.
 func (e *Embedder) GenerateEmbeddings(texts []string) ([][]float32, error) {
+	if e.client == nil {
+		return e.hashEmbeddings(texts), nil
+	}
+
 	queryRequest := openai.EmbeddingRequest{
 		Input: texts,
-		Model: openai.AdaEmbeddingV2, // Use the appropriate model
+		Model: e.model,
 	}
 
 	queryResponse, err := e.client.CreateEmbeddings(context.Background(), queryRequest)
@@ -58,3 +119,33 @@ func (e *Embedder) GenerateEmbeddings(texts []string) ([][]float32, error) {
 
 	return embeddings, nil
 }
+
+// hashEmbeddings counts the words of each text in buckets picked by their
+// hash and scales the counts to unit length.
+func (e *Embedder) hashEmbeddings(texts []string) [][]float32 {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, e.dimensions)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range words {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			vector[h.Sum32()%uint32(e.dimensions)]++
+		}
+
+		var norm float64
+		for _, v := range vector {
+			norm += float64(v) * float64(v)
+		}
+		if norm > 0 {
+			scale := float32(1 / math.Sqrt(norm))
+			for j := range vector {
+				vector[j] *= scale
+			}
+		}
+		embeddings[i] = vector
+	}
+	return embeddings
+}
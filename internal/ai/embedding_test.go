@@ -11,10 +11,13 @@ import (
 func TestGenerateEmbedding(t *testing.T) {
 	chunk := "What is the meaning of life?"
 
-	cfg, err := config.NewConfig()
+	cfg, err := config.Load("")
 	if err != nil {
 		t.Fatalf("Failed to load config: %+v", err)
 	}
+	if cfg.OpenAI.APIKey == "" {
+		t.Skip("OPENAI_API_KEY is not set")
+	}
 
 	embedder := NewEmbedder(cfg)
 
@@ -23,10 +23,14 @@ import (
 // }
 
 type Model struct {
-	client  *genai.Client
-	model   *genai.GenerativeModel
-	chat    *genai.ChatSession
-	history []*genai.Content
+	client    *genai.Client
+	model     *genai.GenerativeModel
+	chat      *genai.ChatSession
+	history   []*genai.Content
+	sysPrompt string
+
+	// maxHistoryTokens bounds the shared chat history of GenerateResponse
+	maxHistoryTokens int
 }
 
 type ImageData struct {
@@ -41,12 +45,12 @@ type ChatMessage struct {
 
 func NewModel(cfg *config.Config, sysPrompt string) (*Model, error) {
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(cfg.GeminiAPIKey))
+	client, err := genai.NewClient(ctx, option.WithAPIKey(cfg.Gemini.APIKey))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Gemini client: %+v", err)
 	}
 
-	model := client.GenerativeModel("gemini-1.5-flash-latest")
+	model := client.GenerativeModel(cfg.Gemini.Model)
 	chat := model.StartChat()
 
 	history := []*genai.Content{
@@ -61,10 +65,12 @@ func NewModel(cfg *config.Config, sysPrompt string) (*Model, error) {
 	chat.History = history
 
 	return &Model{
-		client:  client,
-		model:   model,
-		chat:    chat,
-		history: history,
+		client:           client,
+		model:            model,
+		chat:             chat,
+		history:          history,
+		sysPrompt:        sysPrompt,
+		maxHistoryTokens: cfg.Chat.MaxPromptTokens / 2,
 	}, nil
 }
 
@@ -84,6 +90,7 @@ func (m *Model) GenerateResponse(ctx context.Context, query string, imgData []by
 			Parts: []genai.Part{genai.Text(allText)},
 			Role:  "user",
 		})
+		m.trimHistory()
 
 		var iter *genai.GenerateContentResponseIterator
 		if imgData != nil {
@@ -127,6 +134,157 @@ func (m *Model) GenerateResponse(ctx context.Context, query string, imgData []by
 	return responseChan, errChan
 }
 
+// Generate answers a single prompt outside the chat session, leaving the
+// history untouched. It is meant for short internal tasks such as scoring
+// or rewriting text.
+func (m *Model) Generate(ctx context.Context, prompt string) (string, error) {
+	resp, err := m.model.GenerateContent(ctx, genai.Text(prompt))
+	if err != nil {
+		return "", fmt.Errorf("error generating content: %+v", err)
+	}
+
+	var text strings.Builder
+	for _, candidate := range resp.Candidates {
+		if candidate.Content == nil {
+			continue
+		}
+		for _, part := range candidate.Content.Parts {
+			if textPart, ok := part.(genai.Text); ok {
+				text.WriteString(string(textPart))
+			}
+		}
+	}
+	return text.String(), nil
+}
+
+// GenerateJSON answers a single prompt in Gemini's JSON mode, constrained to
+// schema.
+func (m *Model) GenerateJSON(ctx context.Context, prompt string, schema *genai.Schema) (string, error) {
+	// A copy of the model so the schema does not apply to other requests
+	jsonModel := *m.model
+	jsonModel.ResponseMIMEType = "application/json"
+	jsonModel.ResponseSchema = schema
+
+	resp, err := jsonModel.GenerateContent(ctx, genai.Text(prompt))
+	if err != nil {
+		return "", fmt.Errorf("error generating content: %+v", err)
+	}
+
+	var text strings.Builder
+	for _, candidate := range resp.Candidates {
+		if candidate.Content == nil {
+			continue
+		}
+		for _, part := range candidate.Content.Parts {
+			if textPart, ok := part.(genai.Text); ok {
+				text.WriteString(string(textPart))
+			}
+		}
+	}
+	return text.String(), nil
+}
+
+// trimHistory drops the oldest turns after the system prompt until the
+// history fits in maxHistoryTokens, so the session cannot grow until Gemini
+// rejects it.
+func (m *Model) trimHistory() {
+	if m.maxHistoryTokens <= 0 {
+		return
+	}
+	total := 0
+	for _, content := range m.history {
+		total += contentTokens(content)
+	}
+	// Drop user/model pairs to keep the turns alternating
+	for total > m.maxHistoryTokens && len(m.history) > 3 {
+		total -= contentTokens(m.history[1]) + contentTokens(m.history[2])
+		m.history = append(m.history[:1], m.history[3:]...)
+	}
+	// The session adds the new user turn itself once it is sent
+	m.chat.History = append([]*genai.Content(nil), m.history[:len(m.history)-1]...)
+}
+
+func contentTokens(content *genai.Content) int {
+	n := 0
+	for _, part := range content.Parts {
+		if text, ok := part.(genai.Text); ok {
+			n += EstimateTokens(string(text))
+		}
+	}
+	return n
+}
+
+// Answer streams a reply to prompt in a chat session of its own, seeded with
+// the system prompt and history, so concurrent users never see each other's
+// turns. An empty system uses the model's own system prompt. usage is filled
+// in before the channels are closed.
+func (m *Model) Answer(ctx context.Context, system string, history []ChatMessage, prompt string, usage *Usage) (<-chan string, <-chan error) {
+	responseChan := make(chan string)
+	errChan := make(chan error, 1)
+	if system == "" {
+		system = m.sysPrompt
+	}
+
+	go func() {
+		defer close(responseChan)
+		defer close(errChan)
+
+		chat := m.model.StartChat()
+		chat.History = append(chat.History, &genai.Content{
+			Parts: []genai.Part{genai.Text(system)},
+			Role:  "user",
+		})
+		for _, msg := range history {
+			role := "user"
+			if msg.Sender != "user" {
+				role = "model"
+			}
+			chat.History = append(chat.History, &genai.Content{
+				Parts: []genai.Part{genai.Text(msg.Content)},
+				Role:  role,
+			})
+		}
+
+		iter := chat.SendMessageStream(ctx, genai.Text(prompt))
+		for {
+			resp, err := iter.Next()
+			if err == iterator.Done {
+				return
+			}
+			if err != nil {
+				errChan <- fmt.Errorf("error generating content: %+v", err)
+				return
+			}
+			if resp.UsageMetadata != nil && usage != nil {
+				*usage = usageFrom(resp.UsageMetadata)
+			}
+
+			for _, candidate := range resp.Candidates {
+				if candidate.Content == nil {
+					continue
+				}
+				for _, part := range candidate.Content.Parts {
+					if textPart, ok := part.(genai.Text); ok {
+						select {
+						case responseChan <- string(textPart):
+						case <-ctx.Done():
+							errChan <- ctx.Err()
+							return
+						}
+					}
+				}
+			}
+		}
+	}()
+
+	return responseChan, errChan
+}
+
+// SystemPrompt returns the instructions every chat starts with.
+func (m *Model) SystemPrompt() string {
+	return m.sysPrompt
+}
+
 func (m *Model) ClearHistory() {
 	m.history = m.history[:1] // Keep only the system prompt
 	m.chat.History = m.history
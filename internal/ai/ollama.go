@@ -0,0 +1,126 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// OllamaToolCaller runs tool-calling steps against Ollama's /api/chat, for
+// local models that support tools such as llama3.1 or qwen2.5.
+type OllamaToolCaller struct {
+	URL    string
+	Model  string
+	Client *http.Client
+}
+
+func NewOllamaToolCaller(url, model string) *OllamaToolCaller {
+	if url == "" {
+		url = "http://localhost:11434"
+	}
+	return &OllamaToolCaller{URL: url, Model: model, Client: &http.Client{Timeout: 2 * time.Minute}}
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	} `json:"function"`
+}
+
+func (o *OllamaToolCaller) CallTools(ctx context.Context, req ToolRequest) (AgentMessage, Usage, error) {
+	request := struct {
+		Model    string           `json:"model"`
+		Messages []ollamaMessage  `json:"messages"`
+		Tools    []map[string]any `json:"tools,omitempty"`
+		Stream   bool             `json:"stream"`
+	}{Model: o.Model}
+
+	system := req.System
+	if req.NoCalls {
+		// Ollama has no switch for this, so it is asked in words
+		system += "\n\nDo not call any more tools; answer with what you have."
+	}
+	request.Messages = append(request.Messages, ollamaMessage{Role: "system", Content: system})
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "tool":
+			for _, result := range msg.Results {
+				content, err := json.Marshal(result.Content)
+				if err != nil {
+					return AgentMessage{}, Usage{}, err
+				}
+				request.Messages = append(request.Messages, ollamaMessage{Role: "tool", Content: string(content)})
+			}
+		case "model":
+			out := ollamaMessage{Role: "assistant", Content: msg.Content}
+			for _, call := range msg.Calls {
+				var tc ollamaToolCall
+				tc.Function.Name = call.Name
+				tc.Function.Arguments = call.Args
+				out.ToolCalls = append(out.ToolCalls, tc)
+			}
+			request.Messages = append(request.Messages, out)
+		default:
+			request.Messages = append(request.Messages, ollamaMessage{Role: "user", Content: msg.Content})
+		}
+	}
+	for _, spec := range req.Tools {
+		request.Tools = append(request.Tools, map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name":        spec.Name,
+				"description": spec.Description,
+				"parameters":  spec.jsonSchema(),
+			},
+		})
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return AgentMessage{}, Usage{}, fmt.Errorf("error with marshalling json payload: %+v", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.URL+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return AgentMessage{}, Usage{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.Client.Do(httpReq)
+	if err != nil {
+		return AgentMessage{}, Usage{}, fmt.Errorf("error calling ollama: %+v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return AgentMessage{}, Usage{}, fmt.Errorf("ollama returned status %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Message         ollamaMessage `json:"message"`
+		PromptEvalCount int           `json:"prompt_eval_count"`
+		EvalCount       int           `json:"eval_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return AgentMessage{}, Usage{}, fmt.Errorf("error unmarshalling json response: %+v", err)
+	}
+
+	reply := AgentMessage{Role: "model", Content: response.Message.Content}
+	for _, call := range response.Message.ToolCalls {
+		reply.Calls = append(reply.Calls, ToolCall{Name: call.Function.Name, Args: call.Function.Arguments})
+	}
+	usage := Usage{
+		PromptTokens:     response.PromptEvalCount,
+		CompletionTokens: response.EvalCount,
+		TotalTokens:      response.PromptEvalCount + response.EvalCount,
+	}
+	return reply, usage, nil
+}
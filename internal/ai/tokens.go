@@ -0,0 +1,57 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// TokenCounter counts the tokens a provider's tokenizer produces for text.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, text string) (int, error)
+}
+
+// Usage is the token accounting of one model request.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// EstimateTokens approximates the token count at four characters per token,
+// which is close for English text with the Gemini and OpenAI tokenizers. It
+// is used where a provider has no counting endpoint and to plan a prompt
+// before counting it exactly.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// Estimator is a TokenCounter for providers without a counting endpoint,
+// such as Ollama and Perplexity.
+type Estimator struct{}
+
+func (Estimator) CountTokens(ctx context.Context, text string) (int, error) {
+	return EstimateTokens(text), nil
+}
+
+// CountTokens asks Gemini how many tokens text takes with this model.
+func (m *Model) CountTokens(ctx context.Context, text string) (int, error) {
+	resp, err := m.model.CountTokens(ctx, genai.Text(text))
+	if err != nil {
+		return 0, fmt.Errorf("error counting tokens: %+v", err)
+	}
+	return int(resp.TotalTokens), nil
+}
+
+func usageFrom(metadata *genai.UsageMetadata) Usage {
+	if metadata == nil {
+		return Usage{}
+	}
+	return Usage{
+		PromptTokens:     int(metadata.PromptTokenCount),
+		CompletionTokens: int(metadata.CandidatesTokenCount),
+		TotalTokens:      int(metadata.TotalTokenCount),
+	}
+}
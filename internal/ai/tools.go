@@ -0,0 +1,184 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// ToolSpec describes a function the model may call. Parameters are flat:
+// each is a string, integer, number, boolean or an array of strings.
+type ToolSpec struct {
+	Name        string
+	Description string
+	Parameters  map[string]ToolParam
+	Required    []string
+}
+
+type ToolParam struct {
+	Type        string
+	Description string
+}
+
+// ToolCall is a call the model asked for. ID is empty for providers that do
+// not number their calls.
+type ToolCall struct {
+	ID   string         `json:"id,omitempty"`
+	Name string         `json:"name"`
+	Args map[string]any `json:"args"`
+}
+
+// ToolResult answers a ToolCall.
+type ToolResult struct {
+	CallID  string
+	Name    string
+	Content map[string]any
+}
+
+// AgentMessage is one turn of a tool-calling conversation: user text, model
+// text and calls, or the results of those calls.
+type AgentMessage struct {
+	Role    string // "user", "model" or "tool"
+	Content string
+	Calls   []ToolCall
+	Results []ToolResult
+}
+
+// ToolRequest is one step of a tool-calling conversation. With NoCalls the
+// tools stay declared, since earlier turns refer to them, but the model has
+// to answer in text.
+type ToolRequest struct {
+	System   string
+	Messages []AgentMessage
+	Tools    []ToolSpec
+	NoCalls  bool
+}
+
+// ToolCaller is a provider that supports function calling. A reply without
+// calls is the final answer.
+type ToolCaller interface {
+	CallTools(ctx context.Context, req ToolRequest) (AgentMessage, Usage, error)
+}
+
+// CallTools runs one step of the conversation with Gemini function calling.
+func (m *Model) CallTools(ctx context.Context, req ToolRequest) (AgentMessage, Usage, error) {
+	messages := req.Messages
+	if len(messages) == 0 {
+		return AgentMessage{}, Usage{}, fmt.Errorf("no messages to send")
+	}
+
+	// A copy of the model so the tools do not apply to other requests
+	toolModel := *m.model
+	toolModel.SystemInstruction = &genai.Content{Parts: []genai.Part{genai.Text(req.System)}}
+	toolModel.Tools = nil
+	toolModel.ToolConfig = nil
+	if len(req.Tools) > 0 {
+		tool := &genai.Tool{}
+		for _, spec := range req.Tools {
+			tool.FunctionDeclarations = append(tool.FunctionDeclarations, &genai.FunctionDeclaration{
+				Name:        spec.Name,
+				Description: spec.Description,
+				Parameters:  spec.genaiSchema(),
+			})
+		}
+		toolModel.Tools = []*genai.Tool{tool}
+		if req.NoCalls {
+			toolModel.ToolConfig = &genai.ToolConfig{
+				FunctionCallingConfig: &genai.FunctionCallingConfig{Mode: genai.FunctionCallingNone},
+			}
+		}
+	}
+
+	contents := make([]*genai.Content, len(messages))
+	for i, msg := range messages {
+		contents[i] = msg.genaiContent()
+	}
+
+	chat := toolModel.StartChat()
+	chat.History = contents[:len(contents)-1]
+	resp, err := chat.SendMessage(ctx, contents[len(contents)-1].Parts...)
+	if err != nil {
+		return AgentMessage{}, Usage{}, fmt.Errorf("error generating content: %+v", err)
+	}
+
+	reply := AgentMessage{Role: "model"}
+	if len(resp.Candidates) > 0 && resp.Candidates[0].Content != nil {
+		for _, part := range resp.Candidates[0].Content.Parts {
+			switch p := part.(type) {
+			case genai.Text:
+				reply.Content += string(p)
+			case genai.FunctionCall:
+				reply.Calls = append(reply.Calls, ToolCall{Name: p.Name, Args: p.Args})
+			}
+		}
+	}
+	return reply, usageFrom(resp.UsageMetadata), nil
+}
+
+var genaiParamTypes = map[string]genai.Type{
+	"string":  genai.TypeString,
+	"integer": genai.TypeInteger,
+	"number":  genai.TypeNumber,
+	"boolean": genai.TypeBoolean,
+	"array":   genai.TypeArray,
+}
+
+func (spec ToolSpec) genaiSchema() *genai.Schema {
+	if len(spec.Parameters) == 0 {
+		return nil
+	}
+	schema := &genai.Schema{
+		Type:       genai.TypeObject,
+		Properties: make(map[string]*genai.Schema, len(spec.Parameters)),
+		Required:   spec.Required,
+	}
+	for name, param := range spec.Parameters {
+		property := &genai.Schema{Type: genaiParamTypes[param.Type], Description: param.Description}
+		if param.Type == "array" {
+			property.Items = &genai.Schema{Type: genai.TypeString}
+		}
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+// jsonSchema is the OpenAI-style parameter schema used by Ollama.
+func (spec ToolSpec) jsonSchema() map[string]any {
+	properties := make(map[string]any, len(spec.Parameters))
+	for name, param := range spec.Parameters {
+		property := map[string]any{"type": param.Type, "description": param.Description}
+		if param.Type == "array" {
+			property["items"] = map[string]any{"type": "string"}
+		}
+		properties[name] = property
+	}
+	required := spec.Required
+	if required == nil {
+		required = []string{}
+	}
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
+
+func (msg AgentMessage) genaiContent() *genai.Content {
+	switch msg.Role {
+	case "tool":
+		// Gemini takes all results of a step as one user turn
+		content := &genai.Content{Role: "user"}
+		for _, result := range msg.Results {
+			content.Parts = append(content.Parts, genai.FunctionResponse{Name: result.Name, Response: result.Content})
+		}
+		return content
+	case "model":
+		content := &genai.Content{Role: "model"}
+		if msg.Content != "" {
+			content.Parts = append(content.Parts, genai.Text(msg.Content))
+		}
+		for _, call := range msg.Calls {
+			content.Parts = append(content.Parts, genai.FunctionCall{Name: call.Name, Args: call.Args})
+		}
+		return content
+	default:
+		return &genai.Content{Role: "user", Parts: []genai.Part{genai.Text(msg.Content)}}
+	}
+}
@@ -1,36 +1,316 @@
+// Package config holds the server's configuration. It is loaded once at
+// startup, from an optional YAML or TOML file with environment variables
+// taking precedence, and passed to every component that needs it.
 package config
 
 import (
+	"bytes"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	MongoURI           string
-	MongoDBDatabase    string
-	GoogleClientID     string
-	GoogleClientSecret string
-	GithubClientID     string
-	GithubClientSecret string
-	OpenAIAPIKey       string
-	GeminiAPIKey       string
-	UnidocAPIKey       string
-}
-
-func NewConfig() (*Config, error) {
-	// Load .env file if it exists
-	godotenv.Load()
+	Server    Server    `yaml:"server" toml:"server"`
+	Mongo     Mongo     `yaml:"mongo" toml:"mongo"`
+	Auth      Auth      `yaml:"auth" toml:"auth"`
+	Gemini    Gemini    `yaml:"gemini" toml:"gemini"`
+	OpenAI    OpenAI    `yaml:"openai" toml:"openai"`
+	Unidoc    Unidoc    `yaml:"unidoc" toml:"unidoc"`
+	Ingest    Ingest    `yaml:"ingest" toml:"ingest"`
+	Retrieval Retrieval `yaml:"retrieval" toml:"retrieval"`
+	Chat      Chat      `yaml:"chat" toml:"chat"`
+	Agent     Agent     `yaml:"agent" toml:"agent"`
+	Jobs      Jobs      `yaml:"jobs" toml:"jobs"`
+	API       API       `yaml:"api" toml:"api"`
+}
+
+type Server struct {
+	Port string `yaml:"port" toml:"port" env:"PORT"`
+	// Env is "production" behind HTTPS, which makes session cookies secure
+	Env string `yaml:"env" toml:"env" env:"APP_ENV"`
+	// PublicURL is where users reach the server, used for OAuth callbacks.
+	// It defaults to the Fly.io app URL when FLY_APP_NAME is set, and to
+	// localhost otherwise.
+	PublicURL     string   `yaml:"public_url" toml:"public_url" env:"PUBLIC_URL"`
+	SessionSecret string   `yaml:"session_secret" toml:"session_secret" env:"SESSION_SECRET"`
+	SessionMaxAge Duration `yaml:"session_max_age" toml:"session_max_age" env:"SESSION_MAX_AGE"`
+	// MaxMultipartMemory is how much of a form upload is held in memory
+	// before it is spilled to disk
+	MaxMultipartMemory int64 `yaml:"max_multipart_memory" toml:"max_multipart_memory" env:"MAX_MULTIPART_MEMORY"`
+}
+
+type Mongo struct {
+	URI      string `yaml:"uri" toml:"uri" env:"MONGO_URI"`
+	Database string `yaml:"database" toml:"database" env:"MONGODB_DATABASE"`
+}
+
+// Auth holds the OAuth apps users sign in with. A provider is enabled when
+// its client ID is set.
+type Auth struct {
+	GoogleClientID     string `yaml:"google_client_id" toml:"google_client_id" env:"GOOGLE_CLIENT_ID"`
+	GoogleClientSecret string `yaml:"google_client_secret" toml:"google_client_secret" env:"GOOGLE_CLIENT_SECRET"`
+	GithubClientID     string `yaml:"github_client_id" toml:"github_client_id" env:"GITHUB_CLIENT_ID"`
+	GithubClientSecret string `yaml:"github_client_secret" toml:"github_client_secret" env:"GITHUB_CLIENT_SECRET"`
+
+	// Admins are the comma-separated IDs, as "tusk-server user list" shows them,
+	// of the users allowed to edit the prompt templates
+	Admins string `yaml:"admins" toml:"admins" env:"ADMINS"`
+}
+
+// AdminIDs lists the user IDs of Admins.
+func (a Auth) AdminIDs() []string {
+	var ids []string
+	for _, id := range strings.Split(a.Admins, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+type Gemini struct {
+	APIKey string `yaml:"api_key" toml:"api_key" env:"GEMINI_API_KEY"`
+	Model  string `yaml:"model" toml:"model" env:"GEMINI_MODEL"`
+}
+
+type OpenAI struct {
+	APIKey         string `yaml:"api_key" toml:"api_key" env:"OPENAI_API_KEY"`
+	EmbeddingModel string `yaml:"embedding_model" toml:"embedding_model" env:"EMBEDDING_MODEL"`
+}
+
+type Unidoc struct {
+	APIKey string `yaml:"api_key" toml:"api_key" env:"UNIDOC_API_KEY"`
+}
+
+// Ingest covers turning uploads into embedded chunks.
+type Ingest struct {
+	// Chunks are up to ChunkSize bytes, repeating the last ChunkOverlap
+	// bytes of the previous chunk
+	ChunkSize    int `yaml:"chunk_size" toml:"chunk_size" env:"CHUNK_SIZE"`
+	ChunkOverlap int `yaml:"chunk_overlap" toml:"chunk_overlap" env:"CHUNK_OVERLAP"`
+
+	// Chunks are embedded EmbedBatchSize at a time, with up to
+	// EmbedConcurrency requests in flight
+	EmbedBatchSize   int `yaml:"embed_batch_size" toml:"embed_batch_size" env:"EMBED_BATCH_SIZE"`
+	EmbedConcurrency int `yaml:"embed_concurrency" toml:"embed_concurrency" env:"EMBED_CONCURRENCY"`
+
+	// Embedded chunks are written in bulk writes of up to InsertBatchSize
+	InsertBatchSize int `yaml:"insert_batch_size" toml:"insert_batch_size" env:"INSERT_BATCH_SIZE"`
+
+	// Timeout bounds storing one upload, OCRTimeout describing one image
+	Timeout    Duration `yaml:"timeout" toml:"timeout" env:"INGEST_TIMEOUT"`
+	OCRTimeout Duration `yaml:"ocr_timeout" toml:"ocr_timeout" env:"OCR_TIMEOUT"`
+
+	// ReindexBatchSize is how many documents a re-index reads at once
+	ReindexBatchSize int `yaml:"reindex_batch_size" toml:"reindex_batch_size" env:"REINDEX_BATCH_SIZE"`
+
+	// Enrichment of uploads with a generated title, abstract, tags,
+	// language and document type; users can also turn it off for their
+	// own workspace
+	Enrichment bool `yaml:"enrichment" toml:"enrichment" env:"ENRICHMENT"`
+}
+
+type Retrieval struct {
+	// Each vector search considers NumCandidates nearest neighbours and
+	// returns Candidates chunks for the reranker, which keeps ContextChunks
+	NumCandidates int `yaml:"num_candidates" toml:"num_candidates" env:"NUM_CANDIDATES"`
+	Candidates    int `yaml:"candidates" toml:"candidates" env:"RERANK_CANDIDATES"`
+	ContextChunks int `yaml:"context_chunks" toml:"context_chunks" env:"CONTEXT_CHUNKS"`
+
+	// Reranker is a comma separated chain such as "cross-encoder,mmr"
+	Reranker             string  `yaml:"reranker" toml:"reranker" env:"RERANKER"`
+	CrossEncoderURL      string  `yaml:"cross_encoder_url" toml:"cross_encoder_url" env:"CROSS_ENCODER_URL"`
+	LLMRerankConcurrency int     `yaml:"llm_rerank_concurrency" toml:"llm_rerank_concurrency" env:"LLM_RERANK_CONCURRENCY"`
+	MMRLambda            float64 `yaml:"mmr_lambda" toml:"mmr_lambda" env:"MMR_LAMBDA"`
 
+	// Query transformation before retrieval
+	QueryHistoryTurns int  `yaml:"query_history_turns" toml:"query_history_turns" env:"QUERY_HISTORY_TURNS"`
+	QueryParaphrases  int  `yaml:"query_paraphrases" toml:"query_paraphrases" env:"QUERY_PARAPHRASES"`
+	QueryHyDE         bool `yaml:"query_hyde" toml:"query_hyde" env:"QUERY_HYDE"`
+
+	// FilterAttributes are the comma-separated attribute keys the vector
+	// index can filter on before ranking. Filters on other attributes are
+	// applied to the ranked chunks, so they may return fewer results.
+	FilterAttributes string `yaml:"filter_attributes" toml:"filter_attributes" env:"FILTER_ATTRIBUTES"`
+}
+
+// FilterAttributeKeys lists the keys of FilterAttributes.
+func (r Retrieval) FilterAttributeKeys() []string {
+	var keys []string
+	for _, key := range strings.Split(r.FilterAttributes, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+type Chat struct {
+	// Token budget for a prompt, including history and retrieved chunks,
+	// of which HistoryShare goes to the conversation
+	MaxPromptTokens int     `yaml:"max_prompt_tokens" toml:"max_prompt_tokens" env:"MAX_PROMPT_TOKENS"`
+	HistoryShare    float64 `yaml:"history_share" toml:"history_share" env:"HISTORY_SHARE"`
+
+	// How much of a conversation is sent with each message: "full",
+	// "window" or "summary"
+	MemoryMode             string `yaml:"memory_mode" toml:"memory_mode" env:"MEMORY_MODE"`
+	MemoryWindow           int    `yaml:"memory_window" toml:"memory_window" env:"MEMORY_WINDOW"`
+	MemorySummaryThreshold int    `yaml:"memory_summary_threshold" toml:"memory_summary_threshold" env:"MEMORY_SUMMARY_THRESHOLD"`
+}
+
+// Agent configures the tool-calling agent: Provider is "gemini", or
+// "ollama" for a local model served at OllamaURL.
+type Agent struct {
+	Provider    string   `yaml:"provider" toml:"provider" env:"AGENT_PROVIDER"`
+	MaxSteps    int      `yaml:"max_steps" toml:"max_steps" env:"AGENT_MAX_STEPS"`
+	ToolTimeout Duration `yaml:"tool_timeout" toml:"tool_timeout" env:"AGENT_TOOL_TIMEOUT"`
+	OllamaURL   string   `yaml:"ollama_url" toml:"ollama_url" env:"OLLAMA_URL"`
+	OllamaModel string   `yaml:"ollama_model" toml:"ollama_model" env:"OLLAMA_MODEL"`
+}
+
+// Jobs covers background work on many documents.
+type Jobs struct {
+	Concurrency           int      `yaml:"concurrency" toml:"concurrency" env:"JOB_CONCURRENCY"`
+	Timeout               Duration `yaml:"timeout" toml:"timeout" env:"JOB_TIMEOUT"`
+	ExtractionConcurrency int      `yaml:"extraction_concurrency" toml:"extraction_concurrency" env:"EXTRACTION_CONCURRENCY"`
+	SummaryConcurrency    int      `yaml:"summary_concurrency" toml:"summary_concurrency" env:"SUMMARY_CONCURRENCY"`
+	SummaryBatchTokens    int      `yaml:"summary_batch_tokens" toml:"summary_batch_tokens" env:"SUMMARY_BATCH_TOKENS"`
+}
+
+type API struct {
+	DefaultPageSize      int `yaml:"default_page_size" toml:"default_page_size" env:"API_DEFAULT_PAGE_SIZE"`
+	MaxPageSize          int `yaml:"max_page_size" toml:"max_page_size" env:"API_MAX_PAGE_SIZE"`
+	MaxTokenLifetimeDays int `yaml:"max_token_lifetime_days" toml:"max_token_lifetime_days" env:"API_MAX_TOKEN_LIFETIME_DAYS"`
+}
+
+// Default returns the configuration used for everything a file or the
+// environment does not set.
+func Default() *Config {
 	return &Config{
-		MongoURI:           os.Getenv("MONGO_URI"),
-		MongoDBDatabase:    os.Getenv("MONGODB_DATABASE"),
-		GoogleClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-		GoogleClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
-		GithubClientID:     os.Getenv("GITHUB_CLIENT_ID"),
-		GithubClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
-		OpenAIAPIKey:       os.Getenv("OPENAI_API_KEY"),
-		GeminiAPIKey:       os.Getenv("GEMINI_API_KEY"),
-		UnidocAPIKey:       os.Getenv("UNIDOC_API_KEY"),
-	}, nil
+		Server: Server{
+			Port:               "8080",
+			SessionMaxAge:      Duration{30 * 24 * time.Hour},
+			MaxMultipartMemory: 32 << 20,
+		},
+		Gemini: Gemini{Model: "gemini-1.5-flash-latest"},
+		Ingest: Ingest{
+			ChunkSize:        2048,
+			ChunkOverlap:     50,
+			EmbedBatchSize:   16,
+			EmbedConcurrency: 4,
+			InsertBatchSize:  500,
+			Timeout:          Duration{30 * time.Second},
+			OCRTimeout:       Duration{30 * time.Second},
+			ReindexBatchSize: 20,
+			Enrichment:       true,
+		},
+		Retrieval: Retrieval{
+			NumCandidates:        500,
+			Candidates:           50,
+			ContextChunks:        5,
+			Reranker:             "mmr",
+			LLMRerankConcurrency: 8,
+			MMRLambda:            0.7,
+			QueryHistoryTurns:    6,
+			FilterAttributes:     "language,doc_type",
+		},
+		Chat: Chat{
+			MaxPromptTokens:        30000,
+			HistoryShare:           0.3,
+			MemoryMode:             "summary",
+			MemoryWindow:           8,
+			MemorySummaryThreshold: 16,
+		},
+		Agent: Agent{
+			Provider:    "gemini",
+			MaxSteps:    6,
+			ToolTimeout: Duration{30 * time.Second},
+			OllamaModel: "llama3.1",
+		},
+		Jobs: Jobs{
+			Concurrency:           4,
+			Timeout:               Duration{time.Hour},
+			ExtractionConcurrency: 4,
+			SummaryConcurrency:    4,
+			SummaryBatchTokens:    8000,
+		},
+		API: API{
+			DefaultPageSize:      50,
+			MaxPageSize:          200,
+			MaxTokenLifetimeDays: 365,
+		},
+	}
+}
+
+// Load reads the configuration: the defaults, then the file at path when it
+// is not empty, then the environment, including a .env file if one exists.
+// Malformed values are all reported together; Validate checks that the
+// result is usable.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	godotenv.Load()
+	var problems Problems
+	applyEnv(cfg, &problems)
+	if cfg.Server.PublicURL == "" {
+		cfg.Server.PublicURL = defaultPublicURL(cfg.Server.Port)
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return cfg, nil
+}
+
+// readFile decodes a YAML or TOML file, chosen by its extension. Unknown keys
+// are errors so that typos do not go unnoticed.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+	case ".toml":
+		dec := toml.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	default:
+		return fmt.Errorf("%s: unknown config format, expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func defaultPublicURL(port string) string {
+	if app := os.Getenv("FLY_APP_NAME"); app != "" {
+		return "https://" + app + ".fly.dev"
+	}
+	return "http://localhost:" + port
+}
+
+// Production tells whether the server runs behind HTTPS.
+func (c *Config) Production() bool {
+	return c.Server.Production()
+}
+
+// Production tells whether the server runs behind HTTPS.
+func (s Server) Production() bool {
+	return s.Env == "production"
 }
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadFile(t *testing.T) {
+	files := map[string]string{
+		"tusk.yaml": `
+mongo:
+  uri: mongodb://localhost
+  database: tusk
+ingest:
+  chunk_size: 1000
+  ocr_timeout: 1m
+`,
+		"tusk.toml": `
+[mongo]
+uri = "mongodb://localhost"
+database = "tusk"
+
+[ingest]
+chunk_size = 1000
+ocr_timeout = "1m"
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("MONGODB_DATABASE", "from-env")
+
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.Ingest.ChunkSize != 1000 || cfg.Ingest.OCRTimeout.Duration != time.Minute {
+				t.Errorf("file values not applied: %+v", cfg.Ingest)
+			}
+			if cfg.Mongo.Database != "from-env" {
+				t.Errorf("database = %q, expected the environment to win", cfg.Mongo.Database)
+			}
+			if cfg.Ingest.ChunkOverlap != 50 {
+				t.Errorf("chunk overlap = %d, expected the default", cfg.Ingest.ChunkOverlap)
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Errorf("Validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadUnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tusk.yaml")
+	if err := os.WriteFile(path, []byte("ingest:\n  chunk_sise: 10\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("expected an error for a misspelled key")
+	}
+}
+
+func TestProblemsReportedTogether(t *testing.T) {
+	t.Setenv("CHUNK_SIZE", "big")
+	t.Setenv("QUERY_HYDE", "maybe")
+	_, err := Load("")
+	var problems Problems
+	if !errors.As(err, &problems) || len(problems) != 2 {
+		t.Fatalf("expected two problems, got %v", err)
+	}
+
+	cfg := Default()
+	cfg.Ingest.ChunkOverlap = cfg.Ingest.ChunkSize
+	cfg.Agent.Provider = "ollama"
+	err = cfg.ValidateServer()
+	if !errors.As(err, &problems) {
+		t.Fatalf("expected Problems, got %v", err)
+	}
+	// mongo uri and database, session secret, three API keys, sign-in,
+	// chunk overlap, ollama url
+	if len(problems) != 9 {
+		t.Errorf("got %d problems, expected 9:\n%v", len(problems), err)
+	}
+}
+
+func TestValidAttributeKey(t *testing.T) {
+	for key, valid := range map[string]bool{
+		"language":  true,
+		"doc_type2": true,
+		"2fast":     false,
+		"doc-type":  false,
+		"":          false,
+	} {
+		if ValidAttributeKey(key) != valid {
+			t.Errorf("ValidAttributeKey(%q) = %v, expected %v", key, !valid, valid)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var attributeKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,63}$`)
+
+// ValidAttributeKey reports whether key can name a document attribute: a
+// letter, then up to 63 letters, digits and underscores. Storage checks
+// the keys users set with it too.
+func ValidAttributeKey(key string) bool {
+	return attributeKey.MatchString(key)
+}
+
+// Problems lists everything wrong with a configuration, so that it can be
+// fixed in one go.
+type Problems []string
+
+func (p Problems) Error() string {
+	return "invalid configuration:\n  " + strings.Join(p, "\n  ")
+}
+
+func (p *Problems) add(format string, args ...any) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// require reports value when it is empty, naming the file key and the
+// environment variable that set it.
+func (p *Problems) require(value, key, env string) {
+	if value == "" {
+		p.add("%s is required (%s)", key, env)
+	}
+}
+
+func (p *Problems) positive(value int, key string) {
+	if value <= 0 {
+		p.add("%s must be positive, got %d", key, value)
+	}
+}
+
+func (p Problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
+
+// Validate checks what every command needs: the database, and settings that
+// are out of range or contradict each other. The error is Problems.
+func (c *Config) Validate() error {
+	var p Problems
+	c.validate(&p)
+	return p.err()
+}
+
+// ValidateServer checks, on top of Validate, what serving the app needs: the
+// session secret, a sign-in provider and the keys of the providers the
+// enabled features call.
+func (c *Config) ValidateServer() error {
+	var p Problems
+	c.validate(&p)
+
+	p.require(c.Server.SessionSecret, "server.session_secret", "SESSION_SECRET")
+	p.require(c.Gemini.APIKey, "gemini.api_key", "GEMINI_API_KEY")
+	p.require(c.OpenAI.APIKey, "openai.api_key", "OPENAI_API_KEY")
+	p.require(c.Unidoc.APIKey, "unidoc.api_key", "UNIDOC_API_KEY")
+
+	google := c.Auth.GoogleClientID != ""
+	github := c.Auth.GithubClientID != ""
+	if !google && !github {
+		p.add("no sign-in provider: set auth.google_client_id (GOOGLE_CLIENT_ID) or auth.github_client_id (GITHUB_CLIENT_ID)")
+	}
+	if google {
+		p.require(c.Auth.GoogleClientSecret, "auth.google_client_secret", "GOOGLE_CLIENT_SECRET")
+	}
+	if github {
+		p.require(c.Auth.GithubClientSecret, "auth.github_client_secret", "GITHUB_CLIENT_SECRET")
+	}
+	if c.Production() && !strings.HasPrefix(c.Server.PublicURL, "https://") {
+		p.add("server.public_url must use https in production, got %q", c.Server.PublicURL)
+	}
+	return p.err()
+}
+
+func (c *Config) validate(p *Problems) {
+	p.require(c.Mongo.URI, "mongo.uri", "MONGO_URI")
+	p.require(c.Mongo.Database, "mongo.database", "MONGODB_DATABASE")
+
+	in := c.Ingest
+	p.positive(in.ChunkSize, "ingest.chunk_size")
+	if in.ChunkOverlap < 0 || in.ChunkOverlap >= in.ChunkSize {
+		p.add("ingest.chunk_overlap must be at least 0 and below the chunk size, got %d", in.ChunkOverlap)
+	}
+	p.positive(in.EmbedBatchSize, "ingest.embed_batch_size")
+	p.positive(in.EmbedConcurrency, "ingest.embed_concurrency")
+	p.positive(in.InsertBatchSize, "ingest.insert_batch_size")
+	p.positive(in.ReindexBatchSize, "ingest.reindex_batch_size")
+
+	r := c.Retrieval
+	p.positive(r.ContextChunks, "retrieval.context_chunks")
+	if r.Candidates < r.ContextChunks {
+		p.add("retrieval.candidates must be at least retrieval.context_chunks, got %d", r.Candidates)
+	}
+	if r.NumCandidates < r.Candidates {
+		p.add("retrieval.num_candidates must be at least retrieval.candidates, got %d", r.NumCandidates)
+	}
+	p.positive(r.LLMRerankConcurrency, "retrieval.llm_rerank_concurrency")
+	if r.MMRLambda < 0 || r.MMRLambda > 1 {
+		p.add("retrieval.mmr_lambda must be between 0 and 1, got %g", r.MMRLambda)
+	}
+	for _, name := range strings.Split(r.Reranker, ",") {
+		switch strings.TrimSpace(name) {
+		case "", "none", "llm", "mmr":
+		case "cross-encoder":
+			p.require(r.CrossEncoderURL, "retrieval.cross_encoder_url", "CROSS_ENCODER_URL")
+		default:
+			p.add("unknown reranker %q in retrieval.reranker, expected llm, cross-encoder, mmr or none", name)
+		}
+	}
+	if r.QueryHistoryTurns < 0 || r.QueryParaphrases < 0 {
+		p.add("retrieval.query_history_turns and retrieval.query_paraphrases may not be negative")
+	}
+	for _, key := range r.FilterAttributeKeys() {
+		if !ValidAttributeKey(key) {
+			p.add("retrieval.filter_attributes: %q is not an attribute key", key)
+		}
+	}
+
+	ch := c.Chat
+	p.positive(ch.MaxPromptTokens, "chat.max_prompt_tokens")
+	if ch.HistoryShare <= 0 || ch.HistoryShare >= 1 {
+		p.add("chat.history_share must be between 0 and 1, got %g", ch.HistoryShare)
+	}
+	switch ch.MemoryMode {
+	case "full", "window", "summary":
+	default:
+		p.add("unknown chat.memory_mode %q, expected full, window or summary", ch.MemoryMode)
+	}
+	p.positive(ch.MemoryWindow, "chat.memory_window")
+
+	switch c.Agent.Provider {
+	case "gemini":
+	case "ollama":
+		p.require(c.Agent.OllamaURL, "agent.ollama_url", "OLLAMA_URL")
+		p.require(c.Agent.OllamaModel, "agent.ollama_model", "OLLAMA_MODEL")
+	default:
+		p.add("unknown agent.provider %q, expected gemini or ollama", c.Agent.Provider)
+	}
+	p.positive(c.Agent.MaxSteps, "agent.max_steps")
+
+	p.positive(c.Jobs.Concurrency, "jobs.concurrency")
+	p.positive(c.Jobs.ExtractionConcurrency, "jobs.extraction_concurrency")
+	p.positive(c.Jobs.SummaryConcurrency, "jobs.summary_concurrency")
+	p.positive(c.Jobs.SummaryBatchTokens, "jobs.summary_batch_tokens")
+
+	p.positive(c.API.DefaultPageSize, "api.default_page_size")
+	if c.API.MaxPageSize < c.API.DefaultPageSize {
+		p.add("api.max_page_size must be at least api.default_page_size, got %d", c.API.MaxPageSize)
+	}
+	p.positive(c.API.MaxTokenLifetimeDays, "api.max_token_lifetime_days")
+
+	for _, d := range []struct {
+		key   string
+		value Duration
+	}{
+		{"server.session_max_age", c.Server.SessionMaxAge},
+		{"ingest.timeout", c.Ingest.Timeout},
+		{"ingest.ocr_timeout", c.Ingest.OCRTimeout},
+		{"agent.tool_timeout", c.Agent.ToolTimeout},
+		{"jobs.timeout", c.Jobs.Timeout},
+	} {
+		if d.value.Duration <= 0 {
+			p.add("%s must be positive, got %s", d.key, d.value)
+		}
+	}
+}
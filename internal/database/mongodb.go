@@ -15,7 +15,7 @@ type MongoDB struct {
 }
 
 func NewMongoDB(cfg *config.Config) (*MongoDB, error) {
-	clientOptions := options.Client().ApplyURI(cfg.MongoURI)
+	clientOptions := options.Client().ApplyURI(cfg.Mongo.URI)
 	client, err := mongo.Connect(context.TODO(), clientOptions)
 
 	if err != nil {
@@ -26,7 +26,7 @@ func NewMongoDB(cfg *config.Config) (*MongoDB, error) {
 		return nil, err
 	}
 
-	database := client.Database(cfg.MongoDBDatabase)
+	database := client.Database(cfg.Mongo.Database)
 
 	return &MongoDB{
 		client:   client,
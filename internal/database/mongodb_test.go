@@ -13,10 +13,13 @@ import (
 
 func TestMongoDBMoviesQuery(t *testing.T) {
 	// Load configuration
-	cfg, err := config.NewConfig()
+	cfg, err := config.Load("")
 	if err != nil {
 		t.Fatalf("Failed to load configuration: %+v", err)
 	}
+	if cfg.Mongo.URI == "" {
+		t.Skip("MONGO_URI is not set")
+	}
 
 	// Create a new MongoDB instance
 	mongodb, err := NewMongoDB(cfg)
@@ -0,0 +1,187 @@
+// Package enrich infers a title, abstract, tags, language and document type
+// for newly uploaded documents.
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DocTypes are the document types the model chooses from.
+var DocTypes = []string{
+	"invoice", "receipt", "contract", "paper", "report", "letter", "resume",
+	"manual", "presentation", "article", "notes", "form", "other",
+}
+
+// Completer answers a single prompt, see ai.Model.Generate.
+type Completer interface {
+	Generate(ctx context.Context, prompt string) (string, error)
+}
+
+// Store reads the workspace switch and saves the result.
+type Store interface {
+	GetWorkspaceSettings(userID string) (storage.WorkspaceSettings, error)
+	ApplyEnrichment(id primitive.ObjectID, userID string, e storage.Enrichment) error
+}
+
+type Enricher struct {
+	Model Completer
+	Store Store
+
+	// MaxChars is how much of the start of a document the model reads.
+	MaxChars int
+
+	// Concurrency is how many documents are enriched at once. Uploads
+	// beyond that wait in a queue of up to maxQueued documents.
+	Concurrency int
+
+	queue   chan queued
+	started sync.Once
+}
+
+// queued is an upload waiting for enrichment.
+type queued struct {
+	doc  storage.Document
+	text string
+}
+
+// maxQueued bounds the uploads waiting for enrichment. Past it uploads are
+// stored without enrichment rather than piling up in memory.
+const maxQueued = 1000
+
+func New(model Completer, store Store, concurrency int) *Enricher {
+	return &Enricher{Model: model, Store: store, MaxChars: 12000, Concurrency: concurrency}
+}
+
+const enrichPrompt = `Describe the document below. Reply with a JSON object only, with these fields:
+"title": a short descriptive title,
+"abstract": what the document is about in at most 3 sentences,
+"tags": up to 5 lowercase topic tags,
+"language": the ISO 639-1 code of its main language,
+"doc_type": one of %s.
+
+Filename: %s
+
+%s`
+
+// Ingested queues a newly stored document for enrichment and returns right
+// away. It is meant for storage.MongoStorage.OnIngest.
+func (e *Enricher) Ingested(doc storage.Document, text string) {
+	e.started.Do(func() {
+		e.queue = make(chan queued, maxQueued)
+		for i := 0; i < max(e.Concurrency, 1); i++ {
+			go func() {
+				for item := range e.queue {
+					e.enrich(item.doc, item.text)
+				}
+			}()
+		}
+	})
+
+	// Only the start of the text is read, so only that waits in the queue
+	if runes := []rune(text); e.MaxChars > 0 && len(runes) > e.MaxChars {
+		text = string(runes[:e.MaxChars])
+	}
+	select {
+	case e.queue <- queued{doc: doc, text: text}:
+	default:
+		log.Printf("Not enriching %s: %d uploads are already waiting", doc.Filename, maxQueued)
+	}
+}
+
+// enrich enriches a newly stored document unless its owner turned
+// enrichment off.
+func (e *Enricher) enrich(doc storage.Document, text string) {
+	settings, err := e.Store.GetWorkspaceSettings(doc.UserID)
+	if err != nil || !settings.Enrichment {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	enrichment, err := e.Enrich(ctx, doc.Filename, text)
+	if err != nil {
+		log.Printf("Failed to enrich %s: %+v", doc.Filename, err)
+		return
+	}
+	if err := e.Store.ApplyEnrichment(doc.ID, doc.UserID, enrichment); err != nil {
+		log.Printf("Failed to save enrichment of %s: %+v", doc.Filename, err)
+	}
+}
+
+// Enrich asks the model about the start of the document's text.
+func (e *Enricher) Enrich(ctx context.Context, filename, text string) (storage.Enrichment, error) {
+	if strings.TrimSpace(text) == "" {
+		return storage.Enrichment{}, fmt.Errorf("the document has no text")
+	}
+	if runes := []rune(text); e.MaxChars > 0 && len(runes) > e.MaxChars {
+		text = string(runes[:e.MaxChars])
+	}
+
+	reply, err := e.Model.Generate(ctx, fmt.Sprintf(enrichPrompt, strings.Join(DocTypes, ", "), filename, text))
+	if err != nil {
+		return storage.Enrichment{}, err
+	}
+	return parseEnrichment(reply)
+}
+
+var (
+	jsonObject   = regexp.MustCompile(`(?s)\{.*\}`)
+	languageCode = regexp.MustCompile(`^[a-z]{2}$`)
+)
+
+// parseEnrichment reads the model's reply, tolerating code fences around the
+// JSON, and drops values outside the expected forms.
+func parseEnrichment(reply string) (storage.Enrichment, error) {
+	var parsed struct {
+		Title    string   `json:"title"`
+		Abstract string   `json:"abstract"`
+		Tags     []string `json:"tags"`
+		Language string   `json:"language"`
+		DocType  string   `json:"doc_type"`
+	}
+	raw := jsonObject.FindString(reply)
+	if raw == "" {
+		return storage.Enrichment{}, fmt.Errorf("no JSON object in the reply %q", reply)
+	}
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		return storage.Enrichment{}, fmt.Errorf("error parsing enrichment: %v", err)
+	}
+
+	e := storage.Enrichment{
+		Title:    truncate(strings.TrimSpace(parsed.Title), 200),
+		Abstract: truncate(strings.TrimSpace(parsed.Abstract), 1000),
+		Tags:     storage.NormalizeTags(parsed.Tags),
+		DocType:  "other",
+	}
+	if len(e.Tags) > 5 {
+		e.Tags = e.Tags[:5]
+	}
+	if language := strings.ToLower(strings.TrimSpace(parsed.Language)); languageCode.MatchString(language) {
+		e.Language = language
+	}
+	docType := strings.ToLower(strings.TrimSpace(parsed.DocType))
+	for _, known := range DocTypes {
+		if docType == known {
+			e.DocType = docType
+		}
+	}
+	return e, nil
+}
+
+func truncate(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
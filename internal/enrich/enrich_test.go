@@ -0,0 +1,107 @@
+package enrich
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseEnrichment(t *testing.T) {
+	reply := "```json\n" + `{
+  "title": "Globex services agreement",
+  "abstract": "A two year services agreement between Acme and Globex.",
+  "tags": ["Contracts", "globex", "contracts"],
+  "language": "EN",
+  "doc_type": "Contract"
+}` + "\n```"
+
+	e, err := parseEnrichment(reply)
+	if err != nil {
+		t.Fatalf("parseEnrichment failed: %+v", err)
+	}
+	if e.Title != "Globex services agreement" || e.Language != "en" || e.DocType != "contract" {
+		t.Errorf("Unexpected enrichment: %+v", e)
+	}
+	if strings.Join(e.Tags, ",") != "contracts,globex" {
+		t.Errorf("Expected normalized tags, got %q", e.Tags)
+	}
+
+	// Unknown values are dropped or fall back
+	e, err = parseEnrichment(`{"title": "x", "language": "English", "doc_type": "poem"}`)
+	if err != nil {
+		t.Fatalf("parseEnrichment failed: %+v", err)
+	}
+	if e.Language != "" || e.DocType != "other" {
+		t.Errorf("Expected no language and type other, got %+v", e)
+	}
+
+	if _, err := parseEnrichment("I cannot help with that."); err == nil {
+		t.Error("Expected an error for a reply without JSON")
+	}
+}
+
+// slowModel answers after a pause and records how many calls overlapped.
+type slowModel struct {
+	mu            sync.Mutex
+	running, most int
+}
+
+func (m *slowModel) Generate(ctx context.Context, prompt string) (string, error) {
+	m.mu.Lock()
+	m.running++
+	m.most = max(m.most, m.running)
+	m.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	m.mu.Lock()
+	m.running--
+	m.mu.Unlock()
+	return `{"title": "Notes", "language": "en", "doc_type": "notes"}`, nil
+}
+
+type recordingStore struct {
+	mu      sync.Mutex
+	applied []primitive.ObjectID
+}
+
+func (s *recordingStore) GetWorkspaceSettings(userID string) (storage.WorkspaceSettings, error) {
+	return storage.WorkspaceSettings{UserID: userID, Enrichment: true}, nil
+}
+
+func (s *recordingStore) ApplyEnrichment(id primitive.ObjectID, userID string, e storage.Enrichment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applied = append(s.applied, id)
+	return nil
+}
+
+func TestIngestedBoundsConcurrency(t *testing.T) {
+	model, store := &slowModel{}, &recordingStore{}
+	e := New(model, store, 2)
+	for i := 0; i < 6; i++ {
+		e.Ingested(storage.Document{ID: primitive.NewObjectID(), UserID: "user-1", Filename: "notes.txt"}, "Meeting notes")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		store.mu.Lock()
+		done := len(store.applied)
+		store.mu.Unlock()
+		if done == 6 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 6 documents to be enriched, got %d", done)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if model.most > 2 {
+		t.Errorf("Expected at most 2 enrichments at once, got %d", model.most)
+	}
+}
@@ -0,0 +1,118 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Delta is a summary metric in two runs.
+type Delta struct {
+	Metric string
+	Base   float64
+	Run    float64
+}
+
+// Change is a case whose expected document moved between two runs.
+type Change struct {
+	Question string
+	BaseRank int
+	Rank     int
+}
+
+// Comparison sets a run against a base run of the same dataset.
+type Comparison struct {
+	Base, Run *Run
+	Metrics   []Delta
+	Improved  []Change
+	Regressed []Change
+	// Unmatched counts the cases of either run missing from the other
+	Unmatched int
+}
+
+// Compare lines up the metrics of run with base and lists the questions
+// whose expected document ranked better or worse. Cases are matched by
+// question and expected document.
+func Compare(base, run *Run) *Comparison {
+	c := &Comparison{Base: base, Run: run}
+	c.Metrics = []Delta{
+		{"recall@k", base.Summary.Recall, run.Summary.Recall},
+		{"MRR", base.Summary.MRR, run.Summary.MRR},
+		{"nDCG@k", base.Summary.NDCG, run.Summary.NDCG},
+	}
+	if base.Summary.Faithfulness != nil && run.Summary.Faithfulness != nil {
+		c.Metrics = append(c.Metrics,
+			Delta{"faithfulness", *base.Summary.Faithfulness, *run.Summary.Faithfulness},
+			Delta{"answer relevance", *base.Summary.Relevance, *run.Summary.Relevance},
+		)
+	}
+
+	baseRanks := make(map[Case]int, len(base.Results))
+	for _, result := range base.Results {
+		baseRanks[caseKey(result.Case)] = result.Rank
+	}
+	matched := 0
+	for _, result := range run.Results {
+		baseRank, ok := baseRanks[caseKey(result.Case)]
+		if !ok {
+			c.Unmatched++
+			continue
+		}
+		matched++
+		change := Change{Question: result.Question, BaseRank: baseRank, Rank: result.Rank}
+		switch {
+		case better(result.Rank, baseRank):
+			c.Improved = append(c.Improved, change)
+		case better(baseRank, result.Rank):
+			c.Regressed = append(c.Regressed, change)
+		}
+	}
+	c.Unmatched += len(base.Results) - matched
+	return c
+}
+
+// caseKey identifies a case regardless of its reference answer.
+func caseKey(c Case) Case {
+	return Case{Question: c.Question, ExpectedDocument: c.ExpectedDocument}
+}
+
+// better tells whether rank a beats rank b, where 0 means missed.
+func better(a, b int) bool {
+	return a > 0 && (b == 0 || a < b)
+}
+
+// Write prints the comparison as a table followed by the changed cases.
+func (c *Comparison) Write(w io.Writer) {
+	if c.Base.Dataset != c.Run.Dataset || c.Base.K != c.Run.K {
+		fmt.Fprintf(w, "warning: comparing %s@%d with %s@%d\n\n", c.Base.Dataset, c.Base.K, c.Run.Dataset, c.Run.K)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "METRIC\t%s\t%s\tDELTA\n", c.Base.Name, c.Run.Name)
+	for _, d := range c.Metrics {
+		fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%+.3f\n", d.Metric, d.Base, d.Run, d.Run-d.Base)
+	}
+	tw.Flush()
+
+	for _, group := range []struct {
+		title   string
+		changes []Change
+	}{{"Improved", c.Improved}, {"Regressed", c.Regressed}} {
+		if len(group.changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s (%d):\n", group.title, len(group.changes))
+		for _, change := range group.changes {
+			fmt.Fprintf(w, "  %s -> %s  %s\n", formatRank(change.BaseRank), formatRank(change.Rank), change.Question)
+		}
+	}
+	if c.Unmatched > 0 {
+		fmt.Fprintf(w, "\n%d cases are only in one of the runs\n", c.Unmatched)
+	}
+}
+
+func formatRank(rank int) string {
+	if rank == 0 {
+		return "miss"
+	}
+	return fmt.Sprintf("#%d", rank)
+}
@@ -0,0 +1,66 @@
+// Package eval measures how well retrieval finds the documents that answer a
+// golden set of questions and, optionally, how good the answers are, so
+// chunking, retrieval and prompt settings can be compared run against run.
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Case is one golden question with the filename of the document that
+// answers it and, optionally, a reference answer for the judge.
+type Case struct {
+	Question         string `json:"question"`
+	ExpectedDocument string `json:"expected_document"`
+	ReferenceAnswer  string `json:"reference_answer,omitempty"`
+}
+
+// Dataset is a named list of cases.
+type Dataset struct {
+	Name  string
+	Cases []Case
+}
+
+// LoadDataset reads a JSON Lines file with one case per line. Blank lines
+// and lines starting with # are skipped. The dataset is named after the file.
+func LoadDataset(path string) (*Dataset, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dataset := &Dataset{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+		var c Case
+		if err := decoder.Decode(&c); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		c.Question = strings.TrimSpace(c.Question)
+		c.ExpectedDocument = strings.TrimSpace(c.ExpectedDocument)
+		if c.Question == "" || c.ExpectedDocument == "" {
+			return nil, fmt.Errorf("%s:%d: question and expected_document are required", path, line)
+		}
+		dataset.Cases = append(dataset.Cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(dataset.Cases) == 0 {
+		return nil, fmt.Errorf("%s: no cases", path)
+	}
+	return dataset, nil
+}
@@ -0,0 +1,267 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/retrieval"
+)
+
+// Answerer answers a prompt built from the retrieved chunks, see
+// ai.Model.Answer.
+type Answerer interface {
+	Answer(ctx context.Context, system string, history []ai.ChatMessage, prompt string, usage *ai.Usage) (<-chan string, <-chan error)
+	SystemPrompt() string
+}
+
+// Evaluator runs a dataset through the retrieval pipeline of one user. When
+// Model is set every question is also answered, and when Judge is set too
+// the answers are graded.
+type Evaluator struct {
+	Retriever *retrieval.Retriever
+	Embedder  retrieval.Embedder
+	UserID    string
+
+	// K is the cutoff of recall@k and nDCG@k. The retriever has to keep
+	// enough chunks to cover K documents, see the eval command.
+	K int
+
+	// ContextChunks is how many of the retrieved chunks the answer is
+	// given, like the chat; all of them when zero.
+	ContextChunks int
+
+	Model    Answerer
+	Budgeter *ai.Budgeter
+	Judge    *Judge
+
+	// Concurrency is how many cases run at once.
+	Concurrency int
+}
+
+// Settings records what a run was made with, so runs can be told apart.
+type Settings struct {
+	Ingest         config.Ingest    `json:"ingest"`
+	Retrieval      config.Retrieval `json:"retrieval"`
+	Chat           config.Chat      `json:"chat"`
+	EmbeddingModel string           `json:"embedding_model"`
+	Model          string           `json:"model,omitempty"`
+	SystemPrompt   string           `json:"system_prompt,omitempty"`
+	// Source is the user whose documents were searched, or the directory
+	// that was ingested for the run
+	Source string `json:"source"`
+}
+
+// Run is the outcome of evaluating a dataset, as written to JSON.
+type Run struct {
+	Name      string    `json:"name"`
+	Dataset   string    `json:"dataset"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	K         int       `json:"k"`
+	Settings  Settings  `json:"settings"`
+	Summary   Summary   `json:"summary"`
+	Results   []Result  `json:"results"`
+}
+
+// Summary averages the retrieval metrics over the cases whose retrieval did
+// not fail, and the judged scores over the answers that were graded. Cases
+// that could not be answered or graded still count towards retrieval.
+type Summary struct {
+	Cases        int      `json:"cases"`
+	Failed       int      `json:"failed"`
+	AnswerFailed int      `json:"answer_failed,omitempty"`
+	GradeFailed  int      `json:"grade_failed,omitempty"`
+	Recall       float64  `json:"recall_at_k"`
+	MRR          float64  `json:"mrr"`
+	NDCG         float64  `json:"ndcg_at_k"`
+	Judged       int      `json:"judged,omitempty"`
+	Faithfulness *float64 `json:"faithfulness,omitempty"`
+	Relevance    *float64 `json:"answer_relevance,omitempty"`
+}
+
+// Result is the outcome of one case. Rank is the 1-based position of the
+// expected document among the retrieved ones, 0 when it was missed. Error
+// is set when retrieval failed, AnswerError and GradeError when only the
+// later steps did.
+type Result struct {
+	Case
+	Retrieved      []string `json:"retrieved"`
+	Rank           int      `json:"rank"`
+	Recall         float64  `json:"recall_at_k"`
+	ReciprocalRank float64  `json:"reciprocal_rank"`
+	NDCG           float64  `json:"ndcg_at_k"`
+	Answer         string   `json:"answer,omitempty"`
+	Grade          *Grade   `json:"grade,omitempty"`
+	Error          string   `json:"error,omitempty"`
+	AnswerError    string   `json:"answer_error,omitempty"`
+	GradeError     string   `json:"grade_error,omitempty"`
+}
+
+// Run evaluates every case of dataset. Failures are recorded on the case
+// and left out of the averages they affect; Run only returns an error when
+// ctx is done.
+func (e *Evaluator) Run(ctx context.Context, dataset *Dataset) (*Run, error) {
+	concurrency := e.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	run := &Run{
+		Dataset:   dataset.Name,
+		StartedAt: time.Now().UTC(),
+		K:         e.K,
+		Results:   make([]Result, len(dataset.Cases)),
+	}
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, c := range dataset.Cases {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, c Case) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			run.Results[i] = e.evaluate(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	run.Duration = time.Since(run.StartedAt).Round(time.Millisecond).String()
+	run.Summary = summarize(run.Results)
+	return run, nil
+}
+
+func (e *Evaluator) evaluate(ctx context.Context, c Case) Result {
+	result := Result{Case: c}
+	retrieved, err := e.Retriever.Retrieve(ctx, e.Embedder, retrieval.Request{Query: c.Question, UserID: e.UserID})
+	if err != nil {
+		log.Printf("Failed to retrieve chunks for %q: %+v", c.Question, err)
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Retrieved = rankDocuments(retrieved.Chunks)
+	result.Rank = rankOf(result.Retrieved, c.ExpectedDocument)
+	result.Recall = RecallAt(result.Rank, e.K)
+	result.ReciprocalRank = ReciprocalRank(result.Rank)
+	result.NDCG = NDCGAt(result.Rank, e.K)
+	if e.Model == nil {
+		return result
+	}
+
+	kept := retrieved.Chunks
+	if e.ContextChunks > 0 && len(kept) > e.ContextChunks {
+		kept = kept[:e.ContextChunks]
+	}
+	chunks := make([]string, len(kept))
+	for i, chunk := range kept {
+		chunks[i] = fmt.Sprintf("\n%s\n", chunk.Content)
+	}
+	answer, plan, err := e.answer(ctx, c.Question, chunks)
+	if err != nil {
+		log.Printf("Failed to answer %q: %+v", c.Question, err)
+		result.AnswerError = err.Error()
+		return result
+	}
+	result.Answer = answer
+	if e.Judge == nil {
+		return result
+	}
+
+	result.Grade, err = e.Judge.Grade(ctx, c, plan.Chunks, answer)
+	if err != nil {
+		log.Printf("Failed to grade the answer to %q: %+v", c.Question, err)
+		result.GradeError = err.Error()
+	}
+	return result
+}
+
+// answer prompts the model the way the chat does, within the token budget.
+func (e *Evaluator) answer(ctx context.Context, question string, chunks []string) (string, ai.PromptPlan, error) {
+	parts := ai.PromptParts{System: e.Model.SystemPrompt(), Query: question, Chunks: chunks}
+	plan := ai.PromptPlan{Chunks: chunks}
+	if e.Budgeter != nil {
+		var err error
+		if plan, err = e.Budgeter.Fit(ctx, parts); err != nil {
+			log.Printf("Prompt is over budget: %+v", err)
+		}
+	}
+
+	responseChan, errChan := e.Model.Answer(ctx, "", nil, plan.Prompt(question), nil)
+	var answer bytes.Buffer
+	for token := range responseChan {
+		answer.WriteString(token)
+	}
+	if err := <-errChan; err != nil {
+		return "", plan, err
+	}
+	return answer.String(), plan, nil
+}
+
+func summarize(results []Result) Summary {
+	summary := Summary{Cases: len(results)}
+	var faithfulness, relevance float64
+	for _, result := range results {
+		if result.Error != "" {
+			summary.Failed++
+			continue
+		}
+		summary.Recall += result.Recall
+		summary.MRR += result.ReciprocalRank
+		summary.NDCG += result.NDCG
+		if result.AnswerError != "" {
+			summary.AnswerFailed++
+		}
+		if result.GradeError != "" {
+			summary.GradeFailed++
+		}
+		if result.Grade != nil {
+			summary.Judged++
+			faithfulness += result.Grade.Faithfulness
+			relevance += result.Grade.Relevance
+		}
+	}
+	if n := float64(summary.Cases - summary.Failed); n > 0 {
+		summary.Recall /= n
+		summary.MRR /= n
+		summary.NDCG /= n
+	}
+	if summary.Judged > 0 {
+		faithfulness /= float64(summary.Judged)
+		relevance /= float64(summary.Judged)
+		summary.Faithfulness, summary.Relevance = &faithfulness, &relevance
+	}
+	return summary
+}
+
+// WriteFile saves the run as indented JSON.
+func (r *Run) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// ReadRun loads a run saved by WriteFile.
+func ReadRun(path string) (*Run, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &run, nil
+}
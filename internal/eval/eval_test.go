@@ -0,0 +1,186 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/ai/aitest"
+	"github.com/sdrshn-nmbr/tusk/internal/retrieval"
+	"github.com/sdrshn-nmbr/tusk/internal/storage/memstore"
+)
+
+func TestMetrics(t *testing.T) {
+	tests := []struct {
+		rank, k          int
+		recall, rr, ndcg float64
+	}{
+		{1, 5, 1, 1, 1},
+		{3, 5, 1, 1.0 / 3, 0.5},
+		{6, 5, 0, 1.0 / 6, 0},
+		{0, 5, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := RecallAt(tt.rank, tt.k); got != tt.recall {
+			t.Errorf("RecallAt(%d, %d) = %v, expected %v", tt.rank, tt.k, got, tt.recall)
+		}
+		if got := ReciprocalRank(tt.rank); math.Abs(got-tt.rr) > 1e-9 {
+			t.Errorf("ReciprocalRank(%d) = %v, expected %v", tt.rank, got, tt.rr)
+		}
+		if got := NDCGAt(tt.rank, tt.k); math.Abs(got-tt.ndcg) > 1e-9 {
+			t.Errorf("NDCGAt(%d, %d) = %v, expected %v", tt.rank, tt.k, got, tt.ndcg)
+		}
+	}
+}
+
+func TestLoadDataset(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	dataset, err := LoadDataset(write("golden.jsonl", `# contracts
+{"question": "When are invoices due?", "expected_document": "terms.txt", "reference_answer": "Within thirty days."}
+
+{"question": "What is for lunch?", "expected_document": "menu.txt"}
+`))
+	if err != nil {
+		t.Fatalf("LoadDataset failed: %+v", err)
+	}
+	if dataset.Name != "golden" || len(dataset.Cases) != 2 || dataset.Cases[0].ReferenceAnswer != "Within thirty days." {
+		t.Errorf("Unexpected dataset: %+v", dataset)
+	}
+
+	for content, message := range map[string]string{
+		`{"question": "When?"}`:                             "bad.jsonl:1: question and expected_document are required",
+		"\n" + `{"question": "When?", "expected": "a.txt"}`: "bad.jsonl:2:",
+		"": "no cases",
+	} {
+		if _, err := LoadDataset(write("bad.jsonl", content)); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected an error containing %q, got %v", message, err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	embedder := ai.NewHashEmbedder(256)
+	store := memstore.New()
+	for name, content := range map[string]string{
+		"terms.txt": "Payment terms: invoices are due within thirty days of receipt.",
+		"menu.txt":  "Lunch menu for Friday: soup and bread.",
+	} {
+		if _, err := store.SaveFile(name, strings.NewReader(content), embedder, "user-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	model := &aitest.Model{Reply: "Within thirty days.", JSON: `{"faithfulness": 5, "relevance": 4, "reason": "Supported."}`}
+	e := &Evaluator{
+		Retriever:   &retrieval.Retriever{Searcher: store, Candidates: 10, Limit: 5},
+		Embedder:    embedder,
+		UserID:      "user-1",
+		K:           1,
+		Model:       model,
+		Judge:       &Judge{Model: model},
+		Concurrency: 2,
+	}
+	dataset := &Dataset{Name: "golden", Cases: []Case{
+		{Question: "When are invoices due?", ExpectedDocument: "terms.txt", ReferenceAnswer: "Within thirty days."},
+		{Question: "When are invoices due?", ExpectedDocument: "menu.txt"},
+		{Question: "Where is the handbook?", ExpectedDocument: "handbook.pdf"},
+	}}
+	run, err := e.Run(context.Background(), dataset)
+	if err != nil {
+		t.Fatalf("Run failed: %+v", err)
+	}
+
+	if ranks := []int{run.Results[0].Rank, run.Results[1].Rank, run.Results[2].Rank}; ranks[0] != 1 || ranks[1] != 2 || ranks[2] != 0 {
+		t.Errorf("Unexpected ranks %v", ranks)
+	}
+	s := run.Summary
+	if s.Cases != 3 || s.Failed != 0 || math.Abs(s.Recall-1.0/3) > 1e-9 || math.Abs(s.MRR-0.5) > 1e-9 {
+		t.Errorf("Unexpected summary %+v", s)
+	}
+	if s.Judged != 3 || s.Faithfulness == nil || *s.Faithfulness != 1 || *s.Relevance != 0.75 {
+		t.Errorf("Expected the judged scores to be averaged, got %+v", s)
+	}
+	if run.Results[0].Answer != "Within thirty days." {
+		t.Errorf("Expected the scripted answer, got %q", run.Results[0].Answer)
+	}
+	var judged bool
+	for _, prompt := range model.Prompts() {
+		judged = judged || strings.Contains(prompt, "Reference answer:\nWithin thirty days.")
+	}
+	if !judged {
+		t.Error("Expected the reference answer to be given to the judge")
+	}
+
+	// A saved run reads back and compares against a later one
+	path := filepath.Join(t.TempDir(), "run.json")
+	if err := run.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	base, err := ReadRun(path)
+	if err != nil {
+		t.Fatalf("ReadRun failed: %+v", err)
+	}
+	e.K, e.Judge = 2, nil
+	next, err := e.Run(context.Background(), dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Compare(base, next)
+	if len(c.Metrics) != 3 || len(c.Improved) != 0 || len(c.Regressed) != 0 || c.Unmatched != 0 {
+		t.Errorf("Unexpected comparison %+v", c)
+	}
+	if c.Metrics[0].Run != 2.0/3 {
+		t.Errorf("Expected recall@2 to count the second case, got %v", c.Metrics[0])
+	}
+	var out bytes.Buffer
+	c.Write(&out)
+	if !strings.Contains(out.String(), "warning: comparing golden@1 with golden@2") {
+		t.Errorf("Expected a warning about the different cutoffs, got:\n%s", out.String())
+	}
+}
+
+func TestRunAnswerFailure(t *testing.T) {
+	embedder := ai.NewHashEmbedder(256)
+	store := memstore.New()
+	if _, err := store.SaveFile("terms.txt", strings.NewReader("Payment terms: invoices are due within thirty days of receipt."), embedder, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Evaluator{
+		Retriever: &retrieval.Retriever{Searcher: store, Candidates: 10, Limit: 10},
+		Embedder:  embedder,
+		UserID:    "user-1",
+		K:         1,
+		Model:     &aitest.Model{Err: errors.New("quota exceeded")},
+	}
+	dataset := &Dataset{Name: "golden", Cases: []Case{
+		{Question: "When are invoices due?", ExpectedDocument: "terms.txt"},
+	}}
+	run, err := e.Run(context.Background(), dataset)
+	if err != nil {
+		t.Fatalf("Run failed: %+v", err)
+	}
+
+	// The retrieval metrics still count when the answer fails
+	result, s := run.Results[0], run.Summary
+	if result.Error != "" || result.AnswerError != "quota exceeded" || result.Rank != 1 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if s.Failed != 0 || s.AnswerFailed != 1 || s.Recall != 1 || s.MRR != 1 {
+		t.Errorf("Unexpected summary %+v", s)
+	}
+}
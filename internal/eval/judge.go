@@ -0,0 +1,91 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// JSONCompleter answers a prompt with JSON matching a response schema, see
+// ai.Model.GenerateJSON.
+type JSONCompleter interface {
+	GenerateJSON(ctx context.Context, prompt string, schema *genai.Schema) (string, error)
+}
+
+// Judge asks a model to grade answers. Grading is optional since it costs a
+// request per case and is noisier than the retrieval metrics.
+type Judge struct {
+	Model JSONCompleter
+}
+
+// Grade holds the judge's scores, scaled from its 1 to 5 ratings to 0 to 1.
+// Faithfulness is how well the context supports the answer, and Relevance
+// how well the answer addresses the question.
+type Grade struct {
+	Faithfulness float64 `json:"faithfulness"`
+	Relevance    float64 `json:"answer_relevance"`
+	Reason       string  `json:"reason,omitempty"`
+}
+
+const judgePrompt = `You are grading an answer given by an assistant that answers questions from retrieved documents.
+
+Question: %s
+
+Context the assistant was given:
+%s
+
+Answer:
+%s
+%s
+Rate the answer from 1 to 5 on:
+- faithfulness: every claim in the answer is supported by the context (5), or most claims are not (1)
+- relevance: the answer addresses the question completely and directly (5), or not at all (1)%s
+
+Give a one sentence reason for the scores.`
+
+var judgeSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"faithfulness": {Type: genai.TypeInteger},
+		"relevance":    {Type: genai.TypeInteger},
+		"reason":       {Type: genai.TypeString},
+	},
+	Required: []string{"faithfulness", "relevance", "reason"},
+}
+
+// Grade rates answer to c given the chunks it was generated from.
+func (j *Judge) Grade(ctx context.Context, c Case, chunks []string, answer string) (*Grade, error) {
+	var reference, compared string
+	if c.ReferenceAnswer != "" {
+		reference = fmt.Sprintf("\nReference answer:\n%s\n", c.ReferenceAnswer)
+		compared = ", judged against the reference answer"
+	}
+	prompt := fmt.Sprintf(judgePrompt, c.Question, strings.Join(chunks, "\n"), answer, reference, compared)
+
+	reply, err := j.Model.GenerateJSON(ctx, prompt, judgeSchema)
+	if err != nil {
+		return nil, err
+	}
+	var ratings struct {
+		Faithfulness int    `json:"faithfulness"`
+		Relevance    int    `json:"relevance"`
+		Reason       string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(reply), &ratings); err != nil {
+		return nil, fmt.Errorf("invalid grade %q: %w", reply, err)
+	}
+	return &Grade{
+		Faithfulness: scale(ratings.Faithfulness),
+		Relevance:    scale(ratings.Relevance),
+		Reason:       ratings.Reason,
+	}, nil
+}
+
+// scale maps a rating of 1 to 5 onto 0 to 1, clamping ratings out of range.
+func scale(rating int) float64 {
+	rating = min(max(rating, 1), 5)
+	return float64(rating-1) / 4
+}
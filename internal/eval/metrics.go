@@ -0,0 +1,62 @@
+package eval
+
+import (
+	"math"
+
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// rankDocuments lists the documents of chunks in the order they first
+// appear, which is the order retrieval ranked them.
+func rankDocuments(chunks []storage.Chunk) []string {
+	var documents []string
+	seen := make(map[string]bool)
+	for _, chunk := range chunks {
+		name := chunk.Filename
+		if name == "" {
+			name = chunk.DocumentID.Hex()
+		}
+		if !seen[name] {
+			seen[name] = true
+			documents = append(documents, name)
+		}
+	}
+	return documents
+}
+
+// rankOf returns the 1-based position of expected in documents, 0 when it
+// was not retrieved.
+func rankOf(documents []string, expected string) int {
+	for i, document := range documents {
+		if document == expected {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// RecallAt is 1 when the expected document is among the first k.
+func RecallAt(rank, k int) float64 {
+	if rank > 0 && rank <= k {
+		return 1
+	}
+	return 0
+}
+
+// ReciprocalRank is 1/rank, 0 when the document was not retrieved. Its mean
+// over the cases is the MRR.
+func ReciprocalRank(rank int) float64 {
+	if rank <= 0 {
+		return 0
+	}
+	return 1 / float64(rank)
+}
+
+// NDCGAt discounts a hit by the log of its rank. With a single relevant
+// document the ideal DCG is 1, so this is the normalized value.
+func NDCGAt(rank, k int) float64 {
+	if rank <= 0 || rank > k {
+		return 0
+	}
+	return 1 / math.Log2(float64(rank)+1)
+}
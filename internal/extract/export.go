@@ -0,0 +1,58 @@
+package extract
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// Row is one document's extraction in an export.
+type Row struct {
+	DocumentID string
+	Filename   string
+	Data       string
+	Valid      bool
+}
+
+// WriteCSV writes one line per document with the schema's top-level
+// properties as columns. Nested objects and arrays are written as JSON.
+func WriteCSV(w io.Writer, schema *Schema, rows []Row) error {
+	columns := schema.Columns()
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append([]string{"document_id", "filename", "valid"}, columns...)); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		var object map[string]interface{}
+		json.Unmarshal([]byte(row.Data), &object) // Invalid data leaves the columns empty
+
+		record := []string{row.DocumentID, row.Filename, strconv.FormatBool(row.Valid)}
+		for _, column := range columns {
+			record = append(record, csvValue(object[column]))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
@@ -0,0 +1,83 @@
+// Package extract fills user-defined JSON Schema templates from documents.
+package extract
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// JSONCompleter answers a prompt with JSON matching a response schema, see
+// ai.Model.GenerateJSON.
+type JSONCompleter interface {
+	GenerateJSON(ctx context.Context, prompt string, schema *genai.Schema) (string, error)
+}
+
+type Extractor struct {
+	Model JSONCompleter
+
+	// MaxChars is how much of a document the model reads.
+	MaxChars int
+}
+
+func New(model JSONCompleter, maxChars int) *Extractor {
+	return &Extractor{Model: model, MaxChars: maxChars}
+}
+
+// Result is the extracted JSON and what is wrong with it, if anything.
+type Result struct {
+	Data   string
+	Errors []string
+}
+
+const extractPrompt = `Extract the fields described by the response schema from the document below. Use null for a field the document does not mention, and never guess values.
+
+Filename: %s
+
+%s`
+
+const retryPrompt = `Your previous answer did not match the schema:
+%s
+
+Previous answer:
+%s
+
+Answer again, fixing these problems.`
+
+// Extract fills schema from the document's text. A reply that fails
+// validation is retried once with the problems listed; if it still fails,
+// the result carries the errors and the caller decides what to keep.
+func (e *Extractor) Extract(ctx context.Context, schema *Schema, filename, text string) (*Result, error) {
+	if runes := []rune(text); e.MaxChars > 0 && len(runes) > e.MaxChars {
+		text = string(runes[:e.MaxChars])
+	}
+
+	responseSchema := schema.Genai()
+	prompt := fmt.Sprintf(extractPrompt, filename, text)
+
+	var result *Result
+	for attempt := 0; attempt < 2; attempt++ {
+		reply, err := e.Model.GenerateJSON(ctx, prompt, responseSchema)
+		if err != nil {
+			return nil, err
+		}
+		result = check(schema, reply)
+		if len(result.Errors) == 0 {
+			return result, nil
+		}
+		prompt = fmt.Sprintf(extractPrompt, filename, text) + "\n\n" +
+			fmt.Sprintf(retryPrompt, strings.Join(result.Errors, "\n"), reply)
+	}
+	return result, nil
+}
+
+func check(schema *Schema, reply string) *Result {
+	var value interface{}
+	if err := json.Unmarshal([]byte(reply), &value); err != nil {
+		return &Result{Data: reply, Errors: []string{fmt.Sprintf("$: not valid JSON: %v", err)}}
+	}
+	return &Result{Data: reply, Errors: schema.Validate(value)}
+}
@@ -0,0 +1,87 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+const invoiceSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "type": "object",
+  "properties": {
+    "vendor": {"type": "string"},
+    "total": {"type": "number", "minimum": 0},
+    "due_date": {"type": ["string", "null"], "format": "date"},
+    "currency": {"type": "string", "enum": ["USD", "EUR"]},
+    "lines": {"type": "array", "items": {"type": "object", "properties": {"item": {"type": "string"}}}}
+  },
+  "required": ["vendor", "total"]
+}`
+
+func TestValidate(t *testing.T) {
+	schema, err := ParseSchema([]byte(invoiceSchema))
+	if err != nil {
+		t.Fatalf("ParseSchema failed: %+v", err)
+	}
+
+	valid := check(schema, `{"vendor": "Acme", "total": 12.5, "due_date": null, "currency": "EUR", "lines": [{"item": "Bolts"}]}`)
+	if len(valid.Errors) != 0 {
+		t.Errorf("Expected no errors, got %q", valid.Errors)
+	}
+
+	invalid := check(schema, `{"total": -1, "due_date": "next week", "currency": "GBP", "lines": [{"item": 3}]}`)
+	expected := []string{
+		`$: missing required property "vendor"`,
+		"$.currency: must be one of [USD EUR]",
+		"$.due_date: must be a date (YYYY-MM-DD)",
+		"$.lines[0].item: must be a string",
+		"$.total: must be at least 0",
+	}
+	for _, e := range expected {
+		found := false
+		for _, got := range invalid.Errors {
+			found = found || got == e
+		}
+		if !found {
+			t.Errorf("Expected error %q in %q", e, invalid.Errors)
+		}
+	}
+
+	if _, err := ParseSchema([]byte(`{"type": "object", "properties": {"a": {"type": "array"}}}`)); err == nil {
+		t.Error("Expected an array without items to be rejected")
+	}
+}
+
+type replies []string
+
+func (r *replies) GenerateJSON(ctx context.Context, prompt string, schema *genai.Schema) (string, error) {
+	reply := (*r)[0]
+	*r = (*r)[1:]
+	return reply, nil
+}
+
+func TestExtractRetriesInvalidReplies(t *testing.T) {
+	schema, _ := ParseSchema([]byte(invoiceSchema))
+	model := &replies{`{"vendor": "Acme"}`, `{"vendor": "Acme", "total": 40}`}
+
+	result, err := New(model, 1000).Extract(context.Background(), schema, "invoice.pdf", "Acme invoice, total 40")
+	if err != nil {
+		t.Fatalf("Extract failed: %+v", err)
+	}
+	if len(result.Errors) != 0 || !strings.Contains(result.Data, `"total": 40`) {
+		t.Errorf("Expected the retried reply, got %+v", result)
+	}
+
+	var out bytes.Buffer
+	if err := WriteCSV(&out, schema, []Row{{DocumentID: "1", Filename: "invoice.pdf", Data: result.Data, Valid: true}}); err != nil {
+		t.Fatalf("WriteCSV failed: %+v", err)
+	}
+	expected := "document_id,filename,valid,total,vendor,currency,due_date,lines\n1,invoice.pdf,true,40,Acme,,,\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
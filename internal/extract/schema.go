@@ -0,0 +1,286 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Schema is the subset of JSON Schema that templates may use: type (a name
+// or a list with "null"), properties, required, items, enum, format,
+// minimum, maximum and description. Other keywords such as $schema, title
+// and additionalProperties are accepted and ignored.
+type Schema struct {
+	Type        SchemaType         `json:"type"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Enum        []interface{}      `json:"enum,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+}
+
+// SchemaType is a JSON Schema type, written either as "string" or as
+// ["string", "null"].
+type SchemaType struct {
+	Name     string
+	Nullable bool
+}
+
+func (t *SchemaType) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		var name string
+		if err := json.Unmarshal(data, &name); err != nil {
+			return fmt.Errorf("type must be a string or a list of strings")
+		}
+		names = []string{name}
+	}
+	for _, name := range names {
+		if name == "null" {
+			t.Nullable = true
+			continue
+		}
+		if t.Name != "" {
+			return fmt.Errorf("only one type besides null is supported, got %q", names)
+		}
+		t.Name = name
+	}
+	return nil
+}
+
+func (t SchemaType) MarshalJSON() ([]byte, error) {
+	if t.Nullable {
+		return json.Marshal([]string{t.Name, "null"})
+	}
+	return json.Marshal(t.Name)
+}
+
+// ParseSchema reads a template's schema and checks that it only uses the
+// supported subset. The top level must be an object.
+func ParseSchema(raw []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	if s.Type.Name != "object" {
+		return nil, fmt.Errorf("invalid schema: the top level must be an object")
+	}
+	if err := s.check("$"); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	return &s, nil
+}
+
+func (s *Schema) check(path string) error {
+	switch s.Type.Name {
+	case "object":
+		if len(s.Properties) == 0 {
+			return fmt.Errorf("%s: objects need properties", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := s.Properties[name]; !ok {
+				return fmt.Errorf("%s: required property %q is not defined", path, name)
+			}
+		}
+		for name, property := range s.Properties {
+			if property == nil {
+				return fmt.Errorf("%s.%s: missing schema", path, name)
+			}
+			if err := property.check(path + "." + name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		if s.Items == nil {
+			return fmt.Errorf("%s: arrays need items", path)
+		}
+		return s.Items.check(path + "[]")
+	case "string", "number", "integer", "boolean":
+	case "":
+		return fmt.Errorf("%s: missing type", path)
+	default:
+		return fmt.Errorf("%s: unsupported type %q", path, s.Type.Name)
+	}
+	return nil
+}
+
+// Columns returns the top-level properties, required ones first, each group
+// sorted by name. They are the CSV columns of an export.
+func (s *Schema) Columns() []string {
+	required := make(map[string]bool)
+	for _, name := range s.Required {
+		required[name] = true
+	}
+	columns := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		columns = append(columns, name)
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		if required[columns[i]] != required[columns[j]] {
+			return required[columns[i]]
+		}
+		return columns[i] < columns[j]
+	})
+	return columns
+}
+
+var genaiTypes = map[string]genai.Type{
+	"object":  genai.TypeObject,
+	"array":   genai.TypeArray,
+	"string":  genai.TypeString,
+	"number":  genai.TypeNumber,
+	"integer": genai.TypeInteger,
+	"boolean": genai.TypeBoolean,
+}
+
+// Genai converts the schema into a Gemini response schema. Gemini supports
+// fewer formats and no bounds, so those are described in words instead and
+// enforced by Validate.
+func (s *Schema) Genai() *genai.Schema {
+	g := &genai.Schema{
+		Type:        genaiTypes[s.Type.Name],
+		Description: s.Description,
+		Nullable:    s.Type.Nullable,
+		Required:    s.Required,
+	}
+
+	var notes []string
+	if s.Format != "" {
+		notes = append(notes, "format "+s.Format)
+	}
+	if s.Minimum != nil {
+		notes = append(notes, fmt.Sprintf("at least %v", *s.Minimum))
+	}
+	if s.Maximum != nil {
+		notes = append(notes, fmt.Sprintf("at most %v", *s.Maximum))
+	}
+	if len(s.Enum) > 0 && s.Type.Name == "string" {
+		g.Format = "enum"
+		for _, value := range s.Enum {
+			g.Enum = append(g.Enum, fmt.Sprint(value))
+		}
+	}
+	if len(notes) > 0 {
+		g.Description = strings.TrimSpace(g.Description + " (" + strings.Join(notes, ", ") + ")")
+	}
+
+	if s.Items != nil {
+		g.Items = s.Items.Genai()
+	}
+	if len(s.Properties) > 0 {
+		g.Properties = make(map[string]*genai.Schema, len(s.Properties))
+		for name, property := range s.Properties {
+			g.Properties[name] = property.Genai()
+		}
+	}
+	return g
+}
+
+var formatPatterns = map[string]*regexp.Regexp{
+	"email": regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`),
+}
+
+// Validate checks a decoded JSON value against the schema and returns one
+// message per violation, each prefixed with the path of the value.
+func (s *Schema) Validate(value interface{}) []string {
+	var errs []string
+	s.validate("$", value, &errs)
+	return errs
+}
+
+func (s *Schema) validate(path string, value interface{}, errs *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if value == nil {
+		if !s.Type.Nullable {
+			fail("must not be null")
+		}
+		return
+	}
+
+	switch s.Type.Name {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		for name, property := range s.Properties {
+			if v, ok := object[name]; ok {
+				property.validate(path+"."+name, v, errs)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		for i, item := range items {
+			s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		switch s.Format {
+		case "date":
+			if _, err := time.Parse("2006-01-02", str); err != nil {
+				fail("must be a date (YYYY-MM-DD)")
+			}
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				fail("must be a date-time (RFC 3339)")
+			}
+		default:
+			if pattern, ok := formatPatterns[s.Format]; ok && !pattern.MatchString(str) {
+				fail("must be a valid %s", s.Format)
+			}
+		}
+	case "number", "integer":
+		n, ok := value.(float64)
+		if !ok {
+			fail("must be a %s", s.Type.Name)
+			return
+		}
+		if s.Type.Name == "integer" && n != float64(int64(n)) {
+			fail("must be an integer")
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be a boolean")
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		for _, allowed := range s.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				return
+			}
+		}
+		fail("must be one of %v", s.Enum)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/agent"
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// agentBackend gives the agent's tools the handler's storage, retrieval
+// pipeline and cached summaries.
+type agentBackend struct {
+	Store
+	h *Handler
+}
+
+func (b agentBackend) Search(ctx context.Context, userID, query string, filter storage.DocumentFilter, limit int) ([]storage.Chunk, error) {
+	return b.h.search(ctx, userID, query, filter, limit)
+}
+
+func (b agentBackend) Summarize(ctx context.Context, doc *storage.Document, userID string) (*storage.DocumentSummary, error) {
+	return b.h.documentSummary(ctx, doc, userID)
+}
+
+// DisabledTools reads the tools the user turned off in their settings.
+func (b agentBackend) DisabledTools(userID string) ([]string, error) {
+	settings, err := b.GetWorkspaceSettings(userID)
+	if err != nil {
+		return nil, err
+	}
+	return settings.DisabledTools, nil
+}
+
+// AgentChat answers a chat message with the tool-calling agent and returns
+// the tool calls it made along with the answer.
+func (h *Handler) AgentChat(c *gin.Context) {
+	userID := c.GetString("user_id")
+	query := c.Query("q")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing query"})
+		return
+	}
+
+	result, conversationID, err := h.runAgent(c.Request.Context(), userID, query, c.Query("conversation"))
+	if errors.Is(err, storage.ErrConversationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":        query,
+		"conversation": conversationID,
+		"results":      result.Answer,
+		"trace":        result.Trace,
+		"usage":        result.Usage,
+	})
+}
+
+// runAgent answers query with the agent in the given or a new conversation
+// and saves the turn.
+func (h *Handler) runAgent(ctx context.Context, userID, query, conversationID string) (*agent.Result, string, error) {
+	_, summary, history, err := h.loadConversation(conversationID, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	history = ai.PromptPlan{Summary: summary, History: history}.Messages()
+
+	result, err := h.Agent.Run(ctx, userID, history, query)
+	if err != nil {
+		log.Printf("Agent failed: %+v", err)
+		return nil, "", err
+	}
+
+	conversationID = h.saveTurn(conversationID, userID, query, result.Answer)
+	log.Printf("Agent made %d tool calls and used %d tokens", len(result.Trace), result.Usage.TotalTokens)
+	return result, conversationID, nil
+}
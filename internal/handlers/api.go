@@ -0,0 +1,575 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/agent"
+	"github.com/sdrshn-nmbr/tusk/internal/middleware"
+	"github.com/sdrshn-nmbr/tusk/internal/retrieval"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The /api/v1 routes answer in JSON only. A success carries its result in
+// "data", a list adds "pagination", and a failure is
+// {"error": {"code": ..., "message": ...}}.
+
+// API error codes
+const (
+	codeBadRequest  = "bad_request"
+	codeNotFound    = "not_found"
+	codeUnsupported = "unsupported_media_type"
+	codeTimeout     = "timeout"
+	codeInternal    = "internal"
+)
+
+// apiDocument is a document as the API shows it.
+type apiDocument struct {
+	ID         string                   `json:"id"`
+	Filename   string                   `json:"filename"`
+	Folder     string                   `json:"folder"`
+	Tags       []string                 `json:"tags"`
+	Attributes map[string]interface{}   `json:"attributes"`
+	Title      string                   `json:"title,omitempty"`
+	Abstract   string                   `json:"abstract,omitempty"`
+	SizeBytes  int64                    `json:"size_bytes"`
+	SHA256     string                   `json:"sha256,omitempty"`
+	UploadedAt time.Time                `json:"uploaded_at"`
+	Summary    *storage.DocumentSummary `json:"summary,omitempty"`
+}
+
+func newAPIDocument(doc *storage.Document) apiDocument {
+	out := apiDocument{
+		ID:         doc.ID.Hex(),
+		Filename:   doc.Filename,
+		Folder:     doc.Folder,
+		Tags:       doc.Tags,
+		Attributes: doc.Attributes,
+		Title:      doc.Metadata["title"],
+		Abstract:   doc.Metadata["abstract"],
+		SizeBytes:  doc.Size(),
+		SHA256:     doc.Metadata["sha256"],
+		UploadedAt: doc.UploadedAt,
+		Summary:    doc.Summary,
+	}
+	if out.Tags == nil {
+		out.Tags = []string{}
+	}
+	if out.Attributes == nil {
+		out.Attributes = map[string]interface{}{}
+	}
+	return out
+}
+
+func apiError(c *gin.Context, statusCode int, code, message string) {
+	c.AbortWithStatusJSON(statusCode, gin.H{
+		"error": gin.H{"code": code, "message": message},
+	})
+}
+
+// apiStorageError maps storage errors to API errors.
+func apiStorageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrDocumentNotFound),
+		errors.Is(err, storage.ErrInvalidDocumentID),
+		errors.Is(err, storage.ErrConversationNotFound),
+		errors.Is(err, storage.ErrJobNotFound),
+		errors.Is(err, storage.ErrTemplateNotFound):
+		apiError(c, http.StatusNotFound, codeNotFound, err.Error())
+	case errors.Is(err, storage.ErrInvalidAttributeKey),
+		errors.Is(err, storage.ErrNoTags):
+		apiError(c, http.StatusBadRequest, codeBadRequest, err.Error())
+	default:
+		log.Printf("API error: %+v", err)
+		apiError(c, http.StatusInternalServerError, codeInternal, err.Error())
+	}
+}
+
+func apiData(c *gin.Context, statusCode int, data interface{}) {
+	c.JSON(statusCode, gin.H{"data": data})
+}
+
+func apiList(c *gin.Context, items interface{}, offset, limit, total int64) {
+	c.JSON(http.StatusOK, gin.H{
+		"data": items,
+		"pagination": gin.H{
+			"offset":   offset,
+			"limit":    limit,
+			"total":    total,
+			"has_more": offset+limit < total,
+		},
+	})
+}
+
+// apiPage reads the offset and limit query parameters, bounded by the
+// configured page sizes.
+func (h *Handler) apiPage(c *gin.Context) (int64, int64, bool) {
+	maxPageSize := int64(h.cfg.API.MaxPageSize)
+	offset, limit := int64(0), int64(h.cfg.API.DefaultPageSize)
+	if raw := c.Query("offset"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			apiError(c, http.StatusBadRequest, codeBadRequest, "offset must be a non-negative integer")
+			return 0, 0, false
+		}
+		offset = n
+	}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 1 || n > maxPageSize {
+			apiError(c, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return 0, 0, false
+		}
+		limit = n
+	}
+	return offset, limit, true
+}
+
+// APINotFound answers unknown /api/ routes with an error envelope and
+// leaves other paths to gin's default 404.
+func APINotFound(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+		apiError(c, http.StatusNotFound, codeNotFound, "No such endpoint")
+		return
+	}
+	c.String(http.StatusNotFound, "404 page not found")
+}
+
+// RegisterAPIRoutes sets up the /api/v1 JSON API and serves spec at
+// /api/v1/openapi.json. Every route registered here has to be documented in
+// api/openapi.json, which TestOpenAPISpec checks.
+func (h *Handler) RegisterAPIRoutes(r *gin.Engine, spec []byte) {
+	api := r.Group("/api/v1")
+	api.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", spec)
+	})
+
+	// Signed in users may do everything; API tokens only what their scopes
+	// allow
+	authed := api.Group("", middleware.APIAuthRequired(h.Sessions, h.Storage))
+	read := middleware.RequireScope(storage.ScopeRead)
+	write := middleware.RequireScope(storage.ScopeWrite)
+	chat := middleware.RequireScope(storage.ScopeChat)
+	authed.GET("/documents", read, h.APIListDocuments)
+	authed.POST("/documents", write, h.APIUploadDocument)
+	authed.GET("/documents/:id", read, h.APIGetDocument)
+	authed.PATCH("/documents/:id", write, h.APIUpdateDocument)
+	authed.DELETE("/documents/:id", write, h.APIDeleteDocument)
+	authed.GET("/documents/:id/content", read, h.APIDownloadDocument)
+	authed.GET("/folders", read, h.APIListFolders)
+	authed.GET("/search", read, h.APISearch)
+	authed.POST("/chat", chat, h.APIChat)
+	authed.GET("/prompts", chat, h.APIListPrompts)
+	authed.GET("/jobs", read, h.APIListJobs)
+	authed.POST("/jobs", write, h.APICreateJob)
+	authed.GET("/jobs/:id", read, h.APIGetJob)
+}
+
+// APIListDocuments lists documents with the same filters as the file list.
+func (h *Handler) APIListDocuments(c *gin.Context) {
+	filter, err := documentFilterFromQuery(c)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, codeBadRequest, filterError(err).Error())
+		return
+	}
+	offset, limit, ok := h.apiPage(c)
+	if !ok {
+		return
+	}
+
+	docs, total, err := h.Storage.ListFilesPage(c.GetString("user_id"), filter, offset, limit)
+	if err != nil {
+		apiStorageError(c, err)
+		return
+	}
+	out := make([]apiDocument, len(docs))
+	for i := range docs {
+		out[i] = newAPIDocument(&docs[i])
+	}
+	apiList(c, out, offset, limit, total)
+}
+
+// APIUploadDocument stores a multipart "file", optionally in "folder".
+func (h *Handler) APIUploadDocument(c *gin.Context) {
+	userID := c.GetString("user_id")
+	file, err := c.FormFile("file")
+	if err != nil {
+		apiError(c, http.StatusBadRequest, codeBadRequest, "Missing file")
+		return
+	}
+	opened, err := file.Open()
+	if err != nil {
+		apiStorageError(c, err)
+		return
+	}
+	defer opened.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, opened); err != nil {
+		apiStorageError(c, err)
+		return
+	}
+
+	doc, err := h.Storage.SaveFile(file.Filename, &buf, h.Embedder, userID)
+	if err != nil {
+		if ingestStatus(err) == http.StatusUnsupportedMediaType {
+			apiError(c, http.StatusUnsupportedMediaType, codeUnsupported, err.Error())
+			return
+		}
+		apiStorageError(c, err)
+		return
+	}
+
+	if folder := storage.NormalizeFolder(c.PostForm("folder")); folder != "" {
+		result := h.Storage.BulkMove([]string{doc.ID.Hex()}, folder, userID)[0]
+		if !result.OK {
+			apiStorageError(c, documentResultError(result))
+			return
+		}
+		doc.Folder = folder
+	}
+	apiData(c, http.StatusCreated, newAPIDocument(doc))
+}
+
+func (h *Handler) APIGetDocument(c *gin.Context) {
+	doc, err := h.Storage.GetDocument(c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		apiStorageError(c, err)
+		return
+	}
+	apiData(c, http.StatusOK, newAPIDocument(doc))
+}
+
+// APIUpdateDocument moves a document and replaces its tags; fields missing
+// from the request are left alone.
+func (h *Handler) APIUpdateDocument(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var request struct {
+		Folder *string   `json:"folder"`
+		Tags   *[]string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		apiError(c, http.StatusBadRequest, codeBadRequest, "Invalid request")
+		return
+	}
+
+	doc, err := h.Storage.GetDocument(c.Param("id"), userID)
+	if err != nil {
+		apiStorageError(c, err)
+		return
+	}
+	id := doc.ID.Hex()
+	if request.Folder != nil {
+		if result := h.Storage.BulkMove([]string{id}, *request.Folder, userID)[0]; !result.OK {
+			apiStorageError(c, documentResultError(result))
+			return
+		}
+	}
+	if request.Tags != nil {
+		if err := h.Storage.SetTags(id, *request.Tags, userID); err != nil {
+			apiStorageError(c, err)
+			return
+		}
+	}
+
+	h.APIGetDocument(c)
+}
+
+func (h *Handler) APIDeleteDocument(c *gin.Context) {
+	userID := c.GetString("user_id")
+	doc, err := h.Storage.GetDocument(c.Param("id"), userID)
+	if err != nil {
+		apiStorageError(c, err)
+		return
+	}
+	if result := h.Storage.BulkDelete([]string{doc.ID.Hex()}, userID)[0]; !result.OK {
+		apiStorageError(c, documentResultError(result))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// APIDownloadDocument returns the original file.
+func (h *Handler) APIDownloadDocument(c *gin.Context) {
+	docs, results := h.Storage.GetDocuments([]string{c.Param("id")}, c.GetString("user_id"))
+	if len(docs) == 0 {
+		apiStorageError(c, documentResultError(results[0]))
+		return
+	}
+	doc := docs[0]
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(doc.Filename)))
+	c.Data(http.StatusOK, "application/octet-stream", doc.Content.Data)
+}
+
+// documentResultError returns the error of a failed bulk result, so that
+// apiStorageError can tell not found and invalid documents apart.
+func documentResultError(result storage.BulkResult) error {
+	if result.Err != nil {
+		return result.Err
+	}
+	return errors.New(result.Error)
+}
+
+func (h *Handler) APIListFolders(c *gin.Context) {
+	folders, err := h.Storage.Folders(c.GetString("user_id"))
+	if err != nil {
+		apiStorageError(c, err)
+		return
+	}
+	apiData(c, http.StatusOK, folders)
+}
+
+// APISearch returns the passages most relevant to q, without generating an
+// answer. It takes the file list filters and limit (1 to 20).
+func (h *Handler) APISearch(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		apiError(c, http.StatusBadRequest, codeBadRequest, "Missing query")
+		return
+	}
+	filter, err := documentFilterFromQuery(c)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, codeBadRequest, filterError(err).Error())
+		return
+	}
+	limit := h.cfg.Retrieval.ContextChunks
+	if raw := c.Query("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > 20 {
+			apiError(c, http.StatusBadRequest, codeBadRequest, "limit must be between 1 and 20")
+			return
+		}
+	}
+
+	chunks, err := h.search(c.Request.Context(), c.GetString("user_id"), query, filter, limit)
+	if err != nil {
+		apiStorageError(c, err)
+		return
+	}
+	type passage struct {
+		DocumentID string  `json:"document_id"`
+		Filename   string  `json:"filename"`
+		Content    string  `json:"content"`
+		Score      float64 `json:"score"`
+	}
+	passages := make([]passage, len(chunks))
+	for i, chunk := range chunks {
+		passages[i] = passage{chunk.DocumentID.Hex(), chunk.Filename, chunk.Content, chunk.Score}
+	}
+	apiData(c, http.StatusOK, passages)
+}
+
+// search runs the retrieval pipeline and keeps the first limit chunks.
+func (h *Handler) search(ctx context.Context, userID, query string, filter storage.DocumentFilter, limit int) ([]storage.Chunk, error) {
+	retriever := *h.Retriever
+	if limit > retriever.Limit {
+		retriever.Limit = limit
+	}
+	result, err := retriever.Retrieve(ctx, h.Storage.ActiveEmbedder(h.Embedder), retrieval.Request{
+		Query:  query,
+		UserID: userID,
+		Filter: filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Chunks) > limit {
+		return result.Chunks[:limit], nil
+	}
+	return result.Chunks, nil
+}
+
+// APIChat answers a message, continuing conversation when given. With
+// document_ids the answer uses only those documents; with agent the
+// tool-calling agent answers instead. prompts selects prompt templates by
+// kind for the conversation from this message on. With stream the answer
+// is sent as server-sent events, see streamChat.
+func (h *Handler) APIChat(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var request struct {
+		Query        string            `json:"query"`
+		Conversation string            `json:"conversation"`
+		DocumentIDs  []string          `json:"document_ids"`
+		Agent        bool              `json:"agent"`
+		Stream       bool              `json:"stream"`
+		Prompts      map[string]string `json:"prompts"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil || strings.TrimSpace(request.Query) == "" {
+		apiError(c, http.StatusBadRequest, codeBadRequest, "A query is required")
+		return
+	}
+	if request.Agent && (len(request.DocumentIDs) > 0 || len(request.Prompts) > 0) {
+		apiError(c, http.StatusBadRequest, codeBadRequest, "The agent chooses its own documents and prompts, document_ids and prompts cannot be used with agent")
+		return
+	}
+	if err := h.checkPrompts(request.Prompts); err != nil {
+		apiError(c, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	var filter storage.DocumentFilter
+	for _, id := range request.DocumentIDs {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, codeBadRequest, "Invalid document id: "+id)
+			return
+		}
+		filter.DocumentIDs = append(filter.DocumentIDs, objectID)
+	}
+
+	answer := func(onToken func(string)) (gin.H, error) {
+		if request.Agent {
+			return h.apiAgentChat(c.Request.Context(), userID, request.Query, request.Conversation)
+		}
+		answer, err := h.chat(c.Request.Context(), userID, request.Query, request.Conversation, filter, request.Prompts, onToken)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{
+			"conversation":    answer.Conversation,
+			"answer":          answer.Answer,
+			"rewritten":       answer.Rewritten,
+			"whole_documents": answer.WholeDocuments,
+			"usage":           answer.Usage,
+			"trace":           []agent.Step{},
+		}, nil
+	}
+
+	if request.Stream {
+		h.streamChat(c, answer)
+		return
+	}
+	response, err := answer(nil)
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		apiError(c, http.StatusGatewayTimeout, codeTimeout, "Request timed out")
+		return
+	case err != nil:
+		apiStorageError(c, err)
+		return
+	}
+	apiData(c, http.StatusOK, response)
+}
+
+func (h *Handler) apiAgentChat(ctx context.Context, userID, query, conversationID string) (gin.H, error) {
+	result, conversationID, err := h.runAgent(ctx, userID, query, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	return gin.H{
+		"conversation":    conversationID,
+		"answer":          result.Answer,
+		"rewritten":       query,
+		"whole_documents": false,
+		"usage":           result.Usage,
+		"trace":           result.Trace,
+	}, nil
+}
+
+// streamChat sends the answer as server-sent events: a "token" event
+// {"text": ...} for every piece of the answer as it is generated, then
+// either "done" with the same object a non-streaming request returns in
+// "data", or "error" with the error object. The agent's answer arrives in
+// one piece with "done".
+func (h *Handler) streamChat(c *gin.Context, answer func(onToken func(string)) (gin.H, error)) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	response, err := answer(func(token string) {
+		c.SSEvent("token", gin.H{"text": token})
+		c.Writer.Flush()
+	})
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		c.SSEvent("error", gin.H{"code": codeTimeout, "message": "Request timed out"})
+	case errors.Is(err, storage.ErrConversationNotFound):
+		c.SSEvent("error", gin.H{"code": codeNotFound, "message": err.Error()})
+	case err != nil:
+		log.Printf("API error: %+v", err)
+		c.SSEvent("error", gin.H{"code": codeInternal, "message": err.Error()})
+	default:
+		c.SSEvent("done", response)
+	}
+	c.Writer.Flush()
+}
+
+func (h *Handler) APIListJobs(c *gin.Context) {
+	offset, limit, ok := h.apiPage(c)
+	if !ok {
+		return
+	}
+	jobs, total, err := h.Storage.ListJobs(c.GetString("user_id"), offset, limit)
+	if err != nil {
+		apiStorageError(c, err)
+		return
+	}
+	apiList(c, jobs, offset, limit, total)
+}
+
+// APICreateJob starts a background job over the given documents and
+// answers 202 with the queued job, which can be polled at /jobs/:id.
+func (h *Handler) APICreateJob(c *gin.Context) {
+	var request struct {
+		Type        string   `json:"type"`
+		DocumentIDs []string `json:"document_ids"`
+		TemplateID  string   `json:"template_id"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		apiError(c, http.StatusBadRequest, codeBadRequest, "Invalid request")
+		return
+	}
+	if len(request.DocumentIDs) == 0 {
+		apiError(c, http.StatusBadRequest, codeBadRequest, "No documents selected")
+		return
+	}
+	if len(request.DocumentIDs) > storage.MaxBulkItems {
+		apiError(c, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("At most %d documents can be processed at once", storage.MaxBulkItems))
+		return
+	}
+
+	job := &storage.Job{
+		UserID:      c.GetString("user_id"),
+		Type:        request.Type,
+		DocumentIDs: request.DocumentIDs,
+		TemplateID:  request.TemplateID,
+	}
+	process, err := h.jobProcessor(job)
+	if err != nil {
+		if errors.Is(err, storage.ErrTemplateNotFound) {
+			apiStorageError(c, err)
+			return
+		}
+		apiError(c, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	if err := h.Storage.CreateJob(job); err != nil {
+		apiStorageError(c, err)
+		return
+	}
+
+	go h.runJob(job, process)
+	apiData(c, http.StatusAccepted, job)
+}
+
+func (h *Handler) APIGetJob(c *gin.Context) {
+	job, err := h.Storage.GetJob(c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		apiStorageError(c, err)
+		return
+	}
+	apiData(c, http.StatusOK, job)
+}
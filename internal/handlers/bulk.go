@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// bulkRequest is accepted as JSON or as form values with repeated ids.
+type bulkRequest struct {
+	IDs    []string `json:"ids" form:"ids"`
+	Folder string   `json:"folder" form:"folder"`
+	Tags   []string `json:"tags" form:"tags"`
+}
+
+func (h *Handler) BulkDelete(c *gin.Context) {
+	req, ok := bindBulkRequest(c)
+	if !ok {
+		return
+	}
+	h.respondBulk(c, h.Storage.BulkDelete(req.IDs, c.GetString("user_id")))
+}
+
+func (h *Handler) BulkMove(c *gin.Context) {
+	req, ok := bindBulkRequest(c)
+	if !ok {
+		return
+	}
+	h.respondBulk(c, h.Storage.BulkMove(req.IDs, req.Folder, c.GetString("user_id")))
+}
+
+func (h *Handler) BulkTag(c *gin.Context) {
+	req, ok := bindBulkRequest(c)
+	if !ok {
+		return
+	}
+	h.respondBulk(c, h.Storage.BulkTag(req.IDs, req.Tags, c.GetString("user_id")))
+}
+
+func (h *Handler) BulkReindex(c *gin.Context) {
+	req, ok := bindBulkRequest(c)
+	if !ok {
+		return
+	}
+	h.respondBulk(c, h.Storage.BulkReindex(req.IDs, h.Embedder, c.GetString("user_id")))
+}
+
+// BulkDownload streams the selected documents as a ZIP archive. Documents
+// that could not be loaded are listed in an errors.txt entry.
+func (h *Handler) BulkDownload(c *gin.Context) {
+	req, ok := bindBulkRequest(c)
+	if !ok {
+		return
+	}
+
+	docs, results := h.Storage.GetDocuments(req.IDs, c.GetString("user_id"))
+	if len(docs) == 0 {
+		h.handleError(c, http.StatusNotFound, errors.New("none of the selected files could be found"))
+		return
+	}
+
+	archiveName := fmt.Sprintf("tusk-%s.zip", time.Now().Format("20060102-150405"))
+	c.Header("Content-Disposition", "attachment; filename="+archiveName)
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	used := make(map[string]int)
+	for _, doc := range docs {
+		name := zipEntryName(doc, used)
+		w, err := zw.Create(name)
+		if err != nil {
+			log.Printf("Error adding %s to archive: %+v", name, err)
+			return
+		}
+		if _, err := w.Write(doc.Content.Data); err != nil {
+			log.Printf("Error writing %s to archive: %+v", name, err)
+			return
+		}
+	}
+
+	var failures []string
+	for _, result := range results {
+		if !result.OK {
+			failures = append(failures, fmt.Sprintf("%s: %s", result.ID, result.Error))
+		}
+	}
+	if len(failures) > 0 {
+		if w, err := zw.Create("errors.txt"); err == nil {
+			fmt.Fprintln(w, strings.Join(failures, "\n"))
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		log.Printf("Error finishing archive: %+v", err)
+	}
+}
+
+func bindBulkRequest(c *gin.Context) (*bulkRequest, bool) {
+	var req bulkRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return nil, false
+	}
+	if len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No documents selected"})
+		return nil, false
+	}
+	if len(req.IDs) > storage.MaxBulkItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d documents can be processed at once", storage.MaxBulkItems)})
+		return nil, false
+	}
+	return &req, true
+}
+
+// respondBulk reports per-item results and tells htmx to refresh the list.
+func (h *Handler) respondBulk(c *gin.Context, results []storage.BulkResult) {
+	succeeded := 0
+	for _, result := range results {
+		if result.OK {
+			succeeded++
+		}
+	}
+
+	c.Header("HX-Trigger", "fileListChanged")
+	c.JSON(http.StatusOK, gin.H{
+		"results":   results,
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+	})
+}
+
+// zipEntryName keeps the folder structure inside the archive and numbers
+// duplicate names so no entry overwrites another.
+func zipEntryName(doc storage.Document, used map[string]int) string {
+	name := path.Base(doc.Filename)
+	if doc.Folder != "" {
+		name = doc.Folder + "/" + name
+	}
+
+	used[name]++
+	if n := used[name]; n > 1 {
+		ext := path.Ext(name)
+		name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+	}
+	return name
+}
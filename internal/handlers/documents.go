@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AttributeInfo is a document attribute formatted for the file list.
+type AttributeInfo struct {
+	Key   string
+	Type  string
+	Value string
+}
+
+func (h *Handler) SetTags(c *gin.Context) {
+	var request struct {
+		Tags []string `json:"tags" form:"tags"`
+	}
+	if err := c.ShouldBind(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	err := h.Storage.SetTags(c.Param("id"), request.Tags, c.GetString("user_id"))
+	h.respondDocument(c, err)
+}
+
+func (h *Handler) RemoveTag(c *gin.Context) {
+	err := h.Storage.RemoveTag(c.Param("id"), c.Param("tag"), c.GetString("user_id"))
+	h.respondDocument(c, err)
+}
+
+func (h *Handler) SetAttribute(c *gin.Context) {
+	var request struct {
+		Key   string `json:"key" form:"key" binding:"required"`
+		Type  string `json:"type" form:"type"`
+		Value string `json:"value" form:"value"`
+	}
+	if err := c.ShouldBind(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	value, err := storage.ParseAttribute(request.Type, request.Value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.Storage.SetAttribute(c.Param("id"), request.Key, value, c.GetString("user_id"))
+	h.respondDocument(c, err)
+}
+
+func (h *Handler) RemoveAttribute(c *gin.Context) {
+	err := h.Storage.RemoveAttribute(c.Param("id"), c.Param("key"), c.GetString("user_id"))
+	h.respondDocument(c, err)
+}
+
+// respondDocument answers metadata edits with the updated document and asks
+// htmx to refresh the file list.
+func (h *Handler) respondDocument(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrDocumentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, storage.ErrInvalidAttributeKey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	doc, err := h.Storage.GetDocument(c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("HX-Trigger", "fileListChanged")
+	c.JSON(http.StatusOK, gin.H{
+		"id":         doc.ID.Hex(),
+		"filename":   doc.Filename,
+		"folder":     doc.Folder,
+		"tags":       doc.Tags,
+		"attributes": doc.Attributes,
+	})
+}
+
+// documentFilterFromQuery reads the shared list and search filters:
+// folder, tag (repeatable), from, to, attr=key:op:value (repeatable), sort
+// and order.
+func documentFilterFromQuery(c *gin.Context) (storage.DocumentFilter, error) {
+	filter := storage.DocumentFilter{
+		Folder:   c.Query("folder"),
+		Tags:     c.QueryArray("tag"),
+		SortBy:   c.Query("sort"),
+		SortDesc: c.Query("order") == "desc",
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := storage.ParseDate(from)
+		if err != nil {
+			return filter, err
+		}
+		filter.UploadedAfter = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := storage.ParseDate(to)
+		if err != nil {
+			return filter, err
+		}
+		// "to" is inclusive, so a bare year, month or day covers all of it
+		filter.UploadedBefore = endOfPeriod(to, t)
+	}
+
+	for _, expr := range c.QueryArray("attr") {
+		if expr == "" {
+			continue
+		}
+		attr, err := storage.ParseAttributeFilter(expr)
+		if err != nil {
+			return filter, err
+		}
+		filter.Attributes = append(filter.Attributes, attr)
+	}
+
+	return filter, nil
+}
+
+func endOfPeriod(raw string, t time.Time) time.Time {
+	switch len(raw) {
+	case len("2006"):
+		return t.AddDate(1, 0, 0)
+	case len("2006-01"):
+		return t.AddDate(0, 1, 0)
+	case len("2006-01-02"):
+		return t.AddDate(0, 0, 1)
+	default:
+		return t.Add(time.Nanosecond)
+	}
+}
+
+func attributeInfos(attributes map[string]interface{}) []AttributeInfo {
+	infos := make([]AttributeInfo, 0, len(attributes))
+	for key, value := range attributes {
+		infos = append(infos, AttributeInfo{
+			Key:   key,
+			Type:  storage.AttributeType(value),
+			Value: storage.FormatAttribute(value),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos
+}
+
+func filterError(err error) error {
+	return fmt.Errorf("invalid filter: %v", err)
+}
+
+// SummarizeDocument returns the document's cached summary, producing it with
+// a map-reduce over all of its chunks the first time or when refresh is set.
+func (h *Handler) SummarizeDocument(c *gin.Context) {
+	userID := c.GetString("user_id")
+	doc, err := h.Storage.GetDocument(c.Param("id"), userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrDocumentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	cached := doc.Summary != nil && c.Query("refresh") != "true"
+	if !cached {
+		doc.Summary = nil
+	}
+	summary, err := h.documentSummary(c.Request.Context(), doc, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	doc.Summary = summary
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":       doc.ID.Hex(),
+		"filename": doc.Filename,
+		"summary":  doc.Summary,
+		"cached":   cached,
+	})
+}
+
+// documentSummary returns the cached summary of doc, producing and saving
+// it first if there is none.
+func (h *Handler) documentSummary(ctx context.Context, doc *storage.Document, userID string) (*storage.DocumentSummary, error) {
+	if doc.Summary != nil {
+		return doc.Summary, nil
+	}
+
+	chunks, err := h.Storage.DocumentChunks([]primitive.ObjectID{doc.ID}, userID)
+	if err != nil {
+		return nil, err
+	}
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Content
+	}
+
+	text, err := h.Summarizer.Summarize(ctx, texts)
+	if err != nil {
+		log.Printf("Failed to summarize document %s: %+v", doc.ID.Hex(), err)
+		return nil, err
+	}
+	summary := &storage.DocumentSummary{Text: text, Chunks: len(chunks), GeneratedAt: time.Now()}
+	if err := h.Storage.SaveDocumentSummary(doc.ID.Hex(), userID, *summary); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// fitDocuments plans a prompt with every chunk of the pinned documents and
+// reports whether all of them fit.
+func (h *Handler) fitDocuments(ctx context.Context, userID string, ids []primitive.ObjectID, parts ai.PromptParts, templates chatPrompts) (ai.PromptParts, ai.PromptPlan, bool) {
+	chunks, err := h.Storage.DocumentChunks(ids, userID)
+	if err != nil || len(chunks) == 0 {
+		return parts, ai.PromptPlan{}, false
+	}
+
+	// Skip counting exactly when the estimate is already far over budget
+	tokens := 0
+	for _, chunk := range chunks {
+		tokens += ai.EstimateTokens(chunk.Content)
+	}
+	if tokens > h.Budgeter.MaxPromptTokens {
+		return parts, ai.PromptPlan{}, false
+	}
+
+	// Only search results carry the filename of their chunks
+	hexIDs := make([]string, len(ids))
+	for i, id := range ids {
+		hexIDs[i] = id.Hex()
+	}
+	docs, _ := h.Storage.GetDocuments(hexIDs, userID)
+	filenames := make(map[primitive.ObjectID]string, len(docs))
+	for _, doc := range docs {
+		filenames[doc.ID] = doc.Filename
+	}
+	for i := range chunks {
+		chunks[i].Filename = filenames[chunks[i].DocumentID]
+	}
+
+	whole := templates.withChunks(parts, chunks)
+	plan, err := h.Budgeter.Fit(ctx, whole)
+	if err != nil || plan.DroppedChunks > 0 {
+		return parts, ai.PromptPlan{}, false
+	}
+	return whole, plan, true
+}
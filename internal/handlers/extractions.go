@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/extract"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// extractionResult reports a run for a single document.
+type extractionResult struct {
+	ID       string   `json:"id"`
+	Filename string   `json:"filename,omitempty"`
+	OK       bool     `json:"ok"`
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+func (h *Handler) ListExtractionTemplates(c *gin.Context) {
+	templates, err := h.Storage.ListExtractionTemplates(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+// CreateExtractionTemplate accepts the schema as a JSON object or as a
+// string holding one.
+func (h *Handler) CreateExtractionTemplate(c *gin.Context) {
+	var request struct {
+		Name   string          `json:"name" binding:"required"`
+		Schema json.RawMessage `json:"schema" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	raw := []byte(request.Schema)
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		raw = []byte(text)
+	}
+	if _, err := extract.ParseSchema(raw); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	template, err := h.Storage.CreateExtractionTemplate(c.GetString("user_id"), strings.TrimSpace(request.Name), string(raw))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, template)
+}
+
+func (h *Handler) DeleteExtractionTemplate(c *gin.Context) {
+	err := h.Storage.DeleteExtractionTemplate(c.Param("id"), c.GetString("user_id"))
+	if errors.Is(err, storage.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RunExtraction applies a template to the given documents or to every
+// document in a folder, replacing earlier results.
+func (h *Handler) RunExtraction(c *gin.Context) {
+	userID := c.GetString("user_id")
+	template, schema, ok := h.loadTemplate(c)
+	if !ok {
+		return
+	}
+
+	var request bulkRequest
+	if err := c.ShouldBind(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	var docs []storage.Document
+	var results []extractionResult
+	switch {
+	case len(request.IDs) > 0:
+		if len(request.IDs) > storage.MaxBulkItems {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d documents can be processed at once", storage.MaxBulkItems)})
+			return
+		}
+		for _, id := range request.IDs {
+			doc, err := h.Storage.GetDocument(id, userID)
+			if err != nil {
+				results = append(results, extractionResult{ID: id, Error: err.Error()})
+				continue
+			}
+			docs = append(docs, *doc)
+		}
+	case request.Folder != "":
+		var err error
+		docs, err = h.Storage.ListFiles(userID, storage.DocumentFilter{Folder: request.Folder})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(docs) > storage.MaxBulkItems {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("The folder has more than %d documents", storage.MaxBulkItems)})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No documents selected"})
+		return
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	// Bound the model requests of one run
+	semaphore := make(chan struct{}, h.cfg.Jobs.ExtractionConcurrency)
+	for _, doc := range docs {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(doc storage.Document) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			result := h.extractDocument(c.Request.Context(), userID, template, schema, doc)
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}(doc)
+	}
+	wg.Wait()
+
+	valid := 0
+	for _, result := range results {
+		if result.Valid {
+			valid++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"valid":   valid,
+		"invalid": len(results) - valid,
+	})
+}
+
+func (h *Handler) extractDocument(ctx context.Context, userID string, template *storage.ExtractionTemplate, schema *extract.Schema, doc storage.Document) extractionResult {
+	result := extractionResult{ID: doc.ID.Hex(), Filename: doc.Filename}
+
+	chunks, err := h.Storage.DocumentChunks([]primitive.ObjectID{doc.ID}, userID)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Content
+	}
+
+	extracted, err := h.Extractor.Extract(ctx, schema, doc.Filename, strings.Join(texts, "\n"))
+	if err != nil {
+		log.Printf("Failed to extract %s from %s: %+v", template.Name, doc.Filename, err)
+		result.Error = err.Error()
+		return result
+	}
+
+	err = h.Storage.SaveExtraction(storage.Extraction{
+		TemplateID:  template.ID,
+		DocumentID:  doc.ID,
+		UserID:      userID,
+		Filename:    doc.Filename,
+		Data:        extracted.Data,
+		Valid:       len(extracted.Errors) == 0,
+		Errors:      extracted.Errors,
+		ExtractedAt: time.Now(),
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.OK = true
+	result.Valid = len(extracted.Errors) == 0
+	result.Errors = extracted.Errors
+	return result
+}
+
+// ExportExtractions downloads a template's results as JSON (the default) or
+// as CSV with format=csv.
+func (h *Handler) ExportExtractions(c *gin.Context) {
+	template, schema, ok := h.loadTemplate(c)
+	if !ok {
+		return
+	}
+
+	extractions, err := h.Storage.ListExtractions(template.ID, c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := strings.Map(func(r rune) rune {
+		if r == '"' || r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, template.Name)
+
+	if c.Query("format") == "csv" {
+		rows := make([]extract.Row, len(extractions))
+		for i, e := range extractions {
+			rows[i] = extract.Row{DocumentID: e.DocumentID.Hex(), Filename: e.Filename, Data: e.Data, Valid: e.Valid}
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+		c.Header("Content-Type", "text/csv")
+		c.Status(http.StatusOK)
+		if err := extract.WriteCSV(c.Writer, schema, rows); err != nil {
+			log.Printf("Error writing CSV export: %+v", err)
+		}
+		return
+	}
+
+	type exported struct {
+		DocumentID  string          `json:"document_id"`
+		Filename    string          `json:"filename"`
+		Valid       bool            `json:"valid"`
+		Errors      []string        `json:"errors,omitempty"`
+		Data        json.RawMessage `json:"data"`
+		ExtractedAt time.Time       `json:"extracted_at"`
+	}
+	out := make([]exported, len(extractions))
+	for i, e := range extractions {
+		data := json.RawMessage(e.Data)
+		if !json.Valid(data) {
+			data, _ = json.Marshal(e.Data)
+		}
+		out[i] = exported{e.DocumentID.Hex(), e.Filename, e.Valid, e.Errors, data, e.ExtractedAt}
+	}
+	if c.Query("download") == "true" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, name))
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (h *Handler) loadTemplate(c *gin.Context) (*storage.ExtractionTemplate, *extract.Schema, bool) {
+	template, err := h.Storage.GetExtractionTemplate(c.Param("id"), c.GetString("user_id"))
+	if errors.Is(err, storage.ErrTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	schema, err := extract.ParseSchema([]byte(template.Schema))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	return template, schema, true
+}
@@ -3,6 +3,8 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -12,10 +14,19 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
 	"github.com/markbates/goth/gothic"
+	"github.com/sdrshn-nmbr/tusk/internal/agent"
 	"github.com/sdrshn-nmbr/tusk/internal/ai"
-	// "github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/extract"
+	"github.com/sdrshn-nmbr/tusk/internal/memory"
+	"github.com/sdrshn-nmbr/tusk/internal/middleware"
+	"github.com/sdrshn-nmbr/tusk/internal/prompts"
+	"github.com/sdrshn-nmbr/tusk/internal/retrieval"
 	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"github.com/sdrshn-nmbr/tusk/internal/summarize"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // type Handler struct {
@@ -26,24 +37,74 @@ import (
 // }
 
 type Handler struct {
-	Storage  *storage.MongoStorage
-	Embedder *ai.Embedder
-	Model    *ai.Model
-	tmpl     *template.Template
+	Storage    Store
+	Embedder   *ai.Embedder
+	Model      Model
+	Retriever  *retrieval.Retriever
+	Budgeter   *ai.Budgeter
+	Memory     *memory.Memory
+	Summarizer *summarize.Summarizer
+	Extractor  *extract.Extractor
+	Agent      *agent.Agent
+	Prompts    *prompts.Library
+	Sessions   sessions.Store
+	cfg        *config.Config
+	tmpl       *template.Template
 }
 
 type FileInfo struct {
-	Name string
-	Size string
+	ID         string
+	Name       string
+	Title      string
+	Abstract   string
+	Size       string
+	Folder     string
+	Tags       []string
+	Attributes []AttributeInfo
 }
 
-func NewHandler(storage *storage.MongoStorage, embedder *ai.Embedder, model *ai.Model, tmpl *template.Template) *Handler {
-	return &Handler{
+// NewHandler sizes retrieval, prompts, memory and background work from cfg
+// and keeps sign-in sessions in cookies signed with its session secret.
+// Prompts are written from the templates in storage. The reranker, query
+// transformation and memory mode are set by the caller.
+func NewHandler(cfg *config.Config, storage Store, embedder *ai.Embedder, model Model, tmpl *template.Template) *Handler {
+	summarizer := summarize.New(model)
+	summarizer.BatchTokens = cfg.Jobs.SummaryBatchTokens
+	summarizer.Concurrency = cfg.Jobs.SummaryConcurrency
+
+	h := &Handler{
 		Storage:  storage,
 		Embedder: embedder,
 		Model:    model,
-		tmpl:     tmpl,
+		Retriever: &retrieval.Retriever{
+			Searcher:      storage,
+			NumCandidates: cfg.Retrieval.NumCandidates,
+			Candidates:    cfg.Retrieval.Candidates,
+			Limit:         cfg.Retrieval.ContextChunks,
+		},
+		Budgeter: &ai.Budgeter{
+			Counter:         ai.Estimator{},
+			MaxPromptTokens: cfg.Chat.MaxPromptTokens,
+			HistoryShare:    cfg.Chat.HistoryShare,
+		},
+		Memory:     &memory.Memory{Mode: memory.Window, Window: cfg.Chat.MemoryWindow, Store: storage},
+		Summarizer: summarizer,
+		Extractor:  extract.New(model, 4*cfg.Chat.MaxPromptTokens),
+		Prompts:    prompts.New(storage),
+		Sessions:   middleware.NewSessionStore(cfg.Server),
+		cfg:        cfg,
+		tmpl:       tmpl,
+	}
+
+	backend := agentBackend{Store: storage, h: h}
+	h.Agent = &agent.Agent{
+		Model:       model,
+		Tools:       agent.NewTools(backend),
+		Permissions: backend,
+		MaxSteps:    cfg.Agent.MaxSteps,
+		ToolTimeout: cfg.Agent.ToolTimeout.Duration,
 	}
+	return h
 }
 
 func (h *Handler) Index(c *gin.Context) {
@@ -60,7 +121,7 @@ func (h *Handler) UploadFile(c *gin.Context) {
 	file, err := c.FormFile("file")
 	if err != nil {
 		log.Printf("Error getting file from form: %+v", err)
-		h.handleError(c, http.StatusInternalServerError, err)
+		h.handleError(c, http.StatusBadRequest, err)
 		return
 	}
 
@@ -85,14 +146,10 @@ func (h *Handler) UploadFile(c *gin.Context) {
 	// Create a new io.Reader from the buffer
 	reader := bytes.NewReader(buf.Bytes())
 
-	err = h.Storage.SaveFile(file.Filename, reader, h.Embedder, userID)
+	_, err = h.Storage.SaveFile(file.Filename, reader, h.Embedder, userID)
 	if err != nil {
-		if err.Error() == "file is not a PDF" {
-			h.handleError(c, http.StatusBadRequest, err)
-		} else {
-			log.Printf("Error saving file: %+v", err)
-			h.handleError(c, http.StatusInternalServerError, err)
-		}
+		log.Printf("Error saving file: %+v", err)
+		h.handleError(c, ingestStatus(err), err)
 		return
 	}
 
@@ -131,54 +188,153 @@ func (h *Handler) DownloadFile(c *gin.Context) {
 
 func (h *Handler) GenerateSearch(c *gin.Context) {
 	userID := c.GetString("user_id")
-	query := c.Query("q")
-	ctx := c.Request.Context()
 
-	embedding, err := h.Embedder.GenerateEmbedding(query)
+	filter, err := documentFilterFromQuery(c)
 	if err != nil {
-		log.Printf("Failed to generate embedding: %+v", err)
-		h.handleError(c, http.StatusInternalServerError, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": filterError(err).Error()})
 		return
 	}
+	for _, id := range c.QueryArray("document") {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document id"})
+			return
+		}
+		filter.DocumentIDs = append(filter.DocumentIDs, objectID)
+	}
 
-	chunks, err := h.Storage.VectorSearch(embedding, 500, 5, userID)
-	if err != nil {
-		log.Printf("Failed to perform vector search: %+v", err)
+	answer, err := h.chat(c.Request.Context(), userID, c.Query("q"), c.Query("conversation"), filter, nil, nil)
+	switch {
+	case errors.Is(err, storage.ErrConversationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return
+	case errors.Is(err, errGenerate):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate response"})
+		return
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		c.JSON(http.StatusRequestTimeout, gin.H{"error": "Request timed out"})
+		return
+	case err != nil:
 		h.handleError(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	chunkStr := new(bytes.Buffer)
-	for _, chunk := range chunks {
-		// fmt.Fprintf(chunkStr, "Document %d: \n%s\n\n", i, chunk.Content)
-		fmt.Fprintf(chunkStr, "\n%s\n\n", chunk.Content)
+	c.JSON(http.StatusOK, gin.H{
+		"query":           answer.Query,
+		"rewritten":       answer.Rewritten,
+		"conversation":    answer.Conversation,
+		"whole_documents": answer.WholeDocuments,
+		"results":         answer.Answer,
+		"usage": gin.H{
+			"prompt_tokens":     answer.Usage.PromptTokens,
+			"completion_tokens": answer.Usage.CompletionTokens,
+			"total_tokens":      answer.Usage.TotalTokens,
+			"context_chunks":    answer.ContextChunks,
+			"history_messages":  answer.HistoryMessages,
+		},
+	})
+}
+
+// chatAnswer is a chat answer with what went into its prompt.
+type chatAnswer struct {
+	Query           string
+	Rewritten       string
+	Conversation    string
+	WholeDocuments  bool
+	Answer          string
+	Usage           ai.Usage
+	ContextChunks   int
+	HistoryMessages int
+}
+
+// errGenerate reports that the model failed while answering.
+var errGenerate = errors.New("failed to generate response")
+
+// chat answers query from the user's documents matching filter and saves the
+// turn to the conversation, starting a new one when conversationID is empty.
+// selected picks prompt templates by kind for the conversation from now on.
+// onToken, when set, receives the answer as it is generated.
+func (h *Handler) chat(ctx context.Context, userID, query, conversationID string, filter storage.DocumentFilter, selected map[string]string, onToken func(string)) (*chatAnswer, error) {
+	// Follow-up questions are rewritten using the earlier turns of the
+	// conversation; a search without one starts a new conversation once it
+	// has an answer to save
+	conv, summary, history, err := h.loadConversation(conversationID, userID)
+	if err != nil {
+		return nil, err
 	}
+	if len(selected) > 0 {
+		// Prompts are selected for a conversation, so it is needed now
+		if conversationID == "" {
+			if conversationID, err = h.Storage.CreateConversation(userID); err != nil {
+				return nil, err
+			}
+		}
+		if err := h.Storage.SetConversationPrompts(conversationID, userID, selected); err != nil {
+			log.Printf("Failed to select prompts: %+v", err)
+			return nil, err
+		}
+	}
+	templates := h.chatPrompts(userID, query, conv.Prompts, selected)
 
-	// queryandchunks := fmt.Sprintf("%s\n Query: %s", chunkStr.String(), query)
+	parts := ai.PromptParts{
+		System:  templates.system(),
+		Summary: summary,
+		Query:   query,
+		History: history,
+	}
 
-	// cfg, err := config.NewConfig()
-	// if err != nil {
-	// 	log.Printf("Failed to load config: %+v", err)
-	// 	h.handleError(c, http.StatusInternalServerError, err)
-	// 	return
-	// }
+	// Chat pinned to documents uses them whole when they fit, and otherwise
+	// searches only their chunks
+	var plan ai.PromptPlan
+	wholeDocuments := false
+	if len(filter.DocumentIDs) > 0 {
+		parts, plan, wholeDocuments = h.fitDocuments(ctx, userID, filter.DocumentIDs, parts, templates)
+	}
 
-	// sysPrompt :=
-	// 	`You are an AI assistant that helps users with their queries. Do NOT mention the documents anywhere in your response - make it sound as natural as possible.`
+	rewritten := query
+	if !wholeDocuments {
+		retrieved, err := h.Retriever.Retrieve(ctx, h.Storage.ActiveEmbedder(h.Embedder), retrieval.Request{
+			Query:   query,
+			History: history,
+			UserID:  userID,
+			Filter:  filter,
+		})
+		if err != nil {
+			log.Printf("Failed to retrieve chunks: %+v", err)
+			return nil, err
+		}
+		rewritten = retrieved.Queries[0]
 
-	// model, err := ai.NewModel(cfg, sysPrompt)
-	// if err != nil {
-	// 	log.Printf("Failed to create model: %+v", err)
-	// 	h.handleError(c, http.StatusInternalServerError, err)
-	// 	return
-	// }
-	// defer model.Close()
+		parts = templates.withChunks(parts, retrieved.Chunks)
 
-	// Use the existing Model instance
-	responseChan, errorChan := h.Model.GenerateResponse(ctx, query, nil, chunkStr.String())
+		// Keep the prompt within the token budget, dropping the least
+		// relevant chunks and the oldest turns first
+		plan, err = h.Budgeter.Fit(ctx, parts)
+		if err != nil {
+			log.Printf("Prompt is over budget: %+v", err)
+		}
+		if plan.DroppedChunks > 0 || plan.DroppedTurns > 0 {
+			log.Printf("Prompt budget dropped %d chunks and %d messages", plan.DroppedChunks, plan.DroppedTurns)
+		}
+	}
 
-	// responseChan, errorChan := model.GenerateResponse(ctx, query, nil, chunkStr.String())
-	// responseChan, errorChan := model.GenerateResponsePplx(ctx, query)
+	answer := &chatAnswer{
+		Query:           query,
+		Rewritten:       rewritten,
+		Conversation:    conversationID,
+		WholeDocuments:  wholeDocuments,
+		ContextChunks:   len(plan.Chunks),
+		HistoryMessages: len(plan.History),
+	}
+	respond := func(results string) (*chatAnswer, error) {
+		answer.Conversation = h.saveTurn(conversationID, userID, query, results)
+		log.Printf("Search used %d prompt and %d completion tokens", answer.Usage.PromptTokens, answer.Usage.CompletionTokens)
+		answer.Answer = results
+		return answer, nil
+	}
+
+	// Use the existing Model instance
+	responseChan, errorChan := h.Model.Answer(ctx, parts.System, plan.Messages(), parts.Prompt(plan), &answer.Usage)
 
 	modelResponse := new(bytes.Buffer)
 	timeout := time.After(30 * time.Second)
@@ -187,61 +343,53 @@ func (h *Handler) GenerateSearch(c *gin.Context) {
 		select {
 		case response, ok := <-responseChan:
 			if !ok {
-				// Response channel closed, all data received
-				c.JSON(http.StatusOK, gin.H{
-					"query":   query,
-					"results": modelResponse.String(),
-				})
-				return
+				// Response channel closed, all data received unless the
+				// stream ended with an error, which is sent before
+				if err := <-errorChan; err != nil {
+					log.Printf("Error generating response: %+v", err)
+					return nil, fmt.Errorf("%w: %v", errGenerate, err)
+				}
+				return respond(modelResponse.String())
 			}
 			modelResponse.WriteString(response)
+			if onToken != nil {
+				onToken(response)
+			}
 
 		case err, ok := <-errorChan:
 			if !ok {
 				// Error channel closed without error
 				if modelResponse.Len() == 0 {
-					c.JSON(http.StatusOK, gin.H{
-						"query":   query,
-						"results": "No results found.",
-					})
-				} else {
-					c.JSON(http.StatusOK, gin.H{
-						"query":   query,
-						"results": modelResponse.String(),
-					})
+					return respond("No results found.")
 				}
-				return
+				return respond(modelResponse.String())
 			}
 			log.Printf("Error generating response: %+v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate response"})
-			return
+			return nil, fmt.Errorf("%w: %v", errGenerate, err)
 
 		case <-ctx.Done():
 			log.Printf("Request cancelled by client")
-			c.JSON(http.StatusRequestTimeout, gin.H{"error": "Request timed out"})
-			return
+			return nil, ctx.Err()
 
 		case <-timeout:
 			log.Printf("Request timed out after 30 seconds")
 			if modelResponse.Len() == 0 {
-				c.JSON(http.StatusOK, gin.H{
-					"query":   query,
-					"results": "The request timed out. Please try again.",
-				})
-			} else {
-				c.JSON(http.StatusOK, gin.H{
-					"query":   query,
-					"results": modelResponse.String(),
-				})
+				return respond("The request timed out. Please try again.")
 			}
-			return
+			return respond(modelResponse.String())
 		}
 	}
 }
 
 func (h *Handler) renderFileList(c *gin.Context, templateName string) {
 	userID := c.GetString("user_id")
-	files, err := h.Storage.ListFiles(userID)
+	filter, err := documentFilterFromQuery(c)
+	if err != nil {
+		h.handleError(c, http.StatusBadRequest, filterError(err))
+		return
+	}
+
+	files, err := h.Storage.ListFiles(userID, filter)
 	if err != nil {
 		h.handleError(c, http.StatusInternalServerError, err)
 		return
@@ -249,17 +397,67 @@ func (h *Handler) renderFileList(c *gin.Context, templateName string) {
 
 	var fileInfos []FileInfo
 	for _, file := range files {
-		size, err := h.Storage.GetFileSize(file)
-		if err != nil {
-			h.handleError(c, http.StatusInternalServerError, err)
-			return
-		}
-		fileInfos = append(fileInfos, FileInfo{Name: file, Size: formatFileSize(size)})
+		fileInfos = append(fileInfos, FileInfo{
+			ID:         file.ID.Hex(),
+			Name:       file.Filename,
+			Title:      file.Metadata["title"],
+			Abstract:   file.Metadata["abstract"],
+			Size:       formatFileSize(file.Size()),
+			Folder:     file.Folder,
+			Tags:       file.Tags,
+			Attributes: attributeInfos(file.Attributes),
+		})
 	}
 
 	c.HTML(http.StatusOK, templateName, gin.H{"Files": fileInfos})
 }
 
+// loadConversation continues the conversation with the given id and returns
+// what memory keeps of it. An empty id stands for a new conversation, which
+// saveTurn stores; storage.ErrConversationNotFound is returned for ids that
+// are not the user's.
+func (h *Handler) loadConversation(id string, userID string) (*storage.Conversation, string, []ai.ChatMessage, error) {
+	if id == "" {
+		return &storage.Conversation{UserID: userID}, "", nil, nil
+	}
+	conv, err := h.Storage.GetConversation(id, userID)
+	if err != nil {
+		log.Printf("Failed to load conversation: %+v", err)
+		return nil, "", nil, err
+	}
+	summary, history := h.Memory.Context(conv)
+	return conv, summary, history, nil
+}
+
+// saveTurn appends a question and its answer to the conversation, starting
+// it when conversationID is empty, and lets memory condense it in the
+// background. It returns the conversation's id, or "" when a new one could
+// not be stored.
+func (h *Handler) saveTurn(conversationID, userID, query, answer string) string {
+	if conversationID == "" {
+		id, err := h.Storage.CreateConversation(userID)
+		if err != nil {
+			log.Printf("Failed to start conversation: %+v", err)
+			return ""
+		}
+		conversationID = id
+	}
+	err := h.Storage.AppendMessages(conversationID, userID,
+		ai.ChatMessage{Sender: "user", Content: query},
+		ai.ChatMessage{Sender: "model", Content: answer},
+	)
+	if err != nil {
+		log.Printf("Failed to save conversation: %+v", err)
+		return conversationID
+	}
+	go func() {
+		if err := h.Memory.Update(context.Background(), conversationID, userID); err != nil {
+			log.Printf("Failed to summarize conversation: %+v", err)
+		}
+	}()
+	return conversationID
+}
+
 func (h *Handler) handleError(c *gin.Context, statusCode int, err error) {
 	log.Printf("Error occurred: %+v", err)
 
@@ -311,7 +509,7 @@ func (h *Handler) BeginAuth(c *gin.Context) {
 }
 
 func (h *Handler) Logout(c *gin.Context) {
-	session, _ := gothic.Store.Get(c.Request, "user-session")
+	session, _ := h.Sessions.Get(c.Request, middleware.SessionName)
 	session.Values["user_id"] = nil
 	session.Options.MaxAge = -1
 	err := session.Save(c.Request, c.Writer)
@@ -331,7 +529,19 @@ func (h *Handler) CompleteAuth(c *gin.Context) {
 		})
 		return
 	}
-	session, _ := gothic.Store.Get(c.Request, "user-session")
+	err = h.Storage.RecordLogin(storage.User{ID: user.UserID, Provider: user.Provider, Email: user.Email, Name: user.Name})
+	if errors.Is(err, storage.ErrUserDisabled) {
+		c.HTML(http.StatusForbidden, "error.html", gin.H{
+			"ErrorMessage": "This account is disabled",
+			"StatusCode":   http.StatusForbidden,
+		})
+		return
+	}
+	if err != nil {
+		// Signing in does not depend on the record
+		log.Printf("Error recording login of %s: %+v", user.UserID, err)
+	}
+	session, _ := h.Sessions.Get(c.Request, middleware.SessionName)
 	session.Values["user_id"] = user.UserID
 	session.Save(c.Request, c.Writer)
 	c.Redirect(http.StatusFound, "/")
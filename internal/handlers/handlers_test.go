@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/ai/aitest"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/middleware"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"github.com/sdrshn-nmbr/tusk/internal/storage/memstore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	contract = "Payment terms: invoices are due within thirty days of receipt."
+	menu     = "Lunch menu for Friday: soup and bread."
+)
+
+// testServer serves the web routes as serve does, backed by the in-memory
+// store, the hash embedder and a scripted model.
+type testServer struct {
+	t      *testing.T
+	router *gin.Engine
+	h      *Handler
+	store  *memstore.Store
+	model  *aitest.Model
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	tmpl, err := template.ParseGlob("../../web/templates/*.html")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %+v", err)
+	}
+	cfg := config.Default()
+	cfg.Server.SessionSecret = "test"
+	cfg.Auth.Admins = "admin-1"
+	store := memstore.New()
+	model := &aitest.Model{}
+	h := NewHandler(cfg, store, ai.NewHashEmbedder(256), model, tmpl)
+
+	r := gin.New()
+	r.SetHTMLTemplate(tmpl)
+	h.RegisterRoutes(r, nil)
+
+	return &testServer{t: t, router: r, h: h, store: store, model: model}
+}
+
+// signIn returns the session cookie of userID.
+func (s *testServer) signIn(userID string) *http.Cookie {
+	s.t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := s.h.Sessions.Get(req, middleware.SessionName)
+	session.Values["user_id"] = userID
+	if err := session.Save(req, w); err != nil {
+		s.t.Fatalf("Failed to save session: %+v", err)
+	}
+	return w.Result().Cookies()[0]
+}
+
+func (s *testServer) do(req *http.Request, cookie *http.Cookie) *httptest.ResponseRecorder {
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func (s *testServer) get(path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	return s.do(httptest.NewRequest(http.MethodGet, path, nil), cookie)
+}
+
+func (s *testServer) postJSON(path, body string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return s.do(req, cookie)
+}
+
+func (s *testServer) upload(filename, content string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if filename != "" {
+		part, _ := form.CreateFormFile("file", filename)
+		part.Write([]byte(content))
+	}
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return s.do(req, cookie)
+}
+
+func (s *testServer) search(query, conversation string, cookie *http.Cookie) (*httptest.ResponseRecorder, map[string]any) {
+	s.t.Helper()
+	w := s.get("/generate-search?"+url.Values{"q": {query}, "conversation": {conversation}}.Encode(), cookie)
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		s.t.Fatalf("Expected JSON, got %d %q", w.Code, w.Body.String())
+	}
+	return w, body
+}
+
+func TestAuthRedirects(t *testing.T) {
+	s := newTestServer(t)
+
+	w := s.get("/", nil)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/login" {
+		t.Errorf("Expected a redirect to the login page, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	req := httptest.NewRequest(http.MethodGet, "/files", nil)
+	req.Header.Set("Accept", "application/json")
+	if w := s.do(req, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected scripts to get a 401, got %d", w.Code)
+	}
+	if w := s.get("/login", nil); w.Code != http.StatusOK {
+		t.Errorf("Expected the login page, got %d", w.Code)
+	}
+
+	if w := s.get("/", s.signIn("user-1")); w.Code != http.StatusOK {
+		t.Errorf("Expected the signed in user to see the index, got %d", w.Code)
+	}
+	forged := &http.Cookie{Name: middleware.SessionName, Value: "forged"}
+	if w := s.get("/files", forged); w.Code != http.StatusFound {
+		t.Errorf("Expected a forged session to be redirected, got %d", w.Code)
+	}
+
+	s.store.SetUserDisabled("user-2", true)
+	if w := s.get("/files", s.signIn("user-2")); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a disabled user to be refused, got %d", w.Code)
+	}
+}
+
+func TestUploadListDownloadDelete(t *testing.T) {
+	s := newTestServer(t)
+	alice, bob := s.signIn("user-1"), s.signIn("user-2")
+
+	w := s.upload("terms.txt", contract, alice)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "terms.txt") {
+		t.Fatalf("Expected the file list after uploading, got %d", w.Code)
+	}
+	s.upload("menu.txt", menu, alice)
+
+	w = s.get("/files?sort=name", alice)
+	if body := w.Body.String(); w.Code != http.StatusOK || strings.Index(body, "menu.txt") > strings.Index(body, "terms.txt") {
+		t.Errorf("Expected both files sorted by name, got %d", w.Code)
+	}
+	if w := s.get("/files", bob); strings.Contains(w.Body.String(), "terms.txt") {
+		t.Error("Expected another user not to see the file")
+	}
+
+	w = s.get("/download?filename=terms.txt", alice)
+	if w.Code != http.StatusOK || w.Body.String() != contract || !strings.Contains(w.Header().Get("Content-Disposition"), "terms.txt") {
+		t.Errorf("Expected the file as an attachment, got %d %q", w.Code, w.Body.String())
+	}
+	if w := s.get("/download?filename=terms.txt", bob); w.Code == http.StatusOK {
+		t.Error("Expected another user not to download the file")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/delete", strings.NewReader("filename=terms.txt"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := s.do(req, alice); w.Code != http.StatusOK {
+		t.Fatalf("Expected the file to be deleted, got %d", w.Code)
+	}
+	if w := s.get("/files", alice); strings.Contains(w.Body.String(), "terms.txt") {
+		t.Error("Expected the deleted file to be gone from the list")
+	}
+	w = s.get("/download?filename=terms.txt", alice)
+	if w.Code == http.StatusOK || !strings.Contains(w.Body.String(), "file not found") {
+		t.Errorf("Expected an error page for the deleted file, got %d", w.Code)
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	s := newTestServer(t)
+	cookie := s.signIn("user-1")
+
+	if w := s.upload("", "", cookie); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a file, got %d", w.Code)
+	}
+	w := s.upload("data.xls", "a,b", cookie)
+	if w.Code != http.StatusUnsupportedMediaType || !strings.Contains(w.Body.String(), "unsupported file type") {
+		t.Errorf("Expected 415 for an unsupported file, got %d", w.Code)
+	}
+	if w := s.upload("scan.png", "\x89PNG", cookie); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected images to fail without an image reader, got %d", w.Code)
+	}
+	if w := s.get("/files", cookie); strings.Contains(w.Body.String(), "data.xls") {
+		t.Error("Expected the rejected file not to be stored")
+	}
+}
+
+func TestGenerateSearch(t *testing.T) {
+	s := newTestServer(t)
+	cookie := s.signIn("user-1")
+	s.upload("terms.txt", contract, cookie)
+	s.upload("menu.txt", menu, cookie)
+	s.model.Script = []string{"Within thirty days.", "A late fee applies."}
+
+	w, body := s.search("When are invoices due?", "", cookie)
+	if w.Code != http.StatusOK || body["results"] != "Within thirty days." {
+		t.Fatalf("Expected the scripted answer, got %d %v", w.Code, body)
+	}
+	prompts := s.model.Prompts()
+	if prompt := prompts[len(prompts)-1]; !strings.Contains(prompt, "thirty days of receipt") {
+		t.Errorf("Expected the contract in the prompt, got %q", prompt)
+	}
+
+	conversation, _ := body["conversation"].(string)
+	w, body = s.search("And if I pay late?", conversation, cookie)
+	if body["results"] != "A late fee applies." || body["conversation"] != conversation {
+		t.Errorf("Expected the next scripted answer in the same conversation, got %v", body)
+	}
+	if usage, _ := body["usage"].(map[string]any); usage["history_messages"] != float64(2) {
+		t.Errorf("Expected the first turn in the prompt, got %v", body["usage"])
+	}
+
+	if w := s.get("/generate-search?q=x", nil); w.Code != http.StatusFound {
+		t.Errorf("Expected searching signed out to be redirected, got %d", w.Code)
+	}
+	if w := s.get("/generate-search?q=x&document=nope", cookie); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid document id, got %d", w.Code)
+	}
+
+	// Other users' and unknown conversations are not continued
+	if w, _ := s.search("And if I pay late?", conversation, s.signIn("user-2")); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another user's conversation, got %d", w.Code)
+	}
+	if w, _ := s.search("And if I pay late?", primitive.NewObjectID().Hex(), cookie); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown conversation, got %d", w.Code)
+	}
+	if w := s.postJSON("/api/v1/chat", `{"query":"x","conversation":"nope"}`, cookie); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 from the API for an unknown conversation, got %d", w.Code)
+	}
+}
+
+// countingStore counts the conversations that are started.
+type countingStore struct {
+	Store
+	created int
+}
+
+func (s *countingStore) CreateConversation(userID string) (string, error) {
+	s.created++
+	return s.Store.CreateConversation(userID)
+}
+
+func TestGenerateSearchModelErrors(t *testing.T) {
+	s := newTestServer(t)
+	cookie := s.signIn("user-1")
+	s.upload("terms.txt", contract, cookie)
+	conversation, err := s.store.CreateConversation("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, model := range map[string]*aitest.Model{
+		"before streaming": {Err: errors.New("quota exceeded")},
+		"while streaming":  {StreamErr: errors.New("connection reset")},
+	} {
+		s.h.Model = model
+		w, body := s.search("When are invoices due?", conversation, cookie)
+		if w.Code != http.StatusInternalServerError || body["error"] != "Failed to generate response" {
+			t.Errorf("%s: expected 500, got %d %v", name, w.Code, body)
+		}
+	}
+
+	conv, err := s.store.GetConversation(conversation, "user-1")
+	if err != nil || len(conv.Messages) > 0 {
+		t.Errorf("Expected failed answers not to be saved, got %+v (%v)", conv, err)
+	}
+
+	// A failed first answer starts no conversation
+	store := &countingStore{Store: s.store}
+	s.h.Storage = store
+	s.search("When are invoices due?", "", cookie)
+	if store.created != 0 {
+		t.Errorf("Expected no conversation for a failed answer, got %d", store.created)
+	}
+}
+
+func TestAPIChatStream(t *testing.T) {
+	s := newTestServer(t)
+	cookie := s.signIn("user-1")
+	s.upload("terms.txt", contract, cookie)
+	s.model.Reply = "Invoices are due within thirty days."
+
+	chat := func() string {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/chat", strings.NewReader(`{"query":"When are invoices due?","stream":true}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := s.do(req, cookie)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("Expected an event stream, got %d %q", w.Code, w.Header().Get("Content-Type"))
+		}
+		return w.Body.String()
+	}
+
+	events := chat()
+	if n := strings.Count(events, "event:token"); n != len(aitest.Tokens(s.model.Reply)) {
+		t.Errorf("Expected a token event per word, got %d in %q", n, events)
+	}
+	if !strings.Contains(events, "event:done") || !strings.Contains(events, `"answer":"Invoices are due within thirty days."`) {
+		t.Errorf("Expected the answer in the done event, got %q", events)
+	}
+
+	s.model.StreamErr = errors.New("connection reset")
+	if events := chat(); !strings.Contains(events, "event:error") || strings.Contains(events, "event:done") {
+		t.Errorf("Expected the stream to end with an error, got %q", events)
+	}
+}
+
+func TestAPIStorageErrors(t *testing.T) {
+	s := newTestServer(t)
+	cookie := s.signIn("user-1")
+	if w := s.get("/api/v1/documents/nope/content", cookie); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an invalid document id, got %d %q", w.Code, w.Body.String())
+	}
+
+	missing := primitive.NewObjectID().Hex()
+	tests := []struct {
+		result storage.BulkResult
+		status int
+	}{
+		{s.store.BulkMove([]string{"nope"}, "archive", "user-1")[0], http.StatusNotFound},
+		{s.store.BulkMove([]string{missing}, "archive", "user-1")[0], http.StatusNotFound},
+		{s.store.BulkTag([]string{missing}, nil, "user-1")[0], http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		apiStorageError(c, documentResultError(tt.result))
+		if w.Code != tt.status {
+			t.Errorf("Expected %d for %q, got %d", tt.status, tt.result.Error, w.Code)
+		}
+	}
+}
+
+func TestPromptTemplates(t *testing.T) {
+	s := newTestServer(t)
+	admin, user := s.signIn("admin-1"), s.signIn("user-1")
+	s.store.RecordLogin(storage.User{ID: "user-1", Name: "Ada"})
+	s.upload("terms.txt", contract, user)
+
+	save := func(kind, name, text string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"kind": kind, "name": name, "text": text})
+		return s.postJSON("/admin/prompts", string(body), cookie)
+	}
+	if w := save("system", "brief", "Be brief.", user); w.Code != http.StatusForbidden {
+		t.Errorf("Expected users other than admins to be refused, got %d", w.Code)
+	}
+	if w := save("system", "brief", "Hello {{.Nickname}}", admin); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a template with an unknown variable to be refused, got %d %q", w.Code, w.Body.String())
+	}
+	for _, text := range []string{"Be brief.", "Be brief with {{.UserName}}. Today is {{.Date}}."} {
+		if w := save("system", "brief", text, admin); w.Code != http.StatusCreated {
+			t.Fatalf("Expected the template to be saved, got %d %q", w.Code, w.Body.String())
+		}
+	}
+	save("context", "cited", "{{range .Sources}}[{{.Number}}] {{.Filename}}: {{.Content}}\n{{end}}Question: {{.Query}}", admin)
+
+	w := s.get("/admin/prompts/system/brief", admin)
+	var versions struct{ Versions []storage.PromptTemplate }
+	json.Unmarshal(w.Body.Bytes(), &versions)
+	if len(versions.Versions) != 2 || versions.Versions[0].Version != 2 || versions.Versions[0].CreatedBy != "admin-1" {
+		t.Errorf("Expected both versions, newest first, got %d %q", w.Code, w.Body.String())
+	}
+
+	// Without a selection the built-in prompts apply
+	s.search("When are invoices due?", "", user)
+	if system := s.model.SystemPrompts(); !strings.Contains(system[len(system)-1], "helps users with their queries") {
+		t.Errorf("Expected the built-in system prompt, got %q", system[len(system)-1])
+	}
+
+	// The workspace selects the latest system template
+	if w := s.postJSON("/settings", `{"prompts":{"system":"brief@3"}}`, user); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown version to be refused, got %d", w.Code)
+	}
+	if w := s.postJSON("/settings", `{"prompts":{"system":"brief"}}`, user); w.Code != http.StatusOK {
+		t.Fatalf("Expected the selection to be saved, got %d %q", w.Code, w.Body.String())
+	}
+	_, body := s.search("When are invoices due?", "", user)
+	system := s.model.SystemPrompts()
+	if got := system[len(system)-1]; !strings.HasPrefix(got, "Be brief with Ada. Today is ") {
+		t.Errorf("Expected the selected template with the user's name, got %q", got)
+	}
+
+	// A conversation selects its own, which lasts for its later messages
+	conversation, _ := body["conversation"].(string)
+	request, _ := json.Marshal(map[string]any{
+		"query":        "When are invoices due?",
+		"conversation": conversation,
+		"prompts":      map[string]string{"system": "brief@1", "context": "cited"},
+	})
+	if w := s.postJSON("/api/v1/chat", string(request), user); w.Code != http.StatusOK {
+		t.Fatalf("Expected an answer, got %d %q", w.Code, w.Body.String())
+	}
+	s.search("And if I pay late?", conversation, user)
+	system, prompts := s.model.SystemPrompts(), s.model.Prompts()
+	if got := system[len(system)-1]; got != "Be brief." {
+		t.Errorf("Expected the conversation's pinned version, got %q", got)
+	}
+	if got := prompts[len(prompts)-1]; !strings.HasPrefix(got, "[1] terms.txt: Payment terms") || !strings.HasSuffix(got, "Question: And if I pay late?") {
+		t.Errorf("Expected the sources laid out by the conversation's template, got %q", got)
+	}
+
+	if w := s.postJSON("/api/v1/chat", `{"query":"x","prompts":{"ocr":"default"}}`, user); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected the OCR template not to be selectable, got %d", w.Code)
+	}
+	if w := s.postJSON("/api/v1/chat", `{"query":"x","agent":true,"prompts":{"system":"brief"}}`, user); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected prompts to be refused with the agent, got %d", w.Code)
+	}
+	w = s.get("/api/v1/prompts", user)
+	var listed struct{ Data []storage.PromptTemplate }
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	if len(listed.Data) != 2 || listed.Data[0].Name != "cited" || listed.Data[1].Version != 2 {
+		t.Errorf("Expected the latest version of both templates, got %q", w.Body.String())
+	}
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/sdrshn-nmbr/tusk/internal/extract"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// jobProcessor returns the function that processes one document of job, or
+// an error when the job cannot run, e.g. for an unknown type or template.
+func (h *Handler) jobProcessor(job *storage.Job) (func(ctx context.Context, id string) error, error) {
+	switch job.Type {
+	case storage.JobReindex:
+		return func(ctx context.Context, id string) error {
+			result := h.Storage.BulkReindex([]string{id}, h.Embedder, job.UserID)[0]
+			if !result.OK {
+				return errors.New(result.Error)
+			}
+			return nil
+		}, nil
+
+	case storage.JobSummarize:
+		return func(ctx context.Context, id string) error {
+			doc, err := h.Storage.GetDocument(id, job.UserID)
+			if err != nil {
+				return err
+			}
+			// A summary job regenerates cached summaries
+			doc.Summary = nil
+			_, err = h.documentSummary(ctx, doc, job.UserID)
+			return err
+		}, nil
+
+	case storage.JobExtract:
+		template, err := h.Storage.GetExtractionTemplate(job.TemplateID, job.UserID)
+		if err != nil {
+			return nil, err
+		}
+		schema, err := extract.ParseSchema([]byte(template.Schema))
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, id string) error {
+			doc, err := h.Storage.GetDocument(id, job.UserID)
+			if err != nil {
+				return err
+			}
+			result := h.extractDocument(ctx, job.UserID, template, schema, *doc)
+			if result.Error != "" {
+				return errors.New(result.Error)
+			}
+			if !result.Valid {
+				return fmt.Errorf("invalid extraction: %s", strings.Join(result.Errors, "; "))
+			}
+			return nil
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown job type %q", job.Type)
+	}
+}
+
+// runJob processes every document of the job and records the results as
+// they finish. It is meant to run in its own goroutine.
+func (h *Handler) runJob(job *storage.Job, process func(ctx context.Context, id string) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.cfg.Jobs.Timeout.Duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, h.cfg.Jobs.Concurrency)
+	for _, id := range job.DocumentIDs {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(id string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			err := process(ctx, id)
+			if err != nil {
+				log.Printf("Job %s failed on %s: %+v", job.ID.Hex(), id, err)
+			}
+			h.Storage.AddJobResult(job.ID, storage.NewBulkResult(id, err))
+		}(id)
+	}
+	wg.Wait()
+
+	h.Storage.FinishJob(job.ID, ctx.Err())
+}
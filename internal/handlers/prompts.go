@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/prompts"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// chatPrompts writes the prompts of a chat turn with the templates the
+// conversation selected, or else the workspace.
+type chatPrompts struct {
+	library *prompts.Library
+	refs    map[string]string
+	data    prompts.Data
+}
+
+// chatPrompts looks up the templates and variables of userID's turn. The
+// selections are applied in order, the later ones overriding.
+func (h *Handler) chatPrompts(userID, query string, selections ...map[string]string) chatPrompts {
+	settings, err := h.Storage.GetWorkspaceSettings(userID)
+	if err != nil {
+		log.Printf("Failed to load the workspace prompts: %+v", err)
+	}
+	refs := maps.Clone(settings.Prompts)
+	if refs == nil {
+		refs = map[string]string{}
+	}
+	for _, selected := range selections {
+		for kind, ref := range selected {
+			if ref == "" {
+				delete(refs, kind)
+			} else {
+				refs[kind] = ref
+			}
+		}
+	}
+
+	data := prompts.Data{Query: query, Date: time.Now().Format(prompts.DateLayout)}
+	user, err := h.Storage.GetUser(userID)
+	switch {
+	case err == nil:
+		data.UserName = user.Name
+	case !errors.Is(err, storage.ErrUserNotFound):
+		log.Printf("Failed to load the user's name: %+v", err)
+	}
+	return chatPrompts{library: h.Prompts, refs: refs, data: data}
+}
+
+func (p chatPrompts) system() string {
+	return p.library.Render(prompts.System, p.refs[prompts.System], p.data)
+}
+
+// withChunks gives parts the chunks as sources, laid out by the context
+// template.
+func (p chatPrompts) withChunks(parts ai.PromptParts, chunks []storage.Chunk) ai.PromptParts {
+	data := p.data
+	data.Sources = make([]prompts.Source, len(chunks))
+	parts.Chunks = make([]string, len(chunks))
+	for i, chunk := range chunks {
+		data.Sources[i] = prompts.Source{Filename: chunk.Filename, Content: chunk.Content, Score: chunk.Score}
+		parts.Chunks[i] = chunk.Content
+	}
+	parts.Layout = p.library.Layout(p.refs[prompts.Context], data)
+	return parts
+}
+
+// checkPrompts validates a selection of templates by kind. An empty
+// reference clears the selection of its kind.
+func (h *Handler) checkPrompts(selected map[string]string) error {
+	for kind, ref := range selected {
+		if ref == "" {
+			if !slices.Contains(prompts.Selectable, kind) {
+				return fmt.Errorf("%w %q", prompts.ErrUnknownKind, kind)
+			}
+			continue
+		}
+		if err := h.Prompts.Check(kind, ref); err != nil {
+			if errors.Is(err, storage.ErrPromptTemplateNotFound) {
+				return fmt.Errorf("no %s prompt template %q", kind, ref)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// ListPromptTemplates lists the latest version of every prompt template, or
+// of one kind with ?kind=, along with the built-in templates they replace.
+func (h *Handler) ListPromptTemplates(c *gin.Context) {
+	kind := c.Query("kind")
+	if kind != "" && !slices.Contains(prompts.Kinds, kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown prompt kind: " + kind})
+		return
+	}
+	templates, err := h.Storage.ListPromptTemplates(kind)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	builtin := map[string]string{}
+	for _, k := range prompts.Kinds {
+		if kind == "" || kind == k {
+			builtin[k] = prompts.Builtin(k)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates, "builtin": builtin})
+}
+
+// PromptTemplateVersions lists every version of a template, newest first.
+func (h *Handler) PromptTemplateVersions(c *gin.Context) {
+	versions, err := h.Storage.PromptTemplateVersions(c.Param("kind"), c.Param("name"))
+	if errors.Is(err, storage.ErrPromptTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// SavePromptTemplate saves the next version of a template. Templates that
+// do not parse, or fail with sample variables, are refused. Saving one
+// named "default" changes the prompt of everyone who selected no other.
+func (h *Handler) SavePromptTemplate(c *gin.Context) {
+	var request struct {
+		Kind        string `json:"kind"`
+		Name        string `json:"name"`
+		Text        string `json:"text"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if !prompts.ValidName(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A template name has up to 64 letters, digits, dots, dashes and underscores"})
+		return
+	}
+	if _, err := prompts.Parse(request.Kind, request.Text); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template: " + err.Error()})
+		return
+	}
+
+	template, err := h.Storage.SavePromptTemplate(storage.PromptTemplate{
+		Kind:        request.Kind,
+		Name:        name,
+		Text:        request.Text,
+		Description: strings.TrimSpace(request.Description),
+		CreatedBy:   c.GetString("user_id"),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("User %s saved the %s prompt template %s@%d", template.CreatedBy, template.Kind, template.Name, template.Version)
+	c.JSON(http.StatusCreated, gin.H{"template": template})
+}
+
+// APIListPrompts lists the prompt templates chats may select, the latest
+// version of each. The default of a kind is used when none is selected,
+// even if it is not listed.
+func (h *Handler) APIListPrompts(c *gin.Context) {
+	templates, err := h.Storage.ListPromptTemplates("")
+	if err != nil {
+		apiStorageError(c, err)
+		return
+	}
+	selectable := []storage.PromptTemplate{}
+	for _, template := range templates {
+		if slices.Contains(prompts.Selectable, template.Kind) {
+			selectable = append(selectable, template)
+		}
+	}
+	apiData(c, http.StatusOK, selectable)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/middleware"
+)
+
+// RegisterRoutes sets up the pages and endpoints of the web app and the JSON
+// API, see RegisterAPIRoutes for spec. The server adds its health checks,
+// OAuth providers and static files; the tests use these routes as they are.
+func (h *Handler) RegisterRoutes(r *gin.Engine, spec []byte) {
+	// Sign-in is checked on every page, and refused to disabled users
+	auth := middleware.AuthRequired(h.Sessions, h.Storage)
+
+	r.GET("/", auth, h.Index)
+	r.GET("/login", func(c *gin.Context) {
+		session, _ := h.Sessions.Get(c.Request, middleware.SessionName)
+		if session.Values["user_id"] != nil {
+			c.Redirect(http.StatusFound, "/")
+			return
+		}
+		h.Login(c)
+	})
+
+	// Auth routes
+	r.GET("/auth/:provider", h.BeginAuth)
+	r.GET("/auth/:provider/callback", h.CompleteAuth)
+	r.GET("/logout", h.Logout)
+
+	// Use middleware for protected routes
+	r.POST("/upload", auth, h.UploadFile)
+	r.POST("/delete", auth, h.DeleteFile)
+	r.GET("/files", auth, h.GetFileList)
+	r.GET("/download", auth, h.DownloadFile)
+	r.GET("/generate-search", auth, h.GenerateSearch)
+
+	// Tags, typed metadata and summaries of documents
+	docs := r.Group("/documents/:id", auth)
+	docs.POST("/tags", h.SetTags)
+	docs.DELETE("/tags/:tag", h.RemoveTag)
+	docs.POST("/attributes", h.SetAttribute)
+	docs.DELETE("/attributes/:key", h.RemoveAttribute)
+	docs.POST("/summary", h.SummarizeDocument)
+
+	// Bulk operations on selected documents
+	bulk := r.Group("/bulk", auth)
+	bulk.POST("/delete", h.BulkDelete)
+	bulk.POST("/move", h.BulkMove)
+	bulk.POST("/tag", h.BulkTag)
+	bulk.POST("/reindex", h.BulkReindex)
+	bulk.POST("/download", h.BulkDownload)
+
+	// Structured extraction with JSON Schema templates
+	extractions := r.Group("/extractions/templates", auth)
+	extractions.GET("", h.ListExtractionTemplates)
+	extractions.POST("", h.CreateExtractionTemplate)
+	extractions.DELETE("/:id", h.DeleteExtractionTemplate)
+	extractions.POST("/:id/run", h.RunExtraction)
+	extractions.GET("/:id/results", h.ExportExtractions)
+
+	// Chat with the tool-calling agent
+	r.GET("/agent", auth, h.AgentChat)
+
+	// Per-user switches for optional features
+	r.GET("/settings", auth, h.GetSettings)
+	r.POST("/settings", auth, h.SaveSettings)
+
+	// Prompt templates, edited by the users listed in auth.admins
+	admin := r.Group("/admin/prompts", auth, middleware.AdminRequired(h.cfg.Auth.AdminIDs()))
+	admin.GET("", h.ListPromptTemplates)
+	admin.POST("", h.SavePromptTemplate)
+	admin.GET("/:kind/:name", h.PromptTemplateVersions)
+
+	// Personal API tokens, managed only from a signed in session
+	r.GET("/settings/tokens", auth, h.TokensPage)
+	tokens := r.Group("/tokens", auth)
+	tokens.GET("", h.ListAPITokens)
+	tokens.POST("", h.CreateAPIToken)
+	tokens.DELETE("/:id", h.RevokeAPIToken)
+
+	// Resumable (tus) uploads
+	uploads := r.Group("/uploads", auth)
+	uploads.OPTIONS("", h.TusOptions)
+	uploads.POST("", h.TusCreate)
+	uploads.HEAD("/:id", h.TusHead)
+	uploads.PATCH("/:id", h.TusPatch)
+	uploads.DELETE("/:id", h.TusDelete)
+
+	// JSON API, documented in api/openapi.json
+	h.RegisterAPIRoutes(r, spec)
+	r.NoRoute(APINotFound)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/agent"
+)
+
+func (h *Handler) GetSettings(c *gin.Context) {
+	settings, err := h.Storage.GetWorkspaceSettings(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// SaveSettings updates the settings given in the request and keeps the rest.
+func (h *Handler) SaveSettings(c *gin.Context) {
+	settings, err := h.Storage.GetWorkspaceSettings(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request struct {
+		Enrichment    *bool              `json:"enrichment" form:"enrichment"`
+		DisabledTools *[]string          `json:"disabled_tools" form:"disabled_tools"`
+		Prompts       *map[string]string `json:"prompts"`
+	}
+	if err := c.ShouldBind(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if request.Enrichment != nil {
+		settings.Enrichment = *request.Enrichment
+	}
+	if request.DisabledTools != nil {
+		for _, name := range *request.DisabledTools {
+			if !slices.Contains(agent.ToolNames, name) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown tool: " + name})
+				return
+			}
+		}
+		settings.DisabledTools = *request.DisabledTools
+	}
+	if request.Prompts != nil {
+		if err := h.checkPrompts(*request.Prompts); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		settings.Prompts = map[string]string{}
+		for kind, ref := range *request.Prompts {
+			if ref != "" {
+				settings.Prompts[kind] = ref
+			}
+		}
+	}
+
+	if err := h.Storage.SaveWorkspaceSettings(settings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Store is the storage the handlers work with, see storage.MongoStorage and,
+// for tests, memstore.Store.
+type Store interface {
+	// Documents
+	SaveFile(filename string, content io.Reader, embedder *ai.Embedder, userID string) (*storage.Document, error)
+	GetFile(filename string, userID string) ([]byte, error)
+	DeleteFileFunc(filename string, userID string) error
+	ListFiles(userID string, filter storage.DocumentFilter) ([]storage.Document, error)
+	ListFilesPage(userID string, filter storage.DocumentFilter, offset, limit int64) ([]storage.Document, int64, error)
+	Folders(userID string) ([]storage.FolderInfo, error)
+	GetDocument(id string, userID string) (*storage.Document, error)
+	GetDocuments(ids []string, userID string) ([]storage.Document, []storage.BulkResult)
+	DocumentChunks(ids []primitive.ObjectID, userID string) ([]storage.Chunk, error)
+	SaveDocumentSummary(id string, userID string, summary storage.DocumentSummary) error
+
+	// Metadata and bulk operations
+	SetTags(id string, tags []string, userID string) error
+	RemoveTag(id string, tag string, userID string) error
+	SetAttribute(id string, key string, value interface{}, userID string) error
+	RemoveAttribute(id string, key string, userID string) error
+	BulkDelete(ids []string, userID string) []storage.BulkResult
+	BulkMove(ids []string, folder string, userID string) []storage.BulkResult
+	BulkTag(ids []string, tags []string, userID string) []storage.BulkResult
+	BulkReindex(ids []string, embedder *ai.Embedder, userID string) []storage.BulkResult
+
+	// Search
+	VectorSearch(queryVector []float32, numCandidates, limit int, userID string, filter storage.DocumentFilter) ([]storage.Chunk, error)
+	ActiveEmbedder(base *ai.Embedder) *ai.Embedder
+
+	// Resumable uploads
+	CreateUpload(userID, filename string, length int64, metadata map[string]string) (*storage.Upload, error)
+	GetUpload(id string, userID string) (*storage.Upload, error)
+	WriteUploadPart(id string, userID string, offset int64, data []byte) (*storage.Upload, error)
+	OpenUpload(id string, userID string) (io.ReadCloser, error)
+	StartUploadIngest(id string, userID string) (*storage.Upload, error)
+	FinishUploadIngest(id string, userID string, documentID primitive.ObjectID, ingestErr error) error
+	DeleteUpload(id string, userID string) error
+
+	// Conversations
+	CreateConversation(userID string) (string, error)
+	GetConversation(id string, userID string) (*storage.Conversation, error)
+	AppendMessages(id string, userID string, messages ...ai.ChatMessage) error
+	SaveConversationSummary(id string, userID string, summary string, from, summarized int) (bool, error)
+	SetConversationPrompts(id string, userID string, prompts map[string]string) error
+
+	// Workspace settings
+	GetWorkspaceSettings(userID string) (storage.WorkspaceSettings, error)
+	SaveWorkspaceSettings(settings storage.WorkspaceSettings) error
+
+	// Structured extraction
+	CreateExtractionTemplate(userID, name, schema string) (*storage.ExtractionTemplate, error)
+	ListExtractionTemplates(userID string) ([]storage.ExtractionTemplate, error)
+	GetExtractionTemplate(id string, userID string) (*storage.ExtractionTemplate, error)
+	DeleteExtractionTemplate(id string, userID string) error
+	SaveExtraction(extraction storage.Extraction) error
+	ListExtractions(templateID primitive.ObjectID, userID string) ([]storage.Extraction, error)
+
+	// Jobs
+	CreateJob(job *storage.Job) error
+	AddJobResult(id primitive.ObjectID, result storage.BulkResult) error
+	FinishJob(id primitive.ObjectID, jobErr error) error
+	GetJob(id string, userID string) (*storage.Job, error)
+	ListJobs(userID string, offset, limit int64) ([]storage.Job, int64, error)
+
+	// API tokens
+	CreateAPIToken(userID, name string, scopes []string, expiresAt *time.Time) (*storage.APIToken, string, error)
+	ListAPITokens(userID string) ([]storage.APIToken, error)
+	RevokeAPIToken(id string, userID string) error
+	AuthenticateToken(secret string) (string, []string, error)
+
+	// Prompt templates
+	SavePromptTemplate(template storage.PromptTemplate) (*storage.PromptTemplate, error)
+	GetPromptTemplate(kind, name string, version int) (*storage.PromptTemplate, error)
+	ListPromptTemplates(kind string) ([]storage.PromptTemplate, error)
+	PromptTemplateVersions(kind, name string) ([]storage.PromptTemplate, error)
+
+	// Users
+	RecordLogin(user storage.User) error
+	GetUser(userID string) (*storage.User, error)
+	UserDisabled(userID string) (bool, error)
+}
+
+// Model is the language model the handlers answer with, see ai.Model.
+type Model interface {
+	ai.ToolCaller
+	Generate(ctx context.Context, prompt string) (string, error)
+	GenerateJSON(ctx context.Context, prompt string, schema *genai.Schema) (string, error)
+	Answer(ctx context.Context, system string, history []ai.ChatMessage, prompt string, usage *ai.Usage) (<-chan string, <-chan error)
+	GenerateResponse(ctx context.Context, query string, imgData []byte, chunks ...string) (<-chan string, <-chan error)
+	GetHistory() []ai.ChatMessage
+}
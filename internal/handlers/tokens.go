@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// TokensPage is the settings page where users manage their API tokens.
+func (h *Handler) TokensPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "tokens.html", gin.H{"Scopes": storage.Scopes})
+}
+
+func (h *Handler) ListAPITokens(c *gin.Context) {
+	tokens, err := h.Storage.ListAPITokens(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// CreateAPIToken creates a token and returns its secret, which is shown
+// only this once. Without expires_in_days the token lasts the configured
+// maximum; it only lives forever with never_expires.
+func (h *Handler) CreateAPIToken(c *gin.Context) {
+	var request struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+		NeverExpires  bool     `json:"never_expires"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A token needs a name"})
+		return
+	}
+	if len(request.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pick at least one scope"})
+		return
+	}
+	var scopes []string
+	for _, scope := range request.Scopes {
+		if !slices.Contains(storage.Scopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + scope})
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	var expiresAt *time.Time
+	if !request.NeverExpires {
+		// The configured maximum bounds the expiry users can pick
+		maxDays := h.cfg.API.MaxTokenLifetimeDays
+		days := request.ExpiresInDays
+		if days == 0 {
+			days = maxDays
+		}
+		if days < 0 || days > maxDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expires_in_days must be between 1 and %d", maxDays)})
+			return
+		}
+		t := time.Now().AddDate(0, 0, days)
+		expiresAt = &t
+	} else if request.ExpiresInDays != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pick either expires_in_days or never_expires"})
+		return
+	}
+
+	token, secret, err := h.Storage.CreateAPIToken(c.GetString("user_id"), name, scopes, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": token, "secret": secret})
+}
+
+func (h *Handler) RevokeAPIToken(c *gin.Context) {
+	err := h.Storage.RevokeAPIToken(c.Param("id"), c.GetString("user_id"))
+	if errors.Is(err, storage.ErrTokenNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// Resumable uploads follow the tus 1.0.0 core protocol with the creation and
+// termination extensions (https://tus.io/protocols/resumable-upload).
+//
+// A complete upload is ingested by the PATCH that completes it. HEAD reports
+// how that went in Upload-Ingest ("ingesting", "done" or "failed") and
+// Upload-Ingest-Error, and an empty PATCH at the full length ingests an
+// upload whose ingestion failed again.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+)
+
+func (h *Handler) TusOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(storage.MaxUploadSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) TusCreate(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+	userID := c.GetString("user_id")
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		tusError(c, http.StatusBadRequest, errors.New("invalid Upload-Length header"))
+		return
+	}
+	if length > storage.MaxUploadSize {
+		tusError(c, http.StatusRequestEntityTooLarge, storage.ErrUploadTooLarge)
+		return
+	}
+
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		tusError(c, http.StatusBadRequest, err)
+		return
+	}
+	filename := filepath.Base(metadata["filename"])
+	if filename == "." || filename == string(filepath.Separator) {
+		tusError(c, http.StatusBadRequest, errors.New("filename is missing from Upload-Metadata"))
+		return
+	}
+
+	upload, err := h.Storage.CreateUpload(userID, filename, length, metadata)
+	if err != nil {
+		tusError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Header("Location", "/uploads/"+upload.ID.Hex())
+	c.Header("Tus-Resumable", tusVersion)
+	c.Status(http.StatusCreated)
+}
+
+func (h *Handler) TusHead(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+	userID := c.GetString("user_id")
+
+	upload, err := h.Storage.GetUpload(c.Param("id"), userID)
+	if err != nil {
+		tusStorageError(c, err)
+		return
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	setIngestHeaders(c, upload)
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+func (h *Handler) TusPatch(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+	userID := c.GetString("user_id")
+	id := c.Param("id")
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		tusError(c, http.StatusUnsupportedMediaType, errors.New("Content-Type must be application/offset+octet-stream"))
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		tusError(c, http.StatusBadRequest, errors.New("invalid Upload-Offset header"))
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, storage.MaxUploadPartSize+1))
+	if err != nil {
+		log.Printf("Error reading upload part: %+v", err)
+		tusError(c, http.StatusBadRequest, err)
+		return
+	}
+	if len(data) > storage.MaxUploadPartSize {
+		tusError(c, http.StatusRequestEntityTooLarge, fmt.Errorf("parts may not exceed %d bytes", storage.MaxUploadPartSize))
+		return
+	}
+
+	upload, err := h.Storage.WriteUploadPart(id, userID, offset, data)
+	if err != nil {
+		tusStorageError(c, err)
+		return
+	}
+
+	if upload.Complete() {
+		ingested, err := h.ingestUpload(id, userID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUploadIngesting) {
+				tusStorageError(c, err)
+				return
+			}
+			log.Printf("Error ingesting upload %s: %+v", id, err)
+			tusError(c, ingestStatus(err), err)
+			return
+		}
+		setIngestHeaders(c, ingested)
+		c.Header("HX-Trigger", "fileListChanged")
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) TusDelete(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+	userID := c.GetString("user_id")
+
+	if err := h.Storage.DeleteUpload(c.Param("id"), userID); err != nil {
+		tusStorageError(c, err)
+		return
+	}
+
+	c.Header("Tus-Resumable", tusVersion)
+	c.Status(http.StatusNoContent)
+}
+
+// ingestUpload hands a completed upload to the regular ingestion path and
+// records the outcome on the upload, so that a client resuming it can tell
+// whether the file was saved. Uploads ingested before are left alone.
+func (h *Handler) ingestUpload(id string, userID string) (*storage.Upload, error) {
+	upload, err := h.Storage.StartUploadIngest(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Ingest == storage.UploadIngested {
+		return upload, nil
+	}
+
+	doc, err := h.saveUpload(id, upload, userID)
+	if err != nil {
+		upload.Ingest, upload.IngestError = storage.UploadFailed, err.Error()
+	} else {
+		upload.Ingest, upload.DocumentID = storage.UploadIngested, doc.ID
+	}
+	if finishErr := h.Storage.FinishUploadIngest(id, userID, upload.DocumentID, err); finishErr != nil {
+		log.Printf("Error recording the ingestion of upload %s: %+v", id, finishErr)
+	}
+	return upload, err
+}
+
+func (h *Handler) saveUpload(id string, upload *storage.Upload, userID string) (*storage.Document, error) {
+	content, err := h.Storage.OpenUpload(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return h.Storage.SaveFile(upload.Filename, content, h.Embedder, userID)
+}
+
+func setIngestHeaders(c *gin.Context, upload *storage.Upload) {
+	if upload.Ingest != "" {
+		c.Header("Upload-Ingest", upload.Ingest)
+	}
+	if upload.Ingest == storage.UploadFailed {
+		c.Header("Upload-Ingest-Error", upload.IngestError)
+	}
+}
+
+func checkTusResumable(c *gin.Context) bool {
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.String(http.StatusPreconditionFailed, "unsupported tus version")
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+func tusStorageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, storage.ErrUploadNotFound):
+		tusError(c, http.StatusNotFound, err)
+	case errors.Is(err, storage.ErrUploadOffsetMismatch):
+		tusError(c, http.StatusConflict, err)
+	case errors.Is(err, storage.ErrUploadTooLarge):
+		tusError(c, http.StatusRequestEntityTooLarge, err)
+	case errors.Is(err, storage.ErrUploadIngesting):
+		tusError(c, http.StatusLocked, err)
+	default:
+		tusError(c, http.StatusInternalServerError, err)
+	}
+}
+
+// tusError answers in plain text because tus clients are not browsers
+// rendering error.html.
+func tusError(c *gin.Context, statusCode int, err error) {
+	log.Printf("Upload error: %+v", err)
+	c.Header("Tus-Resumable", tusVersion)
+	c.String(statusCode, err.Error())
+}
+
+func ingestStatus(err error) int {
+	if errors.Is(err, storage.ErrUnsupportedFileType) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusInternalServerError
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header, a comma separated
+// list of "key base64(value)" pairs where the value may be omitted.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value for %q", fields[0])
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid Upload-Metadata pair %q", pair)
+		}
+	}
+
+	return metadata, nil
+}
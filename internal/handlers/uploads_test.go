@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+func TestParseUploadMetadata(t *testing.T) {
+	metadata, err := parseUploadMetadata("filename cmVwb3J0LnBkZg==,is_confidential, filetype YXBwbGljYXRpb24vcGRm")
+	if err != nil {
+		t.Fatalf("parseUploadMetadata failed: %+v", err)
+	}
+
+	expected := map[string]string{
+		"filename":        "report.pdf",
+		"is_confidential": "",
+		"filetype":        "application/pdf",
+	}
+	if len(metadata) != len(expected) {
+		t.Fatalf("Expected %d entries, got %d: %+v", len(expected), len(metadata), metadata)
+	}
+	for key, value := range expected {
+		if metadata[key] != value {
+			t.Errorf("Expected %s=%q, got %q", key, value, metadata[key])
+		}
+	}
+
+	if _, err := parseUploadMetadata("filename not-base64!"); err == nil {
+		t.Error("Expected an error for an invalid base64 value")
+	}
+	if _, err := parseUploadMetadata("filename a b"); err == nil {
+		t.Error("Expected an error for a malformed pair")
+	}
+}
+
+func TestResumableUploadIngest(t *testing.T) {
+	s := newTestServer(t)
+	cookie := s.signIn("user-1")
+
+	tus := func(method, path string, header map[string]string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Tus-Resumable", tusVersion)
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		return s.do(req, cookie)
+	}
+	create := func(filename string, length int) string {
+		w := tus(http.MethodPost, "/uploads", map[string]string{
+			"Upload-Length":   strconv.Itoa(length),
+			"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)),
+		}, "")
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected the upload to be created, got %d %q", w.Code, w.Body.String())
+		}
+		return w.Header().Get("Location")
+	}
+	patch := func(url string, offset int, body string) *httptest.ResponseRecorder {
+		return tus(http.MethodPatch, url, map[string]string{
+			"Upload-Offset": strconv.Itoa(offset),
+			"Content-Type":  "application/offset+octet-stream",
+		}, body)
+	}
+	ingestState := func(url string) string {
+		w := tus(http.MethodHead, url, nil, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected HEAD to find the upload, got %d", w.Code)
+		}
+		return w.Header().Get("Upload-Ingest")
+	}
+
+	url := create("terms.txt", len(contract))
+	if w := patch(url, 0, contract[:10]); w.Code != http.StatusNoContent || w.Header().Get("Upload-Ingest") != "" {
+		t.Fatalf("Expected a partial upload not to be ingested, got %d %q", w.Code, w.Header().Get("Upload-Ingest"))
+	}
+	if w := patch(url, 10, contract[10:]); w.Code != http.StatusNoContent || w.Header().Get("Upload-Ingest") != storage.UploadIngested {
+		t.Fatalf("Expected the complete upload to be ingested, got %d %q", w.Code, w.Body.String())
+	}
+	if state := ingestState(url); state != storage.UploadIngested {
+		t.Errorf("Expected a resumed upload to report it was ingested, got %q", state)
+	}
+	// Finishing again does not save the file twice
+	if w := patch(url, len(contract), ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected finishing an ingested upload to succeed, got %d", w.Code)
+	}
+	if docs, _ := s.store.ListFiles("user-1", storage.DocumentFilter{}); len(docs) != 1 {
+		t.Errorf("Expected one document, got %d", len(docs))
+	}
+
+	// A failed ingestion is reported and retried, never taken for done
+	url = create("tool.exe", 4)
+	if w := patch(url, 0, "MZ\x90\x00"); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Expected the ingestion to fail, got %d", w.Code)
+	}
+	w := tus(http.MethodHead, url, nil, "")
+	if w.Header().Get("Upload-Ingest") != storage.UploadFailed || w.Header().Get("Upload-Ingest-Error") == "" {
+		t.Errorf("Expected the failure to be recorded, got %q %q", w.Header().Get("Upload-Ingest"), w.Header().Get("Upload-Ingest-Error"))
+	}
+	if w := patch(url, 4, ""); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected finishing the upload to ingest it again, got %d", w.Code)
+	}
+}
@@ -0,0 +1,145 @@
+// Package memory decides which part of a conversation is sent to the model
+// with each new message.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// Memory modes.
+const (
+	// Full sends the whole stored conversation, trimmed only by the prompt
+	// budget.
+	Full = "full"
+	// Window sends the last Window messages.
+	Window = "window"
+	// Summary sends a running summary of older messages plus the last
+	// Window messages.
+	Summary = "summary"
+)
+
+// Completer answers a single prompt, see ai.Model.Generate.
+type Completer interface {
+	Generate(ctx context.Context, prompt string) (string, error)
+}
+
+// Store loads conversations and saves their summaries.
+type Store interface {
+	GetConversation(id string, userID string) (*storage.Conversation, error)
+	SaveConversationSummary(id string, userID string, summary string, from, summarized int) (bool, error)
+}
+
+// Memory applies one of the modes to stored conversations.
+type Memory struct {
+	Mode   string
+	Window int
+
+	// Threshold is how many unsummarized messages a conversation may have in
+	// summary mode before the older ones are condensed.
+	Threshold int
+
+	Model Completer
+	Store Store
+}
+
+// New validates the mode and returns a Memory for it.
+func New(mode string, window, threshold int, model Completer, store Store) (*Memory, error) {
+	switch mode {
+	case Full, Window, Summary:
+	case "":
+		mode = Summary
+	default:
+		return nil, fmt.Errorf("unknown memory mode %q, expected full, window or summary", mode)
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("the memory window must be positive")
+	}
+	if threshold < window {
+		threshold = window
+	}
+	return &Memory{Mode: mode, Window: window, Threshold: threshold, Model: model, Store: store}, nil
+}
+
+// Context returns the summary, if any, and the messages to send with the
+// next message of conv.
+func (m *Memory) Context(conv *storage.Conversation) (string, []ai.ChatMessage) {
+	switch m.Mode {
+	case Full:
+		return "", conv.Messages
+	case Window:
+		return "", last(conv.Messages, m.Window)
+	default:
+		return conv.Summary, last(conv.Unsummarized(), m.Window)
+	}
+}
+
+const summaryPrompt = `Update the summary of a conversation between a user and an assistant with the new messages below. Keep facts, names, numbers, decisions and open questions the user may refer back to; drop pleasantries. Reply with the updated summary only, in at most 200 words.
+
+Current summary:
+%s
+
+New messages:
+%s`
+
+// Update condenses the older messages of the conversation into its summary
+// once there are more than Threshold of them, keeping the last Window
+// messages verbatim. It does nothing outside summary mode.
+func (m *Memory) Update(ctx context.Context, id string, userID string) error {
+	if m.Mode != Summary {
+		return nil
+	}
+
+	conv, err := m.Store.GetConversation(id, userID)
+	if err != nil {
+		return err
+	}
+	pending := conv.Unsummarized()
+	if len(pending) <= m.Threshold {
+		return nil
+	}
+
+	fold := pending[:len(pending)-m.Window]
+	var transcript strings.Builder
+	for _, msg := range fold {
+		role := "User"
+		if msg.Sender != "user" {
+			role = "Assistant"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", role, msg.Content)
+	}
+
+	current := conv.Summary
+	if current == "" {
+		current = "(none)"
+	}
+	summary, err := m.Model.Generate(ctx, fmt.Sprintf(summaryPrompt, current, transcript.String()))
+	if err != nil {
+		return err
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return fmt.Errorf("the model returned an empty summary")
+	}
+
+	saved, err := m.Store.SaveConversationSummary(id, userID, summary, conv.SummarizedCount, conv.SummarizedCount+len(fold))
+	if err != nil {
+		return err
+	}
+	if !saved {
+		log.Printf("Conversation %s was summarized concurrently, keeping the other summary", id)
+	}
+	return nil
+}
+
+func last(messages []ai.ChatMessage, n int) []ai.ChatMessage {
+	if len(messages) > n {
+		return messages[len(messages)-n:]
+	}
+	return messages
+}
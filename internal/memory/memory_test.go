@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+type fakeStore struct {
+	conv *storage.Conversation
+}
+
+func (s *fakeStore) GetConversation(id string, userID string) (*storage.Conversation, error) {
+	return s.conv, nil
+}
+
+func (s *fakeStore) SaveConversationSummary(id string, userID string, summary string, from, summarized int) (bool, error) {
+	if s.conv.SummarizedCount != from {
+		return false, nil
+	}
+	s.conv.Summary = summary
+	s.conv.SummarizedCount = summarized
+	return true, nil
+}
+
+type fakeCompleter struct {
+	prompt string
+}
+
+func (f *fakeCompleter) Generate(ctx context.Context, prompt string) (string, error) {
+	f.prompt = prompt
+	return "The user asked about messages 0 to 5.", nil
+}
+
+func TestSummaryModeFoldsOlderMessages(t *testing.T) {
+	conv := &storage.Conversation{}
+	for i := 0; i < 10; i++ {
+		conv.Messages = append(conv.Messages, ai.ChatMessage{Sender: "user", Content: fmt.Sprintf("message %d", i)})
+	}
+	conv.MessageCount = len(conv.Messages)
+
+	model := &fakeCompleter{}
+	m, err := New(Summary, 4, 8, model, &fakeStore{conv: conv})
+	if err != nil {
+		t.Fatalf("New failed: %+v", err)
+	}
+	if err := m.Update(context.Background(), "id", "user"); err != nil {
+		t.Fatalf("Update failed: %+v", err)
+	}
+
+	if !strings.Contains(model.prompt, "message 5") || strings.Contains(model.prompt, "message 6") {
+		t.Errorf("Expected messages 0 to 5 to be summarized, got prompt %q", model.prompt)
+	}
+	summary, history := m.Context(conv)
+	if summary == "" || len(history) != 4 || history[0].Content != "message 6" {
+		t.Errorf("Expected a summary and the last 4 messages, got %q and %+v", summary, history)
+	}
+
+	// Below the threshold nothing more is folded
+	model.prompt = ""
+	if err := m.Update(context.Background(), "id", "user"); err != nil || model.prompt != "" {
+		t.Errorf("Expected no summarization below the threshold, got %q, %v", model.prompt, err)
+	}
+}
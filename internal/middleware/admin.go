@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminRequired lets only the users in admins through. It runs after
+// AuthRequired, which sets the user.
+func AdminRequired(admins []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !slices.Contains(admins, c.GetString("user_id")) {
+			forbidden(c, "Only admins may use this route")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// UserStatus tells whether an operator disabled a user.
+type UserStatus interface {
+	UserDisabled(userID string) (bool, error)
+}
+
+// TokenAuthenticator resolves a personal API token to its user and scopes.
+// An unknown or expired token is storage.ErrInvalidToken; any other error
+// means the token could not be checked.
+type TokenAuthenticator interface {
+	UserStatus
+	AuthenticateToken(secret string) (userID string, scopes []string, err error)
+}
+
+// isDisabled reports whether users says userID is disabled. Errors are
+// logged and let the request through, since every other query of the
+// request would fail as well.
+func isDisabled(users UserStatus, userID string) bool {
+	if users == nil {
+		return false
+	}
+	disabled, err := users.UserDisabled(userID)
+	if err != nil {
+		log.Printf("Error checking whether user %s is disabled: %+v", userID, err)
+		return false
+	}
+	return disabled
+}
+
+// APIAuthRequired authenticates JSON API routes with an
+// "Authorization: Bearer <token>" header or, for the web app, the session
+// cookie of store. Instead of redirecting to the login page it answers 401
+// with an error envelope.
+func APIAuthRequired(store sessions.Store, tokens TokenAuthenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if header := c.GetHeader("Authorization"); header != "" {
+			secret, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				unauthorized(c, "Expected a Bearer token")
+				return
+			}
+			userID, scopes, err := tokens.AuthenticateToken(strings.TrimSpace(secret))
+			if errors.Is(err, storage.ErrInvalidToken) {
+				unauthorized(c, err.Error())
+				return
+			}
+			if err != nil {
+				// Database errors are no reason to sign the client out
+				log.Printf("Error authenticating API token: %+v", err)
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+					"error": gin.H{"code": "unavailable", "message": "The API token could not be checked, try again later"},
+				})
+				return
+			}
+			if isDisabled(tokens, userID) {
+				forbidden(c, "This account is disabled")
+				return
+			}
+			c.Set("user_id", userID)
+			c.Set("token_scopes", scopes)
+			c.Next()
+			return
+		}
+
+		session, err := store.Get(c.Request, SessionName)
+		if err != nil || session.Values["user_id"] == nil {
+			unauthorized(c, "Sign in or send an API token")
+			return
+		}
+		if userID, _ := session.Values["user_id"].(string); isDisabled(tokens, userID) {
+			forbidden(c, "This account is disabled")
+			return
+		}
+		c.Set("user_id", session.Values["user_id"])
+		c.Next()
+	}
+}
+
+// RequireScope rejects requests made with an API token that lacks scope.
+// Requests signed in with the session cookie may do everything.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get("token_scopes")
+		if !ok {
+			c.Next()
+			return
+		}
+		if scopes, _ := value.([]string); !slices.Contains(scopes, scope) {
+			forbidden(c, "The API token lacks the "+scope+" scope")
+			return
+		}
+		c.Next()
+	}
+}
+
+func unauthorized(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"error": gin.H{"code": "unauthorized", "message": message},
+	})
+}
+
+func forbidden(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+		"error": gin.H{"code": "forbidden", "message": message},
+	})
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+type fakeTokens map[string][]string
+
+func (f fakeTokens) AuthenticateToken(secret string) (string, []string, error) {
+	if secret == "tusk_outage" {
+		return "", nil, errors.New("server selection error: connection refused")
+	}
+	scopes, ok := f[secret]
+	if !ok {
+		return "", nil, storage.ErrInvalidToken
+	}
+	if secret == "tusk_disabled" {
+		return "user-2", scopes, nil
+	}
+	return "user-1", scopes, nil
+}
+
+func (f fakeTokens) UserDisabled(userID string) (bool, error) {
+	return userID == "user-2", nil
+}
+
+func TestAPIAuthRequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewSessionStore(config.Server{SessionSecret: "test"})
+
+	r := gin.New()
+	api := r.Group("", APIAuthRequired(store, fakeTokens{"tusk_reader": {"read"}, "tusk_disabled": {"read"}}))
+	api.GET("/read", RequireScope("read"), func(c *gin.Context) { c.String(http.StatusOK, c.GetString("user_id")) })
+	api.POST("/write", RequireScope("write"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		method, path, auth string
+		status             int
+		body               string
+	}{
+		{"GET", "/read", "Bearer tusk_reader", http.StatusOK, "user-1"},
+		{"POST", "/write", "Bearer tusk_reader", http.StatusForbidden, `"forbidden"`},
+		{"GET", "/read", "Bearer tusk_wrong", http.StatusUnauthorized, `"unauthorized"`},
+		{"GET", "/read", "Bearer tusk_disabled", http.StatusForbidden, `"forbidden"`},
+		{"GET", "/read", "Bearer tusk_outage", http.StatusServiceUnavailable, `"unavailable"`},
+		{"GET", "/read", "Basic abc", http.StatusUnauthorized, `"unauthorized"`},
+		{"GET", "/read", "", http.StatusUnauthorized, `"unauthorized"`},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) || strings.Contains(w.Body.String(), "connection refused") {
+			t.Errorf("%s %s with %q: got %d %q, want %d containing %q", tt.method, tt.path, tt.auth, w.Code, w.Body.String(), tt.status, tt.body)
+		}
+	}
+}
+
+func TestAuthRequiredAnswersScriptsWithJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := NewSessionStore(config.Server{SessionSecret: "test"})
+
+	r := gin.New()
+	r.GET("/files", AuthRequired(store, nil), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/files", nil))
+	if w.Code != http.StatusFound {
+		t.Errorf("Expected browsers to be redirected, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/files", nil)
+	req.Header.Set("Authorization", "Bearer tusk_x")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), `"unauthorized"`) {
+		t.Errorf("Expected a 401 JSON error, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -1,18 +1,44 @@
 package middleware
 
 import (
+    "net/http"
+    "strings"
+
     "github.com/gin-gonic/gin"
-    "github.com/markbates/goth/gothic"
+    "github.com/gorilla/sessions"
 )
 
-func AuthRequired() gin.HandlerFunc {
+// AuthRequired lets users signed in to store through and sends everyone
+// else to the login page. Users an operator disabled are refused; users may
+// be nil to skip that check.
+func AuthRequired(store sessions.Store, users UserStatus) gin.HandlerFunc {
     return func(c *gin.Context) {
-        session, err := gothic.Store.Get(c.Request, "user-session")
+        session, err := store.Get(c.Request, SessionName)
         if err != nil || session.Values["user_id"] == nil {
+            // Scripts get a JSON error rather than the login page
+            if c.GetHeader("Authorization") != "" || strings.Contains(c.GetHeader("Accept"), "application/json") {
+                unauthorized(c, "Sign in to use this route; API tokens work with /api/v1")
+                return
+            }
             c.Redirect(302, "/login")
             c.Abort()
             return
         }
+        userID, _ := session.Values["user_id"].(string)
+        if isDisabled(users, userID) {
+            delete(session.Values, "user_id")
+            session.Save(c.Request, c.Writer)
+            if strings.Contains(c.GetHeader("Accept"), "application/json") {
+                forbidden(c, "This account is disabled")
+                return
+            }
+            c.HTML(http.StatusForbidden, "error.html", gin.H{
+                "ErrorMessage": "This account is disabled",
+                "StatusCode":   http.StatusForbidden,
+            })
+            c.Abort()
+            return
+        }
         c.Set("user_id", session.Values["user_id"])
         c.Next()
     }
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/gorilla/sessions"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+)
+
+// SessionName is the cookie session holding the signed in user's ID.
+const SessionName = "user-session"
+
+// NewSessionStore returns the cookie store for sign-in sessions, signed with
+// the configured secret. Cookies are only sent over HTTPS in production.
+func NewSessionStore(cfg config.Server) *sessions.CookieStore {
+	store := sessions.NewCookieStore([]byte(cfg.SessionSecret))
+	store.MaxAge(int(cfg.SessionMaxAge.Seconds()))
+	store.Options.Path = "/"
+	store.Options.HttpOnly = true
+	store.Options.Secure = cfg.Production()
+	return store
+}
@@ -0,0 +1,275 @@
+// Package prompts writes the prompts the assistant is given from
+// text/template templates. Admins save versions of the templates in the
+// database; the built-in templates apply until a "default" one of their
+// kind is saved.
+package prompts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of templates.
+const (
+	// System instructs the model at the start of every chat.
+	System = "system"
+	// Context lays out the retrieved sources and the query of a message.
+	Context = "context"
+	// OCR instructs the model reading an uploaded image.
+	OCR = "ocr"
+)
+
+// Kinds lists every kind of template, and Selectable those a workspace or
+// conversation may choose; images are read with the default OCR template.
+var (
+	Kinds      = []string{System, Context, OCR}
+	Selectable = []string{System, Context}
+)
+
+// DefaultName is the template of a kind used where no other is selected.
+const DefaultName = "default"
+
+// SystemPrompt is the built-in system template.
+const SystemPrompt = `You are an AI assistant that helps users with their queries. Do NOT mention the documents anywhere in your response - make it sound as natural as possible.`
+
+// contextLayout is the built-in context template: every source set off by
+// blank lines, then the query.
+const contextLayout = "{{range .Sources}}\n{{.Content}}\n\n{{end}}Query: {{.Query}}"
+
+var builtins = map[string]*template.Template{
+	System:  template.Must(newTemplate(System).Parse(SystemPrompt)),
+	Context: template.Must(newTemplate(Context).Parse(contextLayout)),
+	OCR:     template.Must(newTemplate(OCR).Parse(storage.OCRPrompt)),
+}
+
+var (
+	ErrUnknownKind = errors.New("unknown prompt kind")
+	ErrInvalidRef  = errors.New("invalid prompt template reference")
+)
+
+// Source is a retrieved chunk as templates see it. Number counts the
+// sources in the prompt from 1.
+type Source struct {
+	Number   int
+	Filename string
+	Content  string
+	Score    float64
+}
+
+// Data are the variables of a template. Sources are only set for context
+// templates, and UserName only when the user's login gave a name.
+type Data struct {
+	Sources  []Source
+	Query    string
+	UserName string
+	// Date is today, such as "Monday, 2 January 2006"
+	Date string
+}
+
+// DateLayout formats Data.Date.
+const DateLayout = "Monday, 2 January 2006"
+
+// sample is what Parse tries a template with.
+var sample = Data{
+	Sources:  []Source{{Number: 1, Filename: "notes.txt", Content: "Invoices are due within thirty days.", Score: 0.9}},
+	Query:    "When are invoices due?",
+	UserName: "Ada",
+	Date:     time.Now().Format(DateLayout),
+}
+
+func newTemplate(kind string) *template.Template {
+	return template.New(kind).Option("missingkey=error")
+}
+
+// Builtin returns the text of the built-in template of kind.
+func Builtin(kind string) string {
+	switch kind {
+	case System:
+		return SystemPrompt
+	case Context:
+		return contextLayout
+	case OCR:
+		return storage.OCRPrompt
+	}
+	return ""
+}
+
+// Parse parses the text of a template of kind and tries it with sample
+// data, so that templates referring to unknown variables are refused when
+// they are saved rather than when a user chats.
+func Parse(kind, text string) (*template.Template, error) {
+	if !slices.Contains(Kinds, kind) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKind, kind)
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("the template is empty")
+	}
+	tmpl, err := newTemplate(kind).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// ValidName reports whether name may name a template: up to 64 letters,
+// digits, dots, dashes and underscores.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// ParseRef splits a reference to a template into its name and version:
+// "concise" is the latest version of the template named concise and
+// "concise@2" its second version. An empty reference is the latest default.
+func ParseRef(ref string) (string, int, error) {
+	if ref == "" {
+		return DefaultName, 0, nil
+	}
+	name, version, pinned := strings.Cut(ref, "@")
+	if !ValidName(name) {
+		return "", 0, fmt.Errorf("%w %q", ErrInvalidRef, ref)
+	}
+	if !pinned {
+		return name, 0, nil
+	}
+	n, err := strconv.Atoi(version)
+	if err != nil || n < 1 {
+		return "", 0, fmt.Errorf("%w %q: the version must be a positive number", ErrInvalidRef, ref)
+	}
+	return name, n, nil
+}
+
+// Store keeps the versions of the templates, see storage.MongoStorage.
+type Store interface {
+	GetPromptTemplate(kind, name string, version int) (*storage.PromptTemplate, error)
+}
+
+// Library resolves references to templates in Store and renders them.
+// Parsed templates are cached, since versions never change.
+type Library struct {
+	Store Store
+
+	mu     sync.Mutex
+	parsed map[primitive.ObjectID]*template.Template
+}
+
+func New(store Store) *Library {
+	return &Library{Store: store, parsed: make(map[primitive.ObjectID]*template.Template)}
+}
+
+// Resolve returns the template of kind that ref selects, with its stored
+// version. The default resolves to the built-in template, and a nil
+// version, as long as no default was saved.
+func (l *Library) Resolve(kind, ref string) (*template.Template, *storage.PromptTemplate, error) {
+	if !slices.Contains(Kinds, kind) {
+		return nil, nil, fmt.Errorf("%w %q", ErrUnknownKind, kind)
+	}
+	name, version, err := ParseRef(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	stored, err := l.Store.GetPromptTemplate(kind, name, version)
+	if errors.Is(err, storage.ErrPromptTemplateNotFound) && name == DefaultName && version == 0 {
+		return builtins[kind], nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if tmpl, ok := l.parsed[stored.ID]; ok {
+		return tmpl, stored, nil
+	}
+	tmpl, err := newTemplate(kind).Parse(stored.Text)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s template %s@%d: %w", kind, stored.Name, stored.Version, err)
+	}
+	l.parsed[stored.ID] = tmpl
+	return tmpl, stored, nil
+}
+
+// Check tells whether ref may be selected for kind by a workspace or a
+// conversation.
+func (l *Library) Check(kind, ref string) error {
+	if !slices.Contains(Selectable, kind) {
+		return fmt.Errorf("%w %q", ErrUnknownKind, kind)
+	}
+	_, _, err := l.Resolve(kind, ref)
+	return err
+}
+
+// template resolves ref, falling back to the default template and then to
+// the built-in one, so that a chat still works when a selected template was
+// lost or the database is unreachable.
+func (l *Library) template(kind, ref string) *template.Template {
+	tmpl, _, err := l.Resolve(kind, ref)
+	if err == nil {
+		return tmpl
+	}
+	log.Printf("Failed to load the %s prompt %q: %+v", kind, ref, err)
+	if ref != "" {
+		if tmpl, _, err := l.Resolve(kind, ""); err == nil {
+			return tmpl
+		}
+	}
+	return builtins[kind]
+}
+
+// execute renders tmpl, or the built-in template of kind when tmpl fails.
+func execute(kind string, tmpl *template.Template, data Data) string {
+	if data.Date == "" {
+		data.Date = time.Now().Format(DateLayout)
+	}
+	var b strings.Builder
+	err := tmpl.Execute(&b, data)
+	if err == nil {
+		return b.String()
+	}
+	log.Printf("Failed to render the %s prompt: %+v", kind, err)
+	b.Reset()
+	builtins[kind].Execute(&b, data)
+	return b.String()
+}
+
+// Render writes the prompt of kind that ref selects.
+func (l *Library) Render(kind, ref string, data Data) string {
+	return execute(kind, l.template(kind, ref), data)
+}
+
+// Layout returns an ai.PromptParts layout that renders the context template
+// ref selects with the sources a prompt plan kept. data.Sources holds every
+// candidate source, in the order of PromptParts.Chunks.
+func (l *Library) Layout(ref string, data Data) func(kept []int) string {
+	tmpl := l.template(Context, ref)
+	return func(kept []int) string {
+		d := data
+		d.Sources = make([]Source, len(kept))
+		for i, index := range kept {
+			d.Sources[i] = data.Sources[index]
+			d.Sources[i].Number = i + 1
+		}
+		return execute(Context, tmpl, d)
+	}
+}
+
+// OCRPrompt renders the default OCR template, see storage.OCRPrompter.
+func (l *Library) OCRPrompt() string {
+	return l.Render(OCR, "", Data{})
+}
@@ -0,0 +1,112 @@
+package prompts
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"github.com/sdrshn-nmbr/tusk/internal/storage/memstore"
+)
+
+func TestParse(t *testing.T) {
+	for _, kind := range Kinds {
+		if _, err := Parse(kind, Builtin(kind)); err != nil {
+			t.Errorf("Expected the built-in %s template to parse, got %+v", kind, err)
+		}
+	}
+
+	tests := []struct {
+		kind, text string
+		err        string
+	}{
+		{Context, "{{range .Sources}}{{.Number}}. {{.Filename}} ({{.Score}})\n{{.Content}}\n{{end}}{{.Query}}", ""},
+		{System, "Hello {{.UserName}}, it is {{.Date}}.", ""},
+		{System, "Hello {{.Name}}", "can't evaluate field Name"},
+		{System, "Hello {{.UserName", "unclosed action"},
+		{System, "  ", "empty"},
+		{"greeting", "Hello", "unknown prompt kind"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.kind, tt.text)
+		if tt.err == "" && err != nil {
+			t.Errorf("Parse(%q, %q) failed: %+v", tt.kind, tt.text, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("Parse(%q, %q) = %v, expected an error containing %q", tt.kind, tt.text, err, tt.err)
+		}
+	}
+}
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		ref     string
+		name    string
+		version int
+		valid   bool
+	}{
+		{"", DefaultName, 0, true},
+		{"concise", "concise", 0, true},
+		{"concise@2", "concise", 2, true},
+		{"concise@0", "", 0, false},
+		{"concise@latest", "", 0, false},
+		{"two words", "", 0, false},
+		{"@2", "", 0, false},
+	}
+	for _, tt := range tests {
+		name, version, err := ParseRef(tt.ref)
+		if (err == nil) != tt.valid || name != tt.name || version != tt.version {
+			t.Errorf("ParseRef(%q) = %q, %d, %v", tt.ref, name, version, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidRef) {
+			t.Errorf("Expected ErrInvalidRef for %q, got %v", tt.ref, err)
+		}
+	}
+}
+
+func TestLibrary(t *testing.T) {
+	store := memstore.New()
+	l := New(store)
+	data := Data{Query: "When are invoices due?", UserName: "Ada", Date: "Monday, 2 January 2006"}
+
+	if got := l.Render(System, "", data); got != SystemPrompt {
+		t.Errorf("Expected the built-in system prompt before any was saved, got %q", got)
+	}
+	if err := l.Check(System, "brief"); !errors.Is(err, storage.ErrPromptTemplateNotFound) {
+		t.Errorf("Expected an unknown template to be refused, got %v", err)
+	}
+	if err := l.Check(OCR, ""); !errors.Is(err, ErrUnknownKind) {
+		t.Errorf("Expected the OCR template not to be selectable, got %v", err)
+	}
+
+	for _, text := range []string{"Be brief, {{.UserName}}.", "Be very brief, {{.UserName}}."} {
+		store.SavePromptTemplate(storage.PromptTemplate{Kind: System, Name: "brief", Text: text})
+	}
+	store.SavePromptTemplate(storage.PromptTemplate{Kind: System, Name: DefaultName, Text: "Today is {{.Date}}."})
+	for ref, expected := range map[string]string{
+		"brief":   "Be very brief, Ada.",
+		"brief@1": "Be brief, Ada.",
+		"":        "Today is Monday, 2 January 2006.",
+		// Lost templates fall back to the default
+		"brief@7": "Today is Monday, 2 January 2006.",
+	} {
+		if got := l.Render(System, ref, data); got != expected {
+			t.Errorf("Render(%q) = %q, expected %q", ref, got, expected)
+		}
+	}
+
+	// The layout numbers the sources a plan kept
+	data.Sources = []Source{
+		{Filename: "terms.txt", Content: "Invoices are due within thirty days."},
+		{Filename: "menu.txt", Content: "Soup and bread."},
+		{Filename: "fees.txt", Content: "Late payments cost 2%."},
+	}
+	store.SavePromptTemplate(storage.PromptTemplate{Kind: Context, Name: "cited", Text: "{{range .Sources}}[{{.Number}}] {{.Filename}}\n{{end}}{{.Query}}"})
+	layout := l.Layout("cited", data)
+	if got := layout([]int{0, 2}); got != "[1] terms.txt\n[2] fees.txt\nWhen are invoices due?" {
+		t.Errorf("Unexpected layout %q", got)
+	}
+	if got := l.Layout("", data)([]int{1}); got != "\nSoup and bread.\n\nQuery: When are invoices due?" {
+		t.Errorf("Expected the built-in layout to join sources as before, got %q", got)
+	}
+}
@@ -0,0 +1,86 @@
+package retrieval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// CrossEncoderReranker scores chunks with a cross-encoder model served over
+// HTTP, using the /rerank API of Hugging Face text-embeddings-inference:
+//
+//	POST {"query": "...", "texts": ["...", ...]}
+//	-> [{"index": 0, "score": 0.93}, ...]
+type CrossEncoderReranker struct {
+	URL    string
+	Client *http.Client
+}
+
+type crossEncoderRequest struct {
+	Query string   `json:"query"`
+	Texts []string `json:"texts"`
+}
+
+type crossEncoderScore struct {
+	Index int     `json:"index"`
+	Score float64 `json:"score"`
+}
+
+func (r *CrossEncoderReranker) Rerank(ctx context.Context, query string, chunks []storage.Chunk, limit int) ([]storage.Chunk, error) {
+	if len(chunks) == 0 {
+		return chunks, nil
+	}
+
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Content
+	}
+	body, err := json.Marshal(crossEncoderRequest{Query: query, Texts: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling cross-encoder: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cross-encoder returned status %d", resp.StatusCode)
+	}
+
+	var scores []crossEncoderScore
+	if err := json.NewDecoder(resp.Body).Decode(&scores); err != nil {
+		return nil, fmt.Errorf("error decoding cross-encoder response: %w", err)
+	}
+
+	scored := make([]storage.Chunk, len(chunks))
+	copy(scored, chunks)
+	for i := range scored {
+		scored[i].Score = 0
+	}
+	for _, s := range scores {
+		if s.Index < 0 || s.Index >= len(scored) {
+			return nil, fmt.Errorf("cross-encoder returned unknown index %d", s.Index)
+		}
+		scored[s.Index].Score = s.Score
+	}
+
+	return truncate(sortByScore(scored), limit), nil
+}
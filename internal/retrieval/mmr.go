@@ -0,0 +1,90 @@
+package retrieval
+
+import (
+	"context"
+	"math"
+
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// DefaultMMRLambda leans towards relevance while still pushing out
+// near-duplicates from overlapping chunk windows.
+const DefaultMMRLambda = 0.7
+
+// MMRReranker picks chunks by Maximal Marginal Relevance: each pick is the
+// chunk with the best trade-off between its score and its similarity to the
+// chunks already picked. Relevance is the score from the previous stage,
+// normally the vector search score.
+type MMRReranker struct {
+	// Lambda weighs relevance against diversity, from 0 (only diversity) to
+	// 1 (only relevance).
+	Lambda float64
+}
+
+func (r *MMRReranker) Rerank(ctx context.Context, query string, chunks []storage.Chunk, limit int) ([]storage.Chunk, error) {
+	if limit < 0 || limit > len(chunks) {
+		limit = len(chunks)
+	}
+
+	// Normalise scores to 0..1 so they are comparable with cosine similarity
+	minScore, maxScore := math.Inf(1), math.Inf(-1)
+	for _, chunk := range chunks {
+		minScore = math.Min(minScore, chunk.Score)
+		maxScore = math.Max(maxScore, chunk.Score)
+	}
+	relevance := make([]float64, len(chunks))
+	for i, chunk := range chunks {
+		if maxScore > minScore {
+			relevance[i] = (chunk.Score - minScore) / (maxScore - minScore)
+		} else {
+			relevance[i] = 1
+		}
+	}
+
+	picked := make([]storage.Chunk, 0, limit)
+	used := make([]bool, len(chunks))
+	// maxSim[i] is the highest similarity of chunk i to any picked chunk
+	maxSim := make([]float64, len(chunks))
+
+	for len(picked) < limit {
+		best, bestScore := -1, math.Inf(-1)
+		for i := range chunks {
+			if used[i] {
+				continue
+			}
+			score := r.Lambda*relevance[i] - (1-r.Lambda)*maxSim[i]
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		used[best] = true
+		chunk := chunks[best]
+		chunk.Score = bestScore
+		picked = append(picked, chunk)
+
+		for i := range chunks {
+			if !used[i] {
+				maxSim[i] = math.Max(maxSim[i], cosine(chunks[i].Embedding, chunks[best].Embedding))
+			}
+		}
+	}
+
+	return picked, ctx.Err()
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,159 @@
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+)
+
+// QueryTransformer turns the user's message into the queries to retrieve
+// for: a standalone rewrite that resolves references to earlier turns, and
+// optionally paraphrases and a hypothetical answer (HyDE), which tends to be
+// closer to the wording of the documents than the question is.
+type QueryTransformer struct {
+	Model Completer
+
+	// HistoryTurns is how many recent messages are shown to the rewriter.
+	HistoryTurns int
+
+	// Paraphrases is the number of extra phrasings to retrieve for.
+	Paraphrases int
+
+	// HyDE adds a hypothetical answer passage as a query.
+	HyDE bool
+}
+
+const rewritePrompt = `Rewrite the last user message as a standalone search query that can be understood without the conversation. Resolve pronouns and references like "the second one" using the conversation. Keep it short and reply with the query only.
+
+Conversation:
+%s
+Last user message: %s`
+
+const paraphrasePrompt = `Write %d different phrasings of this search query, using other words where possible. Reply with one phrasing per line and nothing else.
+
+Query: %s`
+
+const hydePrompt = `Write a short passage, as it might appear in a document, that answers the question below. Do not mention that it is hypothetical.
+
+Question: %s`
+
+// Transform returns the queries to retrieve for, the standalone query first.
+// A failing model call is logged and skipped, so retrieval always has at
+// least the original message to work with.
+func (t *QueryTransformer) Transform(ctx context.Context, query string, history []ai.ChatMessage) []string {
+	standalone := query
+	if recent := lastMessages(history, t.HistoryTurns); len(recent) > 0 {
+		rewritten, err := t.Model.Generate(ctx, fmt.Sprintf(rewritePrompt, formatHistory(recent), query))
+		if err != nil {
+			log.Printf("Error rewriting query: %+v", err)
+		} else if rewritten = cleanLine(rewritten); rewritten != "" {
+			standalone = rewritten
+		}
+	}
+
+	// Each goroutine writes its own variable, read after wg.Wait
+	var (
+		wg      sync.WaitGroup
+		extra   []string
+		passage string
+	)
+	if t.Paraphrases > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply, err := t.Model.Generate(ctx, fmt.Sprintf(paraphrasePrompt, t.Paraphrases, standalone))
+			if err != nil {
+				log.Printf("Error paraphrasing query: %+v", err)
+				return
+			}
+			extra = splitLines(reply, t.Paraphrases)
+		}()
+	}
+	if t.HyDE {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply, err := t.Model.Generate(ctx, fmt.Sprintf(hydePrompt, standalone))
+			if err != nil {
+				log.Printf("Error generating hypothetical answer: %+v", err)
+				return
+			}
+			passage = strings.TrimSpace(reply)
+		}()
+	}
+	wg.Wait()
+
+	queries := dedupe(append([]string{standalone}, extra...))
+	if passage != "" {
+		queries = append(queries, passage)
+	}
+	return queries
+}
+
+func lastMessages(history []ai.ChatMessage, n int) []ai.ChatMessage {
+	if n <= 0 {
+		return nil
+	}
+	if len(history) > n {
+		return history[len(history)-n:]
+	}
+	return history
+}
+
+func formatHistory(messages []ai.ChatMessage) string {
+	var b strings.Builder
+	for _, msg := range messages {
+		role := "User"
+		if msg.Sender != "user" {
+			role = "Assistant"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", role, msg.Content)
+	}
+	return b.String()
+}
+
+// cleanLine strips the quotes and labels models like to wrap answers in.
+func cleanLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	for _, prefix := range []string{"Query:", "query:", "Standalone query:"} {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	return strings.Trim(strings.TrimSpace(s), `"'`)
+}
+
+var listMarker = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)])\s*`)
+
+// splitLines returns up to n non-empty lines with list markers removed.
+func splitLines(s string, n int) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = cleanLine(listMarker.ReplaceAllString(line, "")); line != "" {
+			lines = append(lines, line)
+		}
+		if len(lines) == n {
+			break
+		}
+	}
+	return lines
+}
+
+func dedupe(queries []string) []string {
+	seen := make(map[string]bool, len(queries))
+	var unique []string
+	for _, q := range queries {
+		key := strings.ToLower(q)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, q)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,67 @@
+package retrieval
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type promptCompleter func(prompt string) string
+
+func (f promptCompleter) Generate(ctx context.Context, prompt string) (string, error) {
+	return f(prompt), nil
+}
+
+func TestTransformRewritesFollowUps(t *testing.T) {
+	model := promptCompleter(func(prompt string) string {
+		switch {
+		case strings.HasPrefix(prompt, "Rewrite"):
+			if !strings.Contains(prompt, "User: Compare the Acme and Globex contracts") {
+				t.Errorf("Expected the history in the prompt, got %q", prompt)
+			}
+			return `"Globex contract termination clause"`
+		case strings.HasPrefix(prompt, "Write 2"):
+			return "1. Globex contract ending terms\n2. How to terminate the Globex agreement\n"
+		default:
+			return "The Globex agreement can be terminated with 30 days notice."
+		}
+	})
+	history := []ai.ChatMessage{
+		{Sender: "user", Content: "Compare the Acme and Globex contracts"},
+		{Sender: "model", Content: "Both run for two years."},
+	}
+
+	transformer := &QueryTransformer{Model: model, HistoryTurns: 4, Paraphrases: 2, HyDE: true}
+	queries := transformer.Transform(context.Background(), "and how do I end the second one?", history)
+
+	expected := []string{
+		"Globex contract termination clause",
+		"Globex contract ending terms",
+		"How to terminate the Globex agreement",
+		"The Globex agreement can be terminated with 30 days notice.",
+	}
+	if strings.Join(queries, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %q, got %q", expected, queries)
+	}
+
+	// Without history there is nothing to rewrite
+	queries = (&QueryTransformer{Model: model, HistoryTurns: 4}).Transform(context.Background(), "termination", nil)
+	if len(queries) != 1 || queries[0] != "termination" {
+		t.Errorf("Expected the query unchanged, got %q", queries)
+	}
+}
+
+func TestMergeResultsKeepsBestScore(t *testing.T) {
+	a, b := primitive.NewObjectID(), primitive.NewObjectID()
+	merged := mergeResults([][]storage.Chunk{
+		{{ID: a, Score: 0.7}, {ID: b, Score: 0.6}},
+		{{ID: b, Score: 0.9}},
+	})
+	if len(merged) != 2 || merged[0].ID != b || merged[0].Score != 0.9 || merged[1].ID != a {
+		t.Errorf("Unexpected merge: %+v", merged)
+	}
+}
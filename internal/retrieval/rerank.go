@@ -0,0 +1,163 @@
+// Package retrieval turns a user query into the chunks that are passed to the
+// model as context.
+package retrieval
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// Reranker reorders retrieved chunks by relevance to query and returns at
+// most limit of them. Rerankers set Chunk.Score to their own score.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, chunks []storage.Chunk, limit int) ([]storage.Chunk, error)
+}
+
+// Completer answers a single prompt, see ai.Model.Generate.
+type Completer interface {
+	Generate(ctx context.Context, prompt string) (string, error)
+}
+
+// NewReranker builds a reranker from the comma-separated list of stages in
+// cfg.Reranker, such as "cross-encoder,mmr", applied in order. An empty list
+// or "none" keeps the vector search order.
+func NewReranker(cfg config.Retrieval, model Completer) (Reranker, error) {
+	var stages Chain
+	for _, name := range strings.Split(cfg.Reranker, ",") {
+		switch strings.TrimSpace(name) {
+		case "", "none":
+		case "llm":
+			stages = append(stages, &LLMReranker{Model: model, Concurrency: cfg.LLMRerankConcurrency})
+		case "cross-encoder":
+			if cfg.CrossEncoderURL == "" {
+				return nil, fmt.Errorf("the cross-encoder reranker needs CROSS_ENCODER_URL")
+			}
+			stages = append(stages, &CrossEncoderReranker{URL: cfg.CrossEncoderURL})
+		case "mmr":
+			stages = append(stages, &MMRReranker{Lambda: cfg.MMRLambda})
+		default:
+			return nil, fmt.Errorf("unknown reranker %q", name)
+		}
+	}
+	return stages, nil
+}
+
+// Chain runs rerankers one after another. Only the last one cuts the list
+// down to limit, so earlier stages reorder the full candidate set.
+type Chain []Reranker
+
+func (c Chain) Rerank(ctx context.Context, query string, chunks []storage.Chunk, limit int) ([]storage.Chunk, error) {
+	if len(c) == 0 {
+		return truncate(chunks, limit), nil
+	}
+	var err error
+	for i, r := range c {
+		n := len(chunks)
+		if i == len(c)-1 {
+			n = limit
+		}
+		chunks, err = r.Rerank(ctx, query, chunks, n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// LLMReranker asks the chat model to rate each chunk on its own. It is the
+// most expensive option, one request per candidate.
+type LLMReranker struct {
+	Model Completer
+
+	// Concurrency caps the number of scoring requests in flight.
+	Concurrency int
+}
+
+const llmRerankPrompt = `Rate how useful the passage is for answering the question, from 0 (irrelevant) to 10 (answers it directly). Reply with the number only.
+
+Question: %s
+
+Passage:
+%s`
+
+func (r *LLMReranker) Rerank(ctx context.Context, query string, chunks []storage.Chunk, limit int) ([]storage.Chunk, error) {
+	concurrency := r.Concurrency
+	if concurrency <= 0 {
+		concurrency = 8
+	}
+
+	scored := make([]storage.Chunk, len(chunks))
+	copy(scored, chunks)
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for i := range scored {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(chunk *storage.Chunk) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			reply, err := r.Model.Generate(ctx, fmt.Sprintf(llmRerankPrompt, query, chunk.Content))
+			if err != nil {
+				log.Printf("Error scoring chunk: %+v", err)
+				chunk.Score = 0
+				return
+			}
+			chunk.Score = parseScore(reply) / 10
+		}(&scored[i])
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return truncate(sortByScore(scored), limit), nil
+}
+
+// parseScore reads the first number in reply, clamped to 0..10.
+func parseScore(reply string) float64 {
+	fields := strings.FieldsFunc(reply, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r == '.')
+	})
+	for _, field := range fields {
+		if n, err := strconv.ParseFloat(field, 64); err == nil {
+			return clamp(n, 0, 10)
+		}
+	}
+	return 0
+}
+
+func clamp(n, min, max float64) float64 {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// sortByScore orders chunks by descending score, keeping the previous order
+// for ties.
+func sortByScore(chunks []storage.Chunk) []storage.Chunk {
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].Score > chunks[j].Score
+	})
+	return chunks
+}
+
+func truncate(chunks []storage.Chunk, limit int) []storage.Chunk {
+	if limit >= 0 && len(chunks) > limit {
+		return chunks[:limit]
+	}
+	return chunks
+}
@@ -0,0 +1,71 @@
+package retrieval
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+func TestMMRSkipsNearDuplicates(t *testing.T) {
+	chunks := []storage.Chunk{
+		{Content: "a", Embedding: []float32{1, 0}, Score: 0.95},
+		{Content: "a'", Embedding: []float32{0.99, 0.01}, Score: 0.94},
+		{Content: "b", Embedding: []float32{0, 1}, Score: 0.80},
+	}
+
+	got, err := (&MMRReranker{Lambda: 0.5}).Rerank(context.Background(), "q", chunks, 2)
+	if err != nil {
+		t.Fatalf("Rerank failed: %+v", err)
+	}
+	if len(got) != 2 || got[0].Content != "a" || got[1].Content != "b" {
+		t.Errorf("Expected [a b], got %+v", got)
+	}
+}
+
+func TestCrossEncoderReranker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req crossEncoderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Query != "q" || len(req.Texts) != 3 {
+			t.Errorf("Unexpected request: %+v (%v)", req, err)
+		}
+		json.NewEncoder(w).Encode([]crossEncoderScore{{Index: 2, Score: 0.9}, {Index: 0, Score: 0.5}, {Index: 1, Score: 0.1}})
+	}))
+	defer server.Close()
+
+	chunks := []storage.Chunk{{Content: "x"}, {Content: "y"}, {Content: "z"}}
+	got, err := (&CrossEncoderReranker{URL: server.URL}).Rerank(context.Background(), "q", chunks, 2)
+	if err != nil {
+		t.Fatalf("Rerank failed: %+v", err)
+	}
+	if len(got) != 2 || got[0].Content != "z" || got[1].Content != "x" {
+		t.Errorf("Expected [z x], got %+v", got)
+	}
+}
+
+type scoreCompleter map[string]string
+
+func (c scoreCompleter) Generate(ctx context.Context, prompt string) (string, error) {
+	for content, reply := range c {
+		if len(prompt) >= len(content) && prompt[len(prompt)-len(content):] == content {
+			return reply, nil
+		}
+	}
+	return "0", nil
+}
+
+func TestLLMReranker(t *testing.T) {
+	model := scoreCompleter{"first": "Score: 3", "second": "9/10", "third": "7."}
+	chunks := []storage.Chunk{{Content: "first"}, {Content: "second"}, {Content: "third"}}
+
+	got, err := (&LLMReranker{Model: model}).Rerank(context.Background(), "q", chunks, 2)
+	if err != nil {
+		t.Fatalf("Rerank failed: %+v", err)
+	}
+	if len(got) != 2 || got[0].Content != "second" || got[1].Content != "third" {
+		t.Errorf("Expected [second third], got %+v", got)
+	}
+}
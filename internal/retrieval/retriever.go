@@ -0,0 +1,117 @@
+package retrieval
+
+import (
+	"context"
+	"log"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// Searcher runs a filtered vector search, see storage.MongoStorage.
+type Searcher interface {
+	VectorSearch(queryVector []float32, numCandidates, limit int, userID string, filter storage.DocumentFilter) ([]storage.Chunk, error)
+}
+
+// Embedder embeds a batch of texts, see ai.Embedder.
+type Embedder interface {
+	GenerateEmbeddings(texts []string) ([][]float32, error)
+}
+
+// Retriever finds the chunks to answer a query with: it transforms the query,
+// searches for every resulting query, merges the hits and reranks them.
+type Retriever struct {
+	Searcher    Searcher
+	Transformer *QueryTransformer
+	Reranker    Reranker
+
+	// NumCandidates is how many nearest neighbours each vector search
+	// considers, ten times Candidates when zero. Candidates is how many
+	// chunks it returns for the reranker, and Limit how many chunks are kept
+	// in the end.
+	NumCandidates int
+	Candidates    int
+	Limit         int
+}
+
+// Request describes one retrieval for a user.
+type Request struct {
+	Query   string
+	History []ai.ChatMessage
+	UserID  string
+	Filter  storage.DocumentFilter
+}
+
+// Result holds the queries that were searched, the standalone one first, and
+// the chunks to use as context.
+type Result struct {
+	Queries []string
+	Chunks  []storage.Chunk
+}
+
+// Retrieve runs the pipeline. The embedder is passed per call since it
+// follows the active chunk index.
+func (r *Retriever) Retrieve(ctx context.Context, embedder Embedder, req Request) (*Result, error) {
+	queries := []string{req.Query}
+	if r.Transformer != nil {
+		queries = r.Transformer.Transform(ctx, req.Query, req.History)
+	}
+
+	embeddings, err := embedder.GenerateEmbeddings(queries)
+	if err != nil {
+		return nil, err
+	}
+
+	var results [][]storage.Chunk
+	for _, embedding := range embeddings {
+		numCandidates := r.NumCandidates
+		if numCandidates <= 0 {
+			numCandidates = 10 * r.Candidates
+		}
+		chunks, err := r.Searcher.VectorSearch(embedding, numCandidates, r.Candidates, req.UserID, req.Filter)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, chunks)
+	}
+	candidates := mergeResults(results)
+
+	reranker := r.Reranker
+	if reranker == nil {
+		reranker = Chain{}
+	}
+	chunks, err := reranker.Rerank(ctx, queries[0], candidates, r.Limit)
+	if err != nil {
+		// Fall back to the vector search order rather than failing the search
+		log.Printf("Failed to rerank chunks: %+v", err)
+		chunks = truncate(candidates, r.Limit)
+	}
+
+	return &Result{Queries: queries, Chunks: chunks}, nil
+}
+
+// mergeResults combines the hits of several searches, keeping each chunk
+// once with its best score. The scores all come from the same index, so they
+// are comparable across queries.
+func mergeResults(results [][]storage.Chunk) []storage.Chunk {
+	if len(results) == 1 {
+		return results[0]
+	}
+
+	var merged []storage.Chunk
+	index := make(map[string]int)
+	for _, chunks := range results {
+		for _, chunk := range chunks {
+			key := chunk.ID.Hex()
+			if i, ok := index[key]; ok {
+				if chunk.Score > merged[i].Score {
+					merged[i].Score = chunk.Score
+				}
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, chunk)
+		}
+	}
+	return sortByScore(merged)
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const apiTokensCollection = "api_tokens"
+
+// API token scopes. Read covers listing, downloading and searching, write
+// uploading, changing and deleting documents and starting jobs, and chat
+// asking questions.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeChat  = "chat"
+)
+
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeChat}
+
+// tokenPrefix marks Tusk tokens so they are easy to recognize, e.g. by
+// secret scanners.
+const tokenPrefix = "tusk_"
+
+// lastUsedInterval bounds how often a token's last use is written.
+const lastUsedInterval = time.Minute
+
+var (
+	ErrTokenNotFound = errors.New("API token not found")
+	ErrInvalidToken  = errors.New("invalid or expired API token")
+)
+
+// APIToken is a user's personal access token. Only the SHA-256 hash of the
+// secret is stored; Prefix keeps its start so users can tell tokens apart.
+type APIToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     string             `bson:"user_id" json:"-"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	Hash       string             `bson:"hash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+func apiTokenIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	}
+}
+
+// HashToken returns the hash a token is stored and looked up by.
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIToken generates a token and its secret, which is not kept and
+// cannot be shown again.
+func NewAPIToken(userID, name string, scopes []string, expiresAt *time.Time) (APIToken, string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return APIToken{}, "", err
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	token := APIToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    secret[:len(tokenPrefix)+6],
+		Hash:      HashToken(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	return token, secret, nil
+}
+
+// CreateAPIToken stores a new token and returns it with its secret.
+func (ms *MongoStorage) CreateAPIToken(userID, name string, scopes []string, expiresAt *time.Time) (*APIToken, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	token, secret, err := NewAPIToken(userID, name, scopes, expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	coll := ms.client.Database(ms.database).Collection(apiTokensCollection)
+	result, err := coll.InsertOne(ctx, token)
+	if err != nil {
+		log.Printf("Error creating API token: %+v", err)
+		return nil, "", err
+	}
+	token.ID = result.InsertedID.(primitive.ObjectID)
+	return &token, secret, nil
+}
+
+func (ms *MongoStorage) ListAPITokens(userID string) ([]APIToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(apiTokensCollection)
+	cursor, err := coll.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tokens := []APIToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeAPIToken deletes the token, which stops working immediately.
+func (ms *MongoStorage) RevokeAPIToken(id string, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrTokenNotFound
+	}
+	coll := ms.client.Database(ms.database).Collection(apiTokensCollection)
+	result, err := coll.DeleteOne(ctx, bson.M{"_id": objectID, "user_id": userID})
+	if err != nil {
+		log.Printf("Error revoking API token: %+v", err)
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// AuthenticateToken resolves a bearer token to its user and scopes, and
+// records when it was last used.
+func (ms *MongoStorage) AuthenticateToken(secret string) (string, []string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var token APIToken
+	coll := ms.client.Database(ms.database).Collection(apiTokensCollection)
+	err := coll.FindOne(ctx, bson.M{"hash": HashToken(secret)}).Decode(&token)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", nil, ErrInvalidToken
+		}
+		return "", nil, err
+	}
+	now := time.Now()
+	if token.Expired(now) {
+		return "", nil, ErrInvalidToken
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= lastUsedInterval {
+		if _, err := coll.UpdateByID(ctx, token.ID, bson.M{"$set": bson.M{"last_used_at": now}}); err != nil {
+			log.Printf("Error recording API token use: %+v", err)
+		}
+	}
+	return token.UserID, token.Scopes, nil
+}
@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BulkResult reports the outcome of a bulk operation for a single document.
+// Err keeps the error behind Error for errors.Is; it is lost once the result
+// is stored with a job.
+type BulkResult struct {
+	ID    string `json:"id"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	Err   error  `json:"-" bson:"-"`
+}
+
+// NewBulkResult reports err for id, or success when it is nil.
+func NewBulkResult(id string, err error) BulkResult {
+	if err != nil {
+		return BulkResult{ID: id, Error: err.Error(), Err: err}
+	}
+	return BulkResult{ID: id, OK: true}
+}
+
+// MaxBulkItems caps how many documents a single bulk request may touch.
+const MaxBulkItems = 500
+
+var (
+	ErrDocumentNotFound  = errors.New("document not found")
+	ErrInvalidDocumentID = errors.New("invalid document id")
+	ErrNoTags            = errors.New("no tags given")
+)
+
+// BulkDelete removes each document together with its chunks. Every document
+// is deleted in its own transaction so one failure does not undo the rest.
+func (ms *MongoStorage) BulkDelete(ids []string, userID string) []BulkResult {
+	return ms.forEachDocument(ids, 10*time.Second, func(ctx context.Context, id primitive.ObjectID) error {
+		var doc Document
+		err := ms.withTransaction(ctx, func(ctx context.Context) error {
+			docsColl := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+			opts := options.FindOneAndDelete().SetProjection(bson.M{"content_file": 1})
+			err := docsColl.FindOneAndDelete(ctx, bson.M{"_id": id, "user_id": userID}, opts).Decode(&doc)
+			if err == mongo.ErrNoDocuments {
+				return ErrDocumentNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			for _, chunksColl := range ms.writableChunks() {
+				if _, err = chunksColl.DeleteMany(ctx, bson.M{"document_id": id}); err != nil {
+					return err
+				}
+			}
+			extractionsColl := ms.client.Database(ms.database).Collection(extractionsCollection)
+			_, err = extractionsColl.DeleteMany(ctx, bson.M{"document_id": id})
+			return err
+		})
+		if err == nil {
+			ms.deleteContent(doc.ContentFile)
+		}
+		return err
+	})
+}
+
+// BulkMove puts each document into folder. An empty folder moves the
+// documents back to the root.
+func (ms *MongoStorage) BulkMove(ids []string, folder string, userID string) []BulkResult {
+	folder = NormalizeFolder(folder)
+
+	return ms.forEachDocument(ids, 10*time.Second, func(ctx context.Context, id primitive.ObjectID) error {
+		update := bson.M{"$set": bson.M{"folder": folder}}
+		if folder == "" {
+			update = bson.M{"$unset": bson.M{"folder": ""}}
+		}
+		return ms.updateDocument(ctx, id, userID, update)
+	})
+}
+
+// BulkTag adds tags to each document, leaving existing tags in place.
+func (ms *MongoStorage) BulkTag(ids []string, tags []string, userID string) []BulkResult {
+	tags = NormalizeTags(tags)
+	if len(tags) == 0 {
+		return failAll(ids, ErrNoTags)
+	}
+
+	return ms.forEachDocument(ids, 10*time.Second, func(ctx context.Context, id primitive.ObjectID) error {
+		update := bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}}
+		return ms.updateDocument(ctx, id, userID, update)
+	})
+}
+
+// BulkReindex re-extracts, re-chunks and re-embeds each document. The new
+// embeddings are computed first and then swapped in atomically with the old
+// chunks, so a document is never left without chunks.
+func (ms *MongoStorage) BulkReindex(ids []string, embedder *ai.Embedder, userID string) []BulkResult {
+	embedder = ms.ActiveEmbedder(embedder)
+
+	return ms.forEachDocument(ids, 2*time.Minute, func(ctx context.Context, id primitive.ObjectID) error {
+		var doc Document
+		docsColl := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+		err := docsColl.FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&doc)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrDocumentNotFound
+			}
+			return err
+		}
+		return ms.reembedDocument(ctx, ms.chunks(), &doc, embedder)
+	})
+}
+
+// reembedDocument replaces the document's chunks in coll with freshly
+// extracted and embedded ones, and drops its cached summary.
+func (ms *MongoStorage) reembedDocument(ctx context.Context, coll *mongo.Collection, doc *Document, embedder *ai.Embedder) error {
+	data, err := ms.documentContent(ctx, doc)
+	if err != nil {
+		return err
+	}
+	text, err := ms.extractor.ExtractText(doc.Filename, data)
+	if err != nil {
+		return err
+	}
+
+	var chunks []interface{}
+	resultsChan, errorChan := ms.embedChunks(ms.extractor.ChunkText(text), embedder, doc)
+	for chunk := range resultsChan {
+		chunks = append(chunks, chunk)
+	}
+	if err := <-errorChan; err != nil {
+		return err
+	}
+
+	// The cached summary was made from the previous chunks
+	docsColl := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+	return ms.withTransaction(ctx, func(ctx context.Context) error {
+		if _, err := docsColl.UpdateByID(ctx, doc.ID, bson.M{"$unset": bson.M{"summary": ""}}); err != nil {
+			return err
+		}
+		if _, err := coll.DeleteMany(ctx, bson.M{"document_id": doc.ID}); err != nil {
+			return err
+		}
+		if len(chunks) == 0 {
+			return nil
+		}
+		_, err := coll.InsertMany(ctx, chunks)
+		return err
+	})
+}
+
+// GetDocuments loads the listed documents including their content. Missing
+// or foreign documents are reported in the results rather than failing the
+// whole call.
+func (ms *MongoStorage) GetDocuments(ids []string, userID string) ([]Document, []BulkResult) {
+	var docs []Document
+	results := ms.forEachDocument(ids, 30*time.Second, func(ctx context.Context, id primitive.ObjectID) error {
+		var doc Document
+		docsColl := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+		err := docsColl.FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&doc)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrDocumentNotFound
+			}
+			return err
+		}
+		if err := ms.loadContent(ctx, &doc); err != nil {
+			return err
+		}
+		docs = append(docs, doc)
+		return nil
+	})
+	return docs, results
+}
+
+// updateDocument applies update to the document and copies the fields the
+// chunks mirror for $vectorSearch filtering in the same transaction.
+func (ms *MongoStorage) updateDocument(ctx context.Context, id primitive.ObjectID, userID string, update bson.M) error {
+	return ms.withTransaction(ctx, func(ctx context.Context) error {
+		var doc Document
+		docsColl := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+		opts := options.FindOneAndUpdate().
+			SetReturnDocument(options.After).
+			SetProjection(bson.M{"content": 0})
+		err := docsColl.FindOneAndUpdate(ctx, bson.M{"_id": id, "user_id": userID}, update, opts).Decode(&doc)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrDocumentNotFound
+			}
+			return err
+		}
+
+		for _, chunksColl := range ms.writableChunks() {
+			_, err = chunksColl.UpdateMany(ctx, bson.M{"document_id": doc.ID}, bson.M{"$set": bson.M{
+				"folder":     doc.Folder,
+				"tags":       doc.Tags,
+				"attributes": doc.Attributes,
+			}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// forEachDocument runs fn for every id with its own timeout and collects the
+// per-item outcome in request order.
+func (ms *MongoStorage) forEachDocument(ids []string, timeout time.Duration, fn func(ctx context.Context, id primitive.ObjectID) error) []BulkResult {
+	if len(ids) > MaxBulkItems {
+		return failAll(ids, fmt.Errorf("at most %d documents can be processed at once", MaxBulkItems))
+	}
+
+	results := make([]BulkResult, 0, len(ids))
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			results = append(results, NewBulkResult(id, ErrInvalidDocumentID))
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = fn(ctx, objectID)
+		cancel()
+
+		if err != nil {
+			log.Printf("Bulk operation failed for %s: %+v", id, err)
+		}
+		results = append(results, NewBulkResult(id, err))
+	}
+
+	return results
+}
+
+// withTransaction runs fn inside a transaction when the deployment supports
+// them (replica sets and sharded clusters, which includes Atlas) and runs it
+// directly against a standalone server.
+func (ms *MongoStorage) withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ms.transactionsUnsupported.Load() {
+		return fn(ctx)
+	}
+
+	session, err := ms.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	if isTransactionUnsupported(err) {
+		log.Printf("Transactions are not supported by this deployment, continuing without them")
+		ms.transactionsUnsupported.Store(true)
+		return fn(ctx)
+	}
+	return err
+}
+
+func isTransactionUnsupported(err error) bool {
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	// IllegalOperation: "Transaction numbers are only allowed on a replica
+	// set member or mongos".
+	return cmdErr.Code == 20 && strings.Contains(cmdErr.Message, "Transaction numbers")
+}
+
+func failAll(ids []string, err error) []BulkResult {
+	results := make([]BulkResult, len(ids))
+	for i, id := range ids {
+		results[i] = NewBulkResult(id, err)
+	}
+	return results
+}
+
+// NormalizeTags lower-cases and trims tags, splits comma separated values and
+// drops empty entries and duplicates.
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, value := range tags {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" || seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// NormalizeFolder turns user input like "/Legal//2024/" into "Legal/2024".
+func NormalizeFolder(folder string) string {
+	var parts []string
+	for _, part := range strings.Split(folder, "/") {
+		if part = strings.TrimSpace(part); part != "" && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	got := NormalizeTags([]string{" Legal, contracts", "legal", "", "2024 ,"})
+	expected := []string{"legal", "contracts", "2024"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestNormalizeFolder(t *testing.T) {
+	cases := map[string]string{
+		"":                  "",
+		"/":                 "",
+		"Legal":             "Legal",
+		"/Legal//2024/":     "Legal/2024",
+		" Legal / Drafts ":  "Legal/Drafts",
+		"../Legal/./Drafts": "Legal/Drafts",
+	}
+	for input, expected := range cases {
+		if got := NormalizeFolder(input); got != expected {
+			t.Errorf("NormalizeFolder(%q): expected %q, got %q", input, expected, got)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	searchStateCollection = "search_state"
+	activeChunkIndexID    = "active"
+
+	// chunkIndexTTL is how long a process keeps using the active chunk index
+	// it loaded, so a switch reaches every machine within this time.
+	chunkIndexTTL = 30 * time.Second
+)
+
+// ChunkIndex names the chunks collection searches and uploads use and the
+// embedding model its vectors come from. A re-index builds a new collection
+// and switches to it by replacing this single record.
+type ChunkIndex struct {
+	Collection string    `bson:"collection"`
+	Model      string    `bson:"model,omitempty"`
+	Dimensions int       `bson:"dimensions,omitempty"`
+	SwitchedAt time.Time `bson:"switched_at,omitempty"`
+
+	// Building is the collection a re-index is filling to replace this one.
+	// Changes to the chunks of existing documents are written to both.
+	Building string `bson:"building,omitempty"`
+}
+
+// ActiveChunkIndex returns the chunk index in use. Until it is seeded, see
+// ActiveEmbedder, it is the "chunks" collection without a model.
+func (ms *MongoStorage) ActiveChunkIndex() ChunkIndex {
+	ms.chunkIndexMu.Lock()
+	defer ms.chunkIndexMu.Unlock()
+
+	if ms.chunkIndex.Collection != "" && time.Since(ms.chunkIndexLoaded) < chunkIndexTTL {
+		return ms.chunkIndex
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	index := ChunkIndex{Collection: ms.chunksCollection}
+	coll := ms.client.Database(ms.database).Collection(searchStateCollection)
+	err := coll.FindOne(ctx, bson.M{"_id": activeChunkIndexID}).Decode(&index)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Error loading the active chunk index: %+v", err)
+		if ms.chunkIndex.Collection != "" {
+			return ms.chunkIndex
+		}
+	}
+
+	ms.chunkIndex = index
+	ms.chunkIndexLoaded = time.Now()
+	return index
+}
+
+// ActiveEmbedder returns base switched to the model of the active chunk
+// index, so queries and new uploads are embedded like the stored chunks.
+// The first time, the index is seeded with the model of base. From then on
+// only a re-index changes the model: changing the configured model alone
+// would embed queries unlike the stored chunks.
+func (ms *MongoStorage) ActiveEmbedder(base *ai.Embedder) *ai.Embedder {
+	index := ms.ActiveChunkIndex()
+	if index.Model == "" {
+		seeded, err := ms.seedChunkIndex(base)
+		if err != nil {
+			log.Printf("Error recording the embedding model in use: %+v", err)
+			return base
+		}
+		index = seeded
+	}
+	model := index.Model
+	if model == base.Model() {
+		return base
+	}
+	embedder, err := base.WithModel(model)
+	if err != nil {
+		log.Printf("Error switching to embedding model %s: %+v", model, err)
+		return base
+	}
+	return embedder
+}
+
+// seedChunkIndex records the model of base as the one the active chunks
+// were embedded with, unless a model is recorded already, and returns the
+// recorded index.
+func (ms *MongoStorage) seedChunkIndex(base *ai.Embedder) (ChunkIndex, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	seed := ChunkIndex{Collection: ms.chunksCollection, Model: base.Model(), Dimensions: base.Dimensions(), SwitchedAt: time.Now()}
+	var index ChunkIndex
+	coll := ms.client.Database(ms.database).Collection(searchStateCollection)
+	err := coll.FindOneAndUpdate(ctx,
+		bson.M{"_id": activeChunkIndexID},
+		bson.M{"$setOnInsert": seed},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&index)
+	if err != nil {
+		return ChunkIndex{}, err
+	}
+	log.Printf("Chunks in %s are embedded with %s", index.Collection, index.Model)
+
+	ms.chunkIndexMu.Lock()
+	ms.chunkIndex = index
+	ms.chunkIndexLoaded = time.Now()
+	ms.chunkIndexMu.Unlock()
+	return index, nil
+}
+
+// switchChunkIndex makes index the active one for every process.
+func (ms *MongoStorage) switchChunkIndex(ctx context.Context, index ChunkIndex) error {
+	index.SwitchedAt = time.Now()
+	coll := ms.client.Database(ms.database).Collection(searchStateCollection)
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": activeChunkIndexID}, index, options.Replace().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+
+	ms.chunkIndexMu.Lock()
+	ms.chunkIndex = index
+	ms.chunkIndexLoaded = time.Now()
+	ms.chunkIndexMu.Unlock()
+	return nil
+}
+
+// startBuilding records collection as the one a re-index fills, so that
+// every process also writes chunk changes there once its cached index
+// expires.
+func (ms *MongoStorage) startBuilding(ctx context.Context, collection string) error {
+	coll := ms.client.Database(ms.database).Collection(searchStateCollection)
+	_, err := coll.UpdateOne(ctx, bson.M{"_id": activeChunkIndexID}, bson.M{"$set": bson.M{"building": collection}})
+	if err != nil {
+		return err
+	}
+
+	ms.chunkIndexMu.Lock()
+	ms.chunkIndexLoaded = time.Time{}
+	ms.chunkIndexMu.Unlock()
+	return nil
+}
+
+// chunks returns the active chunks collection.
+func (ms *MongoStorage) chunks() *mongo.Collection {
+	return ms.client.Database(ms.database).Collection(ms.ActiveChunkIndex().Collection)
+}
+
+// writableChunks returns the active chunks collection and the one a
+// re-index is building, if any. Changes to the folder, tags or attributes
+// of chunks and their deletion go to both; the chunks of new uploads only
+// go to the active one, since they are embedded with its model, and are
+// copied by the re-index.
+func (ms *MongoStorage) writableChunks() []*mongo.Collection {
+	index := ms.ActiveChunkIndex()
+	db := ms.client.Database(ms.database)
+	colls := []*mongo.Collection{db.Collection(index.Collection)}
+	if index.Building != "" && index.Building != index.Collection {
+		colls = append(colls, db.Collection(index.Building))
+	}
+	return colls
+}
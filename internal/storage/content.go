@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// contentBucketName is the GridFS bucket holding the content of documents too
+// large to keep inline.
+const contentBucketName = "document_content"
+
+// maxInlineContent is the largest content stored in the document itself.
+// MongoDB refuses documents over 16 MiB, so larger files go to GridFS.
+const maxInlineContent = 8 << 20
+
+func (ms *MongoStorage) contentBucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(ms.client.Database(ms.database), options.GridFSBucket().SetName(contentBucketName))
+}
+
+// storeContent keeps data inline in doc, or in GridFS under doc.ID when it
+// is too large. doc.ID is assigned here in the latter case.
+func (ms *MongoStorage) storeContent(ctx context.Context, doc *Document, data []byte) error {
+	if len(data) <= maxInlineContent {
+		doc.Content = primitive.Binary{Subtype: 0x00, Data: data}
+		return nil
+	}
+
+	bucket, err := ms.contentBucket()
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetWriteDeadline(deadline)
+	}
+	if doc.ID.IsZero() {
+		doc.ID = primitive.NewObjectID()
+	}
+	opts := options.GridFSUpload().SetMetadata(bson.M{"user_id": doc.UserID})
+	if err := bucket.UploadFromStreamWithID(doc.ID, doc.Filename, bytes.NewReader(data), opts); err != nil {
+		log.Printf("Error storing content in GridFS: %+v", err)
+		return err
+	}
+	doc.ContentFile = doc.ID
+	return nil
+}
+
+// documentContent returns the content of doc, reading it from GridFS when it
+// is not inline.
+func (ms *MongoStorage) documentContent(ctx context.Context, doc *Document) ([]byte, error) {
+	if doc.ContentFile.IsZero() {
+		return doc.Content.Data, nil
+	}
+
+	bucket, err := ms.contentBucket()
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetReadDeadline(deadline)
+	}
+	var buf bytes.Buffer
+	buf.Grow(int(doc.Size()))
+	if _, err := bucket.DownloadToStream(doc.ContentFile, &buf); err != nil {
+		log.Printf("Error reading content of %s from GridFS: %+v", doc.ID.Hex(), err)
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// loadContent fills in doc.Content from GridFS when it is not inline.
+func (ms *MongoStorage) loadContent(ctx context.Context, doc *Document) error {
+	if doc.ContentFile.IsZero() {
+		return nil
+	}
+	data, err := ms.documentContent(ctx, doc)
+	if err != nil {
+		return err
+	}
+	doc.Content = primitive.Binary{Subtype: 0x00, Data: data}
+	return nil
+}
+
+// deleteContent removes the GridFS files with ids, skipping zero IDs of
+// documents whose content is inline. It runs after the documents are
+// deleted, outside their transaction, so failures are only logged.
+func (ms *MongoStorage) deleteContent(ids ...primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	bucket, err := ms.contentBucket()
+	if err != nil {
+		log.Printf("Error opening the content bucket: %+v", err)
+		return
+	}
+	for _, id := range ids {
+		if id.IsZero() {
+			continue
+		}
+		err := bucket.DeleteContext(ctx, id)
+		if err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			log.Printf("Error deleting content of %s: %+v", id.Hex(), err)
+		}
+	}
+}
+
+// deleteUserContent removes the GridFS content of every document of userID
+// and returns how many files went.
+func (ms *MongoStorage) deleteUserContent(ctx context.Context, userID string) (int64, error) {
+	files := ms.client.Database(ms.database).Collection(contentBucketName + ".files")
+	cursor, err := files.Find(ctx, bson.M{"metadata.user_id": userID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var found []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &found); err != nil {
+		return 0, err
+	}
+
+	bucket, err := ms.contentBucket()
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, file := range found {
+		if err := bucket.DeleteContext(ctx, file.ID); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
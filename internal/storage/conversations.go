@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const conversationsCollection = "conversations"
+
+// maxConversationMessages caps what is kept of a conversation; older
+// messages are dropped as new ones arrive.
+const maxConversationMessages = 200
+
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// Conversation is a chat between one user and the assistant.
+//
+// MessageCount counts every message ever added, including those dropped from
+// Messages, and the first SummarizedCount of them are condensed in Summary.
+type Conversation struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	UserID          string             `bson:"user_id"`
+	Messages        []ai.ChatMessage   `bson:"messages"`
+	MessageCount    int                `bson:"message_count"`
+	Summary         string             `bson:"summary,omitempty"`
+	SummarizedCount int                `bson:"summarized_count"`
+	CreatedAt       time.Time          `bson:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at"`
+
+	// Prompts selects prompt templates by kind for this conversation,
+	// overriding the workspace settings
+	Prompts map[string]string `bson:"prompts,omitempty"`
+}
+
+// Unsummarized returns the messages that are not part of the summary yet.
+func (conv *Conversation) Unsummarized() []ai.ChatMessage {
+	total := conv.MessageCount
+	if total < len(conv.Messages) {
+		total = len(conv.Messages)
+	}
+	pending := total - conv.SummarizedCount
+	if pending >= len(conv.Messages) {
+		return conv.Messages
+	}
+	if pending <= 0 {
+		return nil
+	}
+	return conv.Messages[len(conv.Messages)-pending:]
+}
+
+// CreateConversation starts an empty conversation and returns its id.
+func (ms *MongoStorage) CreateConversation(userID string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	coll := ms.client.Database(ms.database).Collection(conversationsCollection)
+	result, err := coll.InsertOne(ctx, Conversation{
+		UserID:    userID,
+		Messages:  []ai.ChatMessage{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		log.Printf("Error creating conversation: %+v", err)
+		return "", err
+	}
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// GetConversation returns the user's conversation with its messages.
+func (ms *MongoStorage) GetConversation(id string, userID string) (*Conversation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrConversationNotFound
+	}
+
+	var conv Conversation
+	coll := ms.client.Database(ms.database).Collection(conversationsCollection)
+	err = coll.FindOne(ctx, bson.M{"_id": objectID, "user_id": userID}).Decode(&conv)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrConversationNotFound
+		}
+		return nil, err
+	}
+	return &conv, nil
+}
+
+// AppendMessages adds messages to the end of the user's conversation.
+func (ms *MongoStorage) AppendMessages(id string, userID string, messages ...ai.ChatMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrConversationNotFound
+	}
+
+	coll := ms.client.Database(ms.database).Collection(conversationsCollection)
+	result, err := coll.UpdateOne(ctx, bson.M{"_id": objectID, "user_id": userID}, bson.M{
+		"$push": bson.M{"messages": bson.M{"$each": messages, "$slice": -maxConversationMessages}},
+		"$inc":  bson.M{"message_count": len(messages)},
+		"$set":  bson.M{"updated_at": time.Now()},
+	})
+	if err != nil {
+		log.Printf("Error appending to conversation: %+v", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+// SaveConversationSummary replaces the summary, which now covers the first
+// summarized messages. It only applies if nobody else has updated the
+// summary since it covered from messages, and reports whether it did.
+func (ms *MongoStorage) SaveConversationSummary(id string, userID string, summary string, from, summarized int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, ErrConversationNotFound
+	}
+
+	coll := ms.client.Database(ms.database).Collection(conversationsCollection)
+	filter := bson.M{"_id": objectID, "user_id": userID, "summarized_count": from}
+	if from == 0 {
+		// Conversations from before summaries have no summarized_count
+		filter["summarized_count"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	result, err := coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"summary":          summary,
+		"summarized_count": summarized,
+	}})
+	if err != nil {
+		log.Printf("Error saving conversation summary: %+v", err)
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// SetConversationPrompts selects prompt templates for the conversation by
+// kind. An empty reference removes the selection of its kind.
+func (ms *MongoStorage) SetConversationPrompts(id string, userID string, prompts map[string]string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrConversationNotFound
+	}
+
+	set, unset := bson.M{}, bson.M{}
+	for kind, ref := range prompts {
+		if ref == "" {
+			unset["prompts."+kind] = ""
+		} else {
+			set["prompts."+kind] = ref
+		}
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(update) == 0 {
+		return nil
+	}
+
+	coll := ms.client.Database(ms.database).Collection(conversationsCollection)
+	result, err := coll.UpdateOne(ctx, bson.M{"_id": objectID, "user_id": userID}, update)
+	if err != nil {
+		log.Printf("Error selecting conversation prompts: %+v", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
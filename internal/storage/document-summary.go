@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DocumentSummary is a cached summary of a whole document.
+type DocumentSummary struct {
+	Text        string    `bson:"text" json:"text"`
+	Chunks      int       `bson:"chunks" json:"chunks"`
+	GeneratedAt time.Time `bson:"generated_at" json:"generated_at"`
+}
+
+// DocumentChunks returns the chunks of the user's documents in reading order,
+// without their embeddings or the text they repeat of the chunk before, see
+// TextExtractor.TrimOverlap. Chunks stored before positions were recorded
+// fall back to insertion order.
+func (ms *MongoStorage) DocumentChunks(ids []primitive.ObjectID, userID string) ([]Chunk, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	coll := ms.chunks()
+	opts := options.Find().
+		SetProjection(bson.M{"embedding": 0}).
+		SetSort(bson.D{{Key: "document_id", Value: 1}, {Key: "position", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := coll.Find(ctx, bson.M{"document_id": bson.M{"$in": ids}, "user_id": userID}, opts)
+	if err != nil {
+		log.Printf("Error finding document chunks: %+v", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var chunks []Chunk
+	if err := cursor.All(ctx, &chunks); err != nil {
+		log.Printf("Error decoding document chunks: %+v", err)
+		return nil, err
+	}
+
+	// Keep the documents in the order they were asked for
+	order := make(map[primitive.ObjectID]int, len(ids))
+	for i, id := range ids {
+		order[id] = i
+	}
+	sorted := make([]Chunk, 0, len(chunks))
+	for i := range ids {
+		for _, chunk := range chunks {
+			if order[chunk.DocumentID] == i {
+				sorted = append(sorted, chunk)
+			}
+		}
+	}
+	return ms.extractor.TrimOverlap(sorted), nil
+}
+
+// SaveDocumentSummary caches summary on the document.
+func (ms *MongoStorage) SaveDocumentSummary(id string, userID string, summary DocumentSummary) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrDocumentNotFound
+	}
+
+	coll := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+	result, err := coll.UpdateOne(ctx, bson.M{"_id": objectID, "user_id": userID}, bson.M{"$set": bson.M{"summary": summary}})
+	if err != nil {
+		log.Printf("Error saving document summary: %+v", err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrDocumentNotFound
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const workspaceSettingsCollection = "workspace_settings"
+
+// Attribute keys set by enrichment. They are regular attributes, so search
+// and the file list can filter on them like any other.
+const (
+	LanguageAttribute = "language"
+	DocTypeAttribute  = "doc_type"
+)
+
+// Enrichment is what the model inferred about a document at ingestion.
+type Enrichment struct {
+	Title    string
+	Abstract string
+	Tags     []string
+	Language string
+	DocType  string
+}
+
+// attributes are the inferred language and document type by attribute key.
+func (e Enrichment) attributes() map[string]interface{} {
+	attributes := map[string]interface{}{}
+	if e.Language != "" {
+		attributes[LanguageAttribute] = e.Language
+	}
+	if e.DocType != "" {
+		attributes[DocTypeAttribute] = e.DocType
+	}
+	return attributes
+}
+
+// ApplyEnrichment stores the title and abstract in the document's metadata,
+// the language and document type as attributes unless the user already set
+// them, and adds the suggested tags to those the user already set.
+func (ms *MongoStorage) ApplyEnrichment(id primitive.ObjectID, userID string, e Enrichment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var current Document
+	docsColl := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+	err := docsColl.FindOne(ctx, bson.M{"_id": id, "user_id": userID}, options.FindOne().SetProjection(bson.M{"attributes": 1})).Decode(&current)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrDocumentNotFound
+		}
+		log.Printf("Error applying enrichment: %+v", err)
+		return err
+	}
+
+	set := bson.M{"metadata.enrichedAt": time.Now().Format(time.RFC3339)}
+	if e.Title != "" {
+		set["metadata.title"] = e.Title
+	}
+	if e.Abstract != "" {
+		set["metadata.abstract"] = e.Abstract
+	}
+	for key, value := range e.attributes() {
+		if _, ok := current.Attributes[key]; !ok {
+			set["attributes."+key] = value
+		}
+	}
+	update := bson.M{"$set": set}
+	if tags := NormalizeTags(e.Tags); len(tags) > 0 {
+		update["$addToSet"] = bson.M{"tags": bson.M{"$each": tags}}
+	}
+
+	if err := ms.updateDocument(ctx, id, userID, update); err != nil {
+		log.Printf("Error applying enrichment: %+v", err)
+		return err
+	}
+	return nil
+}
+
+// WorkspaceSettings are the per-user switches for optional features.
+type WorkspaceSettings struct {
+	UserID     string `bson:"_id" json:"-"`
+	Enrichment bool   `bson:"enrichment" json:"enrichment"`
+
+	// DisabledTools are agent tools the user does not allow
+	DisabledTools []string `bson:"disabled_tools,omitempty" json:"disabled_tools"`
+
+	// Prompts selects a prompt template by kind, such as "system", for the
+	// user's chats, see package prompts
+	Prompts map[string]string `bson:"prompts,omitempty" json:"prompts"`
+}
+
+// GetWorkspaceSettings returns the user's settings, or the defaults when
+// they never changed any.
+func (ms *MongoStorage) GetWorkspaceSettings(userID string) (WorkspaceSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	settings := WorkspaceSettings{UserID: userID, Enrichment: true}
+	coll := ms.client.Database(ms.database).Collection(workspaceSettingsCollection)
+	err := coll.FindOne(ctx, bson.M{"_id": userID}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Error loading workspace settings: %+v", err)
+		return settings, err
+	}
+	return settings, nil
+}
+
+func (ms *MongoStorage) SaveWorkspaceSettings(settings WorkspaceSettings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(workspaceSettingsCollection)
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": settings.UserID}, settings, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Printf("Error saving workspace settings: %+v", err)
+	}
+	return err
+}
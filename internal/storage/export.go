@@ -0,0 +1,423 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exportFormat and exportVersion identify export files; Import refuses
+// versions it does not know.
+const (
+	exportFormat  = "tusk-export"
+	exportVersion = 1
+
+	// importBatchSize bounds how many records are inserted at once, since
+	// documents carry their content
+	importBatchSize = 50
+
+	// contentPieceSize is how much of a GridFS content file one record holds
+	contentPieceSize = 1 << 20
+)
+
+// exportHeader is the first line of an export. Chunks are only imported
+// when they were embedded with the model the target embeds with.
+type exportHeader struct {
+	Format         string    `json:"format"`
+	Version        int       `json:"version"`
+	CreatedAt      time.Time `json:"created_at"`
+	UserID         string    `json:"user_id,omitempty"`
+	EmbeddingModel string    `json:"embedding_model"`
+}
+
+// exportRecord is every further line: one record as MongoDB canonical
+// extended JSON, which keeps IDs, dates and binary content intact.
+type exportRecord struct {
+	Collection string          `json:"collection"`
+	Record     json.RawMessage `json:"record"`
+}
+
+// ImportResult counts the records per collection.
+type ImportResult struct {
+	Imported map[string]int64
+	// Existing records are left alone, so an import can be repeated
+	Existing map[string]int64
+	// SkippedChunks were embedded with another model; the documents need
+	// re-indexing to be searchable
+	SkippedChunks int64
+}
+
+// contentPiece is a record of the content bucket: piece N of the GridFS
+// file holding the content of a large document.
+type contentPiece struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	Filename string             `bson:"filename"`
+	Metadata bson.M             `bson:"metadata"`
+	N        int                `bson:"n"`
+	Data     []byte             `bson:"data"`
+}
+
+// exportCollection is a collection an export covers. Records belong to a
+// user by _id rather than user_id when byID is set. The content bucket is
+// exported file by file, in pieces.
+type exportCollection struct {
+	name    string
+	byID    bool
+	chunk   bool
+	content bool
+}
+
+// exportCollections lists the exported collections in import order. Jobs,
+// unfinished uploads and server state are not exported.
+func (ms *MongoStorage) exportCollections() []exportCollection {
+	return []exportCollection{
+		{name: usersCollection, byID: true},
+		{name: workspaceSettingsCollection, byID: true},
+		{name: ms.documentsCollection},
+		{name: contentBucketName, content: true},
+		{name: ms.chunksCollection, chunk: true},
+		{name: conversationsCollection},
+		{name: extractionTemplatesCollection},
+		{name: extractionsCollection},
+		{name: apiTokensCollection},
+	}
+}
+
+// Export writes the data of userID, or of every user when it is "", to w as
+// JSON lines, and returns how many records it wrote per collection. Chunks
+// come from the active chunk index and are written as "chunks", and the
+// content of large documents is read from GridFS and counted by file.
+func (ms *MongoStorage) Export(ctx context.Context, w io.Writer, base *ai.Embedder, userID string) (map[string]int64, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	header := exportHeader{
+		Format:         exportFormat,
+		Version:        exportVersion,
+		CreatedAt:      time.Now().UTC(),
+		UserID:         userID,
+		EmbeddingModel: ms.ActiveEmbedder(base).Model(),
+	}
+	if err := enc.Encode(header); err != nil {
+		return nil, err
+	}
+
+	db := ms.client.Database(ms.database)
+	counts := map[string]int64{}
+	for _, c := range ms.exportCollections() {
+		if c.content {
+			n, err := ms.exportContent(ctx, enc, userID)
+			if n > 0 {
+				counts[c.name] = n
+			}
+			if err != nil {
+				return counts, err
+			}
+			continue
+		}
+
+		filter := bson.M{}
+		if userID != "" && c.byID {
+			filter["_id"] = userID
+		} else if userID != "" {
+			filter["user_id"] = userID
+		}
+		source := c.name
+		if c.chunk {
+			source = ms.ActiveChunkIndex().Collection
+		}
+
+		cursor, err := db.Collection(source).Find(ctx, filter)
+		if err != nil {
+			return counts, err
+		}
+		for cursor.Next(ctx) {
+			data, err := bson.MarshalExtJSON(cursor.Current, true, false)
+			if err != nil {
+				cursor.Close(ctx)
+				return counts, err
+			}
+			if err := enc.Encode(exportRecord{Collection: c.name, Record: data}); err != nil {
+				cursor.Close(ctx)
+				return counts, err
+			}
+			counts[c.name]++
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return counts, err
+		}
+	}
+	return counts, bw.Flush()
+}
+
+// exportContent writes the GridFS content files of userID, or of every user
+// when it is "", as contentPiece records and returns how many files it
+// wrote.
+func (ms *MongoStorage) exportContent(ctx context.Context, enc *json.Encoder, userID string) (int64, error) {
+	filter := bson.M{}
+	if userID != "" {
+		filter["metadata.user_id"] = userID
+	}
+	files := ms.client.Database(ms.database).Collection(contentBucketName + ".files")
+	cursor, err := files.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1, "filename": 1, "metadata": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var found []contentPiece
+	if err := cursor.All(ctx, &found); err != nil {
+		return 0, err
+	}
+
+	bucket, err := ms.contentBucket()
+	if err != nil {
+		return 0, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetReadDeadline(deadline)
+	}
+	var count int64
+	buf := make([]byte, contentPieceSize)
+	for _, piece := range found {
+		stream, err := bucket.OpenDownloadStream(piece.ID)
+		if err != nil {
+			return count, fmt.Errorf("reading content %s: %w", piece.ID.Hex(), err)
+		}
+		for piece.N = 0; ; piece.N++ {
+			n, err := io.ReadFull(stream, buf)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				stream.Close()
+				return count, fmt.Errorf("reading content %s: %w", piece.ID.Hex(), err)
+			}
+			if n == 0 && piece.N > 0 {
+				break
+			}
+			piece.Data = buf[:n]
+			data, merr := bson.MarshalExtJSON(piece, true, false)
+			if merr != nil {
+				stream.Close()
+				return count, merr
+			}
+			if merr := enc.Encode(exportRecord{Collection: contentBucketName, Record: data}); merr != nil {
+				stream.Close()
+				return count, merr
+			}
+			if err != nil {
+				break
+			}
+		}
+		stream.Close()
+		count++
+	}
+	return count, nil
+}
+
+// contentImport re-uploads the content files of an import, one piece at a
+// time. Files that already exist are skipped.
+type contentImport struct {
+	bucket *gridfs.Bucket
+	files  *mongo.Collection
+	id     primitive.ObjectID
+	upload *gridfs.UploadStream
+	skip   bool
+}
+
+// add writes piece, starting a new file on its first piece. It reports
+// whether piece started a file that was imported or already existed.
+func (ci *contentImport) add(ctx context.Context, piece contentPiece) (imported, existing bool, err error) {
+	if piece.N > 0 {
+		if piece.ID != ci.id {
+			return false, false, fmt.Errorf("piece %d of content %s without its start", piece.N, piece.ID.Hex())
+		}
+		if ci.skip {
+			return false, false, nil
+		}
+		_, err := ci.upload.Write(piece.Data)
+		return false, false, err
+	}
+
+	if err := ci.finish(); err != nil {
+		return false, false, err
+	}
+	ci.id = piece.ID
+	n, err := ci.files.CountDocuments(ctx, bson.M{"_id": piece.ID})
+	if err != nil {
+		return false, false, err
+	}
+	if ci.skip = n > 0; ci.skip {
+		return false, true, nil
+	}
+	opts := options.GridFSUpload().SetMetadata(piece.Metadata)
+	if ci.upload, err = ci.bucket.OpenUploadStreamWithID(piece.ID, piece.Filename, opts); err != nil {
+		return false, false, err
+	}
+	if _, err := ci.upload.Write(piece.Data); err != nil {
+		return false, false, err
+	}
+	return true, false, nil
+}
+
+// finish completes the file being uploaded, if any.
+func (ci *contentImport) finish() error {
+	if ci.upload == nil {
+		return nil
+	}
+	err := ci.upload.Close()
+	ci.upload = nil
+	return err
+}
+
+// abort drops the file being uploaded, so an import run again uploads it
+// from the start.
+func (ci *contentImport) abort() {
+	if ci.upload != nil {
+		ci.upload.Abort()
+		ci.upload = nil
+	}
+}
+
+// Import inserts the records of an export made by Export. Records that
+// already exist are kept as they are.
+func (ms *MongoStorage) Import(ctx context.Context, r io.Reader, base *ai.Embedder) (*ImportResult, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return nil, fmt.Errorf("reading the header: %w", err)
+	}
+	var header exportHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Format != exportFormat {
+		return nil, errors.New("not a Tusk export")
+	}
+	if header.Version != exportVersion {
+		return nil, fmt.Errorf("unsupported export version %d", header.Version)
+	}
+
+	known := map[string]bool{}
+	for _, c := range ms.exportCollections() {
+		known[c.name] = true
+	}
+	importChunks := header.EmbeddingModel == ms.ActiveEmbedder(base).Model()
+	chunkTarget := ms.ActiveChunkIndex().Collection
+
+	bucket, err := ms.contentBucket()
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetWriteDeadline(deadline)
+	}
+	content := &contentImport{bucket: bucket, files: ms.client.Database(ms.database).Collection(contentBucketName + ".files")}
+	defer content.abort()
+
+	result := &ImportResult{Imported: map[string]int64{}, Existing: map[string]int64{}}
+	var batch []interface{}
+	var batchCollection string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		target := batchCollection
+		if target == ms.chunksCollection {
+			target = chunkTarget
+		}
+		imported, existing, err := insertNew(ctx, ms.client.Database(ms.database).Collection(target), batch)
+		result.Imported[batchCollection] += imported
+		result.Existing[batchCollection] += existing
+		batch = batch[:0]
+		return err
+	}
+
+	for n := 2; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			var record exportRecord
+			if err := json.Unmarshal(line, &record); err != nil {
+				return result, fmt.Errorf("line %d: %w", n, err)
+			}
+			if !known[record.Collection] {
+				return result, fmt.Errorf("line %d: unknown collection %q", n, record.Collection)
+			}
+			if record.Collection == ms.chunksCollection && !importChunks {
+				result.SkippedChunks++
+				continue
+			}
+			if record.Collection == contentBucketName {
+				var piece contentPiece
+				if err := bson.UnmarshalExtJSON(record.Record, true, &piece); err != nil {
+					return result, fmt.Errorf("line %d: %w", n, err)
+				}
+				imported, existing, err := content.add(ctx, piece)
+				if err != nil {
+					return result, fmt.Errorf("line %d: %w", n, err)
+				}
+				if imported {
+					result.Imported[contentBucketName]++
+				} else if existing {
+					result.Existing[contentBucketName]++
+				}
+				continue
+			}
+			var doc bson.D
+			if err := bson.UnmarshalExtJSON(record.Record, true, &doc); err != nil {
+				return result, fmt.Errorf("line %d: %w", n, err)
+			}
+
+			if record.Collection != batchCollection || len(batch) >= importBatchSize {
+				if err := flush(); err != nil {
+					return result, err
+				}
+				batchCollection = record.Collection
+			}
+			batch = append(batch, doc)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	if err := flush(); err != nil {
+		return result, err
+	}
+	if err := content.finish(); err != nil {
+		return result, err
+	}
+
+	if result.SkippedChunks > 0 {
+		log.Printf("Skipped %d chunks embedded with %s instead of %s", result.SkippedChunks, header.EmbeddingModel, ms.ActiveEmbedder(base).Model())
+	}
+	return result, nil
+}
+
+// insertNew inserts docs, counting those whose _id already exists instead
+// of failing on them.
+func insertNew(ctx context.Context, coll *mongo.Collection, docs []interface{}) (imported, existing int64, err error) {
+	res, err := coll.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if res != nil {
+		imported = int64(len(res.InsertedIDs))
+	}
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if !mongo.IsDuplicateKeyError(writeErr) {
+				return imported, existing, err
+			}
+			existing++
+		}
+		return int64(len(docs)) - existing, existing, nil
+	}
+	return imported, existing, err
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestExportLargeContent round-trips a document whose content is kept in
+// GridFS between two scratch databases.
+func TestExportLargeContent(t *testing.T) {
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %+v", err)
+	}
+	if cfg.Mongo.URI == "" {
+		t.Skip("MONGO_URI is needed")
+	}
+	client, err := Connect(cfg.Mongo)
+	if err != nil {
+		t.Fatalf("Failed to create MongoDB instance: %+v", err)
+	}
+	ctx := context.Background()
+	open := func(name string) *MongoStorage {
+		cfg.Mongo.Database = name
+		ms := NewMongoStorage(client, cfg, NewTextExtractor(cfg.Ingest, nil))
+		t.Cleanup(func() { client.Database(name).Drop(context.Background()) })
+		return ms
+	}
+	suffix := primitive.NewObjectID().Hex()
+	source, target := open("tusk_export_"+suffix), open("tusk_import_"+suffix)
+	base := ai.NewEmbedder(cfg)
+
+	content := bytes.Repeat([]byte("large document "), maxInlineContent/10)
+	doc := Document{Filename: "large.txt", UserID: "user-1"}
+	if err := source.storeContent(ctx, &doc, content); err != nil {
+		t.Fatalf("Failed to store content: %+v", err)
+	}
+	if doc.ContentFile.IsZero() {
+		t.Fatalf("Expected %d bytes to be kept in GridFS", len(content))
+	}
+	if _, err := source.client.Database(source.database).Collection(source.documentsCollection).InsertOne(ctx, doc); err != nil {
+		t.Fatalf("Failed to insert document: %+v", err)
+	}
+
+	var export bytes.Buffer
+	counts, err := source.Export(ctx, &export, base, "user-1")
+	if err != nil {
+		t.Fatalf("Export failed: %+v", err)
+	}
+	if counts[contentBucketName] != 1 {
+		t.Errorf("Expected 1 content file to be exported, got %v", counts)
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := target.Import(ctx, bytes.NewReader(export.Bytes()), base)
+		if err != nil {
+			t.Fatalf("Import %d failed: %+v", i+1, err)
+		}
+		if i == 1 && result.Existing[contentBucketName] != 1 {
+			t.Errorf("Expected the content file to exist on the second import, got %v", result.Existing)
+		}
+	}
+
+	var imported Document
+	if err := target.client.Database(target.database).Collection(target.documentsCollection).FindOne(ctx, bson.M{"_id": doc.ID}).Decode(&imported); err != nil {
+		t.Fatalf("Imported document not found: %+v", err)
+	}
+	if err := target.loadContent(ctx, &imported); err != nil {
+		t.Fatalf("Failed to load imported content: %+v", err)
+	}
+	if !bytes.Equal(imported.Content.Data, content) {
+		t.Errorf("Expected %d bytes of content after import, got %d", len(content), len(imported.Content.Data))
+	}
+}
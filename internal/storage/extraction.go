@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	extractionTemplatesCollection = "extraction_templates"
+	extractionsCollection         = "extractions"
+)
+
+var ErrTemplateNotFound = errors.New("extraction template not found")
+
+// ExtractionTemplate is a user's JSON Schema for structured extraction. The
+// schema is kept as the JSON text the user gave.
+type ExtractionTemplate struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    string             `bson:"user_id" json:"-"`
+	Name      string             `bson:"name" json:"name"`
+	Schema    string             `bson:"schema" json:"schema"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Extraction is the latest result of a template for one document. Data is
+// the JSON the model returned and Errors what failed schema validation.
+type Extraction struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	TemplateID  primitive.ObjectID `bson:"template_id"`
+	DocumentID  primitive.ObjectID `bson:"document_id"`
+	UserID      string             `bson:"user_id"`
+	Filename    string             `bson:"filename"`
+	Data        string             `bson:"data"`
+	Valid       bool               `bson:"valid"`
+	Errors      []string           `bson:"errors,omitempty"`
+	ExtractedAt time.Time          `bson:"extracted_at"`
+}
+
+func extractionIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "template_id", Value: 1}, {Key: "document_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "document_id", Value: 1}}},
+	}
+}
+
+func (ms *MongoStorage) CreateExtractionTemplate(userID, name, schema string) (*ExtractionTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	template := ExtractionTemplate{UserID: userID, Name: name, Schema: schema, CreatedAt: time.Now()}
+	coll := ms.client.Database(ms.database).Collection(extractionTemplatesCollection)
+	result, err := coll.InsertOne(ctx, template)
+	if err != nil {
+		log.Printf("Error creating extraction template: %+v", err)
+		return nil, err
+	}
+	template.ID = result.InsertedID.(primitive.ObjectID)
+	return &template, nil
+}
+
+func (ms *MongoStorage) ListExtractionTemplates(userID string) ([]ExtractionTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(extractionTemplatesCollection)
+	cursor, err := coll.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	templates := []ExtractionTemplate{}
+	if err := cursor.All(ctx, &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (ms *MongoStorage) GetExtractionTemplate(id string, userID string) (*ExtractionTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrTemplateNotFound
+	}
+
+	var template ExtractionTemplate
+	coll := ms.client.Database(ms.database).Collection(extractionTemplatesCollection)
+	err = coll.FindOne(ctx, bson.M{"_id": objectID, "user_id": userID}).Decode(&template)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrTemplateNotFound
+		}
+		return nil, err
+	}
+	return &template, nil
+}
+
+// DeleteExtractionTemplate removes the template and its results.
+func (ms *MongoStorage) DeleteExtractionTemplate(id string, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrTemplateNotFound
+	}
+
+	return ms.withTransaction(ctx, func(ctx context.Context) error {
+		db := ms.client.Database(ms.database)
+		result, err := db.Collection(extractionTemplatesCollection).DeleteOne(ctx, bson.M{"_id": objectID, "user_id": userID})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return ErrTemplateNotFound
+		}
+		_, err = db.Collection(extractionsCollection).DeleteMany(ctx, bson.M{"template_id": objectID})
+		return err
+	})
+}
+
+// SaveExtraction replaces the template's previous result for the document.
+func (ms *MongoStorage) SaveExtraction(extraction Extraction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(extractionsCollection)
+	filter := bson.M{"template_id": extraction.TemplateID, "document_id": extraction.DocumentID}
+	_, err := coll.ReplaceOne(ctx, filter, extraction, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Printf("Error saving extraction: %+v", err)
+	}
+	return err
+}
+
+// ListExtractions returns the template's results ordered by filename.
+func (ms *MongoStorage) ListExtractions(templateID primitive.ObjectID, userID string) ([]Extraction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(extractionsCollection)
+	opts := options.Find().SetSort(bson.D{{Key: "filename", Value: 1}})
+	cursor, err := coll.Find(ctx, bson.M{"template_id": templateID, "user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var extractions []Extraction
+	if err := cursor.All(ctx, &extractions); err != nil {
+		return nil, err
+	}
+	return extractions, nil
+}
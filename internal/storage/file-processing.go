@@ -7,8 +7,8 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/sdrshn-nmbr/tusk/internal/ai"
 	"github.com/sdrshn-nmbr/tusk/internal/config"
@@ -18,21 +18,62 @@ import (
 	"github.com/unidoc/unipdf/v3/model"
 )
 
-const (
-	chunkSize = 2048
-	overlap   = 50
+// SetLicense registers the Unidoc metered license key, which PDF and DOCX
+// extraction need. It is called once at startup.
+func SetLicense(key string) error {
+	if err := license.SetMeteredKey(key); err != nil {
+		log.Printf("Failed to set Unidoc license: %+v", err)
+		return err
+	}
+	return nil
+}
+
+var (
+	// ErrNoImageReader is returned for images when no ImageReader is set.
+	ErrNoImageReader = errors.New("images are not supported without an image reader")
+	// ErrUnsupportedFileType is wrapped with the extension of files that
+	// cannot be turned into text.
+	ErrUnsupportedFileType = errors.New("unsupported file type")
 )
 
-func init() {
-	cfg, err := config.NewConfig()
-	if err != nil {
-		log.Fatal("Config not initialized properly")
-	}
+// ImageReader extracts the text of an image and describes what else it
+// shows, see GeminiOCR.
+type ImageReader interface {
+	ReadImage(ctx context.Context, image []byte) (string, error)
+}
 
-	// Initialize Unidoc license
-	err = license.SetMeteredKey(cfg.UnidocAPIKey)
-	if err != nil {
-		log.Fatalf("Failed to set Unidoc license: %+v", err)
+// TextExtractor turns uploads into text and chunks them as cfg says. Images
+// go to ocr, which may be nil where images are not expected.
+type TextExtractor struct {
+	cfg config.Ingest
+	ocr ImageReader
+}
+
+// NewTextExtractor chunks with cfg's sizes and reads images with ocr.
+func NewTextExtractor(cfg config.Ingest, ocr ImageReader) *TextExtractor {
+	return &TextExtractor{cfg: cfg, ocr: ocr}
+}
+
+// ExtractText pulls the plain text out of a file based on its extension.
+func (e *TextExtractor) ExtractText(filename string, data []byte) (string, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch ext {
+	case ".pdf":
+		return extractTextFromPDF(bytes.NewReader(data))
+	case ".docx":
+		return extractTextFromDOCX(bytes.NewReader(data))
+	case ".txt":
+		return string(data), nil
+	case ".jpg", ".jpeg", ".png", ".webp", ".heic", ".heif":
+		if e.ocr == nil {
+			return "", ErrNoImageReader
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), e.cfg.OCRTimeout.Duration)
+		defer cancel()
+		return e.ocr.ReadImage(ctx, data)
+	default:
+		log.Printf("unsupported file type: %s", ext)
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedFileType, ext)
 	}
 }
 
@@ -85,63 +126,63 @@ func extractTextFromPDF(content io.Reader) (string, error) {
 	return textBuilder.String(), nil
 }
 
-func extractTextFromImage(imgContent []byte) (string, error) {
-	log.Println("Starting extractTextFromImage function")
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
-
-	cfg, err := config.NewConfig()
-	if err != nil {
-		log.Printf("Failed to load config: %+v", err)
-		return "", err
-	}
-	log.Println("Config loaded successfully")
+// OCRPrompter writes the instructions for reading an image, see
+// prompts.Library.
+type OCRPrompter interface {
+	OCRPrompt() string
+}
 
-	sysPrompt :=
-		`You are an AI assistant that extracts and summarizes text from images, acting essentially as an OCR model.
+// OCRPrompt is what GeminiOCR instructs the model with when it has no
+// Prompter.
+const OCRPrompt = `You are an AI assistant that extracts and summarizes text from images, acting essentially as an OCR model.
 		Once you are done extracting all text, if there is more information than just text from the images, describe it in as much detail as possible.`
 
-	log.Println("Creating new AI model")
-	model, err := ai.NewModel(cfg, sysPrompt)
+// GeminiOCR reads images with Gemini. Every image gets a model of its own,
+// since a model keeps the history of its chat.
+type GeminiOCR struct {
+	cfg *config.Config
+
+	// Prompter, when set, writes the system prompt of every image
+	Prompter OCRPrompter
+}
+
+func NewGeminiOCR(cfg *config.Config) *GeminiOCR {
+	return &GeminiOCR{cfg: cfg}
+}
+
+func (o *GeminiOCR) ReadImage(ctx context.Context, imgContent []byte) (string, error) {
+	sysPrompt := OCRPrompt
+	if o.Prompter != nil {
+		sysPrompt = o.Prompter.OCRPrompt()
+	}
+
+	model, err := ai.NewModel(o.cfg, sysPrompt)
 	if err != nil {
 		log.Printf("Failed to create model: %+v", err)
 		return "", err
 	}
 	defer model.Close()
-	log.Println("AI model created successfully")
 
 	query := "Extract and summarize any text visible in this image."
 
-	log.Println("Generating response from AI model")
 	responseChan, errorChan := model.GenerateResponse(ctx, query, imgContent)
 
 	modelResponse := new(bytes.Buffer)
-	timeout := time.After(30 * time.Second)
 
-	log.Println("Entering response processing loop")
 	for {
 		select {
 		case response, ok := <-responseChan:
 			if !ok {
-				log.Println("Response channel closed")
 				if modelResponse.Len() > 0 {
-					log.Println("Returning accumulated response")
 					return modelResponse.String(), nil
 				}
 				return "", fmt.Errorf("response channel closed unexpectedly")
 			}
-			log.Println("Received response chunk, appending to buffer")
 			modelResponse.WriteString(response)
 
 		case err, ok := <-errorChan:
 			if !ok {
-				log.Println("Error channel closed")
 				if modelResponse.Len() > 0 {
-					log.Println("Returning accumulated response despite error channel closure")
-					log.Print("\n\n\n==============================================")
-					log.Print("RESPONSE")
-					log.Print("==============================================\n\n\n")
-					log.Printf("%s\n\n\n", modelResponse.String())
 					return modelResponse.String(), nil
 				}
 				return "", fmt.Errorf("error channel closed unexpectedly")
@@ -150,19 +191,12 @@ func extractTextFromImage(imgContent []byte) (string, error) {
 			return "", err
 
 		case <-ctx.Done():
-			log.Printf("Request cancelled by client")
+			// Cancelled, or the OCR timeout passed
+			log.Printf("Reading the image stopped: %v", ctx.Err())
 			if modelResponse.Len() > 0 {
 				return modelResponse.String(), ctx.Err()
 			}
 			return "", ctx.Err()
-
-		case <-timeout:
-			log.Printf("Request timed out after 30 seconds")
-			if modelResponse.Len() > 0 {
-				log.Println("Returning partial response due to timeout")
-				return modelResponse.String(), fmt.Errorf("request timed out after 30 seconds")
-			}
-			return "", fmt.Errorf("request timed out after 30 seconds")
 		}
 	}
 }
@@ -189,7 +223,10 @@ func extractTextFromDOCX(content io.Reader) (string, error) {
 	return textBuilder.String(), nil
 }
 
-func ChunkText(text string) []string {
+// ChunkText splits text at word boundaries into chunks of at most the
+// configured size, each starting with the end of the previous one.
+func (e *TextExtractor) ChunkText(text string) []string {
+	chunkSize, overlap := e.cfg.ChunkSize, e.cfg.ChunkOverlap
 	words := strings.Fields(text)
 	if len(words) == 0 {
 		return nil
@@ -225,3 +262,26 @@ func ChunkText(text string) []string {
 
 	return chunks
 }
+
+// TrimOverlap removes from each chunk the start it repeats of the chunk
+// before it, so that the chunks of a document read as its text. Only chunks
+// directly following each other by position are trimmed, and by at most
+// the configured overlap, cut where a word starts.
+func (e *TextExtractor) TrimOverlap(chunks []Chunk) []Chunk {
+	trimmed := make([]Chunk, len(chunks))
+	copy(trimmed, chunks)
+	for i := 1; i < len(chunks); i++ {
+		prev, cur := chunks[i-1], chunks[i]
+		if cur.DocumentID != prev.DocumentID || cur.Position != prev.Position+1 {
+			continue
+		}
+		for n := min(e.cfg.ChunkOverlap, len(prev.Content), len(cur.Content)); n > 0; n-- {
+			rest := cur.Content[n:]
+			if strings.HasSuffix(prev.Content, cur.Content[:n]) && (rest == "" || rest[0] == ' ') {
+				trimmed[i].Content = strings.TrimLeft(rest, " ")
+				break
+			}
+		}
+	}
+	return trimmed
+}
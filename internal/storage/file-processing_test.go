@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+)
+
+type fakeOCR string
+
+func (f fakeOCR) ReadImage(ctx context.Context, image []byte) (string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return "", errors.New("expected the OCR timeout")
+	}
+	return string(f), nil
+}
+
+func TestExtractText(t *testing.T) {
+	cfg := config.Default().Ingest
+	e := NewTextExtractor(cfg, fakeOCR("a receipt"))
+
+	if text, err := e.ExtractText("notes.TXT", []byte("hello")); err != nil || text != "hello" {
+		t.Errorf("Expected the text file as is, got %q, %v", text, err)
+	}
+	if text, err := e.ExtractText("scan.png", []byte{0x89}); err != nil || text != "a receipt" {
+		t.Errorf("Expected the image to be read, got %q, %v", text, err)
+	}
+	if _, err := e.ExtractText("data.xls", nil); !errors.Is(err, ErrUnsupportedFileType) {
+		t.Errorf("Expected ErrUnsupportedFileType, got %v", err)
+	}
+
+	e = NewTextExtractor(cfg, nil)
+	if _, err := e.ExtractText("scan.png", []byte{0x89}); !errors.Is(err, ErrNoImageReader) {
+		t.Errorf("Expected ErrNoImageReader, got %v", err)
+	}
+}
+
+func TestChunkText(t *testing.T) {
+	e := NewTextExtractor(config.Ingest{ChunkSize: 20, ChunkOverlap: 5}, nil)
+	chunks := e.ChunkText(strings.Repeat("word ", 20))
+	if len(chunks) < 2 {
+		t.Fatalf("Expected several chunks, got %q", chunks)
+	}
+	for _, chunk := range chunks {
+		if len(chunk) > 20+5 {
+			t.Errorf("Chunk %q is longer than the chunk size and overlap", chunk)
+		}
+	}
+	if e.ChunkText("  ") != nil {
+		t.Error("Expected no chunks for blank text")
+	}
+}
+
+func TestTrimOverlap(t *testing.T) {
+	e := NewTextExtractor(config.Ingest{ChunkSize: 24, ChunkOverlap: 8}, nil)
+	text := "alpha bravo charlie delta echo foxtrot golf hotel india juliet kilo lima mike november"
+	texts := e.ChunkText(text)
+	chunks := make([]Chunk, len(texts))
+	for i, content := range texts {
+		chunks[i] = Chunk{Content: content, Position: i}
+	}
+
+	var joined []string
+	for _, chunk := range e.TrimOverlap(chunks) {
+		joined = append(joined, chunk.Content)
+	}
+	if got := strings.Join(joined, " "); got != text {
+		t.Errorf("Expected the chunks to read as the text, got %q from %q", got, texts)
+	}
+	if chunks[1].Content != texts[1] {
+		t.Error("Expected the chunks passed in to be left alone")
+	}
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// documentIndexes back the per-user listing and the folder, tag, attribute
+// and date filters of ListFiles. Attributes use a wildcard index since their
+// keys are chosen by users.
+func documentIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "filename", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "folder", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "uploaded_at", Value: -1}}},
+		{Keys: bson.D{{Key: "attributes.$**", Value: 1}}},
+	}
+}
+
+// chunkIndexes back deleting and re-indexing a document's chunks and the
+// $lookup from chunks to documents.
+func chunkIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "document_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	}
+}
+
+// regularIndexes lists the indexes EnsureIndexes creates per collection.
+func regularIndexes(documentsCollection, chunksCollection string) map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		documentsCollection:       documentIndexes(),
+		chunksCollection:          chunkIndexes(),
+		extractionsCollection:     extractionIndexes(),
+		jobsCollection:            jobIndexes(),
+		apiTokensCollection:       apiTokenIndexes(),
+		promptTemplatesCollection: promptTemplateIndexes(),
+	}
+}
+
+// EnsureIndexes creates the regular indexes the queries rely on. Creating an
+// index that already exists is a no-op. Regular indexes are usable as soon as
+// this returns, unlike the search indexes from EnsureSearchIndexes.
+func (ms *MongoStorage) EnsureIndexes() error {
+	return ms.ensureIndexes(ms.documentsCollection, ms.ActiveChunkIndex().Collection)
+}
+
+func (ms *MongoStorage) ensureIndexes(documentsCollection, chunksCollection string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	db := ms.client.Database(ms.database)
+	for collection, indexes := range regularIndexes(documentsCollection, chunksCollection) {
+		names, err := db.Collection(collection).Indexes().CreateMany(ctx, indexes)
+		if err != nil {
+			log.Printf("Error creating %s indexes: %+v", collection, err)
+			return err
+		}
+		log.Printf("Indexes on %s ready: %v", collection, names)
+	}
+
+	return nil
+}
+
+// IndexState tells whether an index the server relies on can be used.
+type IndexState struct {
+	Collection string
+	Name       string
+	// Status is "ready" or "missing", or Atlas's status of a search index
+	// such as "BUILDING" or "FAILED"
+	Status string
+	Ready  bool
+}
+
+// IndexStates checks the regular indexes of EnsureIndexes and the search
+// indexes of EnsureSearchIndexes on the active chunks collection.
+func (ms *MongoStorage) IndexStates(ctx context.Context) ([]IndexState, error) {
+	db := ms.client.Database(ms.database)
+	chunks := ms.ActiveChunkIndex().Collection
+	expected := regularIndexes(ms.documentsCollection, chunks)
+	collections := make([]string, 0, len(expected))
+	for collection := range expected {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+
+	var states []IndexState
+	for _, collection := range collections {
+		specs, err := db.Collection(collection).Indexes().ListSpecifications(ctx)
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Code == namespaceNotFound {
+			err = nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing indexes of %s: %w", collection, err)
+		}
+		existing := map[string]bool{}
+		for _, spec := range specs {
+			existing[spec.Name] = true
+		}
+		for _, model := range expected[collection] {
+			name := indexName(model.Keys.(bson.D))
+			state := IndexState{Collection: collection, Name: name, Status: "missing"}
+			if existing[name] {
+				state.Status, state.Ready = "ready", true
+			}
+			states = append(states, state)
+		}
+	}
+
+	coll := db.Collection(chunks)
+	for _, name := range []string{vectorIndexName, textIndexName} {
+		status, err := ms.searchIndex(ctx, coll, name)
+		if err != nil {
+			return nil, err
+		}
+		state := IndexState{Collection: chunks, Name: name, Status: "missing"}
+		if status != nil {
+			state.Status, state.Ready = status.Status, status.Queryable
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// namespaceNotFound is the error code for a collection that does not exist.
+const namespaceNotFound = 26
+
+// indexName is the name MongoDB gives an index created without one, e.g.
+// "user_id_1_uploaded_at_-1".
+func indexName(keys bson.D) string {
+	parts := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
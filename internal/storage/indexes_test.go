@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestIndexName(t *testing.T) {
+	tests := []struct {
+		keys     bson.D
+		expected string
+	}{
+		{bson.D{{Key: "document_id", Value: 1}}, "document_id_1"},
+		{bson.D{{Key: "user_id", Value: 1}, {Key: "uploaded_at", Value: -1}}, "user_id_1_uploaded_at_-1"},
+		{bson.D{{Key: "attributes.$**", Value: 1}}, "attributes.$**_1"},
+	}
+	for _, tt := range tests {
+		if got := indexName(tt.keys); got != tt.expected {
+			t.Errorf("indexName(%v) = %q, expected %q", tt.keys, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const jobsCollection = "jobs"
+
+// Job types
+const (
+	JobReindex   = "reindex"
+	JobSummarize = "summarize"
+	JobExtract   = "extract"
+)
+
+// Job states
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// Job is a user's background operation over a set of documents. Results
+// holds one entry per document as it finishes, so clients can poll the
+// progress.
+type Job struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      string             `bson:"user_id" json:"-"`
+	Type        string             `bson:"type" json:"type"`
+	Status      string             `bson:"status" json:"status"`
+	DocumentIDs []string           `bson:"document_ids" json:"document_ids"`
+	TemplateID  string             `bson:"template_id,omitempty" json:"template_id,omitempty"`
+	Total       int                `bson:"total" json:"total"`
+	Succeeded   int                `bson:"succeeded" json:"succeeded"`
+	Failed      int                `bson:"failed" json:"failed"`
+	Results     []BulkResult       `bson:"results" json:"results,omitempty"`
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+func jobIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	}
+}
+
+// CreateJob stores a new queued job and sets its ID.
+func (ms *MongoStorage) CreateJob(job *Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	job.Status = JobQueued
+	job.Total = len(job.DocumentIDs)
+	job.Results = []BulkResult{}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	coll := ms.client.Database(ms.database).Collection(jobsCollection)
+	result, err := coll.InsertOne(ctx, job)
+	if err != nil {
+		log.Printf("Error creating job: %+v", err)
+		return err
+	}
+	job.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// AddJobResult records the outcome for one document of a running job.
+func (ms *MongoStorage) AddJobResult(id primitive.ObjectID, result BulkResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	counter := "failed"
+	if result.OK {
+		counter = "succeeded"
+	}
+	update := bson.M{
+		"$set":  bson.M{"status": JobRunning, "updated_at": time.Now()},
+		"$push": bson.M{"results": result},
+		"$inc":  bson.M{counter: 1},
+	}
+	coll := ms.client.Database(ms.database).Collection(jobsCollection)
+	if _, err := coll.UpdateByID(ctx, id, update); err != nil {
+		log.Printf("Error updating job: %+v", err)
+		return err
+	}
+	return nil
+}
+
+// FinishJob marks the job done, or failed with the given error. A job
+// without an error still fails when every document failed, and one where
+// only some did is done with the count in its error.
+func (ms *MongoStorage) FinishJob(id primitive.ObjectID, jobErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(jobsCollection)
+	var job Job
+	err := coll.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"results": 0})).Decode(&job)
+	if err != nil {
+		log.Printf("Error finishing job: %+v", err)
+		if err == mongo.ErrNoDocuments {
+			return ErrJobNotFound
+		}
+		return err
+	}
+
+	status, message := job.Outcome(jobErr)
+	set := bson.M{"status": status, "updated_at": time.Now()}
+	if message != "" {
+		set["error"] = message
+	}
+	if _, err := coll.UpdateByID(ctx, id, bson.M{"$set": set}); err != nil {
+		log.Printf("Error finishing job: %+v", err)
+		return err
+	}
+	return nil
+}
+
+// Outcome is the final status and error of a job whose documents have all
+// been processed, or that stopped with jobErr.
+func (job *Job) Outcome(jobErr error) (string, string) {
+	switch {
+	case jobErr != nil:
+		return JobFailed, jobErr.Error()
+	case job.Total > 0 && job.Failed >= job.Total:
+		return JobFailed, "every document failed"
+	case job.Failed > 0:
+		return JobDone, fmt.Sprintf("%d of %d documents failed", job.Failed, job.Total)
+	default:
+		return JobDone, ""
+	}
+}
+
+// FailStaleJobs marks queued and running jobs that have not made progress
+// for longer than age as failed. Jobs run in the server process that
+// created them, so these were cut short by a restart or crash and nothing
+// will ever finish them.
+func (ms *MongoStorage) FailStaleJobs(age time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	coll := ms.client.Database(ms.database).Collection(jobsCollection)
+	result, err := coll.UpdateMany(ctx,
+		bson.M{
+			"status":     bson.M{"$in": []string{JobQueued, JobRunning}},
+			"updated_at": bson.M{"$lt": now.Add(-age)},
+		},
+		bson.M{"$set": bson.M{
+			"status":     JobFailed,
+			"error":      "interrupted before it finished, e.g. by a server restart",
+			"updated_at": now,
+		}},
+	)
+	if err != nil {
+		log.Printf("Error failing stale jobs: %+v", err)
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+func (ms *MongoStorage) GetJob(id string, userID string) (*Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrJobNotFound
+	}
+
+	var job Job
+	coll := ms.client.Database(ms.database).Collection(jobsCollection)
+	err = coll.FindOne(ctx, bson.M{"_id": objectID, "user_id": userID}).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListJobs returns a page of the user's jobs, newest first, and how many
+// there are in total.
+func (ms *MongoStorage) ListJobs(userID string, offset, limit int64) ([]Job, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(jobsCollection)
+	query := bson.M{"user_id": userID}
+	total, err := coll.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(offset).
+		SetLimit(limit).
+		SetProjection(bson.M{"results": 0})
+	cursor, err := coll.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []Job{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, 0, err
+	}
+	return jobs, total, nil
+}
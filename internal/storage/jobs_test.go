@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJobOutcome(t *testing.T) {
+	tests := []struct {
+		job        Job
+		err        error
+		wantStatus string
+		wantError  string
+	}{
+		{Job{Total: 2, Succeeded: 2}, nil, JobDone, ""},
+		{Job{Total: 2, Succeeded: 1, Failed: 1}, nil, JobDone, "1 of 2 documents failed"},
+		{Job{Total: 2, Failed: 2}, nil, JobFailed, "every document failed"},
+		{Job{Total: 2, Succeeded: 2}, errors.New("context deadline exceeded"), JobFailed, "context deadline exceeded"},
+	}
+	for _, tt := range tests {
+		status, message := tt.job.Outcome(tt.err)
+		if status != tt.wantStatus || message != tt.wantError {
+			t.Errorf("Outcome(%+v, %v) = %q, %q, want %q, %q", tt.job, tt.err, status, message, tt.wantStatus, tt.wantError)
+		}
+	}
+}
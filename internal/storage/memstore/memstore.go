@@ -0,0 +1,1123 @@
+// Package memstore keeps everything the handlers store in memory. It stands
+// in for MongoDB in tests and local development; nothing survives a restart.
+package memstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errFileNotFound = errors.New("file not found")
+
+// Store mirrors storage.MongoStorage. Vector search is a brute-force cosine
+// ranking over every chunk of the user.
+type Store struct {
+	mu sync.Mutex
+
+	documents     []*storage.Document
+	chunks        []storage.Chunk
+	uploads       map[primitive.ObjectID]*upload
+	conversations map[primitive.ObjectID]*storage.Conversation
+	settings      map[string]storage.WorkspaceSettings
+	templates     []storage.ExtractionTemplate
+	extractions   []storage.Extraction
+	jobs          []*storage.Job
+	tokens        []storage.APIToken
+	users         map[string]*storage.User
+	prompts       []storage.PromptTemplate
+
+	extractor *storage.TextExtractor
+}
+
+type upload struct {
+	storage.Upload
+	data []byte
+}
+
+// New returns an empty store that chunks uploads with the default
+// configuration. It cannot read images.
+func New() *Store {
+	return NewWithExtractor(storage.NewTextExtractor(config.Default().Ingest, nil))
+}
+
+// NewWithExtractor returns an empty store that extracts and chunks uploads
+// with extractor.
+func NewWithExtractor(extractor *storage.TextExtractor) *Store {
+	return &Store{
+		extractor:     extractor,
+		uploads:       make(map[primitive.ObjectID]*upload),
+		conversations: make(map[primitive.ObjectID]*storage.Conversation),
+		settings:      make(map[string]storage.WorkspaceSettings),
+		users:         make(map[string]*storage.User),
+	}
+}
+
+// withoutContent returns a copy of doc for callers that only want its
+// metadata.
+func withoutContent(doc *storage.Document) storage.Document {
+	out := *doc
+	out.Content = primitive.Binary{}
+	return out
+}
+
+// document finds the user's document by id. The caller holds mu.
+func (s *Store) document(id primitive.ObjectID, userID string) *storage.Document {
+	for _, doc := range s.documents {
+		if doc.ID == id && doc.UserID == userID {
+			return doc
+		}
+	}
+	return nil
+}
+
+func (s *Store) documentByHex(id string, userID string) (*storage.Document, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrDocumentNotFound
+	}
+	doc := s.document(objectID, userID)
+	if doc == nil {
+		return nil, storage.ErrDocumentNotFound
+	}
+	return doc, nil
+}
+
+// SaveFile stores the file and embeds its chunks.
+func (s *Store) SaveFile(filename string, content io.Reader, embedder *ai.Embedder, userID string) (*storage.Document, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	text, err := s.extractor.ExtractText(filename, data)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	doc := &storage.Document{
+		ID:       primitive.NewObjectID(),
+		Filename: filename,
+		Content:  primitive.Binary{Data: data},
+		Metadata: map[string]string{
+			"uploadDate": now.Format(time.RFC3339),
+			"size":       fmt.Sprintf("%d", len(data)),
+			"sha256":     storage.ContentHash(data),
+		},
+		UserID:     userID,
+		UploadedAt: now,
+	}
+	chunks, err := s.embed(doc, text, embedder)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.documents = append(s.documents, doc)
+	s.chunks = append(s.chunks, chunks...)
+
+	out := withoutContent(doc)
+	return &out, nil
+}
+
+// embed chunks and embeds the text of doc.
+func (s *Store) embed(doc *storage.Document, text string, embedder *ai.Embedder) ([]storage.Chunk, error) {
+	texts := s.extractor.ChunkText(text)
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	embeddings, err := embedder.GenerateEmbeddings(texts)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]storage.Chunk, len(texts))
+	for i, text := range texts {
+		chunks[i] = storage.Chunk{
+			ID:         primitive.NewObjectID(),
+			DocumentID: doc.ID,
+			Content:    text,
+			Embedding:  embeddings[i],
+			Position:   i,
+			UserID:     doc.UserID,
+			Folder:     doc.Folder,
+			Tags:       doc.Tags,
+			Attributes: doc.Attributes,
+			UploadedAt: doc.UploadedAt,
+		}
+	}
+	return chunks, nil
+}
+
+func (s *Store) GetFile(filename string, userID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, doc := range s.documents {
+		if doc.Filename == filename && doc.UserID == userID {
+			return doc.Content.Data, nil
+		}
+	}
+	return nil, errFileNotFound
+}
+
+func (s *Store) DeleteFileFunc(filename string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, doc := range s.documents {
+		if doc.Filename == filename && doc.UserID == userID {
+			s.deleteDocument(doc.ID)
+			return nil
+		}
+	}
+	return errFileNotFound
+}
+
+// deleteDocument removes the document with its chunks and extractions. The
+// caller holds mu.
+func (s *Store) deleteDocument(id primitive.ObjectID) {
+	s.documents = slices.DeleteFunc(s.documents, func(doc *storage.Document) bool { return doc.ID == id })
+	s.chunks = slices.DeleteFunc(s.chunks, func(chunk storage.Chunk) bool { return chunk.DocumentID == id })
+	s.extractions = slices.DeleteFunc(s.extractions, func(e storage.Extraction) bool { return e.DocumentID == id })
+}
+
+func (s *Store) ListFiles(userID string, filter storage.DocumentFilter) ([]storage.Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.listFiles(userID, filter), nil
+}
+
+func (s *Store) listFiles(userID string, filter storage.DocumentFilter) []storage.Document {
+	var docs []storage.Document
+	for _, doc := range s.documents {
+		if doc.UserID == userID && filter.Matches(doc) {
+			docs = append(docs, withoutContent(doc))
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool { return filter.Compare(&docs[i], &docs[j]) < 0 })
+	return docs
+}
+
+func (s *Store) ListFilesPage(userID string, filter storage.DocumentFilter, offset, limit int64) ([]storage.Document, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs := s.listFiles(userID, filter)
+	total := int64(len(docs))
+	page := []storage.Document{}
+	if offset < total {
+		page = append(page, docs[offset:min(offset+limit, total)]...)
+	}
+	return page, total, nil
+}
+
+func (s *Store) Folders(userID string) ([]storage.FolderInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, doc := range s.documents {
+		if doc.UserID == userID {
+			counts[doc.Folder]++
+		}
+	}
+	folders := []storage.FolderInfo{}
+	for name, n := range counts {
+		folders = append(folders, storage.FolderInfo{Name: name, Documents: n})
+	}
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Name < folders[j].Name })
+	return folders, nil
+}
+
+func (s *Store) GetDocument(id string, userID string) (*storage.Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, err := s.documentByHex(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := withoutContent(doc)
+	return &out, nil
+}
+
+func (s *Store) GetDocuments(ids []string, userID string) ([]storage.Document, []storage.BulkResult) {
+	var docs []storage.Document
+	results := s.forEachDocument(ids, userID, func(doc *storage.Document) error {
+		docs = append(docs, *doc)
+		return nil
+	})
+	return docs, results
+}
+
+func (s *Store) DocumentChunks(ids []primitive.ObjectID, userID string) ([]storage.Chunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var chunks []storage.Chunk
+	for _, id := range ids {
+		var docChunks []storage.Chunk
+		for _, chunk := range s.chunks {
+			if chunk.DocumentID == id && chunk.UserID == userID {
+				chunk.Embedding = nil
+				docChunks = append(docChunks, chunk)
+			}
+		}
+		sort.SliceStable(docChunks, func(i, j int) bool { return docChunks[i].Position < docChunks[j].Position })
+		chunks = append(chunks, docChunks...)
+	}
+	return s.extractor.TrimOverlap(chunks), nil
+}
+
+func (s *Store) SaveDocumentSummary(id string, userID string, summary storage.DocumentSummary) error {
+	return s.updateDocument(id, userID, func(doc *storage.Document) error {
+		doc.Summary = &summary
+		return nil
+	})
+}
+
+func (s *Store) SetTags(id string, tags []string, userID string) error {
+	return s.updateDocument(id, userID, func(doc *storage.Document) error {
+		doc.Tags = storage.NormalizeTags(tags)
+		return nil
+	})
+}
+
+func (s *Store) RemoveTag(id string, tag string, userID string) error {
+	return s.updateDocument(id, userID, func(doc *storage.Document) error {
+		doc.Tags = slices.DeleteFunc(doc.Tags, func(t string) bool { return t == tag })
+		return nil
+	})
+}
+
+func (s *Store) SetAttribute(id string, key string, value interface{}, userID string) error {
+	if !config.ValidAttributeKey(key) {
+		return storage.ErrInvalidAttributeKey
+	}
+	return s.updateDocument(id, userID, func(doc *storage.Document) error {
+		if doc.Attributes == nil {
+			doc.Attributes = make(map[string]interface{})
+		}
+		doc.Attributes[key] = value
+		return nil
+	})
+}
+
+func (s *Store) RemoveAttribute(id string, key string, userID string) error {
+	if !config.ValidAttributeKey(key) {
+		return storage.ErrInvalidAttributeKey
+	}
+	return s.updateDocument(id, userID, func(doc *storage.Document) error {
+		delete(doc.Attributes, key)
+		return nil
+	})
+}
+
+// updateDocument applies fn to the document and copies the fields chunks
+// mirror for filtering.
+func (s *Store) updateDocument(id string, userID string, fn func(doc *storage.Document) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, err := s.documentByHex(id, userID)
+	if err != nil {
+		return err
+	}
+	return s.update(doc, fn)
+}
+
+// update is updateDocument for a document that was already looked up. The
+// caller holds mu.
+func (s *Store) update(doc *storage.Document, fn func(doc *storage.Document) error) error {
+	if err := fn(doc); err != nil {
+		return err
+	}
+	for i := range s.chunks {
+		if s.chunks[i].DocumentID == doc.ID {
+			s.chunks[i].Folder = doc.Folder
+			s.chunks[i].Tags = doc.Tags
+			s.chunks[i].Attributes = doc.Attributes
+		}
+	}
+	return nil
+}
+
+// forEachDocument runs fn for the user's documents and reports the
+// per-item outcome in request order.
+func (s *Store) forEachDocument(ids []string, userID string, fn func(doc *storage.Document) error) []storage.BulkResult {
+	if len(ids) > storage.MaxBulkItems {
+		return failAll(ids, fmt.Errorf("at most %d documents can be processed at once", storage.MaxBulkItems))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]storage.BulkResult, 0, len(ids))
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			results = append(results, storage.NewBulkResult(id, storage.ErrInvalidDocumentID))
+			continue
+		}
+		doc := s.document(objectID, userID)
+		if doc == nil {
+			err = storage.ErrDocumentNotFound
+		} else {
+			err = fn(doc)
+		}
+		results = append(results, storage.NewBulkResult(id, err))
+	}
+	return results
+}
+
+func failAll(ids []string, err error) []storage.BulkResult {
+	results := make([]storage.BulkResult, len(ids))
+	for i, id := range ids {
+		results[i] = storage.NewBulkResult(id, err)
+	}
+	return results
+}
+
+func (s *Store) BulkDelete(ids []string, userID string) []storage.BulkResult {
+	return s.forEachDocument(ids, userID, func(doc *storage.Document) error {
+		s.deleteDocument(doc.ID)
+		return nil
+	})
+}
+
+func (s *Store) BulkMove(ids []string, folder string, userID string) []storage.BulkResult {
+	folder = storage.NormalizeFolder(folder)
+	return s.forEachDocument(ids, userID, func(doc *storage.Document) error {
+		return s.update(doc, func(doc *storage.Document) error {
+			doc.Folder = folder
+			return nil
+		})
+	})
+}
+
+func (s *Store) BulkTag(ids []string, tags []string, userID string) []storage.BulkResult {
+	tags = storage.NormalizeTags(tags)
+	if len(tags) == 0 {
+		return failAll(ids, storage.ErrNoTags)
+	}
+	return s.forEachDocument(ids, userID, func(doc *storage.Document) error {
+		return s.update(doc, func(doc *storage.Document) error {
+			for _, tag := range tags {
+				if !slices.Contains(doc.Tags, tag) {
+					doc.Tags = append(doc.Tags, tag)
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// BulkReindex re-extracts, re-chunks and re-embeds each document.
+func (s *Store) BulkReindex(ids []string, embedder *ai.Embedder, userID string) []storage.BulkResult {
+	return s.forEachDocument(ids, userID, func(doc *storage.Document) error {
+		text, err := s.extractor.ExtractText(doc.Filename, doc.Content.Data)
+		if err != nil {
+			return err
+		}
+		chunks, err := s.embed(doc, text, embedder)
+		if err != nil {
+			return err
+		}
+		s.chunks = slices.DeleteFunc(s.chunks, func(chunk storage.Chunk) bool { return chunk.DocumentID == doc.ID })
+		s.chunks = append(s.chunks, chunks...)
+		doc.Summary = nil
+		return nil
+	})
+}
+
+// VectorSearch ranks the user's chunks matching filter by cosine similarity,
+// scored from 0 to 1 like Atlas does. numCandidates is ignored since every
+// chunk is compared.
+func (s *Store) VectorSearch(queryVector []float32, numCandidates, limit int, userID string, filter storage.DocumentFilter) ([]storage.Chunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var results []storage.Chunk
+	for _, chunk := range s.chunks {
+		if chunk.UserID != userID || len(chunk.Embedding) != len(queryVector) {
+			continue
+		}
+		doc := s.document(chunk.DocumentID, userID)
+		if doc == nil || !filter.Matches(doc) {
+			continue
+		}
+		chunk.Filename = doc.Filename
+		chunk.Score = (1 + cosine(queryVector, chunk.Embedding)) / 2
+		results = append(results, chunk)
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func cosine(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// ActiveEmbedder returns base; the store has a single chunk index.
+func (s *Store) ActiveEmbedder(base *ai.Embedder) *ai.Embedder {
+	return base
+}
+
+func (s *Store) CreateUpload(userID, filename string, length int64, metadata map[string]string) (*storage.Upload, error) {
+	if length < 0 || length > storage.MaxUploadSize {
+		return nil, storage.ErrUploadTooLarge
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	u := &upload{Upload: storage.Upload{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Filename:  filename,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(24 * time.Hour),
+	}}
+	s.uploads[u.ID] = u
+	out := u.Upload
+	return &out, nil
+}
+
+// findUpload looks up the user's upload. The caller holds mu.
+func (s *Store) findUpload(id string, userID string) (*upload, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrUploadNotFound
+	}
+	u, ok := s.uploads[objectID]
+	if !ok || u.UserID != userID {
+		return nil, storage.ErrUploadNotFound
+	}
+	return u, nil
+}
+
+func (s *Store) GetUpload(id string, userID string) (*storage.Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.findUpload(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := u.Upload
+	return &out, nil
+}
+
+func (s *Store) WriteUploadPart(id string, userID string, offset int64, data []byte) (*storage.Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.findUpload(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := u.Upload
+	if u.Offset != offset {
+		return &out, storage.ErrUploadOffsetMismatch
+	}
+	if offset+int64(len(data)) > u.Length {
+		return &out, storage.ErrUploadTooLarge
+	}
+	u.data = append(u.data, data...)
+	u.Offset += int64(len(data))
+	out = u.Upload
+	return &out, nil
+}
+
+func (s *Store) OpenUpload(id string, userID string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.findUpload(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !u.Complete() {
+		return nil, storage.ErrUploadIncomplete
+	}
+	return io.NopCloser(bytes.NewReader(bytes.Clone(u.data))), nil
+}
+
+func (s *Store) StartUploadIngest(id string, userID string) (*storage.Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.findUpload(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := u.Upload
+	if !u.Complete() {
+		return &out, storage.ErrUploadIncomplete
+	}
+	if u.Ingest == storage.UploadIngested {
+		return &out, nil
+	}
+	if u.Ingest == storage.UploadIngesting && !u.IngestStale(config.Default().Ingest.Timeout.Duration) {
+		return &out, storage.ErrUploadIngesting
+	}
+	u.Ingest = storage.UploadIngesting
+	u.IngestError = ""
+	u.IngestStartedAt = time.Now()
+	out = u.Upload
+	return &out, nil
+}
+
+func (s *Store) FinishUploadIngest(id string, userID string, documentID primitive.ObjectID, ingestErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.findUpload(id, userID)
+	if err != nil {
+		return err
+	}
+	if ingestErr != nil {
+		u.Ingest = storage.UploadFailed
+		u.IngestError = ingestErr.Error()
+		return nil
+	}
+	u.Ingest = storage.UploadIngested
+	u.DocumentID = documentID
+	u.data = nil
+	return nil
+}
+
+func (s *Store) DeleteUpload(id string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.findUpload(id, userID)
+	if err != nil {
+		return err
+	}
+	delete(s.uploads, u.ID)
+	return nil
+}
+
+func (s *Store) CreateConversation(userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	conv := &storage.Conversation{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Messages:  []ai.ChatMessage{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	s.conversations[conv.ID] = conv
+	return conv.ID.Hex(), nil
+}
+
+// conversation looks up the user's conversation. The caller holds mu.
+func (s *Store) conversation(id string, userID string) (*storage.Conversation, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrConversationNotFound
+	}
+	conv, ok := s.conversations[objectID]
+	if !ok || conv.UserID != userID {
+		return nil, storage.ErrConversationNotFound
+	}
+	return conv, nil
+}
+
+func (s *Store) GetConversation(id string, userID string) (*storage.Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.conversation(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := *conv
+	out.Messages = slices.Clone(conv.Messages)
+	out.Prompts = maps.Clone(conv.Prompts)
+	return &out, nil
+}
+
+func (s *Store) AppendMessages(id string, userID string, messages ...ai.ChatMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.conversation(id, userID)
+	if err != nil {
+		return err
+	}
+	conv.Messages = append(conv.Messages, messages...)
+	conv.MessageCount += len(messages)
+	conv.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *Store) SaveConversationSummary(id string, userID string, summary string, from, summarized int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.conversation(id, userID)
+	if err != nil {
+		return false, err
+	}
+	if conv.SummarizedCount != from {
+		return false, nil
+	}
+	conv.Summary = summary
+	conv.SummarizedCount = summarized
+	return true, nil
+}
+
+func (s *Store) SetConversationPrompts(id string, userID string, prompts map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conv, err := s.conversation(id, userID)
+	if err != nil {
+		return err
+	}
+	selected := maps.Clone(conv.Prompts)
+	if selected == nil {
+		selected = map[string]string{}
+	}
+	for kind, ref := range prompts {
+		if ref == "" {
+			delete(selected, kind)
+		} else {
+			selected[kind] = ref
+		}
+	}
+	conv.Prompts = selected
+	return nil
+}
+
+func (s *Store) GetWorkspaceSettings(userID string) (storage.WorkspaceSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if settings, ok := s.settings[userID]; ok {
+		return settings, nil
+	}
+	return storage.WorkspaceSettings{UserID: userID, Enrichment: true}, nil
+}
+
+func (s *Store) SaveWorkspaceSettings(settings storage.WorkspaceSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.settings[settings.UserID] = settings
+	return nil
+}
+
+func (s *Store) CreateExtractionTemplate(userID, name, schema string) (*storage.ExtractionTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	template := storage.ExtractionTemplate{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Name:      name,
+		Schema:    schema,
+		CreatedAt: time.Now(),
+	}
+	s.templates = append(s.templates, template)
+	return &template, nil
+}
+
+func (s *Store) ListExtractionTemplates(userID string) ([]storage.ExtractionTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	templates := []storage.ExtractionTemplate{}
+	for _, template := range s.templates {
+		if template.UserID == userID {
+			templates = append(templates, template)
+		}
+	}
+	sort.SliceStable(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+func (s *Store) GetExtractionTemplate(id string, userID string) (*storage.ExtractionTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, template := range s.templates {
+		if template.ID.Hex() == id && template.UserID == userID {
+			return &template, nil
+		}
+	}
+	return nil, storage.ErrTemplateNotFound
+}
+
+func (s *Store) DeleteExtractionTemplate(id string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.templates, func(t storage.ExtractionTemplate) bool {
+		return t.ID.Hex() == id && t.UserID == userID
+	})
+	if i < 0 {
+		return storage.ErrTemplateNotFound
+	}
+	templateID := s.templates[i].ID
+	s.templates = slices.Delete(s.templates, i, i+1)
+	s.extractions = slices.DeleteFunc(s.extractions, func(e storage.Extraction) bool { return e.TemplateID == templateID })
+	return nil
+}
+
+// SaveExtraction replaces the template's previous result for the document.
+func (s *Store) SaveExtraction(extraction storage.Extraction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.extractions = slices.DeleteFunc(s.extractions, func(e storage.Extraction) bool {
+		return e.TemplateID == extraction.TemplateID && e.DocumentID == extraction.DocumentID
+	})
+	if extraction.ID.IsZero() {
+		extraction.ID = primitive.NewObjectID()
+	}
+	s.extractions = append(s.extractions, extraction)
+	return nil
+}
+
+func (s *Store) ListExtractions(templateID primitive.ObjectID, userID string) ([]storage.Extraction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var extractions []storage.Extraction
+	for _, e := range s.extractions {
+		if e.TemplateID == templateID && e.UserID == userID {
+			extractions = append(extractions, e)
+		}
+	}
+	sort.SliceStable(extractions, func(i, j int) bool { return extractions[i].Filename < extractions[j].Filename })
+	return extractions, nil
+}
+
+func (s *Store) CreateJob(job *storage.Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	job.ID = primitive.NewObjectID()
+	job.Status = storage.JobQueued
+	job.Total = len(job.DocumentIDs)
+	job.Results = []storage.BulkResult{}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	stored := *job
+	s.jobs = append(s.jobs, &stored)
+	return nil
+}
+
+// job looks up a job by id. The caller holds mu.
+func (s *Store) job(id primitive.ObjectID) (*storage.Job, error) {
+	for _, job := range s.jobs {
+		if job.ID == id {
+			return job, nil
+		}
+	}
+	return nil, storage.ErrJobNotFound
+}
+
+func (s *Store) AddJobResult(id primitive.ObjectID, result storage.BulkResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, err := s.job(id)
+	if err != nil {
+		return err
+	}
+	job.Status = storage.JobRunning
+	job.UpdatedAt = time.Now()
+	job.Results = append(job.Results, result)
+	if result.OK {
+		job.Succeeded++
+	} else {
+		job.Failed++
+	}
+	return nil
+}
+
+func (s *Store) FinishJob(id primitive.ObjectID, jobErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, err := s.job(id)
+	if err != nil {
+		return err
+	}
+	job.Status, job.Error = job.Outcome(jobErr)
+	job.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *Store) GetJob(id string, userID string) (*storage.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, storage.ErrJobNotFound
+	}
+	job, err := s.job(objectID)
+	if err != nil || job.UserID != userID {
+		return nil, storage.ErrJobNotFound
+	}
+	out := *job
+	out.Results = slices.Clone(job.Results)
+	return &out, nil
+}
+
+// ListJobs returns a page of the user's jobs, newest first, without their
+// results.
+func (s *Store) ListJobs(userID string, offset, limit int64) ([]storage.Job, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var jobs []storage.Job
+	for i := len(s.jobs) - 1; i >= 0; i-- {
+		if s.jobs[i].UserID == userID {
+			job := *s.jobs[i]
+			job.Results = nil
+			jobs = append(jobs, job)
+		}
+	}
+	total := int64(len(jobs))
+	page := []storage.Job{}
+	if offset < total {
+		page = append(page, jobs[offset:min(offset+limit, total)]...)
+	}
+	return page, total, nil
+}
+
+func (s *Store) CreateAPIToken(userID, name string, scopes []string, expiresAt *time.Time) (*storage.APIToken, string, error) {
+	token, secret, err := storage.NewAPIToken(userID, name, scopes, expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token.ID = primitive.NewObjectID()
+	s.tokens = append(s.tokens, token)
+	return &token, secret, nil
+}
+
+func (s *Store) ListAPITokens(userID string) ([]storage.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := []storage.APIToken{}
+	for i := len(s.tokens) - 1; i >= 0; i-- {
+		if s.tokens[i].UserID == userID {
+			tokens = append(tokens, s.tokens[i])
+		}
+	}
+	return tokens, nil
+}
+
+func (s *Store) RevokeAPIToken(id string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.tokens, func(t storage.APIToken) bool {
+		return t.ID.Hex() == id && t.UserID == userID
+	})
+	if i < 0 {
+		return storage.ErrTokenNotFound
+	}
+	s.tokens = slices.Delete(s.tokens, i, i+1)
+	return nil
+}
+
+func (s *Store) AuthenticateToken(secret string) (string, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := storage.HashToken(secret)
+	now := time.Now()
+	for i := range s.tokens {
+		token := &s.tokens[i]
+		if token.Hash != hash {
+			continue
+		}
+		if token.Expired(now) {
+			return "", nil, storage.ErrInvalidToken
+		}
+		token.LastUsedAt = &now
+		return token.UserID, token.Scopes, nil
+	}
+	return "", nil, storage.ErrInvalidToken
+}
+
+func (s *Store) RecordLogin(user storage.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	stored, ok := s.users[user.ID]
+	if !ok {
+		stored = &storage.User{ID: user.ID, CreatedAt: now}
+		s.users[user.ID] = stored
+	}
+	stored.Provider, stored.Email, stored.Name, stored.LastLogin = user.Provider, user.Email, user.Name, now
+	if stored.Disabled {
+		return storage.ErrUserDisabled
+	}
+	return nil
+}
+
+func (s *Store) GetUser(userID string) (*storage.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, storage.ErrUserNotFound
+	}
+	out := *user
+	return &out, nil
+}
+
+func (s *Store) UserDisabled(userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	return ok && user.Disabled, nil
+}
+
+func (s *Store) SetUserDisabled(userID string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		user = &storage.User{ID: userID}
+		s.users[userID] = user
+	}
+	user.Disabled = disabled
+	return nil
+}
+
+// SavePromptTemplate stores template as the next version of its kind and
+// name.
+func (s *Store) SavePromptTemplate(template storage.PromptTemplate) (*storage.PromptTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	template.ID = primitive.NewObjectID()
+	template.Version = 1
+	template.CreatedAt = time.Now()
+	if latest := s.latestPrompt(template.Kind, template.Name); latest != nil {
+		template.Version = latest.Version + 1
+	}
+	s.prompts = append(s.prompts, template)
+	return &template, nil
+}
+
+// latestPrompt returns the newest version of a template. The caller holds mu.
+func (s *Store) latestPrompt(kind, name string) *storage.PromptTemplate {
+	var latest *storage.PromptTemplate
+	for i, template := range s.prompts {
+		if template.Kind == kind && template.Name == name && (latest == nil || template.Version > latest.Version) {
+			latest = &s.prompts[i]
+		}
+	}
+	return latest
+}
+
+func (s *Store) GetPromptTemplate(kind, name string, version int) (*storage.PromptTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if version == 0 {
+		if latest := s.latestPrompt(kind, name); latest != nil {
+			out := *latest
+			return &out, nil
+		}
+		return nil, storage.ErrPromptTemplateNotFound
+	}
+	for _, template := range s.prompts {
+		if template.Kind == kind && template.Name == name && template.Version == version {
+			return &template, nil
+		}
+	}
+	return nil, storage.ErrPromptTemplateNotFound
+}
+
+func (s *Store) ListPromptTemplates(kind string) ([]storage.PromptTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	templates := []storage.PromptTemplate{}
+	for _, template := range s.prompts {
+		if kind != "" && template.Kind != kind {
+			continue
+		}
+		if latest := s.latestPrompt(template.Kind, template.Name); latest.Version == template.Version {
+			templates = append(templates, template)
+		}
+	}
+	sort.SliceStable(templates, func(i, j int) bool {
+		if templates[i].Kind != templates[j].Kind {
+			return templates[i].Kind < templates[j].Kind
+		}
+		return templates[i].Name < templates[j].Name
+	})
+	return templates, nil
+}
+
+func (s *Store) PromptTemplateVersions(kind, name string) ([]storage.PromptTemplate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var templates []storage.PromptTemplate
+	for _, template := range s.prompts {
+		if template.Kind == kind && template.Name == name {
+			templates = append(templates, template)
+		}
+	}
+	if len(templates) == 0 {
+		return nil, storage.ErrPromptTemplateNotFound
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Version > templates[j].Version })
+	return templates, nil
+}
@@ -0,0 +1,427 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Attribute types users can attach to documents. Values are stored with
+// their native BSON type so range filters and sorting behave as expected.
+const (
+	AttributeString = "string"
+	AttributeNumber = "number"
+	AttributeDate   = "date"
+)
+
+var ErrInvalidAttributeKey = errors.New("attribute keys must start with a letter and contain only letters, digits and underscores")
+
+// ParseAttribute converts raw user input into a value of the given type.
+func ParseAttribute(typ, raw string) (interface{}, error) {
+	raw = strings.TrimSpace(raw)
+	switch typ {
+	case AttributeString, "":
+		return raw, nil
+	case AttributeNumber:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", raw)
+		}
+		return n, nil
+	case AttributeDate:
+		return ParseDate(raw)
+	default:
+		return nil, fmt.Errorf("unknown attribute type %q", typ)
+	}
+}
+
+// ParseDate accepts RFC 3339 timestamps as well as plain dates, months and
+// years, which are taken to mean the start of that period in UTC.
+func ParseDate(raw string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date", raw)
+}
+
+// AttributeType reports the type name of a stored attribute value.
+func AttributeType(value interface{}) string {
+	switch value.(type) {
+	case float64, int32, int64:
+		return AttributeNumber
+	case time.Time, primitive.DateTime:
+		return AttributeDate
+	default:
+		return AttributeString
+	}
+}
+
+// FormatAttribute renders a stored attribute value for display.
+func FormatAttribute(value interface{}) string {
+	switch v := value.(type) {
+	case primitive.DateTime:
+		return v.Time().UTC().Format("2006-01-02")
+	case time.Time:
+		return v.UTC().Format("2006-01-02")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// AttributeFilter compares one attribute against a value. Op is one of eq,
+// ne, gt, gte, lt and lte.
+type AttributeFilter struct {
+	Key   string
+	Op    string
+	Value interface{}
+}
+
+var attributeOps = map[string]string{
+	"eq":  "$eq",
+	"ne":  "$ne",
+	"gt":  "$gt",
+	"gte": "$gte",
+	"lt":  "$lt",
+	"lte": "$lte",
+}
+
+// ParseAttributeFilter parses "key:op:value" (or "key:value" for equality).
+// The value is read as a number or a date when it looks like one.
+func ParseAttributeFilter(expr string) (AttributeFilter, error) {
+	parts := strings.SplitN(expr, ":", 3)
+	var filter AttributeFilter
+	var raw string
+	switch len(parts) {
+	case 2:
+		filter.Key, filter.Op, raw = parts[0], "eq", parts[1]
+	case 3:
+		filter.Key, filter.Op, raw = parts[0], parts[1], parts[2]
+	default:
+		return filter, fmt.Errorf("attribute filter %q must look like key:op:value", expr)
+	}
+
+	if !config.ValidAttributeKey(filter.Key) {
+		return filter, ErrInvalidAttributeKey
+	}
+	if _, ok := attributeOps[filter.Op]; !ok {
+		return filter, fmt.Errorf("unknown attribute operator %q", filter.Op)
+	}
+
+	if n, err := strconv.ParseFloat(raw, 64); err == nil {
+		filter.Value = n
+	} else if t, err := ParseDate(raw); err == nil {
+		filter.Value = t
+	} else {
+		filter.Value = raw
+	}
+	// Atlas vector search compares strings for equality only
+	if _, ok := filter.Value.(string); ok && filter.Op != "eq" && filter.Op != "ne" {
+		return filter, fmt.Errorf("attribute operator %q needs a number or a date, got %q", filter.Op, raw)
+	}
+	return filter, nil
+}
+
+// DocumentFilter narrows ListFiles and VectorSearch. Every set field has to
+// match; a document must carry all of the listed tags.
+type DocumentFilter struct {
+	DocumentIDs    []primitive.ObjectID
+	Folder         string
+	Tags           []string
+	Attributes     []AttributeFilter
+	UploadedAfter  time.Time
+	UploadedBefore time.Time
+
+	// SortBy is "name", "uploaded", "folder" or "attr:<key>"; the default
+	// keeps MongoDB's natural order.
+	SortBy   string
+	SortDesc bool
+}
+
+// conditions returns the filter as field conditions shared by the documents
+// query and the $vectorSearch filter, which accepts the same operators.
+func (f DocumentFilter) conditions() []bson.D {
+	var conds []bson.D
+	if len(f.DocumentIDs) > 0 {
+		conds = append(conds, bson.D{{Key: "document_id", Value: bson.D{{Key: "$in", Value: f.DocumentIDs}}}})
+	}
+	if f.Folder != "" {
+		conds = append(conds, bson.D{{Key: "folder", Value: bson.D{{Key: "$eq", Value: NormalizeFolder(f.Folder)}}}})
+	}
+	for _, tag := range NormalizeTags(f.Tags) {
+		conds = append(conds, bson.D{{Key: "tags", Value: bson.D{{Key: "$eq", Value: tag}}}})
+	}
+	for _, attr := range f.Attributes {
+		conds = append(conds, bson.D{{Key: "attributes." + attr.Key, Value: bson.D{{Key: attributeOps[attr.Op], Value: attr.Value}}}})
+	}
+	if !f.UploadedAfter.IsZero() {
+		conds = append(conds, bson.D{{Key: "uploaded_at", Value: bson.D{{Key: "$gte", Value: f.UploadedAfter}}}})
+	}
+	if !f.UploadedBefore.IsZero() {
+		conds = append(conds, bson.D{{Key: "uploaded_at", Value: bson.D{{Key: "$lt", Value: f.UploadedBefore}}}})
+	}
+	return conds
+}
+
+func (f DocumentFilter) documentQuery(userID string) bson.D {
+	query := bson.D{{Key: "user_id", Value: userID}}
+	if len(f.DocumentIDs) > 0 {
+		// Documents are matched on _id, chunks on document_id
+		query = append(query, bson.E{Key: "_id", Value: bson.D{{Key: "$in", Value: f.DocumentIDs}}})
+		f.DocumentIDs = nil
+	}
+	if conds := f.conditions(); len(conds) > 0 {
+		query = append(query, bson.E{Key: "$and", Value: conds})
+	}
+	return query
+}
+
+// vectorSearchFilter returns the value for the filter field of $vectorSearch
+// and the conditions on attributes other than the indexed ones, which the
+// index cannot filter on and have to be matched after ranking. The user is
+// always part of the filter so the index only ranks that user's chunks.
+func (f DocumentFilter) vectorSearchFilter(userID string, indexed []string) (bson.D, []bson.D) {
+	var after DocumentFilter
+	before := f
+	before.Attributes = nil
+	for _, attr := range f.Attributes {
+		if slices.Contains(indexed, attr.Key) {
+			before.Attributes = append(before.Attributes, attr)
+		} else {
+			after.Attributes = append(after.Attributes, attr)
+		}
+	}
+
+	conds := append([]bson.D{{{Key: "user_id", Value: bson.D{{Key: "$eq", Value: userID}}}}}, before.conditions()...)
+	if len(conds) == 1 {
+		return conds[0], after.conditions()
+	}
+	return bson.D{{Key: "$and", Value: conds}}, after.conditions()
+}
+
+func (f DocumentFilter) sort() bson.D {
+	order := 1
+	if f.SortDesc {
+		order = -1
+	}
+	switch {
+	case f.SortBy == "name":
+		return bson.D{{Key: "filename", Value: order}}
+	case f.SortBy == "uploaded":
+		return bson.D{{Key: "uploaded_at", Value: order}}
+	case f.SortBy == "folder":
+		return bson.D{{Key: "folder", Value: order}, {Key: "filename", Value: 1}}
+	case strings.HasPrefix(f.SortBy, "attr:") && config.ValidAttributeKey(f.SortBy[5:]):
+		return bson.D{{Key: "attributes." + f.SortBy[5:], Value: order}}
+	default:
+		return bson.D{}
+	}
+}
+
+// Matches reports whether doc passes the filter, for stores that filter
+// in Go rather than in MongoDB. It follows the semantics of conditions.
+func (f DocumentFilter) Matches(doc *Document) bool {
+	if len(f.DocumentIDs) > 0 && !slices.Contains(f.DocumentIDs, doc.ID) {
+		return false
+	}
+	if f.Folder != "" && doc.Folder != NormalizeFolder(f.Folder) {
+		return false
+	}
+	for _, tag := range NormalizeTags(f.Tags) {
+		if !slices.Contains(doc.Tags, tag) {
+			return false
+		}
+	}
+	for _, attr := range f.Attributes {
+		value, ok := doc.Attributes[attr.Key]
+		if !attr.matches(value, ok) {
+			return false
+		}
+	}
+	if !f.UploadedAfter.IsZero() && doc.UploadedAt.Before(f.UploadedAfter) {
+		return false
+	}
+	if !f.UploadedBefore.IsZero() && !doc.UploadedAt.Before(f.UploadedBefore) {
+		return false
+	}
+	return true
+}
+
+// matches compares a document's attribute value like MongoDB would: values
+// of different types never match, except for ne, which also matches missing
+// attributes.
+func (a AttributeFilter) matches(value interface{}, ok bool) bool {
+	if !ok {
+		return a.Op == "ne"
+	}
+	c, comparable := compareValues(value, a.Value)
+	if !comparable {
+		return a.Op == "ne"
+	}
+	switch a.Op {
+	case "eq":
+		return c == 0
+	case "ne":
+		return c != 0
+	case "gt":
+		return c > 0
+	case "gte":
+		return c >= 0
+	case "lt":
+		return c < 0
+	case "lte":
+		return c <= 0
+	default:
+		return false
+	}
+}
+
+// Compare orders two documents like sort does, for stores that sort in Go.
+// Documents with equal sort keys compare by id.
+func (f DocumentFilter) Compare(a, b *Document) int {
+	var c int
+	switch {
+	case f.SortBy == "name":
+		c = strings.Compare(a.Filename, b.Filename)
+	case f.SortBy == "uploaded":
+		c = a.UploadedAt.Compare(b.UploadedAt)
+	case f.SortBy == "folder":
+		c = strings.Compare(a.Folder, b.Folder)
+	case strings.HasPrefix(f.SortBy, "attr:"):
+		c, _ = compareValues(a.Attributes[f.SortBy[5:]], b.Attributes[f.SortBy[5:]])
+	}
+	if f.SortDesc {
+		c = -c
+	}
+	if c == 0 && f.SortBy == "folder" {
+		c = strings.Compare(a.Filename, b.Filename)
+	}
+	if c == 0 {
+		c = strings.Compare(a.ID.Hex(), b.ID.Hex())
+	}
+	return c
+}
+
+// compareValues compares two attribute values of the same type and reports
+// whether they could be compared at all.
+func compareValues(a, b interface{}) (int, bool) {
+	if t, ok := a.(primitive.DateTime); ok {
+		a = t.Time()
+	}
+	if t, ok := b.(primitive.DateTime); ok {
+		b = t.Time()
+	}
+	switch x := a.(type) {
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y), true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	default:
+		if x, ok := toFloat(a); ok {
+			if y, ok := toFloat(b); ok {
+				return cmp.Compare(x, y), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// GetDocument returns a document without its content.
+func (ms *MongoStorage) GetDocument(id string, userID string) (*Document, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrDocumentNotFound
+	}
+
+	var doc Document
+	coll := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+	opts := options.FindOne().SetProjection(bson.M{"content": 0})
+	err = coll.FindOne(ctx, bson.M{"_id": objectID, "user_id": userID}, opts).Decode(&doc)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrDocumentNotFound
+		}
+		return nil, err
+	}
+
+	return &doc, nil
+}
+
+// SetTags replaces the document's tags.
+func (ms *MongoStorage) SetTags(id string, tags []string, userID string) error {
+	tags = NormalizeTags(tags)
+	update := bson.M{"$set": bson.M{"tags": tags}}
+	if len(tags) == 0 {
+		update = bson.M{"$unset": bson.M{"tags": ""}}
+	}
+	return ms.updateDocumentByHex(id, userID, update)
+}
+
+func (ms *MongoStorage) RemoveTag(id string, tag string, userID string) error {
+	return ms.updateDocumentByHex(id, userID, bson.M{"$pull": bson.M{"tags": strings.ToLower(strings.TrimSpace(tag))}})
+}
+
+// SetAttribute stores a typed value, as returned by ParseAttribute, under key.
+func (ms *MongoStorage) SetAttribute(id string, key string, value interface{}, userID string) error {
+	if !config.ValidAttributeKey(key) {
+		return ErrInvalidAttributeKey
+	}
+	if err := ms.updateDocumentByHex(id, userID, bson.M{"$set": bson.M{"attributes." + key: value}}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (ms *MongoStorage) RemoveAttribute(id string, key string, userID string) error {
+	if !config.ValidAttributeKey(key) {
+		return ErrInvalidAttributeKey
+	}
+	return ms.updateDocumentByHex(id, userID, bson.M{"$unset": bson.M{"attributes." + key: ""}})
+}
+
+func (ms *MongoStorage) updateDocumentByHex(id string, userID string, update bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrDocumentNotFound
+	}
+	return ms.updateDocument(ctx, objectID, userID, update)
+}
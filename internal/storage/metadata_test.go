@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseAttribute(t *testing.T) {
+	n, err := ParseAttribute(AttributeNumber, " 1250.5 ")
+	if err != nil || n != 1250.5 {
+		t.Errorf("Expected 1250.5, got %v (%v)", n, err)
+	}
+
+	d, err := ParseAttribute(AttributeDate, "2024-03")
+	if err != nil || !d.(time.Time).Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected 2024-03-01, got %v (%v)", d, err)
+	}
+
+	if _, err := ParseAttribute(AttributeNumber, "twelve"); err == nil {
+		t.Error("Expected an error for a non-numeric value")
+	}
+	if _, err := ParseAttribute("boolean", "true"); err == nil {
+		t.Error("Expected an error for an unknown type")
+	}
+}
+
+func TestParseAttributeFilter(t *testing.T) {
+	filter, err := ParseAttributeFilter("amount:gte:100")
+	if err != nil {
+		t.Fatalf("ParseAttributeFilter failed: %+v", err)
+	}
+	if filter.Key != "amount" || filter.Op != "gte" || filter.Value != 100.0 {
+		t.Errorf("Unexpected filter: %+v", filter)
+	}
+
+	filter, err = ParseAttributeFilter("client:acme")
+	if err != nil || filter.Op != "eq" || filter.Value != "acme" {
+		t.Errorf("Unexpected filter: %+v (%v)", filter, err)
+	}
+
+	for _, expr := range []string{"amount", "amount:between:1", "a.b:eq:1", "$where:eq:1", "client:gt:acme"} {
+		if _, err := ParseAttributeFilter(expr); err == nil {
+			t.Errorf("Expected an error for %q", expr)
+		}
+	}
+}
+
+func TestVectorSearchFilter(t *testing.T) {
+	before, after := DocumentFilter{}.vectorSearchFilter("u1", nil)
+	if len(after) != 0 {
+		t.Errorf("Expected no conditions after ranking, got %+v", after)
+	}
+	got, err := bson.MarshalExtJSON(before, false, false)
+	if err != nil {
+		t.Fatalf("Failed to marshal filter: %+v", err)
+	}
+	if expected := `{"user_id":{"$eq":"u1"}}`; string(got) != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := DocumentFilter{
+		Tags:          []string{"Legal"},
+		UploadedAfter: from,
+		Attributes:    []AttributeFilter{{"language", "eq", "de"}, {"amount", "gt", 100.0}},
+	}
+	before, after = filter.vectorSearchFilter("u1", []string{"language"})
+	got, err = bson.MarshalExtJSON(before, false, false)
+	if err != nil {
+		t.Fatalf("Failed to marshal filter: %+v", err)
+	}
+
+	expected := `{"$and":[{"user_id":{"$eq":"u1"}},{"tags":{"$eq":"legal"}},{"attributes.language":{"$eq":"de"}},{"uploaded_at":{"$gte":{"$date":"2024-01-01T00:00:00Z"}}}]}`
+	if string(got) != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+
+	got, err = bson.MarshalExtJSON(bson.D{{Key: "$and", Value: after}}, false, false)
+	if err != nil {
+		t.Fatalf("Failed to marshal conditions: %+v", err)
+	}
+	if expected := `{"$and":[{"attributes.amount":{"$gt":100.0}}]}`; string(got) != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestDocumentFilterMatches(t *testing.T) {
+	doc := &Document{
+		Folder:     "contracts/2024",
+		Tags:       []string{"legal", "signed"},
+		Attributes: map[string]interface{}{"amount": 1250.5, "client": "acme"},
+		UploadedAt: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		filter DocumentFilter
+		want   bool
+	}{
+		{DocumentFilter{}, true},
+		{DocumentFilter{Folder: "/contracts/2024/"}, true},
+		{DocumentFilter{Folder: "contracts"}, false},
+		{DocumentFilter{Tags: []string{"Legal", "signed"}}, true},
+		{DocumentFilter{Tags: []string{"legal", "draft"}}, false},
+		{DocumentFilter{Attributes: []AttributeFilter{{"amount", "gte", 1000.0}}}, true},
+		{DocumentFilter{Attributes: []AttributeFilter{{"amount", "lt", 1000.0}}}, false},
+		{DocumentFilter{Attributes: []AttributeFilter{{"amount", "eq", "1250.5"}}}, false},
+		{DocumentFilter{Attributes: []AttributeFilter{{"region", "ne", "eu"}}}, true},
+		{DocumentFilter{UploadedAfter: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}, true},
+		{DocumentFilter{UploadedBefore: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.Matches(doc); got != tt.want {
+			t.Errorf("Matches(%+v) = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestVectorIndexDefinition(t *testing.T) {
+	def := newVectorIndexDefinition(1536, []string{"amount"})
+	if len(def.Fields) != 1+len(vectorFilterPaths)+1 {
+		t.Fatalf("Unexpected number of fields: %+v", def.Fields)
+	}
+	if def.Fields[0].Path != "embedding" || def.Fields[0].NumDimensions != 1536 {
+		t.Errorf("Unexpected vector field: %+v", def.Fields[0])
+	}
+
+	reordered := vectorIndexDefinition{Fields: append([]vectorIndexField{}, def.Fields...)}
+	reordered.Fields[1], reordered.Fields[2] = reordered.Fields[2], reordered.Fields[1]
+	if !def.equal(reordered) {
+		t.Error("Expected definitions with reordered fields to be equal")
+	}
+	if def.equal(newVectorIndexDefinition(3072, []string{"amount"})) {
+		t.Error("Expected definitions with different dimensions to differ")
+	}
+}
@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	migrationsCollection = "schema_migrations"
+	locksCollection      = "locks"
+	migrationLockID      = "schema_migrations"
+
+	// migrationLockTTL bounds how long a crashed process can block others.
+	// The holder renews the lock while it works.
+	migrationLockTTL = 2 * time.Minute
+)
+
+var (
+	// ErrMigrationLocked is returned when another process is running
+	// migrations.
+	ErrMigrationLocked = errors.New("migrations are being run by another process")
+	// ErrLockLost stops work whose lock could not be renewed, since another
+	// process may hold it by now.
+	ErrLockLost = errors.New("lost the lock")
+)
+
+// Migration is one numbered, reversible change to the data. Up and Down get
+// the database and whether this is a dry run, in which case they should only
+// log what they would change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database, dryRun bool) error
+	Down    func(ctx context.Context, db *mongo.Database, dryRun bool) error
+}
+
+// MigrationStatus pairs a known migration with when it was applied, if ever.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+func (s MigrationStatus) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+type migrationRecord struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+	Duration  int64     `bson:"duration_ms"`
+}
+
+// MigrationStatus lists every known migration in order.
+func (ms *MongoStorage) MigrationStatus() ([]MigrationStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	applied, err := ms.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: applied[m.Version].AppliedAt}
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies every pending migration up to and including target, or
+// all of them when target is 0. It returns the migrations that were applied,
+// or that would be in a dry run.
+func (ms *MongoStorage) MigrateUp(ctx context.Context, target int, dryRun bool) ([]Migration, error) {
+	return ms.migrate(ctx, true, target, dryRun)
+}
+
+// MigrateDown reverts applied migrations newer than target, newest first. A
+// target of 0 reverts all of them.
+func (ms *MongoStorage) MigrateDown(ctx context.Context, target int, dryRun bool) ([]Migration, error) {
+	return ms.migrate(ctx, false, target, dryRun)
+}
+
+func (ms *MongoStorage) migrate(ctx context.Context, up bool, target int, dryRun bool) (_ []Migration, err error) {
+	ctx, release, err := ms.acquireLock(ctx, migrationLockID, migrationLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	defer func() { err = lockError(ctx, err) }()
+
+	applied, err := ms.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := planMigrations(migrations, applied, up, target)
+	db := ms.client.Database(ms.database)
+	coll := db.Collection(migrationsCollection)
+
+	for i, m := range plan {
+		direction := "up"
+		if !up {
+			direction = "down"
+		}
+		if dryRun {
+			log.Printf("Dry run: migration %04d %s (%s)", m.Version, m.Name, direction)
+		} else {
+			log.Printf("Running migration %04d %s (%s)", m.Version, m.Name, direction)
+		}
+
+		start := time.Now()
+		run := m.Up
+		if !up {
+			run = m.Down
+		}
+		if err := run(ctx, db, dryRun); err != nil {
+			log.Printf("Error running migration %04d %s: %+v", m.Version, m.Name, err)
+			return plan[:i], fmt.Errorf("migration %04d %s: %w", m.Version, m.Name, err)
+		}
+		if dryRun {
+			continue
+		}
+
+		if up {
+			_, err = coll.InsertOne(ctx, migrationRecord{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+				Duration:  time.Since(start).Milliseconds(),
+			})
+		} else {
+			_, err = coll.DeleteOne(ctx, bson.M{"_id": m.Version})
+		}
+		if err != nil {
+			log.Printf("Error recording migration %04d: %+v", m.Version, err)
+			return plan[:i], err
+		}
+	}
+
+	return plan, nil
+}
+
+// planMigrations picks the migrations to run, in the order to run them.
+func planMigrations(all []Migration, applied map[int]migrationRecord, up bool, target int) []Migration {
+	var plan []Migration
+	if up {
+		for _, m := range all {
+			if _, ok := applied[m.Version]; !ok && (target == 0 || m.Version <= target) {
+				plan = append(plan, m)
+			}
+		}
+		return plan
+	}
+
+	for i := len(all) - 1; i >= 0; i-- {
+		m := all[i]
+		if _, ok := applied[m.Version]; !ok || m.Version <= target {
+			continue
+		}
+		plan = append(plan, m)
+	}
+	return plan
+}
+
+func (ms *MongoStorage) appliedMigrations(ctx context.Context) (map[int]migrationRecord, error) {
+	coll := ms.client.Database(ms.database).Collection(migrationsCollection)
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	applied := make(map[int]migrationRecord)
+	for cursor.Next(ctx) {
+		var record migrationRecord
+		if err := cursor.Decode(&record); err != nil {
+			return nil, err
+		}
+		applied[record.Version] = record
+	}
+	return applied, cursor.Err()
+}
+
+// acquireLock takes a lease on a lock document shared by every process using
+// the database, and keeps renewing it until the returned release is called.
+// A lease left behind by a crashed process expires after ttl. The work is to
+// run under the returned context, which is cancelled with ErrLockLost as
+// soon as a renewal fails.
+func (ms *MongoStorage) acquireLock(ctx context.Context, id string, ttl time.Duration) (context.Context, func(), error) {
+	coll := ms.client.Database(ms.database).Collection(locksCollection)
+	owner := lockOwner()
+
+	lease := func() error {
+		now := time.Now()
+		filter := bson.M{"_id": id, "$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expires_at": bson.M{"$lt": now}},
+		}}
+		update := bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl)}}
+		_, err := coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if mongo.IsDuplicateKeyError(err) {
+			// The lock exists and is held by someone else
+			return ErrMigrationLocked
+		}
+		return err
+	}
+
+	if err := lease(); err != nil {
+		return nil, nil, err
+	}
+
+	work, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := lease(); err != nil {
+					log.Printf("Error renewing lock %s, stopping: %+v", id, err)
+					cancel(fmt.Errorf("%w %s: %v", ErrLockLost, id, err))
+					return
+				}
+			}
+		}
+	}()
+
+	return work, func() {
+		close(done)
+		cancel(nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := coll.DeleteOne(ctx, bson.M{"_id": id, "owner": owner}); err != nil {
+			log.Printf("Error releasing lock %s: %+v", id, err)
+		}
+	}, nil
+}
+
+// lockError returns why the context of a lock was cancelled in place of the
+// bare context error err, so losing the lock is reported as such.
+func lockError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+// lockOwner identifies this process, preferring the Fly machine ID.
+func lockOwner() string {
+	host := os.Getenv("FLY_MACHINE_ID")
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(b))
+}
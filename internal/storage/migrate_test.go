@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPlanMigrations(t *testing.T) {
+	all := []Migration{{Version: 1}, {Version: 2}, {Version: 3}, {Version: 4}}
+	applied := map[int]migrationRecord{1: {Version: 1}, 2: {Version: 2}}
+
+	versions := func(plan []Migration) []int {
+		var v []int
+		for _, m := range plan {
+			v = append(v, m.Version)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name     string
+		up       bool
+		target   int
+		expected []int
+	}{
+		{"up to latest", true, 0, []int{3, 4}},
+		{"up to target", true, 3, []int{3}},
+		{"down to target", false, 1, []int{2}},
+		{"down all", false, 0, []int{2, 1}},
+	}
+	for _, tt := range tests {
+		got := versions(planMigrations(all, applied, tt.up, tt.target))
+		if len(got) != len(tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+				break
+			}
+		}
+	}
+}
+
+func TestLockError(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	if err := lockError(ctx, nil); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	cancel(fmt.Errorf("%w schema_migrations: connection reset", ErrLockLost))
+	if err := lockError(ctx, ctx.Err()); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Expected ErrLockLost once the lock is lost, got %v", err)
+	}
+	if err := lockError(ctx, nil); err != nil {
+		t.Errorf("Expected work that finished to succeed, got %v", err)
+	}
+}
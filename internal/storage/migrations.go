@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrations lists every migration in version order. Versions are never
+// reused, and a migration is never edited once it has been deployed.
+var migrations = []Migration{
+	{Version: 1, Name: "document_sizes", Up: upDocumentSizes, Down: downDocumentSizes},
+	{Version: 2, Name: "document_hashes", Up: upDocumentHashes, Down: downDocumentHashes},
+	{Version: 3, Name: "upload_dates", Up: upUploadDates, Down: downUploadDates},
+}
+
+// missingSizeFilter matches documents uploaded before SaveFile recorded sizes.
+var missingSizeFilter = bson.M{"metadata.size": bson.M{"$exists": false}}
+
+// upDocumentSizes fills in metadata.size from the stored content. The size is
+// computed by the server so the content never has to be read by the app.
+func upDocumentSizes(ctx context.Context, db *mongo.Database, dryRun bool) error {
+	coll := db.Collection("documents")
+	if dryRun {
+		n, err := coll.CountDocuments(ctx, missingSizeFilter)
+		if err != nil {
+			return err
+		}
+		log.Printf("Would set the size of %d documents", n)
+		return nil
+	}
+
+	result, err := coll.UpdateMany(ctx, missingSizeFilter, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"metadata.size": bson.M{"$toString": bson.M{"$binarySize": "$content"}}}}},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Set the size of %d documents", result.ModifiedCount)
+	return nil
+}
+
+// downDocumentSizes keeps the sizes: they are correct either way and
+// documents uploaded since then have them too.
+func downDocumentSizes(ctx context.Context, db *mongo.Database, dryRun bool) error {
+	return nil
+}
+
+// missingHashFilter matches documents uploaded before SaveFile recorded
+// content hashes.
+var missingHashFilter = bson.M{"metadata.sha256": bson.M{"$exists": false}}
+
+// upDocumentHashes fills in metadata.sha256. MongoDB cannot hash in an
+// update, so every document's content is read once.
+func upDocumentHashes(ctx context.Context, db *mongo.Database, dryRun bool) error {
+	coll := db.Collection("documents")
+	if dryRun {
+		n, err := coll.CountDocuments(ctx, missingHashFilter)
+		if err != nil {
+			return err
+		}
+		log.Printf("Would hash the content of %d documents", n)
+		return nil
+	}
+
+	cursor, err := coll.Find(ctx, missingHashFilter, options.Find().SetProjection(bson.M{"content": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	hashed := 0
+	for cursor.Next(ctx) {
+		var doc Document
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		_, err := coll.UpdateByID(ctx, doc.ID, bson.M{"$set": bson.M{"metadata.sha256": ContentHash(doc.Content.Data)}})
+		if err != nil {
+			return err
+		}
+		hashed++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	log.Printf("Hashed the content of %d documents", hashed)
+	return nil
+}
+
+// downDocumentHashes keeps the hashes for the same reason as
+// downDocumentSizes.
+func downDocumentHashes(ctx context.Context, db *mongo.Database, dryRun bool) error {
+	return nil
+}
+
+// missingUploadDateFilter matches documents, and chunks, stored before
+// SaveFile recorded uploaded_at. Filters on the upload date would leave
+// them out.
+var missingUploadDateFilter = bson.M{"uploaded_at": bson.M{"$exists": false}}
+
+// upUploadDates sets uploaded_at from metadata.uploadDate, or from the
+// creation time of the ID when that is missing or malformed, and copies
+// the folder, tags, attributes and upload date of every document onto its
+// chunks, as SaveFile does, so that $vectorSearch filters match them.
+func upUploadDates(ctx context.Context, db *mongo.Database, dryRun bool) error {
+	docsColl := db.Collection("documents")
+	chunkCollections, err := db.ListCollectionNames(ctx, bson.M{"name": bson.M{"$regex": "^chunks"}})
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		n, err := docsColl.CountDocuments(ctx, missingUploadDateFilter)
+		if err != nil {
+			return err
+		}
+		log.Printf("Would set the upload date of %d documents", n)
+		for _, name := range chunkCollections {
+			n, err := db.Collection(name).CountDocuments(ctx, missingUploadDateFilter)
+			if err != nil {
+				return err
+			}
+			log.Printf("Would copy document fields onto %d chunks in %s", n, name)
+		}
+		return nil
+	}
+
+	result, err := docsColl.UpdateMany(ctx, missingUploadDateFilter, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"uploaded_at": bson.M{"$dateFromString": bson.M{
+			"dateString": "$metadata.uploadDate",
+			"onError":    bson.M{"$toDate": "$_id"},
+			"onNull":     bson.M{"$toDate": "$_id"},
+		}}}}},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Set the upload date of %d documents", result.ModifiedCount)
+
+	for _, name := range chunkCollections {
+		chunksColl := db.Collection(name)
+		ids, err := chunksColl.Distinct(ctx, "document_id", missingUploadDateFilter)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		cursor, err := docsColl.Find(ctx, bson.M{"_id": bson.M{"$in": ids}},
+			options.Find().SetProjection(bson.M{"folder": 1, "tags": 1, "attributes": 1, "uploaded_at": 1}))
+		if err != nil {
+			return err
+		}
+		var updated int64
+		for cursor.Next(ctx) {
+			var doc Document
+			if err := cursor.Decode(&doc); err != nil {
+				cursor.Close(ctx)
+				return err
+			}
+			set := bson.M{"uploaded_at": doc.UploadedAt}
+			if doc.Folder != "" {
+				set["folder"] = doc.Folder
+			}
+			if len(doc.Tags) > 0 {
+				set["tags"] = doc.Tags
+			}
+			if len(doc.Attributes) > 0 {
+				set["attributes"] = doc.Attributes
+			}
+			filter := bson.M{"document_id": doc.ID, "uploaded_at": bson.M{"$exists": false}}
+			result, err := chunksColl.UpdateMany(ctx, filter, bson.M{"$set": set})
+			if err != nil {
+				cursor.Close(ctx)
+				return err
+			}
+			updated += result.ModifiedCount
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Copied document fields onto %d chunks in %s", updated, name)
+	}
+	return nil
+}
+
+// downUploadDates keeps the upload dates for the same reason as
+// downDocumentSizes.
+func downUploadDates(ctx context.Context, db *mongo.Database, dryRun bool) error {
+	return nil
+}
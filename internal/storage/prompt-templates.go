@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const promptTemplatesCollection = "prompt_templates"
+
+var ErrPromptTemplateNotFound = errors.New("prompt template not found")
+
+// PromptTemplate is one version of a prompt written as a text/template, see
+// package prompts. Versions are never changed: saving a template again adds
+// the next version, so conversations pinned to an older one keep it.
+type PromptTemplate struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind        string             `bson:"kind" json:"kind"`
+	Name        string             `bson:"name" json:"name"`
+	Version     int                `bson:"version" json:"version"`
+	Text        string             `bson:"text" json:"text"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	CreatedBy   string             `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
+
+func promptTemplateIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{{
+		Keys:    bson.D{{Key: "kind", Value: 1}, {Key: "name", Value: 1}, {Key: "version", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	}}
+}
+
+// maxVersionAttempts bounds the retries of SavePromptTemplate when another
+// admin saves the same template at once.
+const maxVersionAttempts = 3
+
+// SavePromptTemplate stores template as the next version of its kind and
+// name, starting at 1, and returns it with its ID and version set.
+func (ms *MongoStorage) SavePromptTemplate(template PromptTemplate) (*PromptTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(promptTemplatesCollection)
+	template.ID = primitive.NilObjectID
+	template.CreatedAt = time.Now()
+	for attempt := 1; ; attempt++ {
+		latest, err := ms.latestPromptTemplate(ctx, template.Kind, template.Name)
+		switch {
+		case err == ErrPromptTemplateNotFound:
+			template.Version = 1
+		case err != nil:
+			return nil, err
+		default:
+			template.Version = latest.Version + 1
+		}
+
+		// The unique index refuses a version someone else just took
+		result, err := coll.InsertOne(ctx, template)
+		if mongo.IsDuplicateKeyError(err) && attempt < maxVersionAttempts {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error saving prompt template: %+v", err)
+			return nil, err
+		}
+		template.ID = result.InsertedID.(primitive.ObjectID)
+		return &template, nil
+	}
+}
+
+// GetPromptTemplate returns a version of the template, or the latest one
+// when version is 0.
+func (ms *MongoStorage) GetPromptTemplate(kind, name string, version int) (*PromptTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if version == 0 {
+		return ms.latestPromptTemplate(ctx, kind, name)
+	}
+	var template PromptTemplate
+	coll := ms.client.Database(ms.database).Collection(promptTemplatesCollection)
+	err := coll.FindOne(ctx, bson.M{"kind": kind, "name": name, "version": version}).Decode(&template)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPromptTemplateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+func (ms *MongoStorage) latestPromptTemplate(ctx context.Context, kind, name string) (*PromptTemplate, error) {
+	var template PromptTemplate
+	coll := ms.client.Database(ms.database).Collection(promptTemplatesCollection)
+	err := coll.FindOne(ctx, bson.M{"kind": kind, "name": name},
+		options.FindOne().SetSort(bson.M{"version": -1})).Decode(&template)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPromptTemplateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// ListPromptTemplates returns the latest version of every template of kind,
+// or of every kind when it is empty, sorted by kind and name.
+func (ms *MongoStorage) ListPromptTemplates(kind string) ([]PromptTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	match := bson.M{}
+	if kind != "" {
+		match["kind"] = kind
+	}
+	coll := ms.client.Database(ms.database).Collection(promptTemplatesCollection)
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "kind", Value: 1}, {Key: "name", Value: 1}, {Key: "version", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": bson.M{"kind": "$kind", "name": "$name"}, "latest": bson.M{"$first": "$$ROOT"}}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$latest"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "kind", Value: 1}, {Key: "name", Value: 1}}}},
+	})
+	if err != nil {
+		log.Printf("Error listing prompt templates: %+v", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	templates := []PromptTemplate{}
+	if err := cursor.All(ctx, &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// PromptTemplateVersions returns every version of a template, newest first.
+func (ms *MongoStorage) PromptTemplateVersions(kind, name string) ([]PromptTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(promptTemplatesCollection)
+	cursor, err := coll.Find(ctx, bson.M{"kind": kind, "name": name}, options.Find().SetSort(bson.M{"version": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	templates := []PromptTemplate{}
+	if err := cursor.All(ctx, &templates); err != nil {
+		return nil, err
+	}
+	if len(templates) == 0 {
+		return nil, ErrPromptTemplateNotFound
+	}
+	return templates, nil
+}
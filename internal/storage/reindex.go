@@ -0,0 +1,473 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	reindexJobsCollection = "reindex_jobs"
+	reindexLockID         = "reindex"
+)
+
+// Re-index job states. A job that is "running" or "built" can be resumed.
+const (
+	ReindexRunning  = "running"
+	ReindexBuilt    = "built"
+	ReindexDone     = "done"
+	ReindexSwitched = "switched"
+)
+
+var ErrNoReindexJob = errors.New("no unfinished re-index job")
+
+// ReindexOptions selects what a re-index job rebuilds.
+//
+// When Model differs from the active model every document is re-embedded
+// into a new chunks collection, which replaces the active one once it is
+// complete and its search indexes are queryable. Otherwise the matching
+// documents are re-embedded in place.
+type ReindexOptions struct {
+	Model  string
+	UserID string
+	Folder string
+	Tags   []string
+
+	// Rate limits how many documents are embedded per second; 0 means no
+	// limit.
+	Rate float64
+
+	// Resume continues the latest unfinished job instead of starting one.
+	Resume bool
+
+	// DropOld drops the previous chunks collection after switching, as long
+	// as every document made it into the new one.
+	DropOld bool
+}
+
+// ReindexJob is the checkpoint of a re-index run. Documents are processed in
+// _id order so LastDocumentID is enough to resume, and documents uploaded
+// while the job runs are picked up at the end.
+type ReindexJob struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	Model          string             `bson:"model"`
+	Source         string             `bson:"source"`
+	Target         string             `bson:"target"`
+	UserID         string             `bson:"user_id,omitempty"`
+	Folder         string             `bson:"folder,omitempty"`
+	Tags           []string           `bson:"tags,omitempty"`
+	Status         string             `bson:"status"`
+	LastDocumentID primitive.ObjectID `bson:"last_document_id,omitempty"`
+	Processed      int                `bson:"processed"`
+	Failed         int                `bson:"failed"`
+	LastError      string             `bson:"last_error,omitempty"`
+	StartedAt      time.Time          `bson:"started_at"`
+	UpdatedAt      time.Time          `bson:"updated_at"`
+}
+
+func (job *ReindexJob) inPlace() bool {
+	return job.Source == job.Target
+}
+
+func (job *ReindexJob) filter() DocumentFilter {
+	return DocumentFilter{Folder: job.Folder, Tags: job.Tags}
+}
+
+// Reindex runs a re-index job to completion. Only one job runs at a time
+// across all machines. Cancelling ctx stops the job at the last checkpoint.
+func (ms *MongoStorage) Reindex(ctx context.Context, base *ai.Embedder, opts ReindexOptions) (job *ReindexJob, err error) {
+	ctx, release, err := ms.acquireLock(ctx, reindexLockID, migrationLockTTL)
+	if err != nil {
+		if err == ErrMigrationLocked {
+			return nil, errors.New("another re-index job is running")
+		}
+		return nil, err
+	}
+	defer release()
+	defer func() { err = lockError(ctx, err) }()
+
+	if opts.Resume {
+		job, err = ms.unfinishedReindexJob(ctx)
+	} else {
+		job, err = ms.newReindexJob(ctx, base, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	embedder, err := base.WithModel(job.Model)
+	if err != nil {
+		return job, err
+	}
+
+	if job.Status == ReindexRunning {
+		if !job.inPlace() {
+			// Let every process learn of the new collection before it is
+			// filled, so none misses a change to a document already copied
+			if err := ms.startBuilding(ctx, job.Target); err != nil {
+				return job, err
+			}
+			if err := sleepContext(ctx, chunkIndexTTL); err != nil {
+				return job, err
+			}
+
+			// Indexes on the new collection are built while it fills up
+			if err := ms.ensureIndexes(ms.documentsCollection, job.Target); err != nil {
+				return job, err
+			}
+			if err := ms.ensureSearchIndexes(job.Target, embedder.Dimensions()); err != nil {
+				return job, err
+			}
+		}
+		if err := ms.reembedAll(ctx, job, embedder, opts.Rate); err != nil {
+			return job, err
+		}
+		if job.inPlace() {
+			return job, ms.saveReindexJob(ctx, job, ReindexDone)
+		}
+		if err := ms.saveReindexJob(ctx, job, ReindexBuilt); err != nil {
+			return job, err
+		}
+	}
+
+	if job.Status == ReindexBuilt {
+		log.Printf("Waiting for the search indexes on %s", job.Target)
+		if err := ms.waitForSearchIndexes(ctx, job.Target); err != nil {
+			return job, err
+		}
+
+		// Copy the uploads that landed in the old collection since the
+		// build and retry the documents that failed. Switching while any
+		// fails would drop them from search.
+		if err := ms.catchUp(ctx, job, embedder, opts.Rate); err != nil {
+			return job, err
+		}
+		if job.Failed > 0 {
+			return job, fmt.Errorf("%d documents failed to re-embed, the last with %s; fix them and resume", job.Failed, job.LastError)
+		}
+		err := ms.switchChunkIndex(ctx, ChunkIndex{
+			Collection: job.Target,
+			Model:      job.Model,
+			Dimensions: embedder.Dimensions(),
+		})
+		if err != nil {
+			return job, err
+		}
+		log.Printf("Searches now use %s (%s)", job.Target, job.Model)
+		if err := ms.saveReindexJob(ctx, job, ReindexSwitched); err != nil {
+			return job, err
+		}
+
+		// Other processes upload into the old collection until their cached
+		// index expires; copy what they wrote once they have all switched
+		if err := sleepContext(ctx, chunkIndexTTL); err != nil {
+			return job, err
+		}
+		if err := ms.catchUp(ctx, job, embedder, opts.Rate); err != nil {
+			return job, err
+		}
+		if job.Failed > 0 {
+			return job, fmt.Errorf("%d documents uploaded during the switch failed to re-embed, the last with %s; re-index them before dropping %s", job.Failed, job.LastError, job.Source)
+		}
+
+		if opts.DropOld {
+			log.Printf("Dropping the previous chunks collection %s", job.Source)
+			if err := ms.client.Database(ms.database).Collection(job.Source).Drop(ctx); err != nil {
+				return job, err
+			}
+		}
+	}
+
+	return job, nil
+}
+
+// ReindexJobs lists the most recent re-index jobs, newest first.
+func (ms *MongoStorage) ReindexJobs(limit int64) ([]ReindexJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(reindexJobsCollection)
+	opts := options.Find().SetSort(bson.M{"started_at": -1}).SetLimit(limit)
+	cursor, err := coll.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []ReindexJob
+	err = cursor.All(ctx, &jobs)
+	return jobs, err
+}
+
+func (ms *MongoStorage) newReindexJob(ctx context.Context, base *ai.Embedder, opts ReindexOptions) (*ReindexJob, error) {
+	if _, err := ms.unfinishedReindexJob(ctx); err == nil {
+		return nil, errors.New("an unfinished re-index job exists, resume it first")
+	} else if err != ErrNoReindexJob {
+		return nil, err
+	}
+
+	active := ms.ActiveChunkIndex()
+	activeModel := ms.ActiveEmbedder(base).Model()
+
+	now := time.Now()
+	job := &ReindexJob{
+		Model:     opts.Model,
+		Source:    active.Collection,
+		Target:    active.Collection,
+		UserID:    opts.UserID,
+		Folder:    NormalizeFolder(opts.Folder),
+		Tags:      NormalizeTags(opts.Tags),
+		Status:    ReindexRunning,
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+	if job.Model == "" {
+		job.Model = activeModel
+	}
+	if job.Model != activeModel {
+		if job.UserID != "" || job.Folder != "" || len(job.Tags) > 0 {
+			return nil, errors.New("changing the embedding model re-embeds every document, so filters cannot be used")
+		}
+		job.Target = fmt.Sprintf("chunks_%s", now.UTC().Format("20060102150405"))
+	}
+
+	coll := ms.client.Database(ms.database).Collection(reindexJobsCollection)
+	result, err := coll.InsertOne(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	job.ID = result.InsertedID.(primitive.ObjectID)
+
+	log.Printf("Started re-index job %s: %s -> %s with %s", job.ID.Hex(), job.Source, job.Target, job.Model)
+	return job, nil
+}
+
+func (ms *MongoStorage) unfinishedReindexJob(ctx context.Context) (*ReindexJob, error) {
+	var job ReindexJob
+	coll := ms.client.Database(ms.database).Collection(reindexJobsCollection)
+	opts := options.FindOne().SetSort(bson.M{"started_at": -1})
+	filter := bson.M{"status": bson.M{"$in": bson.A{ReindexRunning, ReindexBuilt}}}
+	if err := coll.FindOne(ctx, filter, opts).Decode(&job); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNoReindexJob
+		}
+		return nil, err
+	}
+
+	log.Printf("Resuming re-index job %s after %d documents", job.ID.Hex(), job.Processed)
+	return &job, nil
+}
+
+// reembedAll re-embeds every matching document after the checkpoint into
+// the job's target collection, saving the checkpoint after each one.
+func (ms *MongoStorage) reembedAll(ctx context.Context, job *ReindexJob, embedder *ai.Embedder, rate float64) error {
+	docsColl := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+	target := ms.client.Database(ms.database).Collection(job.Target)
+
+	for {
+		query := job.filter().conditions()
+		if job.UserID != "" {
+			query = append(query, bson.D{{Key: "user_id", Value: job.UserID}})
+		}
+		if !job.LastDocumentID.IsZero() {
+			query = append(query, bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: job.LastDocumentID}}}})
+		}
+		filter := bson.D{}
+		if len(query) > 0 {
+			filter = bson.D{{Key: "$and", Value: query}}
+		}
+
+		opts := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(ms.ingest.ReindexBatchSize))
+		cursor, err := docsColl.Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		var docs []Document
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+		if err := ms.reembedDocuments(ctx, job, docs, target, embedder, rate); err != nil {
+			return err
+		}
+		log.Printf("Re-indexed %d documents (%d failed)", job.Processed, job.Failed)
+	}
+}
+
+// reembedDocuments re-embeds docs into target at most rate per second,
+// checkpointing the job after each one.
+func (ms *MongoStorage) reembedDocuments(ctx context.Context, job *ReindexJob, docs []Document, target *mongo.Collection, embedder *ai.Embedder, rate float64) error {
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+
+	for i := range docs {
+		start := time.Now()
+
+		docCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		err := ms.reembedDocument(docCtx, target, &docs[i], embedder)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("Error re-embedding %s: %+v", docs[i].ID.Hex(), err)
+			job.Failed++
+			job.LastError = fmt.Sprintf("%s: %v", docs[i].ID.Hex(), err)
+		}
+		job.Processed++
+		// Retried documents may come before the checkpoint
+		if bytes.Compare(docs[i].ID[:], job.LastDocumentID[:]) > 0 {
+			job.LastDocumentID = docs[i].ID
+		}
+		if err := ms.saveReindexJob(ctx, job, job.Status); err != nil {
+			return err
+		}
+
+		if wait := interval - time.Since(start); wait > 0 {
+			if err := sleepContext(ctx, wait); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// catchUp re-embeds into the job's target every document that has no
+// chunks there: uploads stored in the source collection since they were
+// copied, and documents that failed before. The document fields copied onto
+// chunks are then brought up to date. job.Failed counts the documents that
+// still failed.
+func (ms *MongoStorage) catchUp(ctx context.Context, job *ReindexJob, embedder *ai.Embedder, rate float64) error {
+	docsColl := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+	target := ms.client.Database(ms.database).Collection(job.Target)
+
+	ids, err := target.Distinct(ctx, "document_id", bson.M{})
+	if err != nil {
+		return err
+	}
+	copied := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			copied[oid] = true
+		}
+	}
+
+	cursor, err := docsColl.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var all []Document
+	if err := cursor.All(ctx, &all); err != nil {
+		return err
+	}
+	var missing []primitive.ObjectID
+	for _, doc := range all {
+		if !copied[doc.ID] {
+			missing = append(missing, doc.ID)
+		}
+	}
+
+	job.Failed = 0
+	if len(missing) > 0 {
+		log.Printf("Re-embedding %d documents missing from %s", len(missing), job.Target)
+	}
+	for len(missing) > 0 {
+		batch := missing[:min(len(missing), ms.ingest.ReindexBatchSize)]
+		missing = missing[len(batch):]
+
+		cursor, err := docsColl.Find(ctx, bson.M{"_id": bson.M{"$in": batch}})
+		if err != nil {
+			return err
+		}
+		var docs []Document
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		if err := ms.reembedDocuments(ctx, job, docs, target, embedder, rate); err != nil {
+			return err
+		}
+	}
+	return ms.syncChunkMetadata(ctx, job.Target)
+}
+
+// sleepContext waits for d unless ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// syncChunkMetadata brings the document fields copied onto chunks up to date
+// in coll and removes chunks of documents deleted while the job ran.
+func (ms *MongoStorage) syncChunkMetadata(ctx context.Context, collection string) error {
+	docsColl := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+	chunksColl := ms.client.Database(ms.database).Collection(collection)
+
+	cursor, err := docsColl.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"content": 0}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	existing := make(map[primitive.ObjectID]bool)
+	for cursor.Next(ctx) {
+		var doc Document
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		existing[doc.ID] = true
+
+		_, err := chunksColl.UpdateMany(ctx, bson.M{"document_id": doc.ID}, bson.M{"$set": bson.M{
+			"folder":     doc.Folder,
+			"tags":       doc.Tags,
+			"attributes": doc.Attributes,
+		}})
+		if err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	ids, err := chunksColl.Distinct(ctx, "document_id", bson.M{})
+	if err != nil {
+		return err
+	}
+	var deleted []interface{}
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok && !existing[oid] {
+			deleted = append(deleted, oid)
+		}
+	}
+	if len(deleted) > 0 {
+		_, err = chunksColl.DeleteMany(ctx, bson.M{"document_id": bson.M{"$in": deleted}})
+	}
+	return err
+}
+
+func (ms *MongoStorage) saveReindexJob(ctx context.Context, job *ReindexJob, status string) error {
+	job.Status = status
+	job.UpdatedAt = time.Now()
+	coll := ms.client.Database(ms.database).Collection(reindexJobsCollection)
+	_, err := coll.ReplaceOne(ctx, bson.M{"_id": job.ID}, job)
+	if err != nil {
+		log.Printf("Error saving re-index checkpoint: %+v", err)
+	}
+	return err
+}
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Atlas Search indexes on the chunks collection. Both are built
+// asynchronously by Atlas, and queries against an index that does not exist
+// yet silently return no results.
+const (
+	vectorIndexName = "chunks_embedding_index"
+	textIndexName   = "chunks_text_index"
+)
+
+// searchIndexPollInterval is how often WaitForSearchIndexes checks on Atlas.
+const searchIndexPollInterval = 5 * time.Second
+
+// ErrVectorDimensions is returned by EnsureVectorIndex when the existing
+// index was built for another embedding size. Updating it in place would
+// make every stored chunk unsearchable, so the chunks have to be re-embedded
+// into a new collection with reindex instead.
+var ErrVectorDimensions = errors.New("vector index dimensions differ from the embedding model")
+
+// vectorFilterPaths are the chunk fields DocumentFilter can pre-filter on.
+// Attributes are added for the keys listed in retrieval.filter_attributes.
+var vectorFilterPaths = []string{"user_id", "document_id", "folder", "tags", "uploaded_at"}
+
+// vectorIndexField is one entry of an Atlas vector index definition.
+type vectorIndexField struct {
+	Type          string `bson:"type"`
+	Path          string `bson:"path"`
+	NumDimensions int    `bson:"numDimensions,omitempty"`
+	Similarity    string `bson:"similarity,omitempty"`
+}
+
+type vectorIndexDefinition struct {
+	Fields []vectorIndexField `bson:"fields"`
+}
+
+type searchIndexStatus struct {
+	Name             string   `bson:"name"`
+	Status           string   `bson:"status"`
+	Queryable        bool     `bson:"queryable"`
+	LatestDefinition bson.Raw `bson:"latestDefinition"`
+}
+
+// newVectorIndexDefinition describes the embedding field and every path used
+// in the $vectorSearch filter of VectorSearch.
+func newVectorIndexDefinition(dimensions int, attributeKeys []string) vectorIndexDefinition {
+	def := vectorIndexDefinition{
+		Fields: []vectorIndexField{{
+			Type:          "vector",
+			Path:          "embedding",
+			NumDimensions: dimensions,
+			Similarity:    "cosine",
+		}},
+	}
+	for _, path := range vectorFilterPaths {
+		def.Fields = append(def.Fields, vectorIndexField{Type: "filter", Path: path})
+	}
+	for _, key := range attributeKeys {
+		def.Fields = append(def.Fields, vectorIndexField{Type: "filter", Path: "attributes." + key})
+	}
+	return def
+}
+
+// textIndexDefinition indexes chunk content for full-text search, with the
+// user and document as filterable fields.
+func textIndexDefinition() bson.D {
+	return bson.D{{Key: "mappings", Value: bson.D{
+		{Key: "dynamic", Value: false},
+		{Key: "fields", Value: bson.D{
+			{Key: "content", Value: bson.D{{Key: "type", Value: "string"}}},
+			{Key: "user_id", Value: bson.D{{Key: "type", Value: "token"}}},
+			{Key: "document_id", Value: bson.D{{Key: "type", Value: "objectId"}}},
+		}},
+	}}}
+}
+
+func (d vectorIndexDefinition) dimensions() int {
+	for _, field := range d.Fields {
+		if field.Type == "vector" {
+			return field.NumDimensions
+		}
+	}
+	return 0
+}
+
+// equal compares definitions regardless of field order.
+func (d vectorIndexDefinition) equal(other vectorIndexDefinition) bool {
+	if len(d.Fields) != len(other.Fields) {
+		return false
+	}
+	seen := make(map[vectorIndexField]bool, len(d.Fields))
+	for _, field := range d.Fields {
+		seen[field] = true
+	}
+	for _, field := range other.Fields {
+		if !seen[field] {
+			return false
+		}
+	}
+	return true
+}
+
+// EnsureSearchIndexes creates the vector and text indexes on the active
+// chunks collection when they are missing. It does not wait for Atlas to
+// build them, see WaitForSearchIndexes.
+func (ms *MongoStorage) EnsureSearchIndexes(dimensions int) error {
+	return ms.ensureSearchIndexes(ms.ActiveChunkIndex().Collection, dimensions)
+}
+
+func (ms *MongoStorage) ensureSearchIndexes(collection string, dimensions int) error {
+	if err := ms.ensureVectorIndex(collection, dimensions); err != nil {
+		return err
+	}
+	return ms.ensureTextIndex(collection)
+}
+
+// EnsureVectorIndex creates the Atlas vector index on the active chunks
+// collection, or updates it when the filter fields have changed. An index
+// with other dimensions is left alone, see ErrVectorDimensions. Atlas builds
+// the index asynchronously, so it may not be queryable right away.
+func (ms *MongoStorage) EnsureVectorIndex(dimensions int) error {
+	return ms.ensureVectorIndex(ms.ActiveChunkIndex().Collection, dimensions)
+}
+
+func (ms *MongoStorage) ensureVectorIndex(collection string, dimensions int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	want := newVectorIndexDefinition(dimensions, ms.filterAttributes)
+
+	coll := ms.client.Database(ms.database).Collection(collection)
+	current, err := ms.searchIndex(ctx, coll, vectorIndexName)
+	if err != nil {
+		return err
+	}
+
+	if current == nil {
+		log.Printf("Creating vector index %s with %d dimensions", vectorIndexName, dimensions)
+		_, err = coll.SearchIndexes().CreateOne(ctx, mongo.SearchIndexModel{
+			Definition: want,
+			Options:    options.SearchIndexes().SetName(vectorIndexName).SetType("vectorSearch"),
+		})
+	} else {
+		var have vectorIndexDefinition
+		if err := bson.Unmarshal(current.LatestDefinition, &have); err != nil {
+			return fmt.Errorf("decoding vector index definition: %w", err)
+		}
+		if d := have.dimensions(); d != 0 && d != dimensions {
+			return fmt.Errorf("%w: %s on %s has %d, the model produces %d; run reindex -model to re-embed into a new collection",
+				ErrVectorDimensions, vectorIndexName, collection, d, dimensions)
+		}
+		if !have.equal(want) {
+			log.Printf("Updating vector index %s", vectorIndexName)
+			err = coll.SearchIndexes().UpdateOne(ctx, vectorIndexName, want)
+		}
+	}
+	if err != nil {
+		log.Printf("Error ensuring vector index: %+v", err)
+	}
+	return err
+}
+
+func (ms *MongoStorage) ensureTextIndex(collection string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(collection)
+	current, err := ms.searchIndex(ctx, coll, textIndexName)
+	if err != nil || current != nil {
+		return err
+	}
+
+	log.Printf("Creating text index %s", textIndexName)
+	_, err = coll.SearchIndexes().CreateOne(ctx, mongo.SearchIndexModel{
+		Definition: textIndexDefinition(),
+		Options:    options.SearchIndexes().SetName(textIndexName).SetType("search"),
+	})
+	if err != nil {
+		log.Printf("Error creating text index: %+v", err)
+	}
+	return err
+}
+
+// WaitForSearchIndexes blocks until every search index on the active chunks
+// collection is queryable, or ctx is done. An index that failed to build is
+// reported as an error straight away.
+func (ms *MongoStorage) WaitForSearchIndexes(ctx context.Context) error {
+	return ms.waitForSearchIndexes(ctx, ms.ActiveChunkIndex().Collection)
+}
+
+// SearchIndexesReady checks once whether every search index on the active
+// chunks collection is queryable. Indexes that are still building are not
+// an error, a missing or failed index is.
+func (ms *MongoStorage) SearchIndexesReady(ctx context.Context) (bool, error) {
+	coll := ms.client.Database(ms.database).Collection(ms.ActiveChunkIndex().Collection)
+	pending, err := ms.pendingSearchIndexes(ctx, coll)
+	return err == nil && len(pending) == 0, err
+}
+
+func (ms *MongoStorage) waitForSearchIndexes(ctx context.Context, collection string) error {
+	coll := ms.client.Database(ms.database).Collection(collection)
+	for {
+		pending, err := ms.pendingSearchIndexes(ctx, coll)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		log.Printf("Waiting for search indexes: %s", strings.Join(pending, ", "))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(searchIndexPollInterval):
+		}
+	}
+}
+
+func (ms *MongoStorage) searchIndex(ctx context.Context, coll *mongo.Collection, name string) (*searchIndexStatus, error) {
+	cursor, err := coll.SearchIndexes().List(ctx, options.SearchIndexes().SetName(name))
+	if err != nil {
+		return nil, fmt.Errorf("listing search indexes: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		return nil, cursor.Err()
+	}
+	var status searchIndexStatus
+	if err := cursor.Decode(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// pendingSearchIndexes lists the search indexes on coll that are not
+// queryable yet, with their status.
+func (ms *MongoStorage) pendingSearchIndexes(ctx context.Context, coll *mongo.Collection) ([]string, error) {
+	pending := []string{}
+	for _, name := range []string{vectorIndexName, textIndexName} {
+		status, err := ms.searchIndex(ctx, coll, name)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case status == nil:
+			return nil, fmt.Errorf("search index %s does not exist", name)
+		case status.Status == "FAILED":
+			return nil, fmt.Errorf("search index %s failed to build", name)
+		case !status.Queryable:
+			pending = append(pending, fmt.Sprintf("%s (%s)", name, status.Status))
+		}
+	}
+	return pending, nil
+}
@@ -2,17 +2,17 @@
 package storage
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"path/filepath"
 	// "runtime"
 	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sdrshn-nmbr/tusk/internal/ai"
@@ -21,113 +21,172 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 type MongoStorage struct {
-	client              *mongo.Client
-	database            string
-	documentsCollection string
-	chunksCollection    string
+	client                *mongo.Client
+	database              string
+	documentsCollection   string
+	chunksCollection      string
+	uploadsCollection     string
+	uploadPartsCollection string
+
+	// Chunking, embedding and extraction settings
+	ingest    config.Ingest
+	extractor *TextExtractor
+
+	uploadIndexesOnce       sync.Once
+	transactionsUnsupported atomic.Bool
+
+	// Attribute keys the vector index filters on, see EnsureVectorIndex
+	filterAttributes []string
+
+	// Cached active chunk index, see ActiveChunkIndex
+	chunkIndexMu     sync.Mutex
+	chunkIndex       ChunkIndex
+	chunkIndexLoaded time.Time
+
+	// OnIngest, when set, is called with every newly stored document and
+	// its extracted text. It must not block the upload, see enrich.Enricher.
+	OnIngest func(doc Document, text string)
 }
 
 type Document struct {
 	ID       primitive.ObjectID `bson:"_id,omitempty"`
 	Filename string             `bson:"filename"`
 	Content  primitive.Binary   `bson:"content"`
-	Metadata map[string]string  `bson:"metadata,omitempty"`
-	UserID   string             `bson:"user_id"`
+	// ContentFile is the GridFS file holding the content instead when it
+	// is too large for the document, see maxInlineContent.
+	ContentFile primitive.ObjectID     `bson:"content_file,omitempty"`
+	Metadata    map[string]string      `bson:"metadata,omitempty"`
+	UserID      string                 `bson:"user_id"`
+	Folder      string                 `bson:"folder,omitempty"`
+	Tags        []string               `bson:"tags,omitempty"`
+	Attributes  map[string]interface{} `bson:"attributes,omitempty"`
+	UploadedAt  time.Time              `bson:"uploaded_at,omitempty"`
+	Summary     *DocumentSummary       `bson:"summary,omitempty"`
 }
 
+// Chunk carries copies of the document's folder, tags, attributes and upload
+// date so that $vectorSearch can filter on them before ranking.
 type Chunk struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty"`
-	DocumentID primitive.ObjectID `bson:"document_id"`
-	Content    string             `bson:"content"`
-	Embedding  []float32          `bson:"embedding"`
-	parent     string             `bson:"parent"`
-	UserID     string             `bson:"user_id"`
+	ID         primitive.ObjectID     `bson:"_id,omitempty"`
+	DocumentID primitive.ObjectID     `bson:"document_id"`
+	Content    string                 `bson:"content"`
+	Embedding  []float32              `bson:"embedding"`
+	Position   int                    `bson:"position"`
+	parent     string                 `bson:"parent"`
+	UserID     string                 `bson:"user_id"`
+	Folder     string                 `bson:"folder,omitempty"`
+	Tags       []string               `bson:"tags,omitempty"`
+	Attributes map[string]interface{} `bson:"attributes,omitempty"`
+	UploadedAt time.Time              `bson:"uploaded_at,omitempty"`
+
+	// Set on search results only
+	Filename string  `bson:"filename,omitempty"`
+	Score    float64 `bson:"score,omitempty"`
 }
 
 const (
 	numWorkers = 16
-	batchSize  = 500
 	maxRetries = 3
 )
 
-func NewMongoStorage(cfg *config.Config) (*MongoStorage, error) {
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(cfg.MongoURI))
-	if err != nil {
-		return nil, err
-	}
+// Connect creates a client for the deployment at cfg.URI. The driver only
+// connects once the client is first used.
+func Connect(cfg config.Mongo) (*mongo.Client, error) {
+	return mongo.Connect(context.Background(), options.Client().ApplyURI(cfg.URI))
+}
 
+// NewMongoStorage stores in cfg.Mongo.Database through client, extracting
+// the text of uploads with extractor.
+func NewMongoStorage(client *mongo.Client, cfg *config.Config, extractor *TextExtractor) *MongoStorage {
 	return &MongoStorage{
-		client:              client,
-		database:            cfg.MongoDBDatabase,
-		documentsCollection: "documents",
-		chunksCollection:    "chunks",
-	}, nil
+		client:                client,
+		database:              cfg.Mongo.Database,
+		documentsCollection:   "documents",
+		chunksCollection:      "chunks",
+		uploadsCollection:     "uploads",
+		uploadPartsCollection: "upload_parts",
+		ingest:                cfg.Ingest,
+		extractor:             extractor,
+		filterAttributes:      cfg.Retrieval.FilterAttributeKeys(),
+	}
 }
-func (ms *MongoStorage) SaveFile(filename string, content io.Reader, embedder *ai.Embedder, userID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+// Ping checks that the MongoDB deployment can be reached. NewMongoStorage
+// does not, since the driver connects lazily.
+func (ms *MongoStorage) Ping(ctx context.Context) error {
+	return ms.client.Ping(ctx, readpref.Primary())
+}
+
+// SaveFile stores the file, embeds its chunks and returns the new document
+// without its content.
+func (ms *MongoStorage) SaveFile(filename string, content io.Reader, embedder *ai.Embedder, userID string) (*Document, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ms.ingest.Timeout.Duration)
 	defer cancel()
 
 	data, err := io.ReadAll(content)
 	if err != nil {
 		log.Printf("Error reading file content: %+v", err)
-		return err
-	}
-
-	var text string
-	ext := strings.ToLower(filepath.Ext(filename))
-	switch ext {
-	case ".pdf":
-		text, err = extractTextFromPDF(bytes.NewReader(data))
-	case ".docx":
-		text, err = extractTextFromDOCX(bytes.NewReader(data))
-	case ".txt":
-		text, err = string(data), nil
-	case ".jpg", ".jpeg", ".png", ".webp", ".heic", ".heif":
-		text, err = extractTextFromImage(data)
-	default:
-		log.Printf("unsupported file type: %s", ext)
-		err = fmt.Errorf("unsupported file type: %s", ext)
+		return nil, err
 	}
 
+	text, err := ms.extractor.ExtractText(filename, data)
 	if err != nil {
 		log.Printf("Error extracting text from file: %+v", err)
-		return err
+		return nil, err
 	}
 
+	now := time.Now()
 	doc := Document{
 		Filename: filename,
-		Content: primitive.Binary{
-			Subtype: 0x00,
-			Data:    data,
-		},
 		Metadata: map[string]string{
-			"uploadDate": time.Now().Format(time.RFC3339),
+			"uploadDate": now.Format(time.RFC3339),
 			"size":       fmt.Sprintf("%d", len(data)),
+			"sha256":     ContentHash(data),
 		},
-		UserID: userID,
+		UserID:     userID,
+		UploadedAt: now,
+	}
+	if err := ms.storeContent(ctx, &doc, data); err != nil {
+		return nil, err
 	}
 
 	docsColl := ms.client.Database(ms.database).Collection(ms.documentsCollection)
 	result, err := docsColl.InsertOne(ctx, doc)
 	if err != nil {
 		log.Printf("Error inserting document into MongoDB: %+v", err)
-		return err
+		ms.deleteContent(doc.ContentFile)
+		return nil, err
 	}
 
-	documentID := result.InsertedID.(primitive.ObjectID)
+	doc.ID = result.InsertedID.(primitive.ObjectID)
+
+	resultsChan, errorChan := ms.embedChunks(ms.extractor.ChunkText(text), ms.ActiveEmbedder(embedder), &doc)
+
+	// Collect results and insert into MongoDB
+	if err := ms.insertChunks(ctx, resultsChan, errorChan); err != nil {
+		return nil, err
+	}
 
-	chunks := ChunkText(text)
+	doc.Content = primitive.Binary{}
+	if ms.OnIngest != nil {
+		ms.OnIngest(doc, text)
+	}
+	return &doc, nil
+}
 
+// embedChunks embeds the chunks in concurrent batches and streams the
+// resulting Chunk values. Both channels are closed once every batch is done.
+func (ms *MongoStorage) embedChunks(chunks []string, embedder *ai.Embedder, doc *Document) (<-chan Chunk, <-chan error) {
 	resultsChan := make(chan Chunk, len(chunks))
 	errorChan := make(chan error, len(chunks))
 	var wg sync.WaitGroup
 
-	batchSize := 16           // Adjust based on API limits
-	maxConcurrentBatches := 4 // Adjust based on system capabilities and API rate limits
-	semaphore := make(chan struct{}, maxConcurrentBatches)
+	batchSize := ms.ingest.EmbedBatchSize
+	semaphore := make(chan struct{}, ms.ingest.EmbedConcurrency)
 
 	for i := 0; i < len(chunks); i += batchSize {
 		end := i + batchSize
@@ -138,7 +197,7 @@ func (ms *MongoStorage) SaveFile(filename string, content io.Reader, embedder *a
 
 		wg.Add(1)
 		semaphore <- struct{}{}
-		go func(batchChunks []string) {
+		go func(start int, batchChunks []string) {
 			defer wg.Done()
 			defer func() { <-semaphore }()
 
@@ -162,15 +221,20 @@ func (ms *MongoStorage) SaveFile(filename string, content io.Reader, embedder *a
 
 			for i, embedding := range embeddings {
 				chunk := Chunk{
-					DocumentID: documentID,
+					DocumentID: doc.ID,
 					Content:    batchChunks[i],
 					Embedding:  embedding,
-					parent:     filename,
-					UserID:     userID,
+					Position:   start + i,
+					parent:     doc.Filename,
+					UserID:     doc.UserID,
+					Folder:     doc.Folder,
+					Tags:       doc.Tags,
+					Attributes: doc.Attributes,
+					UploadedAt: doc.UploadedAt,
 				}
 				resultsChan <- chunk
 			}
-		}(batchChunks)
+		}(i, batchChunks)
 	}
 
 	// Wait for all batches to complete
@@ -180,13 +244,12 @@ func (ms *MongoStorage) SaveFile(filename string, content io.Reader, embedder *a
 		close(errorChan)
 	}()
 
-	// Collect results and insert into MongoDB
-	return ms.insertChunks(ctx, resultsChan, errorChan)
+	return resultsChan, errorChan
 }
 
 func (ms *MongoStorage) insertChunks(ctx context.Context, resultsChan <-chan Chunk, errorChan <-chan error) error {
 	var bulkOps []mongo.WriteModel
-	chunksColl := ms.client.Database(ms.database).Collection(ms.chunksCollection)
+	chunksColl := ms.chunks()
 
 	flushBulkOps := func() error {
 		if len(bulkOps) == 0 {
@@ -219,7 +282,7 @@ func (ms *MongoStorage) insertChunks(ctx context.Context, resultsChan <-chan Chu
 			insertModel := mongo.NewInsertOneModel().SetDocument(chunk)
 			bulkOps = append(bulkOps, insertModel)
 
-			if len(bulkOps) >= batchSize {
+			if len(bulkOps) >= ms.ingest.InsertBatchSize {
 				if err := flushBulkOps(); err != nil {
 					return err
 				}
@@ -276,7 +339,7 @@ func (ms *MongoStorage) GetFile(filename string, userID string) ([]byte, error)
 		return nil, err
 	}
 
-	return result.Content.Data, nil
+	return ms.documentContent(ctx, &result)
 }
 
 func (ms *MongoStorage) DeleteFileFunc(filename string, userID string) error {
@@ -299,40 +362,117 @@ func (ms *MongoStorage) DeleteFileFunc(filename string, userID string) error {
 		return err
 	}
 
-	chunksColl := ms.client.Database(ms.database).Collection(ms.chunksCollection)
-	_, err = chunksColl.DeleteMany(ctx, bson.M{"document_id": doc.ID})
-	if err != nil {
-		log.Printf("Error deleting chunks: %+v", err)
+	ms.deleteContent(doc.ContentFile)
+
+	for _, chunksColl := range ms.writableChunks() {
+		_, err = chunksColl.DeleteMany(ctx, bson.M{"document_id": doc.ID})
+		if err != nil {
+			log.Printf("Error deleting chunks: %+v", err)
+		}
+	}
+
+	extractionsColl := ms.client.Database(ms.database).Collection(extractionsCollection)
+	if _, err := extractionsColl.DeleteMany(ctx, bson.M{"document_id": doc.ID}); err != nil {
+		log.Printf("Error deleting extractions: %+v", err)
 	}
 
 	return nil
 }
 
-func (ms *MongoStorage) ListFiles(userID string) ([]string, error) {
+// ListFiles returns the user's documents matching filter, without their
+// content.
+func (ms *MongoStorage) ListFiles(userID string, filter DocumentFilter) ([]Document, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	collection := ms.client.Database(ms.database).Collection(ms.documentsCollection)
-	cursor, err := collection.Find(ctx, bson.M{"user_id": userID})
+	opts := options.Find().SetProjection(bson.M{"content": 0}).SetSort(filter.sort())
+	cursor, err := collection.Find(ctx, filter.documentQuery(userID), opts)
 	if err != nil {
 		return nil, err
 	}
 
 	defer cursor.Close(ctx)
 
-	var files []string
+	var files []Document
 	for cursor.Next(ctx) {
 		var doc Document
 		if err := cursor.Decode(&doc); err != nil {
 			return nil, err
 		}
 
-		files = append(files, doc.Filename)
+		files = append(files, doc)
 	}
 
 	return files, nil
 }
 
+// ListFilesPage returns one page of ListFiles and how many documents match
+// in total. Ties in the sort order are broken by _id so pages do not
+// overlap.
+func (ms *MongoStorage) ListFilesPage(userID string, filter DocumentFilter, offset, limit int64) ([]Document, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+	query := filter.documentQuery(userID)
+	total, err := collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetProjection(bson.M{"content": 0}).
+		SetSort(append(filter.sort(), bson.E{Key: "_id", Value: 1})).
+		SetSkip(offset).
+		SetLimit(limit)
+	cursor, err := collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	files := []Document{}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, 0, err
+	}
+	return files, total, nil
+}
+
+// FolderInfo is a folder and how many documents it holds directly.
+type FolderInfo struct {
+	Name      string `bson:"_id" json:"name"`
+	Documents int    `bson:"documents" json:"documents"`
+}
+
+// Folders lists the user's folders by name. Documents outside any folder are
+// counted under "".
+func (ms *MongoStorage) Folders(userID string) ([]FolderInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := ms.client.Database(ms.database).Collection(ms.documentsCollection)
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": userID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       bson.M{"$ifNull": bson.A{"$folder", ""}},
+			"documents": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		log.Printf("Error listing folders: %+v", err)
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	folders := []FolderInfo{}
+	if err := cursor.All(ctx, &folders); err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
 func (ms *MongoStorage) GetFileSize(filename string) (int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -352,17 +492,23 @@ func (ms *MongoStorage) GetFileSize(filename string) (int64, error) {
 		return 0, errors.New("file metadata not found")
 	}
 
-	sizeStr, ok := result.Metadata["size"]
-	if !ok {
-		// If size is not in metadata, return the length of the content
-		return int64(len(result.Content.Data)), nil
-	}
+	return result.Size(), nil
+}
 
-	size, err := strconv.ParseInt(sizeStr, 10, 64)
+// Size returns the file size recorded in the metadata, falling back to the
+// length of the content when it is missing or malformed.
+func (d *Document) Size() int64 {
+	size, err := strconv.ParseInt(d.Metadata["size"], 10, 64)
 	if err != nil {
-		// If we can't parse the size, return the length of the content
-		return int64(len(result.Content.Data)), nil
+		return int64(len(d.Content.Data))
 	}
+	return size
+}
 
-	return size, nil
+// ContentHash returns the hex SHA-256 of a file's content. SaveFile records
+// it in the "sha256" metadata so clients can tell whether a local file is
+// already uploaded.
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,395 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Upload tracks a resumable upload whose bytes arrive in several requests.
+// The received bytes are kept in the upload_parts collection so that any
+// machine can accept the next part or assemble the finished file.
+type Upload struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    string             `bson:"user_id"`
+	Filename  string             `bson:"filename"`
+	Length    int64              `bson:"length"`
+	Offset    int64              `bson:"offset"`
+	Metadata  map[string]string  `bson:"metadata,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+
+	// Ingestion of the complete upload, see StartUploadIngest
+	Ingest          string             `bson:"ingest,omitempty"`
+	IngestError     string             `bson:"ingest_error,omitempty"`
+	IngestStartedAt time.Time          `bson:"ingest_started_at,omitempty"`
+	DocumentID      primitive.ObjectID `bson:"document_id,omitempty"`
+}
+
+type uploadPart struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UploadID  primitive.ObjectID `bson:"upload_id"`
+	Offset    int64              `bson:"offset"`
+	Data      primitive.Binary   `bson:"data"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+const (
+	// MaxUploadPartSize keeps every part well below MongoDB's 16 MiB
+	// document limit.
+	MaxUploadPartSize = 8 << 20
+	// MaxUploadSize is the largest file accepted through resumable uploads.
+	// SaveFile holds the whole file and its extracted text in memory, so
+	// this is what one ingestion can afford rather than what GridFS could
+	// store.
+	MaxUploadSize = 128 << 20
+
+	uploadTTL = 24 * time.Hour
+)
+
+// Ingestion states of a complete upload. An upload that is not complete, or
+// whose ingestion has not started, has no state.
+const (
+	UploadIngesting = "ingesting"
+	UploadIngested  = "done"
+	UploadFailed    = "failed"
+)
+
+var (
+	ErrUploadIngesting      = errors.New("upload is being ingested")
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+	ErrUploadTooLarge       = errors.New("upload exceeds its declared length")
+	ErrUploadIncomplete     = errors.New("upload is not complete")
+)
+
+// Complete reports whether every byte of the upload has been received.
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// IngestStale reports whether an ingestion that started before timeout ago
+// was abandoned, for instance by a machine that restarted.
+func (u *Upload) IngestStale(timeout time.Duration) bool {
+	return u.Ingest == UploadIngesting && time.Since(u.IngestStartedAt) > timeout
+}
+
+func (ms *MongoStorage) CreateUpload(userID, filename string, length int64, metadata map[string]string) (*Upload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if length < 0 || length > MaxUploadSize {
+		return nil, ErrUploadTooLarge
+	}
+
+	ms.ensureUploadIndexes(ctx)
+
+	now := time.Now()
+	upload := &Upload{
+		UserID:    userID,
+		Filename:  filename,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(uploadTTL),
+	}
+
+	coll := ms.client.Database(ms.database).Collection(ms.uploadsCollection)
+	result, err := coll.InsertOne(ctx, upload)
+	if err != nil {
+		log.Printf("Error creating upload: %+v", err)
+		return nil, err
+	}
+	upload.ID = result.InsertedID.(primitive.ObjectID)
+
+	return upload, nil
+}
+
+func (ms *MongoStorage) GetUpload(id string, userID string) (*Upload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return ms.findUpload(ctx, id, userID)
+}
+
+// WriteUploadPart appends data at offset. The offset has to match what the
+// server already holds, which is what lets a client resume after asking for
+// the current offset.
+func (ms *MongoStorage) WriteUploadPart(id string, userID string, offset int64, data []byte) (*Upload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	upload, err := ms.findUpload(ctx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Offset != offset {
+		return upload, ErrUploadOffsetMismatch
+	}
+	if offset+int64(len(data)) > upload.Length {
+		return upload, ErrUploadTooLarge
+	}
+	if len(data) == 0 {
+		return upload, nil
+	}
+
+	expiresAt := time.Now().Add(uploadTTL)
+	partsColl := ms.client.Database(ms.database).Collection(ms.uploadPartsCollection)
+	result, err := partsColl.InsertOne(ctx, uploadPart{
+		UploadID:  upload.ID,
+		Offset:    offset,
+		Data:      primitive.Binary{Subtype: 0x00, Data: data},
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Printf("Error storing upload part: %+v", err)
+		return nil, err
+	}
+	partID := result.InsertedID.(primitive.ObjectID)
+
+	// Only advance the offset if nobody else wrote this range in the meantime;
+	// the losing writer removes its own part again.
+	uploadsColl := ms.client.Database(ms.database).Collection(ms.uploadsCollection)
+	err = uploadsColl.FindOneAndUpdate(ctx,
+		bson.M{"_id": upload.ID, "user_id": userID, "offset": offset},
+		bson.M{
+			"$inc": bson.M{"offset": int64(len(data))},
+			"$set": bson.M{"expires_at": expiresAt},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(upload)
+	if err != nil {
+		if _, delErr := partsColl.DeleteOne(ctx, bson.M{"_id": partID}); delErr != nil {
+			log.Printf("Error removing orphaned upload part: %+v", delErr)
+		}
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUploadOffsetMismatch
+		}
+		return nil, err
+	}
+
+	// The parts written earlier expire with the upload, not before it. The
+	// next part retries if this fails.
+	_, err = partsColl.UpdateMany(ctx,
+		bson.M{"upload_id": upload.ID, "expires_at": bson.M{"$lt": expiresAt}},
+		bson.M{"$set": bson.M{"expires_at": expiresAt}})
+	if err != nil {
+		log.Printf("Error extending the upload parts: %+v", err)
+	}
+
+	return upload, nil
+}
+
+// OpenUpload streams the content of a completed upload part by part, so
+// that only one part is held in memory at a time.
+func (ms *MongoStorage) OpenUpload(id string, userID string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+
+	upload, err := ms.findUpload(ctx, id, userID)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if !upload.Complete() {
+		cancel()
+		return nil, ErrUploadIncomplete
+	}
+
+	partsColl := ms.client.Database(ms.database).Collection(ms.uploadPartsCollection)
+	cursor, err := partsColl.Find(ctx, bson.M{"upload_id": upload.ID}, options.Find().SetSort(bson.D{{Key: "offset", Value: 1}}))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &uploadReader{ctx: ctx, cancel: cancel, cursor: cursor, upload: upload}, nil
+}
+
+// uploadReader reads the parts of an upload in order and fails on gaps.
+type uploadReader struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	cursor *mongo.Cursor
+	upload *Upload
+	offset int64
+	part   []byte
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	for len(r.part) == 0 {
+		if !r.cursor.Next(r.ctx) {
+			if err := r.cursor.Err(); err != nil {
+				return 0, err
+			}
+			if r.offset != r.upload.Length {
+				return 0, ErrUploadIncomplete
+			}
+			return 0, io.EOF
+		}
+		var part uploadPart
+		if err := r.cursor.Decode(&part); err != nil {
+			return 0, err
+		}
+		if part.Offset != r.offset {
+			return 0, fmt.Errorf("upload %s has a gap at offset %d", r.upload.ID.Hex(), r.offset)
+		}
+		r.part = part.Data.Data
+		r.offset += int64(len(r.part))
+	}
+	n := copy(p, r.part)
+	r.part = r.part[n:]
+	return n, nil
+}
+
+func (r *uploadReader) Close() error {
+	defer r.cancel()
+	return r.cursor.Close(r.ctx)
+}
+
+// StartUploadIngest marks a complete upload as being ingested. It fails
+// with ErrUploadIngesting while another request ingests it, unless that
+// ingestion outlived the ingest timeout. Ingested uploads are returned as
+// they are so that callers can report the document again.
+func (ms *MongoStorage) StartUploadIngest(id string, userID string) (*Upload, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	upload, err := ms.findUpload(ctx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !upload.Complete() {
+		return upload, ErrUploadIncomplete
+	}
+	if upload.Ingest == UploadIngested {
+		return upload, nil
+	}
+	if upload.Ingest == UploadIngesting && !upload.IngestStale(ms.ingest.Timeout.Duration) {
+		return upload, ErrUploadIngesting
+	}
+
+	// Claim the upload only if nobody started ingesting it since it was read
+	claim := bson.M{"_id": upload.ID, "ingest_started_at": upload.IngestStartedAt}
+	if upload.IngestStartedAt.IsZero() {
+		claim["ingest_started_at"] = bson.M{"$exists": false}
+	}
+	uploadsColl := ms.client.Database(ms.database).Collection(ms.uploadsCollection)
+	err = uploadsColl.FindOneAndUpdate(ctx, claim,
+		bson.M{
+			"$set":   bson.M{"ingest": UploadIngesting, "ingest_started_at": time.Now()},
+			"$unset": bson.M{"ingest_error": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(upload)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUploadIngesting
+	}
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// FinishUploadIngest records the outcome of ingesting the upload. Once the
+// document is saved the parts are dropped, while the upload itself stays
+// until it expires so that a client resuming it learns it is done. After a
+// failure the parts are kept so that ingestion can be retried.
+func (ms *MongoStorage) FinishUploadIngest(id string, userID string, documentID primitive.ObjectID, ingestErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	upload, err := ms.findUpload(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{"$set": bson.M{"ingest": UploadIngested, "document_id": documentID}}
+	if ingestErr != nil {
+		update = bson.M{"$set": bson.M{"ingest": UploadFailed, "ingest_error": ingestErr.Error()}}
+	}
+	uploadsColl := ms.client.Database(ms.database).Collection(ms.uploadsCollection)
+	if _, err := uploadsColl.UpdateByID(ctx, upload.ID, update); err != nil {
+		return err
+	}
+	if ingestErr != nil {
+		return nil
+	}
+
+	partsColl := ms.client.Database(ms.database).Collection(ms.uploadPartsCollection)
+	if _, err := partsColl.DeleteMany(ctx, bson.M{"upload_id": upload.ID}); err != nil {
+		log.Printf("Error deleting upload parts: %+v", err)
+	}
+	return nil
+}
+
+func (ms *MongoStorage) DeleteUpload(id string, userID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	upload, err := ms.findUpload(ctx, id, userID)
+	if err != nil {
+		return err
+	}
+
+	uploadsColl := ms.client.Database(ms.database).Collection(ms.uploadsCollection)
+	if _, err := uploadsColl.DeleteOne(ctx, bson.M{"_id": upload.ID}); err != nil {
+		return err
+	}
+
+	partsColl := ms.client.Database(ms.database).Collection(ms.uploadPartsCollection)
+	if _, err := partsColl.DeleteMany(ctx, bson.M{"upload_id": upload.ID}); err != nil {
+		log.Printf("Error deleting upload parts: %+v", err)
+	}
+
+	return nil
+}
+
+func (ms *MongoStorage) findUpload(ctx context.Context, id string, userID string) (*Upload, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	var upload Upload
+	coll := ms.client.Database(ms.database).Collection(ms.uploadsCollection)
+	err = coll.FindOne(ctx, bson.M{"_id": objectID, "user_id": userID}).Decode(&upload)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+// ensureUploadIndexes lets MongoDB expire abandoned uploads and their parts.
+func (ms *MongoStorage) ensureUploadIndexes(ctx context.Context) {
+	ms.uploadIndexesOnce.Do(func() {
+		ttl := mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		}
+		for _, name := range []string{ms.uploadsCollection, ms.uploadPartsCollection} {
+			coll := ms.client.Database(ms.database).Collection(name)
+			if _, err := coll.Indexes().CreateOne(ctx, ttl); err != nil {
+				log.Printf("Error creating TTL index on %s: %+v", name, err)
+			}
+		}
+
+		partsColl := ms.client.Database(ms.database).Collection(ms.uploadPartsCollection)
+		_, err := partsColl.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "upload_id", Value: 1}, {Key: "offset", Value: 1}},
+		})
+		if err != nil {
+			log.Printf("Error creating upload parts index: %+v", err)
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const usersCollection = "users"
+
+var (
+	ErrUserDisabled = errors.New("this account is disabled")
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// User is someone who signed in. Users are keyed by the ID their login
+// provider gives them, which is also the user_id on all their data. Users
+// who only signed in before logins were recorded have no record, just data.
+type User struct {
+	ID        string    `bson:"_id" json:"id"`
+	Provider  string    `bson:"provider,omitempty" json:"provider,omitempty"`
+	Email     string    `bson:"email,omitempty" json:"email,omitempty"`
+	Name      string    `bson:"name,omitempty" json:"name,omitempty"`
+	CreatedAt time.Time `bson:"created_at,omitempty" json:"created_at,omitempty"`
+	LastLogin time.Time `bson:"last_login,omitempty" json:"last_login,omitempty"`
+	Disabled  bool      `bson:"disabled,omitempty" json:"disabled"`
+
+	// Documents is how many documents the user has, set by ListUsers
+	Documents int64 `bson:"-" json:"documents"`
+}
+
+// RecordLogin creates or updates the user's record on sign-in. It returns
+// ErrUserDisabled, and the login must be refused, when an operator disabled
+// the user.
+func (ms *MongoStorage) RecordLogin(user User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	coll := ms.client.Database(ms.database).Collection(usersCollection)
+	var stored User
+	err := coll.FindOneAndUpdate(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set":         bson.M{"provider": user.Provider, "email": user.Email, "name": user.Name, "last_login": now},
+		"$setOnInsert": bson.M{"created_at": now},
+	}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&stored)
+	if err != nil {
+		log.Printf("Error recording login: %+v", err)
+		return err
+	}
+	if stored.Disabled {
+		return ErrUserDisabled
+	}
+	return nil
+}
+
+// GetUser returns the user's record, or ErrUserNotFound for users who never
+// signed in since logins were recorded.
+func (ms *MongoStorage) GetUser(userID string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user User
+	coll := ms.client.Database(ms.database).Collection(usersCollection)
+	err := coll.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UserDisabled reports whether an operator disabled the user.
+func (ms *MongoStorage) UserDisabled(userID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(usersCollection)
+	err := coll.FindOne(ctx, bson.M{"_id": userID, "disabled": true}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SetUserDisabled disables or re-enables a user. Disabled users cannot sign
+// in, their sessions stop working and their API tokens are refused; their
+// data is kept.
+func (ms *MongoStorage) SetUserDisabled(userID string, disabled bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := ms.client.Database(ms.database).Collection(usersCollection)
+	_, err := coll.UpdateByID(ctx, userID, bson.M{"$set": bson.M{"disabled": disabled}}, options.Update().SetUpsert(true))
+	return err
+}
+
+// ListUsers returns every user with a record or with documents, sorted by ID.
+func (ms *MongoStorage) ListUsers(ctx context.Context) ([]User, error) {
+	db := ms.client.Database(ms.database)
+	cursor, err := db.Collection(usersCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*User, len(users))
+	for i := range users {
+		byID[users[i].ID] = &users[i]
+	}
+
+	cursor, err = db.Collection(ms.documentsCollection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$user_id", "documents": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var counts []struct {
+		UserID    string `bson:"_id"`
+		Documents int64  `bson:"documents"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	for _, count := range counts {
+		if user, ok := byID[count.UserID]; ok {
+			user.Documents = count.Documents
+		} else {
+			users = append(users, User{ID: count.UserID, Documents: count.Documents})
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// DeleteUser deletes the user and everything they own from every
+// collection, and returns how many records went per collection.
+func (ms *MongoStorage) DeleteUser(ctx context.Context, userID string) (map[string]int64, error) {
+	db := ms.client.Database(ms.database)
+	deleted := map[string]int64{}
+	remove := func(collection string, filter bson.M) error {
+		result, err := db.Collection(collection).DeleteMany(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount > 0 {
+			deleted[collection] += result.DeletedCount
+		}
+		return nil
+	}
+
+	// Parts of unfinished uploads carry only their upload's ID
+	var uploads []Upload
+	cursor, err := db.Collection(ms.uploadsCollection).Find(ctx, bson.M{"user_id": userID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return deleted, err
+	}
+	if err := cursor.All(ctx, &uploads); err != nil {
+		return deleted, err
+	}
+	uploadIDs := make([]primitive.ObjectID, len(uploads))
+	for i, upload := range uploads {
+		uploadIDs[i] = upload.ID
+	}
+	if err := remove(ms.uploadPartsCollection, bson.M{"upload_id": bson.M{"$in": uploadIDs}}); err != nil {
+		return deleted, err
+	}
+
+	files, err := ms.deleteUserContent(ctx, userID)
+	if err != nil {
+		return deleted, err
+	}
+	if files > 0 {
+		deleted[contentBucketName] = files
+	}
+
+	chunkCollections, err := ms.chunkCollections(ctx)
+	if err != nil {
+		return deleted, err
+	}
+	byUser := bson.M{"user_id": userID}
+	for _, collection := range append(chunkCollections,
+		ms.uploadsCollection,
+		conversationsCollection,
+		extractionsCollection,
+		extractionTemplatesCollection,
+		jobsCollection,
+		apiTokensCollection,
+		ms.documentsCollection,
+	) {
+		if err := remove(collection, byUser); err != nil {
+			return deleted, err
+		}
+	}
+	for _, collection := range []string{workspaceSettingsCollection, usersCollection} {
+		if err := remove(collection, bson.M{"_id": userID}); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// chunkCollections lists the active chunks collection and any others left
+// by re-indexing with a new model.
+func (ms *MongoStorage) chunkCollections(ctx context.Context) ([]string, error) {
+	names, err := ms.client.Database(ms.database).ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var chunks []string
+	for _, name := range names {
+		if name == ms.chunksCollection || strings.HasPrefix(name, ms.chunksCollection+"_") {
+			chunks = append(chunks, name)
+		}
+	}
+	sort.Strings(chunks)
+	return chunks, nil
+}
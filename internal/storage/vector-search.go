@@ -10,21 +10,38 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func (ms *MongoStorage) VectorSearch(queryVector []float32, numCandidates, limit int, userID string) ([]Chunk, error) {
+func (ms *MongoStorage) VectorSearch(queryVector []float32, numCandidates, limit int, userID string, filter DocumentFilter) ([]Chunk, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	coll := ms.client.Database(ms.database).Collection(ms.chunksCollection)
+	coll := ms.chunks()
 
+	// The user, document, folder, tag, date and indexed attribute filters
+	// are applied by the index before ranking, so other users' chunks never
+	// take up the limit. Every filtered path has to be declared in the index
+	// definition. Other attributes are matched among all the candidates.
+	before, after := filter.vectorSearchFilter(userID, ms.filterAttributes)
+	ranked := limit
+	if len(after) > 0 {
+		ranked = numCandidates
+	}
 	pipeline := mongo.Pipeline{
 		{{Key: "$vectorSearch", Value: bson.D{
-			{Key: "index", Value: "chunks_embedding_index"},
+			{Key: "index", Value: vectorIndexName},
 			{Key: "path", Value: "embedding"},
 			{Key: "queryVector", Value: queryVector},
 			{Key: "numCandidates", Value: numCandidates},
-			{Key: "limit", Value: limit},
+			{Key: "limit", Value: ranked},
+			{Key: "filter", Value: before},
 		}}},
-		{{Key: "$match", Value: bson.M{"user_id": userID}}},
+	}
+	if len(after) > 0 {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$match", Value: bson.D{{Key: "$and", Value: after}}}},
+			bson.D{{Key: "$limit", Value: limit}},
+		)
+	}
+	pipeline = append(pipeline, mongo.Pipeline{
 		{{Key: "$lookup", Value: bson.D{
 			{Key: "from", Value: ms.documentsCollection},
 			{Key: "localField", Value: "document_id"},
@@ -33,12 +50,13 @@ func (ms *MongoStorage) VectorSearch(queryVector []float32, numCandidates, limit
 		}}},
 		{{Key: "$unwind", Value: "$document"}},
 		{{Key: "$project", Value: bson.D{
+			{Key: "document_id", Value: 1},
 			{Key: "content", Value: 1},
 			{Key: "embedding", Value: 1},
 			{Key: "filename", Value: "$document.filename"},
 			{Key: "score", Value: bson.D{{Key: "$meta", Value: "vectorSearchScore"}}},
 		}}},
-	}
+	}...)
 
 	// log.Printf("Executing pipeline: %+v", pipeline)
 
@@ -12,17 +12,22 @@ import (
 func TestVectorSearch(t *testing.T) {
 	query := "In a what paper was mentioned a shocking finding where scientists unicorns? tell me more about this and what is mentioned in the paper."
 
-	cfg, err := config.NewConfig()
+	cfg, err := config.Load("")
 	if err != nil {
 		t.Fatalf("Failed to load config: %+v", err)
 	}
+	if cfg.OpenAI.APIKey == "" || cfg.Mongo.URI == "" {
+		t.Skip("OPENAI_API_KEY and MONGO_URI are needed")
+	}
 
 	// Create a new MongoDB instance
-	mongodb, err := NewMongoStorage(cfg)
+	client, err := Connect(cfg.Mongo)
 	if err != nil {
 		t.Fatalf("Failed to create MongoDB instance: %+v", err)
 	}
 
+	mongodb := NewMongoStorage(client, cfg, NewTextExtractor(cfg.Ingest, nil))
+
 	// Test MongoDB connection
 	err = mongodb.client.Ping(context.TODO(), nil)
 	if err != nil {
@@ -43,7 +48,7 @@ func TestVectorSearch(t *testing.T) {
 		t.Error("Generated embedding is empty")
 	}
 
-	results, err := mongodb.VectorSearch(queryEmbedding, 20, 1, "user_id")
+	results, err := mongodb.VectorSearch(queryEmbedding, 20, 1, "user_id", DocumentFilter{})
 	if err != nil {
 		t.Fatalf("VectorSearch failed: %+v", err)
 	}
@@ -0,0 +1,133 @@
+// Package summarize condenses documents too long for a single prompt with a
+// map-reduce over their chunks.
+package summarize
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+)
+
+// Completer answers a single prompt, see ai.Model.Generate.
+type Completer interface {
+	Generate(ctx context.Context, prompt string) (string, error)
+}
+
+// Summarizer summarizes each batch of chunks (map), then summarizes the
+// summaries (reduce) until one is left.
+type Summarizer struct {
+	Model Completer
+
+	// BatchTokens bounds the text sent in one request, and Concurrency how
+	// many requests run at once.
+	BatchTokens int
+	Concurrency int
+}
+
+// New returns a Summarizer with defaults that suit Gemini's context window.
+func New(model Completer) *Summarizer {
+	return &Summarizer{Model: model, BatchTokens: 8000, Concurrency: 4}
+}
+
+const mapPrompt = `Summarize the following part of a document. Keep the key facts, names, numbers and conclusions. Reply with the summary only.
+
+%s`
+
+const reducePrompt = `The following are summaries of consecutive parts of one document. Combine them into a single summary, keeping the key facts, names, numbers and conclusions. Reply with the summary only.
+
+%s`
+
+const finalPrompt = `Write a summary of the following document in at most 300 words: first a one-sentence overview, then its main points. Reply with the summary only.
+
+%s`
+
+// Summarize returns a summary of the document made of chunks, in order.
+func (s *Summarizer) Summarize(ctx context.Context, chunks []string) (string, error) {
+	if len(chunks) == 0 {
+		return "", fmt.Errorf("the document has no text to summarize")
+	}
+
+	parts := chunks
+	prompt := mapPrompt
+	for {
+		groups := s.batches(parts, prompt != mapPrompt)
+		if len(groups) == 1 {
+			return s.complete(ctx, finalPrompt, groups[0])
+		}
+
+		summaries, err := s.completeAll(ctx, prompt, groups)
+		if err != nil {
+			return "", err
+		}
+		parts = summaries
+		prompt = reducePrompt
+	}
+}
+
+// batches groups consecutive texts up to BatchTokens. When reducing, every
+// batch takes at least two texts so that each round makes progress.
+func (s *Summarizer) batches(texts []string, reducing bool) [][]string {
+	var groups [][]string
+	var group []string
+	tokens := 0
+	for _, text := range texts {
+		n := ai.EstimateTokens(text)
+		full := tokens+n > s.BatchTokens && len(group) > 0
+		if full && (!reducing || len(group) > 1) {
+			groups = append(groups, group)
+			group, tokens = nil, 0
+		}
+		group = append(group, text)
+		tokens += n
+	}
+	if len(group) == 1 && reducing && len(groups) > 0 {
+		groups[len(groups)-1] = append(groups[len(groups)-1], group[0])
+	} else if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func (s *Summarizer) completeAll(ctx context.Context, prompt string, groups [][]string) ([]string, error) {
+	concurrency := s.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	summaries := make([]string, len(groups))
+	errs := make([]error, len(groups))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, group []string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			summaries[i], errs[i] = s.complete(ctx, prompt, group)
+		}(i, group)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return summaries, nil
+}
+
+func (s *Summarizer) complete(ctx context.Context, prompt string, texts []string) (string, error) {
+	summary, err := s.Model.Generate(ctx, fmt.Sprintf(prompt, strings.Join(texts, "\n\n")))
+	if err != nil {
+		return "", fmt.Errorf("error summarizing document: %+v", err)
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return "", fmt.Errorf("the model returned an empty summary")
+	}
+	return summary, nil
+}
@@ -0,0 +1,54 @@
+package summarize
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type countingCompleter struct {
+	mu      sync.Mutex
+	prompts map[string]int
+}
+
+func (c *countingCompleter) Generate(ctx context.Context, prompt string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kind := strings.SplitN(prompt, " ", 4)[2]
+	c.prompts[kind]++
+	return strings.Repeat("summary ", 100), nil // ~200 tokens
+}
+
+func TestSummarizeMapReduce(t *testing.T) {
+	chunk := strings.Repeat("word ", 400) // ~500 tokens
+	chunks := make([]string, 20)
+	for i := range chunks {
+		chunks[i] = chunk
+	}
+
+	model := &countingCompleter{prompts: make(map[string]int)}
+	s := &Summarizer{Model: model, BatchTokens: 1000, Concurrency: 3}
+	summary, err := s.Summarize(context.Background(), chunks)
+	if err != nil {
+		t.Fatalf("Summarize failed: %+v", err)
+	}
+	if summary == "" {
+		t.Fatal("Expected a summary")
+	}
+
+	// 10 map requests of two chunks, then 2 reduce requests of five
+	// summaries, then the final summary of those two
+	if model.prompts["following"] != 10 || model.prompts["are"] != 2 || model.prompts["summary"] != 1 {
+		t.Errorf("Unexpected requests: %v", model.prompts)
+	}
+}
+
+func TestBatchesReduceMakesProgress(t *testing.T) {
+	big := strings.Repeat("word ", 1000)
+	s := &Summarizer{BatchTokens: 100}
+	groups := s.batches([]string{big, big, big}, true)
+	if len(groups) != 1 || len(groups[0]) != 3 {
+		t.Errorf("Expected oversized summaries to be combined, got %d groups", len(groups))
+	}
+}
@@ -12,7 +12,10 @@ import (
 )
 
 func TestGenerateResponse(t *testing.T) {
-	cfg, _ := config.NewConfig()
+	cfg, _ := config.Load("")
+	if cfg.Gemini.APIKey == "" {
+		t.Skip("GEMINI_API_KEY is not set")
+	}
 
 	sysPrompt := `You are an AI assistant that helps users with their queries. Do NOT mention the documents anywhere in your response - make it sound as natural as possible.`
 
@@ -46,15 +49,19 @@ func TestGenerateResponse(t *testing.T) {
 
 func TestGeneratewithVectorSearch(t *testing.T) {
 
-	cfg, err := config.NewConfig()
+	cfg, err := config.Load("")
 	if err != nil {
 		t.Logf("Error: %+v", err)
 	}
+	if cfg.Gemini.APIKey == "" || cfg.OpenAI.APIKey == "" || cfg.Mongo.URI == "" {
+		t.Skip("GEMINI_API_KEY, OPENAI_API_KEY and MONGO_URI are needed")
+	}
 
-	ms, err := storage.NewMongoStorage(cfg)
+	client, err := storage.Connect(cfg.Mongo)
 	if err != nil {
 		t.Logf("Error: %+v", err)
 	}
+	ms := storage.NewMongoStorage(client, cfg, storage.NewTextExtractor(cfg.Ingest, nil))
 
 	sysPrompt :=
 		`You are an AI assistant that helps users with their queries. Do NOT mention the documents anywhere in your response - make it sound as natural as possible.`
@@ -74,7 +81,7 @@ func TestGeneratewithVectorSearch(t *testing.T) {
 		t.Fatalf("Failed to generate embedding: %+v", err)
 	}
 
-	chunks, err := ms.VectorSearch(queryEmbedding, 50, 2, "user_id")
+	chunks, err := ms.VectorSearch(queryEmbedding, 50, 2, "user_id", storage.DocumentFilter{})
 	if err != nil {
 		t.Logf("Error: %+v", err)
 	}
@@ -119,7 +126,10 @@ func TestGeneratewithVectorSearch(t *testing.T) {
 }
 
 func TestGenerateVision(t *testing.T) {
-	cfg, _ := config.NewConfig()
+	cfg, _ := config.Load("")
+	if cfg.Gemini.APIKey == "" {
+		t.Skip("GEMINI_API_KEY is not set")
+	}
 	sysPrompt := `You are an AI assistant that helps users with their queries. Do NOT mention the documents anywhere in your response - make it sound as natural as possible.`
 
 	model, _ := ai.NewModel(cfg, sysPrompt)
@@ -2,169 +2,98 @@ package main
 
 import (
 	"embed"
+	"errors"
+	"flag"
 	"fmt"
-	"html/template"
-	"io/fs"
 	"log"
-	"net/http"
 	"os"
-	"path/filepath"
 
-	"github.com/gin-gonic/gin"
-	"github.com/gorilla/sessions"
-	"github.com/markbates/goth"
-	"github.com/markbates/goth/gothic"
-	"github.com/markbates/goth/providers/github"
-	"github.com/markbates/goth/providers/google"
-	"github.com/sdrshn-nmbr/tusk/internal/ai"
 	"github.com/sdrshn-nmbr/tusk/internal/config"
-	"github.com/sdrshn-nmbr/tusk/internal/handlers"
-	"github.com/sdrshn-nmbr/tusk/internal/middleware"
-	"github.com/sdrshn-nmbr/tusk/internal/storage"
 )
 
 //go:embed web/templates/*
 var templateFS embed.FS
 
-func main() {
-	// Load configuration
-	cfg, err := config.NewConfig()
-	if err != nil {
-		log.Fatalf("Config not initialized properly: %v", err)
-	}
+//go:embed api/openapi.json
+var openAPISpec []byte
 
-	// Initialize MongoDB storage
-	ms, err := storage.NewMongoStorage(cfg)
-	if err != nil {
-		log.Fatalf("Failed to initialize MongoDB storage: %v", err)
-	}
+// command is a subcommand of the server binary. It parses its own flags.
+type command struct {
+	name    string
+	summary string
+	run     func(cfg *config.Config, args []string) error
+}
 
-	// Run migration
-	if err := ms.MigrateMissingFileSizes(); err != nil {
-		log.Printf("Error migrating file sizes: %v", err)
-	}
+var commands = []command{
+	{"serve", "run the web server (the default without a command)", serve},
+	{"migrate", "show, apply or revert schema migrations", migrate},
+	{"reindex", "re-embed documents, optionally with a new model", reindex},
+	{"create-indexes", "create the database and search indexes", createIndexes},
+	{"user", "list, disable, enable or delete users", user},
+	{"export", "export users' documents, conversations and settings", export},
+	{"import", "import an export", importData},
+	{"doctor", "check the database, indexes, provider keys and license", doctor},
+	{"eval", "measure retrieval and answers on a golden dataset, or compare runs", evaluate},
+}
 
-	// Initialize embedder
-	embedder := ai.NewEmbedder(cfg)
+// exitStatus ends the process with the given code and no further message,
+// for commands whose output already explains what is wrong.
+type exitStatus int
 
-	// Parse templates using embedded file system
-	tmpl, err := parseTemplates()
-	if err != nil {
-		log.Fatalf("Failed to parse templates: %v", err)
-	}
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
 
-	// After creating the embedder
-	sysPrompt := `You are an AI assistant that helps users with their queries. Do NOT mention the documents anywhere in your response - make it sound as natural as possible.`
-	model, err := ai.NewModel(cfg, sysPrompt)
-	if err != nil {
-		log.Fatalf("Failed to create model: %v", err)
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-config FILE] [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", cmd.name, cmd.summary)
 	}
-	defer model.Close()
-
-	// Update the NewHandler call
-
-	// Initialize handler with MongoDB storage and embedder
-	h := handlers.NewHandler(ms, embedder, model, tmpl)
-
-	// Set up Gin router
-	r := gin.Default()
-	r.MaxMultipartMemory = 32 << 20 // 32 MiB
-
-	// Load HTML templates
-	r.SetHTMLTemplate(tmpl)
-	log.Println("HTML templates loaded into Gin")
+	fmt.Fprintf(os.Stderr, "\nThe configuration is read from FILE, a .yaml or .toml file, or $TUSK_CONFIG,\nwith environment variables taking precedence.\n")
+	fmt.Fprintf(os.Stderr, "Run %s <command> -h for the flags of a command.\n", os.Args[0])
+}
 
-	// Determine the environment
-	isProd := os.Getenv("APP_ENV") == "production"
+func main() {
+	configPath := flag.String("config", os.Getenv("TUSK_CONFIG"), "YAML or TOML configuration file")
+	flag.Usage = usage
+	flag.Parse()
 
-	var callbackURL string
-	if isProd {
-		// in prod
-		appURL := "https://" + os.Getenv("FLY_APP_NAME") + ".fly.dev"
-		callbackURL = appURL + "/auth/%s/callback"
-	} else {
-		// in dev
-		callbackURL = "http://localhost:8080/auth/%s/callback"
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
 	}
-
-	// Set up session store
-	key := os.Getenv("SESSION_SECRET")
-	if key == "" {
-		log.Fatal("SESSION_SECRET environment variable is not set")
+	if name == "help" {
+		usage()
+		return
 	}
-	maxAge := 86400 * 30 // 30 days
-	store := sessions.NewCookieStore([]byte(key))
-	store.MaxAge(maxAge)
-	store.Options.Path = "/"
-	store.Options.HttpOnly = true
-	store.Options.Secure = isProd
-	gothic.Store = store
-
-	// Set up Goth providers based on the environment
-	var providers []goth.Provider
-	providers = append(providers, google.New(cfg.GoogleClientID, cfg.GoogleClientSecret, fmt.Sprintf(callbackURL, "google")))
-	providers = append(providers, github.New(cfg.GithubClientID, cfg.GithubClientSecret, fmt.Sprintf(callbackURL, "github")))
-	goth.UseProviders(providers...)
-
-	// Update the root route
-	r.GET("/", middleware.AuthRequired(), func(c *gin.Context) {
-		h.Index(c)
-	})
 
-	// Update the login route
-	r.GET("/login", func(c *gin.Context) {
-		session, _ := gothic.Store.Get(c.Request, "user-session")
-		if session.Values["user_id"] != nil {
-			c.Redirect(http.StatusFound, "/")
-			return
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
 		}
-		h.Login(c)
-	})
-
-	// Auth routes
-	r.GET("/auth/:provider", h.BeginAuth)
-	r.GET("/auth/:provider/callback", h.CompleteAuth)
-	r.GET("/logout", h.Logout)
-
-	// Use middleware for protected routes
-	r.POST("/upload", middleware.AuthRequired(), h.UploadFile)
-	r.POST("/delete", middleware.AuthRequired(), h.DeleteFile)
-	r.GET("/files", middleware.AuthRequired(), h.GetFileList)
-	r.GET("/download", middleware.AuthRequired(), h.DownloadFile)
-	r.GET("/generate-search", middleware.AuthRequired(), h.GenerateSearch)
-
-	// Serve static files
-	r.Static("/static", "./web/static")
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
 
-	// Use PORT environment variable provided by Fly.io
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Load the configuration once; doctor reports problems itself
+	cfg, err := config.Load(*configPath)
+	if err == nil && cmd.name != "doctor" {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Fatalf("Config not initialized properly: %v", err)
 	}
-	log.Printf("Server starting on :%s", port)
-	log.Fatal(r.Run("0.0.0.0:" + port))
-}
 
-// parseTemplates parses HTML templates from the embedded file system.
-func parseTemplates() (*template.Template, error) {
-	tmpl := template.New("")
-	err := fs.WalkDir(templateFS, "web/templates", func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-			return nil
-		}
-		if filepath.Ext(path) != ".html" {
-			return nil
-		}
-		b, err := templateFS.ReadFile(path)
-		if err != nil {
-			return err
-		}
-		name := filepath.ToSlash(path[len("web/templates/"):])
-		_, err = tmpl.New(name).Parse(string(b))
-		return err
-	})
-	return tmpl, err
+	err = cmd.run(cfg, args)
+	var status exitStatus
+	if errors.As(err, &status) {
+		os.Exit(int(status))
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/handlers"
+	"github.com/sdrshn-nmbr/tusk/internal/middleware"
+)
+
+// TestOpenAPISpec checks that api/openapi.json documents exactly the routes
+// RegisterAPIRoutes sets up.
+func TestOpenAPISpec(t *testing.T) {
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("api/openapi.json is not valid JSON: %+v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") || len(spec.Servers) != 1 {
+		t.Fatalf("Expected an OpenAPI 3 document with one server, got %q and %d servers", spec.OpenAPI, len(spec.Servers))
+	}
+	base := spec.Servers[0].URL
+
+	documented := map[string]bool{}
+	for path, item := range spec.Paths {
+		for method := range item {
+			if method == "parameters" {
+				continue
+			}
+			documented[strings.ToUpper(method)+" "+base+path] = true
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	(&handlers.Handler{}).RegisterAPIRoutes(r, openAPISpec)
+
+	param := regexp.MustCompile(`:(\w+)`)
+	registered := map[string]bool{}
+	for _, route := range r.Routes() {
+		registered[route.Method+" "+param.ReplaceAllString(route.Path, "{$1}")] = true
+	}
+
+	if missing := difference(registered, documented); len(missing) > 0 {
+		t.Errorf("Routes missing from api/openapi.json: %v", missing)
+	}
+	if stale := difference(documented, registered); len(stale) > 0 {
+		t.Errorf("api/openapi.json documents routes that are not registered: %v", stale)
+	}
+}
+
+func TestOpenAPISpecIsServed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	h := &handlers.Handler{Sessions: middleware.NewSessionStore(config.Server{SessionSecret: "test"})}
+	h.RegisterAPIRoutes(r, openAPISpec)
+	r.NoRoute(handlers.APINotFound)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	if w.Code != http.StatusOK || !json.Valid(w.Body.Bytes()) {
+		t.Errorf("Expected the spec, got %d", w.Code)
+	}
+
+	// Without a session the API answers with an error envelope, not a
+	// redirect to the login page
+	for _, path := range []string{"/api/v1/documents", "/api/v1/nope"} {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var body struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Code == "" {
+			t.Errorf("%s: expected an error envelope, got %d %q", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func difference(a, b map[string]bool) []string {
+	var out []string
+	for key := range a {
+		if !b[key] {
+			out = append(out, key)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,211 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Passage is a piece of a document found by Search.
+type Passage struct {
+	DocumentID string  `json:"document_id"`
+	Filename   string  `json:"filename"`
+	Content    string  `json:"content"`
+	Score      float64 `json:"score"`
+}
+
+type SearchOptions struct {
+	Filter
+	// Limit is how many passages to return, 1 to 20; the server's default
+	// applies when it is 0
+	Limit int
+}
+
+// PromptTemplate is a version of a prompt template chats may select.
+type PromptTemplate struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	Name        string    `json:"name"`
+	Version     int       `json:"version"`
+	Text        string    `json:"text"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ListPrompts returns the latest version of every prompt template chats may
+// select. The template named "default" applies when none is selected.
+func (c *Client) ListPrompts(ctx context.Context) ([]PromptTemplate, error) {
+	var templates []PromptTemplate
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: "/prompts"}, &templates, nil); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// Search returns the passages most relevant to query without generating an
+// answer.
+func (c *Client) Search(ctx context.Context, query string, opts *SearchOptions) ([]Passage, error) {
+	if opts == nil {
+		opts = &SearchOptions{}
+	}
+	q := opts.values()
+	q.Set("q", query)
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.Itoa(opts.Limit))
+	}
+
+	var passages []Passage
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: "/search", query: q}, &passages, nil); err != nil {
+		return nil, err
+	}
+	return passages, nil
+}
+
+type ChatRequest struct {
+	Query string `json:"query"`
+	// Conversation continues an earlier conversation; a new one is started
+	// when it is empty
+	Conversation string `json:"conversation,omitempty"`
+	// DocumentIDs restricts the answer to these documents
+	DocumentIDs []string `json:"document_ids,omitempty"`
+	// Agent lets the tool-calling agent answer; it cannot be combined with
+	// DocumentIDs or Prompts
+	Agent bool `json:"agent,omitempty"`
+	// Prompts selects prompt templates by kind, "system" or "context", for
+	// the conversation from this message on: "name" for the latest version
+	// or "name@2" for a fixed one, see ListPrompts
+	Prompts map[string]string `json:"prompts,omitempty"`
+}
+
+type ChatResponse struct {
+	Conversation   string `json:"conversation"`
+	Answer         string `json:"answer"`
+	Rewritten      string `json:"rewritten"`
+	WholeDocuments bool   `json:"whole_documents"`
+	Usage          Usage  `json:"usage"`
+	// Trace lists the agent's tool calls
+	Trace []Step `json:"trace"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Step is one tool call of the agent.
+type Step struct {
+	Step       int                    `json:"step"`
+	Tool       string                 `json:"tool"`
+	Args       map[string]interface{} `json:"args"`
+	Result     string                 `json:"result,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	DurationMS int64                  `json:"duration_ms"`
+}
+
+// Chat answers req from the user's documents. When onToken is set the
+// answer is streamed and onToken receives every piece of it as it is
+// generated; the returned response holds the whole answer either way.
+func (c *Client) Chat(ctx context.Context, req ChatRequest, onToken func(token string)) (*ChatResponse, error) {
+	stream := onToken != nil
+	body, err := json.Marshal(struct {
+		ChatRequest
+		Stream bool `json:"stream,omitempty"`
+	}{req, stream})
+	if err != nil {
+		return nil, err
+	}
+	call := request{
+		method:      http.MethodPost,
+		path:        "/chat",
+		body:        func() (io.Reader, error) { return bytes.NewReader(body), nil },
+		contentType: "application/json",
+	}
+
+	if !stream {
+		var answer ChatResponse
+		if err := c.getJSON(ctx, call, &answer, nil); err != nil {
+			return nil, err
+		}
+		return &answer, nil
+	}
+
+	call.accept = "text/event-stream"
+	resp, err := c.do(ctx, call)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return readChatEvents(resp, onToken)
+}
+
+// readChatEvents reads the server-sent events of a streamed chat answer.
+func readChatEvents(resp *http.Response, onToken func(string)) (*ChatResponse, error) {
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+
+	var event string
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if field, value, ok := strings.Cut(line, ":"); ok && line != "" {
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				event = value
+			case "data":
+				if data.Len() > 0 {
+					data.WriteByte('\n')
+				}
+				data.WriteString(value)
+			}
+			continue
+		}
+		if line != "" {
+			continue
+		}
+
+		// A blank line ends the event
+		payload := []byte(data.String())
+		data.Reset()
+		switch event {
+		case "token":
+			var token struct {
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal(payload, &token); err != nil {
+				return nil, fmt.Errorf("tusk: decoding token: %w", err)
+			}
+			onToken(token.Text)
+		case "done":
+			var answer ChatResponse
+			if err := json.Unmarshal(payload, &answer); err != nil {
+				return nil, fmt.Errorf("tusk: decoding answer: %w", err)
+			}
+			return &answer, nil
+		case "error":
+			// The status was sent before the answer failed, so it is
+			// derived from the error code
+			apiErr := &Error{StatusCode: http.StatusInternalServerError}
+			if err := json.Unmarshal(payload, apiErr); err != nil {
+				return nil, fmt.Errorf("tusk: decoding error: %w", err)
+			}
+			if apiErr.Code == "timeout" {
+				apiErr.StatusCode = http.StatusGatewayTimeout
+			}
+			return nil, apiErr
+		}
+		event = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.ErrUnexpectedEOF
+}
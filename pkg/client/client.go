@@ -0,0 +1,229 @@
+// Package client is a Go client for the Tusk /api/v1 API.
+//
+// A client authenticates with a personal API token, created at
+// /settings/tokens:
+//
+//	c := client.New("https://tusk.example.com", os.Getenv("TUSK_TOKEN"))
+//	docs, err := c.ListFiles(ctx, &client.ListOptions{Filter: client.Filter{Folder: "contracts"}})
+//
+// Requests that fail with a network error or an overloaded server are
+// retried with exponential backoff, see Client.MaxRetries.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBackoff caps the wait between two attempts, including waits asked for
+// with Retry-After.
+const maxBackoff = 30 * time.Second
+
+type Client struct {
+	// BaseURL is where Tusk is served, without the /api/v1 path
+	BaseURL string
+	// Token is a personal API token, sent as a Bearer token
+	Token string
+	// HTTPClient sends the requests; http.DefaultClient when nil
+	HTTPClient *http.Client
+
+	// MaxRetries is how often a failed request is retried. Reads and
+	// deletes are retried after network errors and 429, 502, 503 and 504
+	// responses; uploads and chat only after 429 and 503, which the server
+	// sends before doing any work.
+	MaxRetries int
+	// Backoff is the wait before the first retry. It doubles with every
+	// further attempt, with jitter.
+	Backoff time.Duration
+}
+
+// New returns a client for the Tusk server at baseURL that retries three
+// times.
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		MaxRetries: 3,
+		Backoff:    500 * time.Millisecond,
+	}
+}
+
+// Error is an error response of the API.
+type Error struct {
+	StatusCode int `json:"-"`
+	// Code is the API's error code, such as "not_found" or "forbidden"
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("tusk: %s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// IsNotFound reports whether err is an API error for a missing resource.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// request describes one API call. body returns a fresh request body for
+// every attempt; a call whose body cannot be replayed sets noRetry.
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        func() (io.Reader, error)
+	contentType string
+	accept      string
+	noRetry     bool
+}
+
+// do sends req, retrying as described on Client.MaxRetries, and returns the
+// successful response. Error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	u := c.BaseURL + "/api/v1" + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	idempotent := req.method == http.MethodGet || req.method == http.MethodDelete
+
+	for attempt := 0; ; attempt++ {
+		var body io.Reader
+		if req.body != nil {
+			var err error
+			if body, err = req.body(); err != nil {
+				return nil, err
+			}
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, u, body)
+		if err != nil {
+			return nil, err
+		}
+		if c.Token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+		if req.contentType != "" {
+			httpReq.Header.Set("Content-Type", req.contentType)
+		}
+		accept := req.accept
+		if accept == "" {
+			accept = "application/json"
+		}
+		httpReq.Header.Set("Accept", accept)
+
+		resp, err := httpClient.Do(httpReq)
+		retry := attempt < c.MaxRetries && !req.noRetry
+		if err != nil {
+			if ctx.Err() != nil || !retry || !idempotent {
+				return nil, err
+			}
+			if err := c.wait(ctx, attempt, ""); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		apiErr := readError(resp)
+		if !retry || !retryable(resp.StatusCode, idempotent) {
+			return nil, apiErr
+		}
+		if err := c.wait(ctx, attempt, resp.Header.Get("Retry-After")); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func retryable(status int, idempotent bool) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	default:
+		return false
+	}
+}
+
+// wait sleeps before the next attempt: Backoff doubled per attempt with up
+// to half of it taken off at random, or longer when the server asked for it.
+func (c *Client) wait(ctx context.Context, attempt int, retryAfter string) error {
+	delay := c.Backoff << attempt
+	if delay <= 0 || delay > maxBackoff {
+		delay = maxBackoff
+	}
+	delay -= time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && time.Duration(seconds)*time.Second > delay {
+		delay = min(time.Duration(seconds)*time.Second, maxBackoff)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// readError reads an error envelope and closes the response.
+func readError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+
+	apiErr := &Error{StatusCode: resp.StatusCode, Message: resp.Status}
+	var envelope struct {
+		Error Error `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if json.Unmarshal(data, &envelope) == nil && envelope.Error.Code != "" {
+		apiErr.Code = envelope.Error.Code
+		apiErr.Message = envelope.Error.Message
+	}
+	return apiErr
+}
+
+// pagination is the page of a list response.
+type pagination struct {
+	Offset  int64 `json:"offset"`
+	Limit   int64 `json:"limit"`
+	Total   int64 `json:"total"`
+	HasMore bool  `json:"has_more"`
+}
+
+// getJSON sends req and decodes the "data" of the response into data, and
+// its "pagination" into page when given.
+func (c *Client) getJSON(ctx context.Context, req request, data interface{}, page *pagination) error {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if data == nil {
+		return nil
+	}
+	envelope := struct {
+		Data       interface{} `json:"data"`
+		Pagination *pagination `json:"pagination"`
+	}{Data: data, Pagination: page}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("tusk: decoding response: %w", err)
+	}
+	return nil
+}
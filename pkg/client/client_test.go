@@ -0,0 +1,176 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/ai/aitest"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/handlers"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+	"github.com/sdrshn-nmbr/tusk/internal/storage/memstore"
+)
+
+const testReply = "Invoices are due within thirty days."
+
+// newTestServer serves the real API handlers backed by the in-memory store
+// and a scripted model, and returns a client with a token of the given
+// scopes.
+func newTestServer(t *testing.T, scopes ...string) (*Client, *httptest.Server) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := memstore.New()
+	h := handlers.NewHandler(config.Default(), store, ai.NewHashEmbedder(256), &aitest.Model{Reply: testReply}, template.New(""))
+	r := gin.New()
+	h.RegisterAPIRoutes(r, nil)
+	r.NoRoute(handlers.APINotFound)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	_, secret, err := store.CreateAPIToken("user-1", "test", scopes, nil)
+	if err != nil {
+		t.Fatalf("Failed to create token: %+v", err)
+	}
+	c := New(server.URL, secret)
+	c.Backoff = time.Millisecond
+	return c, server
+}
+
+func TestClient(t *testing.T) {
+	c, _ := newTestServer(t, storage.Scopes...)
+	ctx := context.Background()
+
+	content := "Payment terms: invoices are due within thirty days of receipt."
+	// A reader that is not an io.Seeker is streamed without retries
+	doc, err := c.UploadFile(ctx, "terms.txt", bytes.NewBufferString(content), &UploadOptions{Folder: "contracts"})
+	if err != nil {
+		t.Fatalf("UploadFile failed: %+v", err)
+	}
+	if doc.Filename != "terms.txt" || doc.Folder != "contracts" || doc.SizeBytes != int64(len(content)) {
+		t.Errorf("Unexpected document: %+v", doc)
+	}
+	if _, err := c.UploadFile(ctx, "notes.txt", strings.NewReader("Lunch menu for Friday."), nil); err != nil {
+		t.Fatalf("UploadFile failed: %+v", err)
+	}
+
+	list, err := c.ListFiles(ctx, &ListOptions{Filter: Filter{Folder: "contracts"}})
+	if err != nil {
+		t.Fatalf("ListFiles failed: %+v", err)
+	}
+	if list.Total != 1 || len(list.Documents) != 1 || list.Documents[0].ID != doc.ID {
+		t.Errorf("Expected only the contract, got %+v", list)
+	}
+	list, err = c.ListFiles(ctx, &ListOptions{Sort: "name", Limit: 1})
+	if err != nil || list.Total != 2 || !list.HasMore || list.Documents[0].Filename != "notes.txt" {
+		t.Errorf("Expected the first of two pages, got %+v (%v)", list, err)
+	}
+
+	passages, err := c.Search(ctx, "when are invoices due", &SearchOptions{Limit: 1})
+	if err != nil {
+		t.Fatalf("Search failed: %+v", err)
+	}
+	if len(passages) != 1 || passages[0].DocumentID != doc.ID {
+		t.Errorf("Expected the contract to rank first, got %+v", passages)
+	}
+
+	var buf bytes.Buffer
+	if n, err := c.Download(ctx, doc.ID, &buf); err != nil || n != int64(len(content)) || buf.String() != content {
+		t.Errorf("Download returned %d bytes %q (%v)", n, buf.String(), err)
+	}
+
+	var tokens []string
+	answer, err := c.Chat(ctx, ChatRequest{Query: "When are invoices due?"}, func(token string) {
+		tokens = append(tokens, token)
+	})
+	if err != nil {
+		t.Fatalf("Chat failed: %+v", err)
+	}
+	if answer.Answer != testReply || strings.Join(tokens, "") != testReply || len(tokens) < 2 {
+		t.Errorf("Expected the reply streamed in pieces, got %q from %q", answer.Answer, tokens)
+	}
+	followUp, err := c.Chat(ctx, ChatRequest{Query: "And late fees?", Conversation: answer.Conversation}, nil)
+	if err != nil || followUp.Answer != testReply || followUp.Conversation != answer.Conversation {
+		t.Errorf("Expected the conversation to continue, got %+v (%v)", followUp, err)
+	}
+
+	if err := c.DeleteFile(ctx, doc.ID); err != nil {
+		t.Fatalf("DeleteFile failed: %+v", err)
+	}
+	if _, err := c.GetFile(ctx, doc.ID); !IsNotFound(err) {
+		t.Errorf("Expected not found after deleting, got %v", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	c, server := newTestServer(t, storage.ScopeRead)
+	ctx := context.Background()
+
+	var apiErr *Error
+	_, err := c.UploadFile(ctx, "a.txt", strings.NewReader("a"), nil)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden || apiErr.Code != "forbidden" {
+		t.Errorf("Expected a forbidden error for a read-only token, got %v", err)
+	}
+
+	_, err = New(server.URL, "tusk_wrong").ListFiles(ctx, nil)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected an unauthorized error, got %v", err)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	var calls, failures, status atomic.Int32
+	failures.Store(2)
+	status.Store(http.StatusServiceUnavailable)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures.Load() {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(int(status.Load()))
+			return
+		}
+		w.Write([]byte(`{"data": [], "pagination": {"total": 0}}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, "tusk_x")
+	c.Backoff = time.Millisecond
+	ctx := context.Background()
+
+	if _, err := c.ListFiles(ctx, nil); err != nil || calls.Load() != 3 {
+		t.Errorf("Expected success on the third attempt, got %d calls (%v)", calls.Load(), err)
+	}
+
+	// A gateway error may come after the server did the work, so uploads
+	// are not sent twice
+	calls.Store(0)
+	status.Store(http.StatusBadGateway)
+	if _, err := c.UploadFile(ctx, "a.txt", strings.NewReader("a"), nil); err == nil || calls.Load() != 1 {
+		t.Errorf("Expected the upload to fail without a retry, got %d calls (%v)", calls.Load(), err)
+	}
+
+	calls.Store(0)
+	c.MaxRetries = 1
+	if _, err := c.ListFiles(ctx, nil); err == nil || calls.Load() != 2 {
+		t.Errorf("Expected to give up after one retry, got %d calls (%v)", calls.Load(), err)
+	}
+
+	calls.Store(0)
+	failures.Store(100)
+	c.MaxRetries = 10
+	c.Backoff = time.Hour
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.ListFiles(ctx, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context to stop the retries, got %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Document is a stored file without its content.
+type Document struct {
+	ID         string                 `json:"id"`
+	Filename   string                 `json:"filename"`
+	Folder     string                 `json:"folder"`
+	Tags       []string               `json:"tags"`
+	Attributes map[string]interface{} `json:"attributes"`
+	Title      string                 `json:"title,omitempty"`
+	Abstract   string                 `json:"abstract,omitempty"`
+	SizeBytes  int64                  `json:"size_bytes"`
+	// SHA256 is the hex hash of the content. Documents uploaded before the
+	// server recorded hashes have none.
+	SHA256     string    `json:"sha256,omitempty"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	Summary    *Summary  `json:"summary,omitempty"`
+}
+
+// Summary is a cached summary of a whole document.
+type Summary struct {
+	Text        string    `json:"text"`
+	Chunks      int       `json:"chunks"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// Filter narrows listings and searches. Every set field has to match.
+type Filter struct {
+	Folder string
+	// Tags the documents must all carry
+	Tags []string
+	// Attributes are key:op:value expressions such as "amount:gte:100"
+	Attributes []string
+	// From and To bound the upload date, e.g. "2024" or "2024-05-01"; To
+	// is inclusive
+	From string
+	To   string
+}
+
+func (f Filter) values() url.Values {
+	q := url.Values{}
+	if f.Folder != "" {
+		q.Set("folder", f.Folder)
+	}
+	for _, tag := range f.Tags {
+		q.Add("tag", tag)
+	}
+	for _, attr := range f.Attributes {
+		q.Add("attr", attr)
+	}
+	if f.From != "" {
+		q.Set("from", f.From)
+	}
+	if f.To != "" {
+		q.Set("to", f.To)
+	}
+	return q
+}
+
+type ListOptions struct {
+	Filter
+	// Sort is "name", "uploaded", "folder" or "attr:<key>"
+	Sort string
+	Desc bool
+	// Offset and Limit page through the documents; the server's default
+	// page size applies when Limit is 0
+	Offset int64
+	Limit  int64
+}
+
+// DocumentList is one page of documents.
+type DocumentList struct {
+	Documents []Document
+	Offset    int64
+	Limit     int64
+	Total     int64
+	HasMore   bool
+}
+
+type UploadOptions struct {
+	// Folder to put the document in
+	Folder string
+}
+
+// UploadFile streams content to the server as a new document named
+// filename. Content is only retried when it is an io.Seeker, since it
+// has to be sent again from the start.
+func (c *Client) UploadFile(ctx context.Context, filename string, content io.Reader, opts *UploadOptions) (*Document, error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	seeker, canRewind := content.(io.Seeker)
+	var written chan struct{}
+
+	// The multipart body is written as it is sent, so large files are
+	// never held in memory
+	boundary := multipart.NewWriter(nil).Boundary()
+	body := func() (io.Reader, error) {
+		if written != nil {
+			// The previous attempt stops reading content once its
+			// request body is closed
+			<-written
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		done := make(chan struct{})
+		written = done
+
+		pr, pw := io.Pipe()
+		go func() {
+			defer close(done)
+			mw := multipart.NewWriter(pw)
+			mw.SetBoundary(boundary)
+			err := func() error {
+				if opts.Folder != "" {
+					if err := mw.WriteField("folder", opts.Folder); err != nil {
+						return err
+					}
+				}
+				part, err := mw.CreateFormFile("file", filename)
+				if err != nil {
+					return err
+				}
+				if _, err := io.Copy(part, content); err != nil {
+					return err
+				}
+				return mw.Close()
+			}()
+			pw.CloseWithError(err)
+		}()
+		return pr, nil
+	}
+
+	var doc Document
+	err := c.getJSON(ctx, request{
+		method:      http.MethodPost,
+		path:        "/documents",
+		body:        body,
+		contentType: "multipart/form-data; boundary=" + boundary,
+		noRetry:     !canRewind,
+	}, &doc, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// ListFiles returns one page of the documents matching opts.
+func (c *Client) ListFiles(ctx context.Context, opts *ListOptions) (*DocumentList, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	q := opts.values()
+	if opts.Sort != "" {
+		q.Set("sort", opts.Sort)
+	}
+	if opts.Desc {
+		q.Set("order", "desc")
+	}
+	if opts.Offset > 0 {
+		q.Set("offset", strconv.FormatInt(opts.Offset, 10))
+	}
+	if opts.Limit > 0 {
+		q.Set("limit", strconv.FormatInt(opts.Limit, 10))
+	}
+
+	var docs []Document
+	var page pagination
+	err := c.getJSON(ctx, request{method: http.MethodGet, path: "/documents", query: q}, &docs, &page)
+	if err != nil {
+		return nil, err
+	}
+	return &DocumentList{
+		Documents: docs,
+		Offset:    page.Offset,
+		Limit:     page.Limit,
+		Total:     page.Total,
+		HasMore:   page.HasMore,
+	}, nil
+}
+
+func (c *Client) GetFile(ctx context.Context, id string) (*Document, error) {
+	var doc Document
+	err := c.getJSON(ctx, request{method: http.MethodGet, path: "/documents/" + url.PathEscape(id)}, &doc, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// MoveFile puts the document in folder; "" takes it out of any folder.
+func (c *Client) MoveFile(ctx context.Context, id, folder string) (*Document, error) {
+	body, err := json.Marshal(map[string]string{"folder": folder})
+	if err != nil {
+		return nil, err
+	}
+	var doc Document
+	err = c.getJSON(ctx, request{
+		method:      http.MethodPatch,
+		path:        "/documents/" + url.PathEscape(id),
+		body:        func() (io.Reader, error) { return bytes.NewReader(body), nil },
+		contentType: "application/json",
+	}, &doc, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// DeleteFile deletes the document with its chunks.
+func (c *Client) DeleteFile(ctx context.Context, id string) error {
+	return c.getJSON(ctx, request{method: http.MethodDelete, path: "/documents/" + url.PathEscape(id)}, nil, nil)
+}
+
+// Folder is a folder with the number of documents directly in it.
+type Folder struct {
+	Name      string `json:"name"`
+	Documents int    `json:"documents"`
+}
+
+// Folders lists the folders that hold documents. Documents outside any
+// folder are counted under "".
+func (c *Client) Folders(ctx context.Context) ([]Folder, error) {
+	var folders []Folder
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: "/folders"}, &folders, nil); err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+// Download writes the original file to w and returns how many bytes it
+// wrote. An error while copying the file is not retried.
+func (c *Client) Download(ctx context.Context, id string, w io.Writer) (int64, error) {
+	resp, err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/documents/" + url.PathEscape(id) + "/content",
+		accept: "application/octet-stream",
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return io.Copy(w, resp.Body)
+}
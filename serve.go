@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/google"
+	"github.com/sdrshn-nmbr/tusk/internal/ai"
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/enrich"
+	"github.com/sdrshn-nmbr/tusk/internal/handlers"
+	"github.com/sdrshn-nmbr/tusk/internal/memory"
+	"github.com/sdrshn-nmbr/tusk/internal/prompts"
+	"github.com/sdrshn-nmbr/tusk/internal/retrieval"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// serve migrates the database, makes sure the indexes exist and runs the web
+// server until it fails.
+func serve(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Parse(args)
+
+	if err := cfg.ValidateServer(); err != nil {
+		return err
+	}
+	if err := storage.SetLicense(cfg.Unidoc.APIKey); err != nil {
+		return fmt.Errorf("setting the Unidoc license: %w", err)
+	}
+
+	// Initialize MongoDB storage
+	ocr := storage.NewGeminiOCR(cfg)
+	ms, err := openStorage(cfg, ocr)
+	if err != nil {
+		return fmt.Errorf("initializing MongoDB storage: %w", err)
+	}
+
+	// Apply pending migrations; when several machines boot at once only one
+	// gets the lock and the others carry on
+	if _, err := ms.MigrateUp(context.Background(), 0, false); err != nil {
+		log.Printf("Error running migrations: %v", err)
+	}
+
+	// Create indexes used for metadata filtering
+	if err := ms.EnsureIndexes(); err != nil {
+		log.Printf("Error creating indexes: %v", err)
+	}
+
+	// Jobs run in the process that created them, so fail those a previous
+	// process left behind, now and whenever one goes quiet for too long
+	go failStaleJobs(ms, cfg.Jobs.Timeout.Duration)
+
+	// Initialize embedder
+	embedder := ai.NewEmbedder(cfg)
+
+	// The model only changes through reindex, which re-embeds the chunks
+	active := ms.ActiveEmbedder(embedder)
+	if active.Model() != embedder.Model() {
+		log.Printf("Chunks are embedded with %s rather than the configured %s; run reindex -model %s to switch", active.Model(), embedder.Model(), embedder.Model())
+	}
+
+	// Create the Atlas search indexes if missing and report readiness once
+	// they can be queried, since searches return nothing until then
+	if err := ms.EnsureSearchIndexes(active.Dimensions()); err != nil {
+		log.Printf("Error ensuring search indexes: %v", err)
+	}
+	var ready atomic.Bool
+	go watchSearchIndexes(ms, &ready)
+
+	// Parse templates using embedded file system
+	tmpl, err := parseTemplates()
+	if err != nil {
+		return fmt.Errorf("parsing templates: %w", err)
+	}
+
+	model, err := ai.NewModel(cfg, prompts.SystemPrompt)
+	if err != nil {
+		return fmt.Errorf("creating model: %w", err)
+	}
+	defer model.Close()
+
+	// Initialize handler with MongoDB storage and embedder
+	h := handlers.NewHandler(cfg, ms, embedder, model, tmpl)
+
+	// Images are read with the default OCR prompt template
+	ocr.Prompter = h.Prompts
+
+	// Rewrite follow-up questions and rerank the vector search candidates,
+	// e.g. RERANKER=cross-encoder,mmr
+	h.Retriever.Reranker, err = retrieval.NewReranker(cfg.Retrieval, model)
+	if err != nil {
+		return fmt.Errorf("creating reranker: %w", err)
+	}
+	h.Memory, err = memory.New(cfg.Chat.MemoryMode, cfg.Chat.MemoryWindow, cfg.Chat.MemorySummaryThreshold, model, ms)
+	if err != nil {
+		return fmt.Errorf("configuring conversation memory: %w", err)
+	}
+	if cfg.Ingest.Enrichment {
+		ms.OnIngest = enrich.New(model, ms, cfg.Jobs.Concurrency).Ingested
+	}
+	switch cfg.Agent.Provider {
+	case "gemini":
+		// NewHandler already set the Gemini model as the tool caller
+	case "ollama":
+		h.Agent.Model = ai.NewOllamaToolCaller(cfg.Agent.OllamaURL, cfg.Agent.OllamaModel)
+	default:
+		return fmt.Errorf("unknown agent provider %q", cfg.Agent.Provider)
+	}
+	h.Budgeter.Counter = model
+	h.Retriever.Transformer = &retrieval.QueryTransformer{
+		Model:        model,
+		HistoryTurns: cfg.Retrieval.QueryHistoryTurns,
+		Paraphrases:  cfg.Retrieval.QueryParaphrases,
+		HyDE:         cfg.Retrieval.QueryHyDE,
+	}
+
+	// Set up Gin router
+	r := gin.Default()
+	r.MaxMultipartMemory = cfg.Server.MaxMultipartMemory
+
+	// Load HTML templates
+	r.SetHTMLTemplate(tmpl)
+	log.Println("HTML templates loaded into Gin")
+
+	// OAuth providers call back to the public URL
+	callbackURL := strings.TrimSuffix(cfg.Server.PublicURL, "/") + "/auth/%s/callback"
+
+	// Goth keeps the OAuth state in the same sessions as the handlers
+	gothic.Store = h.Sessions
+
+	// Set up the Goth providers that are configured
+	var providers []goth.Provider
+	if cfg.Auth.GoogleClientID != "" {
+		providers = append(providers, google.New(cfg.Auth.GoogleClientID, cfg.Auth.GoogleClientSecret, fmt.Sprintf(callbackURL, "google")))
+	}
+	if cfg.Auth.GithubClientID != "" {
+		providers = append(providers, github.New(cfg.Auth.GithubClientID, cfg.Auth.GithubClientSecret, fmt.Sprintf(callbackURL, "github")))
+	}
+	goth.UseProviders(providers...)
+
+	// Health checks: the process is alive, and search is usable
+	r.GET("/healthz", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	r.GET("/readyz", func(c *gin.Context) {
+		if !ready.Load() {
+			c.String(http.StatusServiceUnavailable, "search indexes are not queryable yet")
+			return
+		}
+		c.String(http.StatusOK, "ready")
+	})
+
+	// Pages, endpoints and the JSON API, documented in api/openapi.json
+	h.RegisterRoutes(r, openAPISpec)
+
+	// Serve static files
+	r.Static("/static", "./web/static")
+
+	// Fly.io provides the port through PORT
+	log.Printf("Server starting on :%s", cfg.Server.Port)
+	return r.Run("0.0.0.0:" + cfg.Server.Port)
+}
+
+// watchSearchIndexes keeps ready in step with whether the search indexes can
+// be queried. It checks every few seconds until they can and every minute
+// after that, so that an index that failed, was rebuilt or was dropped is
+// noticed without a restart.
+func watchSearchIndexes(ms *storage.MongoStorage, ready *atomic.Bool) {
+	var lastErr string
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ok, err := ms.SearchIndexesReady(ctx)
+		cancel()
+
+		switch {
+		case err != nil:
+			if err.Error() != lastErr {
+				log.Printf("Search indexes are not usable: %v", err)
+			}
+			lastErr = err.Error()
+		case ok && !ready.Load():
+			log.Println("Search indexes are queryable")
+		case !ok && ready.Load():
+			log.Println("Search indexes are no longer queryable")
+		}
+		if err == nil {
+			lastErr = ""
+		}
+		ready.Store(ok)
+
+		interval := 5 * time.Second
+		if ok {
+			interval = time.Minute
+		}
+		time.Sleep(interval)
+	}
+}
+
+// failStaleJobs marks jobs that made no progress for longer than the job
+// timeout as failed, at startup and every few minutes after that. A running
+// job stops at the timeout, so such jobs belong to a process that is gone.
+func failStaleJobs(ms *storage.MongoStorage, timeout time.Duration) {
+	for {
+		if n, err := ms.FailStaleJobs(timeout); err == nil && n > 0 {
+			log.Printf("Marked %d interrupted jobs as failed", n)
+		}
+		time.Sleep(5 * time.Minute)
+	}
+}
+
+// parseTemplates parses HTML templates from the embedded file system.
+func parseTemplates() (*template.Template, error) {
+	tmpl := template.New("")
+	err := fs.WalkDir(templateFS, "web/templates", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if filepath.Ext(path) != ".html" {
+			return nil
+		}
+		b, err := templateFS.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(path[len("web/templates/"):])
+		_, err = tmpl.New(name).Parse(string(b))
+		return err
+	})
+	return tmpl, err
+}
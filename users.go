@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sdrshn-nmbr/tusk/internal/config"
+	"github.com/sdrshn-nmbr/tusk/internal/storage"
+)
+
+// user runs "user list", "user disable <id>", "user enable <id>" or
+// "user delete <id>".
+func user(cfg *config.Config, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New("expected list, disable, enable or delete")
+	}
+	action, args := args[0], args[1:]
+
+	ms, err := openStorage(cfg, nil)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch action {
+	case "list":
+		flags := flag.NewFlagSet("user list", flag.ExitOnError)
+		asJSON := flags.Bool("json", false, "print the users as JSON")
+		flags.Parse(args)
+		return listUsers(ctx, ms, *asJSON)
+	case "disable", "enable":
+		flags := flag.NewFlagSet("user "+action, flag.ExitOnError)
+		flags.Parse(args)
+		if flags.NArg() != 1 {
+			return fmt.Errorf("expected one user ID")
+		}
+		if err := ms.SetUserDisabled(flags.Arg(0), action == "disable"); err != nil {
+			return err
+		}
+		fmt.Printf("User %s is %sd\n", flags.Arg(0), action)
+		return nil
+	case "delete":
+		flags := flag.NewFlagSet("user delete", flag.ExitOnError)
+		yes := flags.Bool("yes", false, "do not ask for confirmation")
+		flags.Parse(args)
+		if flags.NArg() != 1 {
+			return fmt.Errorf("expected one user ID")
+		}
+		return deleteUser(ctx, ms, flags.Arg(0), *yes)
+	default:
+		return fmt.Errorf("unknown user action %q, expected list, disable, enable or delete", action)
+	}
+}
+
+func listUsers(ctx context.Context, ms *storage.MongoStorage, asJSON bool) error {
+	users, err := ms.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(users)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEMAIL\tPROVIDER\tDOCUMENTS\tLAST LOGIN\tSTATUS")
+	for _, u := range users {
+		lastLogin, status := "-", "active"
+		if !u.LastLogin.IsZero() {
+			lastLogin = u.LastLogin.Local().Format(time.DateTime)
+		}
+		if u.Disabled {
+			status = "disabled"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", u.ID, orDash(u.Email), orDash(u.Provider), u.Documents, lastLogin, status)
+	}
+	return w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// deleteUser deletes the user with all their data, after asking unless yes
+// is set.
+func deleteUser(ctx context.Context, ms *storage.MongoStorage, userID string, yes bool) error {
+	if !yes {
+		users, err := ms.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+		documents := int64(-1)
+		for _, u := range users {
+			if u.ID == userID {
+				documents = u.Documents
+			}
+		}
+		if documents < 0 {
+			return fmt.Errorf("no user %s", userID)
+		}
+		fmt.Printf("Delete user %s and their %d documents, conversations, settings and tokens? This cannot be undone. [y/N] ", userID, documents)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return errors.New("aborted")
+		}
+	}
+
+	deleted, err := ms.DeleteUser(ctx, userID)
+	collections := make([]string, 0, len(deleted))
+	for collection := range deleted {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	for _, collection := range collections {
+		fmt.Printf("%-22s %d deleted\n", collection, deleted[collection])
+	}
+	return err
+}